@@ -0,0 +1,145 @@
+// Copyright (c) 2024 Huawei Technologies Co., Ltd.
+// openFuyao is licensed under Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//          http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+// EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+// MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+// See the Mulan PSL v2 for more details.
+
+package v1beta1
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// startWebhookEnv starts an API server calling the webhooks of a manager and returns a client of it.
+// The test is skipped without the envtest binaries, make test installs them.
+func startWebhookEnv(t *testing.T, allowedImages []string) client.Client {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	testEnv := &envtest.Environment{
+		CRDInstallOptions: envtest.CRDInstallOptions{
+			Paths: []string{filepath.Join("..", "..", "charts", "web-terminal-service", "templates",
+				"terminal.openfuyao.com_webterminaltemplates.yaml")},
+			ErrorIfPathMissing: true,
+		},
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			Paths: []string{filepath.Join("..", "..", "config", "webhook")},
+		},
+	}
+	cfg, err := testEnv.Start()
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, testEnv.Stop()) })
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, AddToScheme(scheme))
+	options := &testEnv.WebhookInstallOptions
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:  scheme,
+		Metrics: metricsserver.Options{BindAddress: "0"},
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Host:    options.LocalServingHost,
+			Port:    options.LocalServingPort,
+			CertDir: options.LocalServingCertDir,
+		}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, (&WebterminalTemplate{}).SetupWebhookWithManager(mgr, allowedImages, ""))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		assert.NoError(t, mgr.Start(ctx))
+	}()
+	address := net.JoinHostPort(options.LocalServingHost, fmt.Sprint(options.LocalServingPort))
+	require.Eventually(t, func() bool {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", address,
+			&tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return false
+		}
+		return conn.Close() == nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	require.NoError(t, err)
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: newWebhookTemplate().Namespace}}
+	require.NoError(t, c.Create(context.Background(), namespace))
+	return c
+}
+
+func TestWebterminalTemplateWebhooks(t *testing.T) {
+	c := startWebhookEnv(t, []string{"cr.openfuyao.cn/openfuyao/"})
+	ctx := context.Background()
+
+	obj := newWebhookTemplate()
+	require.NoError(t, c.Create(ctx, obj))
+	created := &WebterminalTemplate{}
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(obj), created))
+	assert.Equal(t, obj.Name, created.Spec.PodTemplate.ObjectMeta.Name)
+	assert.Equal(t, obj.Namespace, created.Spec.PodTemplate.ObjectMeta.Namespace)
+	assert.Equal(t, obj.Name, created.Spec.PodTemplate.ObjectMeta.Labels[TemplateLabel])
+	assert.Equal(t, corev1.RestartPolicyAlways, created.Spec.PodTemplate.Spec.RestartPolicy)
+	require.NotNil(t, created.Spec.PodTemplate.Spec.Containers[0].SecurityContext)
+	assert.False(t, *created.Spec.PodTemplate.Spec.Containers[0].SecurityContext.AllowPrivilegeEscalation)
+
+	tests := []struct {
+		name   string
+		modify func(obj *WebterminalTemplate)
+	}{
+		{name: "hostPath volume", modify: func(obj *WebterminalTemplate) {
+			obj.Spec.PodTemplate.Spec.Volumes = []corev1.Volume{{Name: "root", VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: "/"}}}}
+		}},
+		{name: "privileged container", modify: func(obj *WebterminalTemplate) {
+			privileged := true
+			obj.Spec.PodTemplate.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{Privileged: &privileged}
+		}},
+		{name: "host port", modify: func(obj *WebterminalTemplate) {
+			obj.Spec.PodTemplate.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 22, HostPort: 22}}
+		}},
+		{name: "image outside the allowlist", modify: func(obj *WebterminalTemplate) {
+			obj.Spec.PodTemplate.Spec.Containers[0].Image = "docker.io/library/busybox"
+		}},
+		{name: "pod in another namespace", modify: func(obj *WebterminalTemplate) {
+			obj.Spec.PodTemplate.ObjectMeta.Namespace = "kube-system"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejected := newWebhookTemplate()
+			rejected.Name = "rejected"
+			tt.modify(rejected)
+			err := c.Create(ctx, rejected)
+			assert.True(t, apierrors.IsInvalid(err) || apierrors.IsForbidden(err), "%v", err)
+
+			updated := created.DeepCopy()
+			tt.modify(updated)
+			assert.Error(t, c.Update(ctx, updated))
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Huawei Technologies Co., Ltd.
+// openFuyao is licensed under Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//          http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+// EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+// MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+// See the Mulan PSL v2 for more details.
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CommandAction is what happens to a command line matching a rule
+// +kubebuilder:validation:Enum=Deny;Confirm;Warn
+type CommandAction string
+
+const (
+	// CommandDeny keeps the command from running
+	CommandDeny CommandAction = "Deny"
+	// CommandConfirm runs the command only once the user pressed Enter a second time
+	CommandConfirm CommandAction = "Confirm"
+	// CommandWarn runs the command and warns the user
+	CommandWarn CommandAction = "Warn"
+)
+
+// CommandRule matches the command lines users submit in terminal sessions
+type CommandRule struct {
+	// Name identifies the rule in the messages to the user and in the audit log
+	// +kubebuilder:validation:MinLength=1
+	Name   string        `json:"name"`
+	Action CommandAction `json:"action"`
+	// Pattern is the regular expression, in RE2 syntax, searched for in the command line
+	// +kubebuilder:validation:MinLength=1
+	Pattern string `json:"pattern"`
+	// Groups limits the rule to users in one of the groups, empty applies it to every user
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+	// Namespaces limits the rule to sessions in pods of the namespaces, empty applies it to every session.
+	// Cluster terminals run in openfuyao-system.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Message is shown to the user when the rule matches
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// WebTerminalCommandPolicySpec lists the rules a command line typed in a terminal is checked against before
+// it runs. Lines edited through the shell history or completion, whose text is not known, need confirmation
+// when a Deny or Confirm rule applies to the session.
+type WebTerminalCommandPolicySpec struct {
+	// +kubebuilder:validation:MinItems=1
+	Rules []CommandRule `json:"rules"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// WebTerminalCommandPolicy is the Schema for the webterminalcommandpolicies API
+type WebTerminalCommandPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WebTerminalCommandPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WebTerminalCommandPolicyList contains a list of WebTerminalCommandPolicy
+type WebTerminalCommandPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WebTerminalCommandPolicy `json:"items"`
+}
+
+// Applies reports whether the rule applies to a user in groups in a session in namespace
+func (r *CommandRule) Applies(groups []string, namespace string) bool {
+	return (len(r.Groups) == 0 || containsAny(r.Groups, groups)) &&
+		(len(r.Namespaces) == 0 || containsAny(r.Namespaces, []string{namespace}))
+}
+
+func containsAny(list, values []string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func init() {
+	SchemeBuilder.Register(&WebTerminalCommandPolicy{}, &WebTerminalCommandPolicyList{})
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Huawei Technologies Co., Ltd.
+// openFuyao is licensed under Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//          http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+// EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+// MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+// See the Mulan PSL v2 for more details.
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WebTerminalProfileSpec describes the toolbox environment of cluster terminals started with the profile
+type WebTerminalProfileSpec struct {
+	// Image is the image of the terminal container
+	Image string `json:"image"`
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// VolumeMounts of the terminal container, they refer to Volumes
+	// +optional
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+	// +optional
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+	// InitContainers prepare the environment before the terminal starts
+	// +optional
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// SecurityContext of the terminal container
+	// +optional
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+	// AllowedGroups lists the user groups that may start a terminal with the profile, empty allows every user
+	// +optional
+	AllowedGroups []string `json:"allowedGroups,omitempty"`
+	// SessionTimeout is the number of minutes the terminal pod is kept without activity, see
+	// WebterminalTemplateSpec
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	SessionTimeout int `json:"sessionTimeout,omitempty"`
+	// IdleTimeout disconnects a session of the profile after that long without input, 0 never does.
+	// The idle timeout of the service applies when unset.
+	// +optional
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+	// MaxSessionDuration disconnects a session of the profile once it lasted that long, 0 never does.
+	// The maximum session duration of the service applies when unset.
+	// +optional
+	MaxSessionDuration *metav1.Duration `json:"maxSessionDuration,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// WebTerminalProfile is the Schema for the webterminalprofiles API
+type WebTerminalProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WebTerminalProfileSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WebTerminalProfileList contains a list of WebTerminalProfile
+type WebTerminalProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WebTerminalProfile `json:"items"`
+}
+
+// AllowsGroups reports whether a user in groups may use the profile
+func (p *WebTerminalProfile) AllowsGroups(groups []string) bool {
+	if len(p.Spec.AllowedGroups) == 0 {
+		return true
+	}
+	for _, allowed := range p.Spec.AllowedGroups {
+		for _, group := range groups {
+			if group == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func init() {
+	SchemeBuilder.Register(&WebTerminalProfile{}, &WebTerminalProfileList{})
+}
@@ -11,7 +11,10 @@
 package v1beta1
 
 import (
+	"strings"
+
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -20,11 +23,54 @@ import (
 
 // WebterminalTemplateSpec defines the desired state of WebterminalTemplate
 type WebterminalTemplateSpec struct {
-	DefaultImage   string      `json:"defaultImage,omitempty"`
-	SessionTimeout int         `json:"sessionTimeout,omitempty"`
-	ExistsTime     metav1.Time `json:"existstime,omitempty"`
-	RenewTime      metav1.Time `json:"renewTime,omitempty"`
-	PodTemplate    PodTemplate `json:"podTemplate"`
+	// DefaultImage is the image of the pod template containers that do not name one
+	DefaultImage string `json:"defaultImage,omitempty"`
+	// SessionTimeout is the number of minutes the terminal pod is kept without activity.
+	// 0 uses the default session timeout of the controller.
+	// +kubebuilder:validation:Minimum=0
+	SessionTimeout int `json:"sessionTimeout,omitempty"`
+	// User and Groups are the identity the kubeconfig of the terminal pod acts as, only the web terminal
+	// service may set them. Without a user the kubeconfig carries only the rights of the pod's own service
+	// account. System users and groups are never impersonated.
+	User   string   `json:"user,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	// Home is the persistent home directory of the user, the terminal pod starts with an empty one without it
+	// +optional
+	Home        *HomeVolume `json:"home,omitempty"`
+	ExistsTime  metav1.Time `json:"existstime,omitempty"`
+	RenewTime   metav1.Time `json:"renewTime,omitempty"`
+	PodTemplate PodTemplate `json:"podTemplate"`
+}
+
+// KubeconfigSecretName returns the name of the Secret holding the kubeconfig of the terminal pod
+// of the WebterminalTemplate named name
+func KubeconfigSecretName(name string) string {
+	return name + "-kubeconfig"
+}
+
+// IsSystemIdentity reports whether name is a user or group of Kubernetes itself, such as system:masters
+func IsSystemIdentity(name string) bool {
+	return strings.HasPrefix(name, "system:")
+}
+
+// HomeClaimName returns the name of the PersistentVolumeClaim holding the home directory of the user of the
+// WebterminalTemplate named name
+func HomeClaimName(name string) string {
+	return name + "-home"
+}
+
+// HomeVolume describes the PersistentVolumeClaim the controller provisions for the home directory of a user.
+// The claim outlives the WebterminalTemplate and is removed once it has been unused for the retention period
+// of the controller.
+type HomeVolume struct {
+	// StorageClassName of the claim, the default storage class when empty
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+	// Size requested by the claim
+	Size resource.Quantity `json:"size"`
+	// FSGroup owns the volume so that the terminal user can write to it
+	// +optional
+	FSGroup *int64 `json:"fsGroup,omitempty"`
 }
 
 type PodTemplate struct {
@@ -43,12 +89,20 @@ type PodTemplateSpec struct {
 	Containers     []corev1.Container   `json:"containers"`
 	Volumes        []corev1.Volume      `json:"volumes"`
 	RestartPolicy  corev1.RestartPolicy `json:"restartPolicy"`
+	Tolerations    []corev1.Toleration  `json:"tolerations,omitempty"`
+	NodeSelector   map[string]string    `json:"nodeSelector,omitempty"`
 }
 
 // WebterminalTemplateStatus defines the observed state of WebterminalTemplate
 type WebterminalTemplateStatus struct {
 	Phase      WebTerminalTemplatePhase       `json:"phase,omitempty"`
 	Conditions []WebTerminalTemplateCondition `json:"conditions,omitempty"`
+	// ObservedGeneration is the generation of the spec the status was computed for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastActivityTime is the last time the terminal was used
+	LastActivityTime *metav1.Time `json:"lastActivityTime,omitempty"`
+	// ExpiresAt is when the terminal pod is removed unless it is used before
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
 }
 
 type WebTerminalTemplatePhase string
@@ -56,21 +110,43 @@ type WebTerminalTemplatePhase string
 // valid phase
 const (
 	WebTerminalTemplateStarting WebTerminalTemplatePhase = "Starting"
-	WebTerminalTemplateRunning  WebTerminalTemplatePhase = "Runnings"
+	WebTerminalTemplateRunning  WebTerminalTemplatePhase = "Running"
 	WebTerminalTemplateStopped  WebTerminalTemplatePhase = "Stopped"
 	WebTerminalTemplateStopping WebTerminalTemplatePhase = "Stopping"
 	WebTerminalTemplateError    WebTerminalTemplatePhase = "Error"
 )
 
+// WebTerminalTemplateConditionType names a condition of a WebterminalTemplate
+type WebTerminalTemplateConditionType string
+
+// condition types
+const (
+	// PodScheduled and PodReady mirror the conditions of the terminal pod
+	WebTerminalTemplatePodScheduled WebTerminalTemplateConditionType = "PodScheduled"
+	WebTerminalTemplatePodReady     WebTerminalTemplateConditionType = "PodReady"
+	// KubeconfigReady tells whether the kubeconfig of the terminal pod holds a valid token
+	WebTerminalTemplateKubeconfigReady WebTerminalTemplateConditionType = "KubeconfigReady"
+	// Expiring is true when the terminal pod is removed soon for lack of activity
+	WebTerminalTemplateExpiring WebTerminalTemplateConditionType = "Expiring"
+)
+
 type WebTerminalTemplateCondition struct {
-	Status             corev1.ConditionStatus `json:"status"`
-	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
-	Reason             string                 `json:"reason,omitempty"`
-	Message            string                 `json:"message,omitempty"`
+	Type               WebTerminalTemplateConditionType `json:"type"`
+	Status             corev1.ConditionStatus           `json:"status"`
+	LastTransitionTime metav1.Time                      `json:"lastTransitionTime,omitempty"`
+	Reason             string                           `json:"reason,omitempty"`
+	Message            string                           `json:"message,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="User",type=string,JSONPath=`.spec.user`
+//+kubebuilder:printcolumn:name="Profile",type=string,JSONPath=`.metadata.labels.terminal\.openfuyao\.com/profile`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="PodReady")].status`
+//+kubebuilder:printcolumn:name="Last Activity",type=date,JSONPath=`.status.lastActivityTime`
+//+kubebuilder:printcolumn:name="Expires At",type=string,JSONPath=`.status.expiresAt`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // WebterminalTemplate is the Schema for the webterminaltemplates API
 type WebterminalTemplate struct {
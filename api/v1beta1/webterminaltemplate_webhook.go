@@ -0,0 +1,236 @@
+// Copyright (c) 2024 Huawei Technologies Co., Ltd.
+// openFuyao is licensed under Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//          http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+// EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+// MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+// See the Mulan PSL v2 for more details.
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// TemplateLabel is set on the pod of a WebterminalTemplate, its value is the name of the template
+	TemplateLabel = "terminal.openfuyao.com/template"
+	// ManagedByLabel marks the pods of WebterminalTemplates as managed by the web terminal service
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ManagedBy is the value of ManagedByLabel
+	ManagedBy = "web-terminal-service"
+)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks of WebterminalTemplates.
+// allowedImages restricts the images of terminal containers, serviceUser is the user of the service, see
+// WebterminalTemplateValidator.
+func (r *WebterminalTemplate) SetupWebhookWithManager(mgr ctrl.Manager, allowedImages []string,
+	serviceUser string) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&WebterminalTemplateDefaulter{}).
+		WithValidator(&WebterminalTemplateValidator{AllowedImages: allowedImages, ServiceUser: serviceUser}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-terminal-openfuyao-com-v1beta1-webterminaltemplate,mutating=true,failurePolicy=fail,sideEffects=None,groups=terminal.openfuyao.com,resources=webterminaltemplates,verbs=create;update,versions=v1beta1,name=mwebterminaltemplate.kb.io,admissionReviewVersions=v1
+
+// WebterminalTemplateDefaulter fills the pod template of a WebterminalTemplate from the template itself
+// +kubebuilder:object:generate=false
+type WebterminalTemplateDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &WebterminalTemplateDefaulter{}
+
+// Default places the pod next to the template, labels it and restricts the privileges of its containers
+func (d *WebterminalTemplateDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*WebterminalTemplate)
+	if !ok {
+		return fmt.Errorf("expected a WebterminalTemplate but got a %T", obj)
+	}
+	meta := &r.Spec.PodTemplate.ObjectMeta
+	if meta.Name == "" {
+		meta.Name = r.Name
+	}
+	if meta.Namespace == "" {
+		meta.Namespace = r.Namespace
+	}
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	meta.Labels[TemplateLabel] = r.Name
+	meta.Labels[ManagedByLabel] = ManagedBy
+
+	spec := &r.Spec.PodTemplate.Spec
+	if spec.RestartPolicy == "" {
+		spec.RestartPolicy = corev1.RestartPolicyAlways
+	}
+	for i := range spec.InitContainers {
+		defaultSecurityContext(&spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		defaultSecurityContext(&spec.Containers[i])
+	}
+	return nil
+}
+
+// defaultSecurityContext forbids privilege escalation and applies the runtime seccomp profile unless the
+// container decides otherwise
+func defaultSecurityContext(container *corev1.Container) {
+	if container.SecurityContext == nil {
+		container.SecurityContext = &corev1.SecurityContext{}
+	}
+	sc := container.SecurityContext
+	if sc.AllowPrivilegeEscalation == nil && (sc.Privileged == nil || !*sc.Privileged) {
+		allow := false
+		sc.AllowPrivilegeEscalation = &allow
+	}
+	if sc.SeccompProfile == nil {
+		sc.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-terminal-openfuyao-com-v1beta1-webterminaltemplate,mutating=false,failurePolicy=fail,sideEffects=None,groups=terminal.openfuyao.com,resources=webterminaltemplates,verbs=create;update,versions=v1beta1,name=vwebterminaltemplate.kb.io,admissionReviewVersions=v1
+
+// WebterminalTemplateValidator rejects WebterminalTemplates whose pod would reach into the node it runs on,
+// or whose kubeconfig would act as a user nobody vouched for
+// +kubebuilder:object:generate=false
+type WebterminalTemplateValidator struct {
+	// AllowedImages are the images terminal containers may run, any image when empty.
+	// An entry ending with "/" allows every repository below it, other entries allow one repository
+	// with any tag or digest.
+	AllowedImages []string
+	// ServiceUser is the user the web terminal service acts as. The kubeconfig of a terminal pod impersonates
+	// the user and groups of its template, so only ServiceUser may write templates setting them. Nobody may
+	// when it is empty.
+	ServiceUser string
+}
+
+var _ webhook.CustomValidator = &WebterminalTemplateValidator{}
+
+// ValidateCreate validates a new WebterminalTemplate
+func (v *WebterminalTemplateValidator) ValidateCreate(ctx context.Context,
+	obj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(ctx, obj)
+}
+
+// ValidateUpdate validates the new version of a WebterminalTemplate
+func (v *WebterminalTemplateValidator) ValidateUpdate(ctx context.Context,
+	oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(ctx, newObj)
+}
+
+// ValidateDelete accepts every deletion
+func (v *WebterminalTemplateValidator) ValidateDelete(ctx context.Context,
+	obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *WebterminalTemplateValidator) validate(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*WebterminalTemplate)
+	if !ok {
+		return fmt.Errorf("expected a WebterminalTemplate but got a %T", obj)
+	}
+	var errs field.ErrorList
+	podTemplate := field.NewPath("spec", "podTemplate")
+	meta := podTemplate.Child("objectmeta")
+	if r.Spec.PodTemplate.ObjectMeta.Name != r.Name {
+		errs = append(errs, field.Invalid(meta.Child("name"), r.Spec.PodTemplate.ObjectMeta.Name,
+			"must be the name of the WebterminalTemplate"))
+	}
+	if r.Spec.PodTemplate.ObjectMeta.Namespace != r.Namespace {
+		errs = append(errs, field.Invalid(meta.Child("namespace"), r.Spec.PodTemplate.ObjectMeta.Namespace,
+			"must be the namespace of the WebterminalTemplate"))
+	}
+	spec := podTemplate.Child("spec")
+	for i, volume := range r.Spec.PodTemplate.Spec.Volumes {
+		if volume.HostPath != nil {
+			errs = append(errs, field.Forbidden(spec.Child("volumes").Index(i).Child("hostPath"),
+				"hostPath volumes are not allowed"))
+		}
+	}
+	if r.Spec.DefaultImage != "" && !v.imageAllowed(r.Spec.DefaultImage) {
+		errs = append(errs, field.NotSupported(field.NewPath("spec", "defaultImage"), r.Spec.DefaultImage,
+			v.AllowedImages))
+	}
+	errs = append(errs, v.validateContainers(spec.Child("initContainers"),
+		r.Spec.PodTemplate.Spec.InitContainers)...)
+	errs = append(errs, v.validateContainers(spec.Child("containers"), r.Spec.PodTemplate.Spec.Containers)...)
+	errs = append(errs, v.validateIdentity(ctx, r)...)
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("WebterminalTemplate").GroupKind(), r.Name, errs)
+}
+
+// validateContainers rejects privileged containers, containers outside the image allowlist and host ports.
+// The pod template has no hostNetwork field, host ports are the only way it could bind to the node network.
+func (v *WebterminalTemplateValidator) validateContainers(path *field.Path,
+	containers []corev1.Container) field.ErrorList {
+	var errs field.ErrorList
+	for i, container := range containers {
+		if sc := container.SecurityContext; sc != nil && sc.Privileged != nil && *sc.Privileged {
+			errs = append(errs, field.Forbidden(path.Index(i).Child("securityContext", "privileged"),
+				"privileged containers are not allowed"))
+		}
+		if container.Image != "" && !v.imageAllowed(container.Image) {
+			errs = append(errs, field.NotSupported(path.Index(i).Child("image"), container.Image, v.AllowedImages))
+		}
+		for j, port := range container.Ports {
+			if port.HostPort != 0 {
+				errs = append(errs, field.Forbidden(path.Index(i).Child("ports").Index(j).Child("hostPort"),
+					"host networking is not allowed"))
+			}
+		}
+	}
+	return errs
+}
+
+// validateIdentity rejects templates setting the user or the groups of their kubeconfig unless the service
+// wrote them, and system users and groups, which the service never impersonates
+func (v *WebterminalTemplateValidator) validateIdentity(ctx context.Context, r *WebterminalTemplate) field.ErrorList {
+	if r.Spec.User == "" && len(r.Spec.Groups) == 0 {
+		return nil
+	}
+	var errs field.ErrorList
+	user, groups := field.NewPath("spec", "user"), field.NewPath("spec", "groups")
+	if req, err := admission.RequestFromContext(ctx); err != nil || v.ServiceUser == "" ||
+		req.UserInfo.Username != v.ServiceUser {
+		errs = append(errs, field.Forbidden(user, "only the web terminal service may set the user and groups"))
+	}
+	if IsSystemIdentity(r.Spec.User) {
+		errs = append(errs, field.Forbidden(user, "system users may not be impersonated"))
+	}
+	for i, group := range r.Spec.Groups {
+		if IsSystemIdentity(group) {
+			errs = append(errs, field.Forbidden(groups.Index(i), "system groups may not be impersonated"))
+		}
+	}
+	return errs
+}
+
+func (v *WebterminalTemplateValidator) imageAllowed(image string) bool {
+	if len(v.AllowedImages) == 0 {
+		return true
+	}
+	for _, allowed := range v.AllowedImages {
+		if strings.HasSuffix(allowed, "/") && strings.HasPrefix(image, allowed) {
+			return true
+		}
+		if image == allowed || strings.HasPrefix(image, allowed+":") || strings.HasPrefix(image, allowed+"@") {
+			return true
+		}
+	}
+	return false
+}
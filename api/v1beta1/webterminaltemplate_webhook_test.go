@@ -0,0 +1,198 @@
+// Copyright (c) 2024 Huawei Technologies Co., Ltd.
+// openFuyao is licensed under Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//          http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+// EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+// MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+// See the Mulan PSL v2 for more details.
+
+package v1beta1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newWebhookTemplate() *WebterminalTemplate {
+	return &WebterminalTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: "openfuyao-system"},
+		Spec: WebterminalTemplateSpec{
+			DefaultImage: "cr.openfuyao.cn/openfuyao/kubectl-openfuyao:latest",
+			PodTemplate: PodTemplate{
+				Spec: PodTemplateSpec{
+					Containers: []corev1.Container{{Name: "terminal"}},
+				},
+			},
+		},
+	}
+}
+
+func TestWebterminalTemplateDefaulter(t *testing.T) {
+	privileged := true
+	obj := newWebhookTemplate()
+	obj.Spec.PodTemplate.ObjectMeta.Labels = map[string]string{"team": "sre"}
+	obj.Spec.PodTemplate.Spec.InitContainers = []corev1.Container{{Name: "init",
+		SecurityContext: &corev1.SecurityContext{Privileged: &privileged}}}
+	require.NoError(t, (&WebterminalTemplateDefaulter{}).Default(context.Background(), obj))
+
+	meta := obj.Spec.PodTemplate.ObjectMeta
+	assert.Equal(t, "alice", meta.Name)
+	assert.Equal(t, "openfuyao-system", meta.Namespace)
+	assert.Equal(t, map[string]string{"team": "sre", TemplateLabel: "alice", ManagedByLabel: ManagedBy}, meta.Labels)
+	assert.Equal(t, corev1.RestartPolicyAlways, obj.Spec.PodTemplate.Spec.RestartPolicy)
+
+	sc := obj.Spec.PodTemplate.Spec.Containers[0].SecurityContext
+	require.NotNil(t, sc)
+	require.NotNil(t, sc.AllowPrivilegeEscalation)
+	assert.False(t, *sc.AllowPrivilegeEscalation)
+	assert.Equal(t, corev1.SeccompProfileTypeRuntimeDefault, sc.SeccompProfile.Type)
+	// privileged containers are left to the validating webhook
+	assert.Nil(t, obj.Spec.PodTemplate.Spec.InitContainers[0].SecurityContext.AllowPrivilegeEscalation)
+
+	// the pod template of the user is kept
+	obj.Spec.PodTemplate.ObjectMeta.Name = "bob"
+	obj.Spec.PodTemplate.Spec.RestartPolicy = corev1.RestartPolicyNever
+	require.NoError(t, (&WebterminalTemplateDefaulter{}).Default(context.Background(), obj))
+	assert.Equal(t, "bob", obj.Spec.PodTemplate.ObjectMeta.Name)
+	assert.Equal(t, corev1.RestartPolicyNever, obj.Spec.PodTemplate.Spec.RestartPolicy)
+
+	assert.Error(t, (&WebterminalTemplateDefaulter{}).Default(context.Background(), &WebTerminalProfile{}))
+}
+
+func TestWebterminalTemplateValidator(t *testing.T) {
+	privileged := true
+	tests := []struct {
+		name          string
+		allowedImages []string
+		modify        func(obj *WebterminalTemplate)
+		wantFields    []string
+	}{
+		{name: "valid", modify: func(obj *WebterminalTemplate) {}},
+		{name: "pod in another namespace", modify: func(obj *WebterminalTemplate) {
+			obj.Spec.PodTemplate.ObjectMeta.Namespace = "kube-system"
+		}, wantFields: []string{"spec.podTemplate.objectmeta.namespace"}},
+		{name: "pod of another name", modify: func(obj *WebterminalTemplate) {
+			obj.Spec.PodTemplate.ObjectMeta.Name = "bob"
+		}, wantFields: []string{"spec.podTemplate.objectmeta.name"}},
+		{name: "hostPath volume", modify: func(obj *WebterminalTemplate) {
+			obj.Spec.PodTemplate.Spec.Volumes = []corev1.Volume{{Name: "root", VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: "/"}}}}
+		}, wantFields: []string{"spec.podTemplate.spec.volumes[0].hostPath"}},
+		{name: "privileged init container", modify: func(obj *WebterminalTemplate) {
+			obj.Spec.PodTemplate.Spec.InitContainers = []corev1.Container{{Name: "init",
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged}}}
+		}, wantFields: []string{"spec.podTemplate.spec.initContainers[0].securityContext.privileged"}},
+		{name: "host port", modify: func(obj *WebterminalTemplate) {
+			obj.Spec.PodTemplate.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 22, HostPort: 22}}
+		}, wantFields: []string{"spec.podTemplate.spec.containers[0].ports[0].hostPort"}},
+		{name: "allowed registry", allowedImages: []string{"cr.openfuyao.cn/openfuyao/"},
+			modify: func(obj *WebterminalTemplate) {
+				obj.Spec.PodTemplate.Spec.Containers[0].Image = "cr.openfuyao.cn/openfuyao/busybox:1.36.1"
+			}},
+		{name: "allowed repository", allowedImages: []string{"cr.openfuyao.cn/openfuyao/kubectl-openfuyao"},
+			modify: func(obj *WebterminalTemplate) {}},
+		{name: "images outside the allowlist",
+			allowedImages: []string{"cr.openfuyao.cn/openfuyao/kubectl", "docker.io/library/"},
+			modify: func(obj *WebterminalTemplate) {
+				obj.Spec.PodTemplate.Spec.Containers[0].Image = "docker.io/attacker/busybox"
+			}, wantFields: []string{"spec.defaultImage", "spec.podTemplate.spec.containers[0].image"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := newWebhookTemplate()
+			require.NoError(t, (&WebterminalTemplateDefaulter{}).Default(context.Background(), obj))
+			tt.modify(obj)
+			v := &WebterminalTemplateValidator{AllowedImages: tt.allowedImages}
+			_, createErr := v.ValidateCreate(context.Background(), obj)
+			_, updateErr := v.ValidateUpdate(context.Background(), newWebhookTemplate(), obj)
+			if len(tt.wantFields) == 0 {
+				assert.NoError(t, createErr)
+				assert.NoError(t, updateErr)
+				return
+			}
+			require.True(t, apierrors.IsInvalid(createErr), "%v", createErr)
+			assert.Equal(t, createErr, updateErr)
+			var fields []string
+			for _, cause := range createErr.(apierrors.APIStatus).Status().Details.Causes {
+				fields = append(fields, cause.Field)
+			}
+			assert.Equal(t, tt.wantFields, fields)
+		})
+	}
+}
+
+func TestWebterminalTemplateValidatorIdentity(t *testing.T) {
+	const service = "system:serviceaccount:openfuyao-system:web-terminal-service"
+	tests := []struct {
+		name        string
+		requester   string
+		serviceUser string
+		user        string
+		groups      []string
+		wantFields  []string
+	}{
+		{name: "no identity", requester: "mallory", serviceUser: service},
+		{name: "written by the service", requester: service, serviceUser: service, user: "alice",
+			groups: []string{"sre"}},
+		{name: "user written by another user", requester: "mallory", serviceUser: service, user: "alice",
+			wantFields: []string{"spec.user"}},
+		{name: "groups written by another user", requester: "mallory", serviceUser: service,
+			groups: []string{"sre"}, wantFields: []string{"spec.user"}},
+		{name: "no service user", requester: service, user: "alice", wantFields: []string{"spec.user"}},
+		{name: "system user", requester: service, serviceUser: service, user: "system:admin",
+			wantFields: []string{"spec.user"}},
+		{name: "system group", requester: service, serviceUser: service, user: "alice",
+			groups: []string{"sre", "system:masters"}, wantFields: []string{"spec.groups[1]"}},
+		{name: "system group of another user", requester: "mallory", serviceUser: service, user: "alice",
+			groups: []string{"system:masters"}, wantFields: []string{"spec.user", "spec.groups[0]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := newWebhookTemplate()
+			require.NoError(t, (&WebterminalTemplateDefaulter{}).Default(context.Background(), obj))
+			obj.Spec.User, obj.Spec.Groups = tt.user, tt.groups
+			ctx := admission.NewContextWithRequest(context.Background(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{UserInfo: authenticationv1.UserInfo{
+					Username: tt.requester}}})
+			v := &WebterminalTemplateValidator{ServiceUser: tt.serviceUser}
+			_, createErr := v.ValidateCreate(ctx, obj)
+			_, updateErr := v.ValidateUpdate(ctx, obj, obj)
+			if len(tt.wantFields) == 0 {
+				assert.NoError(t, createErr)
+				assert.NoError(t, updateErr)
+				return
+			}
+			require.True(t, apierrors.IsInvalid(createErr), "%v", createErr)
+			assert.Equal(t, createErr, updateErr)
+			var fields []string
+			for _, cause := range createErr.(apierrors.APIStatus).Status().Details.Causes {
+				fields = append(fields, cause.Field)
+			}
+			assert.Equal(t, tt.wantFields, fields)
+		})
+	}
+
+	// requests without admission info, which the webhook server always attaches, set no identity
+	obj := newWebhookTemplate()
+	obj.Spec.User = "alice"
+	_, err := (&WebterminalTemplateValidator{ServiceUser: service}).ValidateCreate(context.Background(), obj)
+	assert.Error(t, err)
+}
+
+func TestWebterminalTemplateValidatorDelete(t *testing.T) {
+	obj := newWebhookTemplate()
+	obj.Spec.PodTemplate.ObjectMeta.Namespace = "kube-system"
+	_, err := (&WebterminalTemplateValidator{}).ValidateDelete(context.Background(), obj)
+	assert.NoError(t, err)
+}
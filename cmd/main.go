@@ -19,9 +19,13 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -36,10 +40,14 @@ import (
 	terminalv1beta1 "openfuyao.com/web-terminal-service/api/v1beta1"
 	"openfuyao.com/web-terminal-service/internal/controller"
 	v1 "openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1"
+	"openfuyao.com/web-terminal-service/pkg/tracing"
 	"openfuyao.com/web-terminal-service/pkg/zlog"
 	//+kubebuilder:scaffold:imports
 )
 
+// tracingShutdownTimeout bounds the export of the spans still pending on exit
+const tracingShutdownTimeout = 5 * time.Second
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -58,6 +66,10 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var sessionTimeout time.Duration
+	var tokenTTL time.Duration
+	var homeRetention time.Duration
+	var allowedImages string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -67,6 +79,15 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.DurationVar(&sessionTimeout, "default-session-timeout", controller.DefaultSessionTimeout,
+		"How long a terminal pod is kept without activity when its template sets no session timeout")
+	flag.DurationVar(&tokenTTL, "kubeconfig-token-ttl", controller.DefaultTokenTTL,
+		"Lifetime of the tokens in the kubeconfigs of terminal pods, they are renewed before they expire")
+	flag.DurationVar(&homeRetention, "home-retention", controller.DefaultHomeRetention,
+		"How long the home directory of a user is kept after their terminal pod was removed, 0 keeps it forever")
+	flag.StringVar(&allowedImages, "allowed-images", "",
+		"Comma separated images terminal pods may run, entries ending with / allow a whole registry path. "+
+			"Empty allows any image")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -75,6 +96,24 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	tracingCfg := tracing.NewTracingCfg()
+	if errs := tracingCfg.Validate(); len(errs) > 0 {
+		setupLog.Error(errors.Join(errs...), "invalid tracing config")
+		os.Exit(1)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingCfg)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			setupLog.Error(err, "unable to export the pending traces")
+		}
+	}()
+
 	disableHTTP2 := func(c *tls.Config) {
 		setupLog.Info("disabling http/2")
 		c.NextProtos = []string{"http/1.1"}
@@ -106,19 +145,49 @@ func main() {
 		os.Exit(1)
 	}
 
+	apiServer, caData, err := controller.KubeconfigTarget(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to locate the API server for terminal kubeconfigs")
+		os.Exit(1)
+	}
+	// webhooks are on by default, both install paths mount their serving certificate
+	enableWebhooks := os.Getenv("ENABLE_WEBHOOKS") != "false"
+	user := serviceUser()
+	// the webhook keeps users from naming whom their terminal impersonates, without it nobody is impersonated
+	impersonate := enableWebhooks && user != ""
+	if !impersonate {
+		setupLog.Info("admission webhook or service account unknown, terminal kubeconfigs impersonate nobody")
+	}
 	if err = (&controller.WebterminalTemplateReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:         mgr.GetClient(),
+		Scheme:         mgr.GetScheme(),
+		Recorder:       mgr.GetEventRecorderFor("webterminaltemplate-controller"),
+		SessionTimeout: sessionTimeout,
+		APIServer:      apiServer,
+		CAData:         caData,
+		TokenTTL:       tokenTTL,
+		HomeRetention:  homeRetention,
+		Impersonate:    impersonate,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "WebterminalTemplate")
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		if user == "" {
+			setupLog.Info("POD_NAMESPACE or SERVICE_ACCOUNT_NAME unset, rejecting templates that impersonate users")
+		}
+		if err = (&terminalv1beta1.WebterminalTemplate{}).SetupWebhookWithManager(mgr,
+			splitList(allowedImages), user); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "WebterminalTemplate")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	defer zlog.Sync()
 	zlog.LogInfo("Hello, openFuyao!")
 
-	go v1.StartAPIServer(mgr.GetClient())
+	go v1.StartAPIServer(mgr)
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
@@ -135,3 +204,24 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// serviceUser returns the user the service account of this pod authenticates as, empty when the pod does
+// not tell it in POD_NAMESPACE and SERVICE_ACCOUNT_NAME
+func serviceUser() string {
+	namespace, name := os.Getenv("POD_NAMESPACE"), os.Getenv("SERVICE_ACCOUNT_NAME")
+	if namespace == "" || name == "" {
+		return ""
+	}
+	return "system:serviceaccount:" + namespace + ":" + name
+}
+
+// splitList returns the non-empty entries of a comma separated list
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/tracing"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	// HomeLabel marks the home directory claims of terminal users, its value is the name of the template
+	HomeLabel = "terminal.openfuyao.com/home"
+	// DefaultHomeRetention is how long a home directory is kept after its terminal was removed
+	DefaultHomeRetention = 30 * 24 * time.Hour
+
+	homeReleasedAnnotation = "terminal.openfuyao.com/released-at"
+	homeCollectPeriod      = 10 * time.Minute
+)
+
+//+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+
+// ensureHome provisions the home directory claim of the template. The claim is not owned by the template,
+// it keeps the files of the user once the terminal pod is gone.
+func (r *WebterminalTemplateReconciler) ensureHome(ctx context.Context, obj *v1beta1.WebterminalTemplate) error {
+	ctx, span := tracing.Start(ctx, "ensureHome")
+	defer span.End()
+	if obj.Spec.Home == nil {
+		return nil
+	}
+	claim := &corev1.PersistentVolumeClaim{}
+	key := client.ObjectKey{Name: v1beta1.HomeClaimName(obj.Name), Namespace: obj.Namespace}
+	err := r.Get(ctx, key, claim)
+	if errors.IsNotFound(err) {
+		claim = newHomeClaim(key, obj)
+		if err = r.Create(ctx, claim); err != nil {
+			zlog.LogErrorf("Failed to create home directory of %s: %v", obj.Name, err)
+			return err
+		}
+		zlog.LogInfof("Created home directory %s", key.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !claim.DeletionTimestamp.IsZero() {
+		return fmt.Errorf("home directory %s is being deleted", key.Name)
+	}
+	if _, released := claim.Annotations[homeReleasedAnnotation]; released {
+		delete(claim.Annotations, homeReleasedAnnotation)
+		if err = r.Update(ctx, claim); err != nil {
+			zlog.LogErrorf("Failed to reclaim home directory of %s: %v", obj.Name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func newHomeClaim(key client.ObjectKey, obj *v1beta1.WebterminalTemplate) *corev1.PersistentVolumeClaim {
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels:    map[string]string{HomeLabel: obj.Name},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: obj.Spec.Home.Size},
+			},
+		},
+	}
+	if obj.Spec.Home.StorageClassName != "" {
+		storageClass := obj.Spec.Home.StorageClassName
+		claim.Spec.StorageClassName = &storageClass
+	}
+	return claim
+}
+
+// releaseHome starts the retention period of the home directory of the removed template
+func (r *WebterminalTemplateReconciler) releaseHome(ctx context.Context, obj *v1beta1.WebterminalTemplate) error {
+	claim := &corev1.PersistentVolumeClaim{}
+	key := client.ObjectKey{Name: v1beta1.HomeClaimName(obj.Name), Namespace: obj.Namespace}
+	if err := r.Get(ctx, key, claim); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if err := r.markReleased(ctx, claim); err != nil {
+		zlog.LogErrorf("Failed to release home directory of %s: %v", obj.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (r *WebterminalTemplateReconciler) markReleased(ctx context.Context, claim *corev1.PersistentVolumeClaim) error {
+	if _, released := claim.Annotations[homeReleasedAnnotation]; released {
+		return nil
+	}
+	if claim.Annotations == nil {
+		claim.Annotations = map[string]string{}
+	}
+	claim.Annotations[homeReleasedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	return r.Update(ctx, claim)
+}
+
+// collectHomes deletes the home directories that have not been used by a terminal for the retention period
+func (r *WebterminalTemplateReconciler) collectHomes(ctx context.Context) {
+	claims := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, claims, client.HasLabels{HomeLabel}); err != nil {
+		zlog.LogErrorf("Failed to list home directories: %v", err)
+		return
+	}
+	for i := range claims.Items {
+		claim := &claims.Items[i]
+		if err := r.collectHome(ctx, claim); err != nil {
+			zlog.LogErrorf("Failed to collect home directory %s/%s: %v", claim.Namespace, claim.Name, err)
+		}
+	}
+}
+
+func (r *WebterminalTemplateReconciler) collectHome(ctx context.Context, claim *corev1.PersistentVolumeClaim) error {
+	key := client.ObjectKey{Name: claim.Labels[HomeLabel], Namespace: claim.Namespace}
+	err := r.Get(ctx, key, &v1beta1.WebterminalTemplate{})
+	if err == nil {
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	releasedAt, err := time.Parse(time.RFC3339, claim.Annotations[homeReleasedAnnotation])
+	if err != nil {
+		// the template went away without releasing its home, the retention period starts now
+		return r.markReleased(ctx, claim)
+	}
+	if time.Since(releasedAt) < r.HomeRetention {
+		return nil
+	}
+	if err = r.Delete(ctx, claim); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	zlog.LogInfof("Deleted home directory %s/%s unused since %s", claim.Namespace, claim.Name,
+		releasedAt.Format(time.RFC3339))
+	return nil
+}
+
+// homeSecurityContext returns the security context of the pod of obj, letting it write to its home directory
+func homeSecurityContext(obj *v1beta1.WebterminalTemplate) *corev1.PodSecurityContext {
+	if obj.Spec.Home == nil || obj.Spec.Home.FSGroup == nil {
+		return nil
+	}
+	fsGroup := *obj.Spec.Home.FSGroup
+	changePolicy := corev1.FSGroupChangeOnRootMismatch
+	return &corev1.PodSecurityContext{FSGroup: &fsGroup, FSGroupChangePolicy: &changePolicy}
+}
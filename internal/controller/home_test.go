@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+)
+
+func newHomeTemplate(name string) *v1beta1.WebterminalTemplate {
+	fsGroup := int64(65532)
+	return &v1beta1.WebterminalTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1beta1.WebterminalTemplateSpec{Home: &v1beta1.HomeVolume{StorageClassName: "ssd",
+			Size: resource.MustParse("1Gi"), FSGroup: &fsGroup}},
+	}
+}
+
+func newHomeReconciler(objs ...client.Object) *WebterminalTemplateReconciler {
+	c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objs...).Build()
+	return &WebterminalTemplateReconciler{Client: c, Scheme: c.Scheme(), HomeRetention: time.Hour}
+}
+
+func getHome(t *testing.T, r *WebterminalTemplateReconciler, name string) *corev1.PersistentVolumeClaim {
+	claim := &corev1.PersistentVolumeClaim{}
+	err := r.Get(context.Background(), client.ObjectKey{Name: v1beta1.HomeClaimName(name), Namespace: "default"}, claim)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	return claim
+}
+
+func TestEnsureHome(t *testing.T) {
+	ctx := context.Background()
+	obj := newHomeTemplate("openfuyao-alice")
+	r := newHomeReconciler(obj)
+	require.NoError(t, r.ensureHome(ctx, obj))
+
+	claim := getHome(t, r, obj.Name)
+	require.NotNil(t, claim)
+	assert.Equal(t, obj.Name, claim.Labels[HomeLabel])
+	assert.Empty(t, claim.OwnerReferences)
+	assert.Equal(t, "ssd", *claim.Spec.StorageClassName)
+	assert.True(t, resource.MustParse("1Gi").Equal(claim.Spec.Resources.Requests[corev1.ResourceStorage]))
+
+	// the claim survives the template and is reclaimed by its next terminal
+	require.NoError(t, r.releaseHome(ctx, obj))
+	assert.Contains(t, getHome(t, r, obj.Name).Annotations, homeReleasedAnnotation)
+	require.NoError(t, r.ensureHome(ctx, obj))
+	assert.NotContains(t, getHome(t, r, obj.Name).Annotations, homeReleasedAnnotation)
+
+	// templates without a home get no claim
+	plain := &v1beta1.WebterminalTemplate{ObjectMeta: metav1.ObjectMeta{Name: "openfuyao-bob", Namespace: "default"}}
+	require.NoError(t, r.ensureHome(ctx, plain))
+	assert.Nil(t, getHome(t, r, plain.Name))
+	require.NoError(t, r.releaseHome(ctx, plain))
+}
+
+func TestCollectHomes(t *testing.T) {
+	ctx := context.Background()
+	releasedClaim := func(name string, releasedAt time.Time) *corev1.PersistentVolumeClaim {
+		claim := newHomeClaim(client.ObjectKey{Name: v1beta1.HomeClaimName(name), Namespace: "default"},
+			newHomeTemplate(name))
+		if !releasedAt.IsZero() {
+			claim.Annotations = map[string]string{homeReleasedAnnotation: releasedAt.UTC().Format(time.RFC3339)}
+		}
+		return claim
+	}
+	active := newHomeTemplate("openfuyao-active")
+	r := newHomeReconciler(active,
+		releasedClaim("openfuyao-active", time.Now().Add(-2*time.Hour)),
+		releasedClaim("openfuyao-expired", time.Now().Add(-2*time.Hour)),
+		releasedClaim("openfuyao-recent", time.Now().Add(-time.Minute)),
+		releasedClaim("openfuyao-orphan", time.Time{}),
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"}},
+	)
+	r.collectHomes(ctx)
+
+	assert.NotNil(t, getHome(t, r, "openfuyao-active"))
+	assert.Nil(t, getHome(t, r, "openfuyao-expired"))
+	assert.NotNil(t, getHome(t, r, "openfuyao-recent"))
+	orphan := getHome(t, r, "openfuyao-orphan")
+	require.NotNil(t, orphan)
+	assert.Contains(t, orphan.Annotations, homeReleasedAnnotation)
+	assert.NoError(t, r.Get(ctx, client.ObjectKey{Name: "data", Namespace: "default"}, &corev1.PersistentVolumeClaim{}))
+}
+
+func TestHomeSecurityContext(t *testing.T) {
+	assert.Nil(t, homeSecurityContext(&v1beta1.WebterminalTemplate{}))
+	got := homeSecurityContext(newHomeTemplate("openfuyao-alice"))
+	require.NotNil(t, got)
+	assert.Equal(t, int64(65532), *got.FSGroup)
+	assert.Equal(t, corev1.FSGroupChangeOnRootMismatch, *got.FSGroupChangePolicy)
+}
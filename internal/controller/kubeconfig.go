@@ -0,0 +1,301 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/tracing"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	// KubeconfigKey is the key of the kubeconfig in its Secret
+	KubeconfigKey = "config"
+	// DefaultTokenTTL is the lifetime of the tokens in user kubeconfigs
+	DefaultTokenTTL = time.Hour
+
+	tokenExpiryAnnotation = "terminal.openfuyao.com/token-expires-at"
+	impersonatorPrefix    = "webterminal-impersonate-"
+	accountPrefix         = "webterminal-kubeconfig-"
+	kubeconfigName        = "webterminal"
+	// refreshFraction of the token lifetime is left when the token is renewed
+	refreshFraction = 4
+)
+
+//+kubebuilder:rbac:groups="",resources=serviceaccounts;secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=serviceaccounts/token,verbs=create
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;patch;delete;bind;escalate
+
+// KubeconfigTarget returns the API server address and CA bundle written into user kubeconfigs
+func KubeconfigTarget(config *rest.Config) (string, []byte, error) {
+	caData := config.CAData
+	if len(caData) == 0 && config.CAFile != "" {
+		var err error
+		if caData, err = os.ReadFile(config.CAFile); err != nil {
+			return "", nil, fmt.Errorf("reading API server CA: %w", err)
+		}
+	}
+	return config.Host, caData, nil
+}
+
+func (r *WebterminalTemplateReconciler) tokenTTL() time.Duration {
+	if r.TokenTTL > 0 {
+		return r.TokenTTL
+	}
+	return DefaultTokenTTL
+}
+
+// ensureKubeconfig provisions the service account of the terminal pod and keeps the kubeconfig Secret
+// mounted into the pod supplied with a fresh token. Everything it provisions is owned by obj, objects of
+// the same names that obj does not own are never taken over. The kubeconfig acts as the user of the template,
+// the service account may impersonate that user and nobody else. It returns when the token has to be
+// renewed, the zero time when kubeconfigs are not provisioned.
+func (r *WebterminalTemplateReconciler) ensureKubeconfig(ctx context.Context,
+	obj *v1beta1.WebterminalTemplate) (time.Time, error) {
+	ctx, span := tracing.Start(ctx, "ensureKubeconfig")
+	defer span.End()
+	if r.APIServer == "" {
+		return time.Time{}, nil
+	}
+	account := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: accountPrefix + obj.Name,
+		Namespace: obj.Namespace}}
+	if err := r.provision(ctx, obj, account, func() error { return nil }); err != nil {
+		zlog.LogErrorf("Failed to provision service account of %s: %v", obj.Name, err)
+		return time.Time{}, err
+	}
+	if err := r.ensureImpersonation(ctx, obj); err != nil {
+		zlog.LogErrorf("Failed to grant impersonation to %s: %v", obj.Name, err)
+		return time.Time{}, err
+	}
+
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Name: v1beta1.KubeconfigSecretName(obj.Name), Namespace: obj.Namespace}
+	err := r.Get(ctx, key, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return time.Time{}, err
+	}
+	if err == nil {
+		if renewAt := r.tokenRenewal(secret); time.Now().Before(renewAt) {
+			return renewAt, nil
+		}
+	}
+
+	token, expiresAt, err := r.requestToken(ctx, account)
+	if err != nil {
+		zlog.LogErrorf("Failed to request token for %s: %v", obj.Name, err)
+		return time.Time{}, err
+	}
+	kubeconfig, err := r.renderKubeconfig(obj, token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	secret = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	err = r.provision(ctx, obj, secret, func() error {
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[tokenExpiryAnnotation] = expiresAt.UTC().Format(time.RFC3339)
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = map[string][]byte{KubeconfigKey: kubeconfig}
+		return nil
+	})
+	if err != nil {
+		zlog.LogErrorf("Failed to save kubeconfig of %s: %v", obj.Name, err)
+		return time.Time{}, err
+	}
+	zlog.LogInfof("Issued kubeconfig of %s valid until %s", obj.Name, expiresAt.Format(time.RFC3339))
+	return expiresAt.Add(-r.tokenTTL() / refreshFraction), nil
+}
+
+// tokenRenewal returns when the token in secret is close to its expiry, the zero time when it is missing
+func (r *WebterminalTemplateReconciler) tokenRenewal(secret *corev1.Secret) time.Time {
+	expiresAt, err := time.Parse(time.RFC3339, secret.Annotations[tokenExpiryAnnotation])
+	if err != nil || len(secret.Data[KubeconfigKey]) == 0 {
+		return time.Time{}
+	}
+	return expiresAt.Add(-r.tokenTTL() / refreshFraction)
+}
+
+func (r *WebterminalTemplateReconciler) requestToken(ctx context.Context,
+	account *corev1.ServiceAccount) (string, time.Time, error) {
+	seconds := int64(r.tokenTTL().Seconds())
+	request := &authenticationv1.TokenRequest{Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &seconds}}
+	if err := r.SubResource("token").Create(ctx, account, request); err != nil {
+		return "", time.Time{}, err
+	}
+	return request.Status.Token, request.Status.ExpirationTimestamp.Time, nil
+}
+
+func (r *WebterminalTemplateReconciler) renderKubeconfig(obj *v1beta1.WebterminalTemplate,
+	token string) ([]byte, error) {
+	config := clientcmdapi.NewConfig()
+	config.Clusters[kubeconfigName] = &clientcmdapi.Cluster{Server: r.APIServer, CertificateAuthorityData: r.CAData}
+	user, groups := r.impersonated(obj)
+	config.AuthInfos[kubeconfigName] = &clientcmdapi.AuthInfo{Token: token, Impersonate: user,
+		ImpersonateGroups: groups}
+	config.Contexts[kubeconfigName] = &clientcmdapi.Context{Cluster: kubeconfigName, AuthInfo: kubeconfigName,
+		Namespace: metav1.NamespaceDefault}
+	config.CurrentContext = kubeconfigName
+	return clientcmd.Write(*config)
+}
+
+// impersonated returns the user and groups the kubeconfig of obj acts as, only users are given groups.
+// Nobody is impersonated unless the admission webhook vouches for the user of obj. System users and groups
+// are left out in case a template carrying them got past the webhook.
+func (r *WebterminalTemplateReconciler) impersonated(obj *v1beta1.WebterminalTemplate) (string, []string) {
+	if !r.Impersonate || obj.Spec.User == "" || v1beta1.IsSystemIdentity(obj.Spec.User) {
+		return "", nil
+	}
+	var groups []string
+	for _, group := range obj.Spec.Groups {
+		if !v1beta1.IsSystemIdentity(group) {
+			groups = append(groups, group)
+		}
+	}
+	return obj.Spec.User, groups
+}
+
+// ensureImpersonation lets the service account of the terminal pod impersonate the user of the template
+func (r *WebterminalTemplateReconciler) ensureImpersonation(ctx context.Context,
+	obj *v1beta1.WebterminalTemplate) error {
+	name := impersonatorPrefix + obj.Name
+	user, groups := r.impersonated(obj)
+	if user == "" {
+		if obj.Spec.User != "" {
+			zlog.LogWarnf("Not impersonating user %s for %s", obj.Spec.User, obj.Name)
+		}
+		return r.deleteImpersonation(ctx, obj)
+	}
+	role := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if err := r.provision(ctx, obj, role, func() error {
+		role.Rules = []rbacv1.PolicyRule{{
+			APIGroups: []string{""}, Resources: []string{"users"}, Verbs: []string{"impersonate"},
+			ResourceNames: []string{user},
+		}}
+		if len(groups) > 0 {
+			role.Rules = append(role.Rules, rbacv1.PolicyRule{
+				APIGroups: []string{""}, Resources: []string{"groups"}, Verbs: []string{"impersonate"},
+				ResourceNames: groups,
+			})
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	binding := &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	return r.provision(ctx, obj, binding, func() error {
+		binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name}
+		binding.Subjects = []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: accountPrefix + obj.Name,
+			Namespace: obj.Namespace}}
+		return nil
+	})
+}
+
+func (r *WebterminalTemplateReconciler) deleteImpersonation(ctx context.Context,
+	obj *v1beta1.WebterminalTemplate) error {
+	name := impersonatorPrefix + obj.Name
+	return r.deleteOwned(ctx, obj,
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: name}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: name}})
+}
+
+// provision creates or updates target for the credentials of obj through mutate and marks it as owned by obj.
+// An object of the same name that obj does not own is left alone and reported as an error.
+func (r *WebterminalTemplateReconciler) provision(ctx context.Context, obj *v1beta1.WebterminalTemplate,
+	target client.Object, mutate controllerutil.MutateFn) error {
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, target, func() error {
+		if target.GetResourceVersion() != "" && !owns(obj, target) {
+			return fmt.Errorf("%s exists and is not owned by template %s/%s", target.GetName(), obj.Namespace,
+				obj.Name)
+		}
+		labels := target.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[v1beta1.TemplateLabel] = obj.Name
+		labels[v1beta1.ManagedByLabel] = v1beta1.ManagedBy
+		target.SetLabels(labels)
+		if target.GetNamespace() != "" {
+			if err := controllerutil.SetControllerReference(obj, target, r.Scheme); err != nil {
+				return err
+			}
+		}
+		return mutate()
+	})
+	return err
+}
+
+// owns reports whether target was provisioned for obj. Namespaced objects have to be controlled by obj, cluster
+// scoped ones can only carry its labels.
+func owns(obj *v1beta1.WebterminalTemplate, target client.Object) bool {
+	labels := target.GetLabels()
+	if labels[v1beta1.TemplateLabel] != obj.Name || labels[v1beta1.ManagedByLabel] != v1beta1.ManagedBy {
+		return false
+	}
+	return target.GetNamespace() == "" || metav1.IsControlledBy(target, obj)
+}
+
+// deleteOwned deletes those of targets that obj owns, the others are left in place
+func (r *WebterminalTemplateReconciler) deleteOwned(ctx context.Context, obj *v1beta1.WebterminalTemplate,
+	targets ...client.Object) error {
+	for _, target := range targets {
+		err := r.Get(ctx, client.ObjectKeyFromObject(target), target)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !owns(obj, target) {
+			zlog.LogWarnf("Leaving %s in place, it is not owned by %s", target.GetName(), obj.Name)
+			continue
+		}
+		if err := r.Delete(ctx, target); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteKubeconfig removes the credentials provisioned for the terminal pod of obj
+func (r *WebterminalTemplateReconciler) deleteKubeconfig(ctx context.Context, obj *v1beta1.WebterminalTemplate) error {
+	if err := r.deleteImpersonation(ctx, obj); err != nil {
+		zlog.LogErrorf("Failed to revoke impersonation of %s: %v", obj.Name, err)
+		return err
+	}
+	err := r.deleteOwned(ctx, obj,
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: v1beta1.KubeconfigSecretName(obj.Name),
+			Namespace: obj.Namespace}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: accountPrefix + obj.Name,
+			Namespace: obj.Namespace}})
+	if err != nil {
+		zlog.LogErrorf("Failed to delete kubeconfig of %s: %v", obj.Name, err)
+	}
+	return err
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+)
+
+const testAPIServer = "https://kubernetes.default.svc"
+
+// newKubeconfigReconciler returns a reconciler whose token requests are answered with token-<n>
+func newKubeconfigReconciler(t *testing.T, objs ...client.Object) (*WebterminalTemplateReconciler, *int) {
+	issued := new(int)
+	c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objs...).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResource string, obj client.Object,
+				sub client.Object, opts ...client.SubResourceCreateOption) error {
+				request, ok := sub.(*authenticationv1.TokenRequest)
+				require.True(t, ok)
+				require.Equal(t, "token", subResource)
+				*issued++
+				request.Status.Token = "token-" + string(rune('0'+*issued))
+				request.Status.ExpirationTimestamp = metav1.NewTime(
+					time.Now().Add(time.Duration(*request.Spec.ExpirationSeconds) * time.Second))
+				return nil
+			},
+		}).Build()
+	return &WebterminalTemplateReconciler{Client: c, Scheme: c.Scheme(), APIServer: testAPIServer,
+		CAData: []byte("ca"), TokenTTL: time.Hour, Impersonate: true}, issued
+}
+
+func newUserTemplate() *v1beta1.WebterminalTemplate {
+	return &v1beta1.WebterminalTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "openfuyao-alice", Namespace: "default"},
+		Spec:       v1beta1.WebterminalTemplateSpec{User: "alice", Groups: []string{"sre"}},
+	}
+}
+
+func TestEnsureKubeconfig(t *testing.T) {
+	r, issued := newKubeconfigReconciler(t)
+	obj := newUserTemplate()
+	ctx := context.Background()
+	renewAt, err := r.ensureKubeconfig(ctx, obj)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(45*time.Minute), renewAt, time.Minute)
+
+	account := &corev1.ServiceAccount{}
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Name: accountPrefix + obj.Name, Namespace: obj.Namespace},
+		account))
+	assert.True(t, metav1.IsControlledBy(account, obj))
+	role := &rbacv1.ClusterRole{}
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Name: impersonatorPrefix + obj.Name}, role))
+	require.Len(t, role.Rules, 2)
+	assert.Equal(t, []string{"alice"}, role.Rules[0].ResourceNames)
+	assert.Equal(t, []string{"sre"}, role.Rules[1].ResourceNames)
+	binding := &rbacv1.ClusterRoleBinding{}
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Name: impersonatorPrefix + obj.Name}, binding))
+	assert.Equal(t, account.Name, binding.Subjects[0].Name)
+	assert.Equal(t, obj.Name, binding.Labels[v1beta1.TemplateLabel])
+
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Name: v1beta1.KubeconfigSecretName(obj.Name), Namespace: obj.Namespace}
+	require.NoError(t, r.Get(ctx, key, secret))
+	config, err := clientcmd.Load(secret.Data[KubeconfigKey])
+	require.NoError(t, err)
+	auth := config.AuthInfos[config.Contexts[config.CurrentContext].AuthInfo]
+	assert.Equal(t, "token-1", auth.Token)
+	assert.Equal(t, "alice", auth.Impersonate)
+	assert.Equal(t, []string{"sre"}, auth.ImpersonateGroups)
+	assert.Equal(t, testAPIServer, config.Clusters[kubeconfigName].Server)
+
+	// a fresh token is kept
+	kept, err := r.ensureKubeconfig(ctx, obj)
+	require.NoError(t, err)
+	assert.Equal(t, 1, *issued)
+	assert.WithinDuration(t, renewAt, kept, time.Second)
+
+	// a token close to its expiry is renewed
+	secret.Annotations[tokenExpiryAnnotation] = time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	require.NoError(t, r.Update(ctx, secret))
+	_, err = r.ensureKubeconfig(ctx, obj)
+	require.NoError(t, err)
+	assert.Equal(t, 2, *issued)
+	require.NoError(t, r.Get(ctx, key, secret))
+	config, err = clientcmd.Load(secret.Data[KubeconfigKey])
+	require.NoError(t, err)
+	assert.Equal(t, "token-2", config.AuthInfos[kubeconfigName].Token)
+
+	require.NoError(t, r.deleteKubeconfig(ctx, obj))
+	assert.True(t, errors.IsNotFound(r.Get(ctx, key, &corev1.Secret{})))
+	assert.True(t, errors.IsNotFound(r.Get(ctx, client.ObjectKeyFromObject(account), &corev1.ServiceAccount{})))
+	assert.True(t, errors.IsNotFound(r.Get(ctx, client.ObjectKeyFromObject(role), &rbacv1.ClusterRole{})))
+	assert.True(t, errors.IsNotFound(r.Get(ctx, client.ObjectKeyFromObject(binding), &rbacv1.ClusterRoleBinding{})))
+}
+
+func TestEnsureKubeconfigWithoutUser(t *testing.T) {
+	obj := newUserTemplate()
+	obj.Spec.User = ""
+	stale := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: impersonatorPrefix + obj.Name,
+		Labels: map[string]string{v1beta1.TemplateLabel: obj.Name, v1beta1.ManagedByLabel: v1beta1.ManagedBy}}}
+	r, _ := newKubeconfigReconciler(t, stale)
+	ctx := context.Background()
+	_, err := r.ensureKubeconfig(ctx, obj)
+	require.NoError(t, err)
+
+	assert.True(t, errors.IsNotFound(r.Get(ctx, client.ObjectKeyFromObject(stale), &rbacv1.ClusterRole{})))
+	secret := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Name: v1beta1.KubeconfigSecretName(obj.Name),
+		Namespace: obj.Namespace}, secret))
+	config, err := clientcmd.Load(secret.Data[KubeconfigKey])
+	require.NoError(t, err)
+	assert.Empty(t, config.AuthInfos[kubeconfigName].Impersonate)
+	assert.Empty(t, config.AuthInfos[kubeconfigName].ImpersonateGroups)
+}
+
+func TestEnsureKubeconfigDisabled(t *testing.T) {
+	r, issued := newKubeconfigReconciler(t)
+	r.APIServer = ""
+	renewAt, err := r.ensureKubeconfig(context.Background(), newUserTemplate())
+	require.NoError(t, err)
+	assert.True(t, renewAt.IsZero())
+	assert.Zero(t, *issued)
+	accounts := &corev1.ServiceAccountList{}
+	require.NoError(t, r.List(context.Background(), accounts))
+	assert.Empty(t, accounts.Items)
+}
+
+func TestKubeconfigTarget(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, []byte("file-ca"), 0o600))
+
+	tests := []struct {
+		name    string
+		config  *rest.Config
+		wantCA  string
+		wantErr bool
+	}{
+		{name: "inline CA", config: &rest.Config{Host: testAPIServer,
+			TLSClientConfig: rest.TLSClientConfig{CAData: []byte("inline-ca"), CAFile: caFile}}, wantCA: "inline-ca"},
+		{name: "CA file", config: &rest.Config{Host: testAPIServer,
+			TLSClientConfig: rest.TLSClientConfig{CAFile: caFile}}, wantCA: "file-ca"},
+		{name: "missing CA file", config: &rest.Config{Host: testAPIServer,
+			TLSClientConfig: rest.TLSClientConfig{CAFile: caFile + ".missing"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, ca, err := KubeconfigTarget(tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testAPIServer, host)
+			assert.Equal(t, tt.wantCA, string(ca))
+		})
+	}
+}
+
+func TestEnsureKubeconfigSystemIdentity(t *testing.T) {
+	r, _ := newKubeconfigReconciler(t)
+	ctx := context.Background()
+	obj := newUserTemplate()
+	obj.Spec.Groups = []string{"sre", "system:masters"}
+	_, err := r.ensureKubeconfig(ctx, obj)
+	require.NoError(t, err)
+	role := &rbacv1.ClusterRole{}
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Name: impersonatorPrefix + obj.Name}, role))
+	require.Len(t, role.Rules, 2)
+	assert.Equal(t, []string{"sre"}, role.Rules[1].ResourceNames)
+
+	obj.Spec.User = "system:admin"
+	obj.Spec.Groups = []string{"system:masters"}
+	user, groups := r.impersonated(obj)
+	assert.Empty(t, user)
+	assert.Empty(t, groups)
+	require.NoError(t, r.ensureImpersonation(ctx, obj))
+	assert.True(t, errors.IsNotFound(r.Get(ctx, client.ObjectKeyFromObject(role), &rbacv1.ClusterRole{})))
+}
+
+func TestEnsureKubeconfigWithoutWebhook(t *testing.T) {
+	r, _ := newKubeconfigReconciler(t)
+	r.Impersonate = false
+	ctx := context.Background()
+	obj := newUserTemplate()
+	obj.Spec.User = "cluster-admin"
+	_, err := r.ensureKubeconfig(ctx, obj)
+	require.NoError(t, err)
+
+	err = r.Get(ctx, client.ObjectKey{Name: impersonatorPrefix + obj.Name}, &rbacv1.ClusterRole{})
+	assert.True(t, errors.IsNotFound(err), "the user of a template is not trusted without the webhook")
+	secret := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Name: v1beta1.KubeconfigSecretName(obj.Name),
+		Namespace: obj.Namespace}, secret))
+	config, err := clientcmd.Load(secret.Data[KubeconfigKey])
+	require.NoError(t, err)
+	assert.Empty(t, config.AuthInfos[kubeconfigName].Impersonate)
+	assert.Empty(t, config.AuthInfos[kubeconfigName].ImpersonateGroups)
+}
+
+func TestEnsureKubeconfigForeignObjects(t *testing.T) {
+	obj := newUserTemplate()
+	account := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: accountPrefix + obj.Name,
+		Namespace: obj.Namespace}}
+	role := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: impersonatorPrefix + obj.Name}}
+	r, issued := newKubeconfigReconciler(t, account, role)
+	ctx := context.Background()
+
+	_, err := r.ensureKubeconfig(ctx, obj)
+	assert.Error(t, err, "a service account the template does not own is not adopted")
+	assert.Zero(t, *issued)
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(account), account))
+	assert.Empty(t, account.OwnerReferences)
+
+	require.NoError(t, r.Delete(ctx, account))
+	_, err = r.ensureKubeconfig(ctx, obj)
+	assert.Error(t, err, "a cluster role the template does not own is not adopted")
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(role), role))
+	assert.Empty(t, role.Rules)
+
+	obj.Spec.User = ""
+	require.NoError(t, r.deleteKubeconfig(ctx, obj))
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(role), &rbacv1.ClusterRole{}),
+		"only owned objects are deleted")
+	assert.True(t, errors.IsNotFound(r.Get(ctx, client.ObjectKeyFromObject(account), &corev1.ServiceAccount{})))
+}
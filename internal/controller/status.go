@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+)
+
+// reasons of the events and conditions of WebterminalTemplates
+const (
+	ReasonPodCreated       = "PodCreated"
+	ReasonReady            = "Ready"
+	ReasonPodFailed        = "PodFailed"
+	ReasonExpired          = "Expired"
+	ReasonKubeconfigFailed = "KubeconfigFailed"
+
+	reasonTokenIssued = "TokenIssued"
+	reasonNoPod       = "PodNotFound"
+	reasonIdle        = "Idle"
+	reasonActive      = "Active"
+
+	// expiringNotice is how long before its session timeout a template is reported as expiring
+	expiringNotice = 5 * time.Minute
+)
+
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// event records an event on obj when the reconciler has a recorder
+func (r *WebterminalTemplateReconciler) event(obj *v1beta1.WebterminalTemplate, eventType, reason, format string,
+	args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(obj, eventType, reason, format, args...)
+	}
+}
+
+// setCondition sets the condition of type cond.Type, it keeps the transition time while the status is unchanged
+func setCondition(conditions []v1beta1.WebTerminalTemplateCondition,
+	cond v1beta1.WebTerminalTemplateCondition) []v1beta1.WebTerminalTemplateCondition {
+	for i := range conditions {
+		if conditions[i].Type != cond.Type {
+			continue
+		}
+		if conditions[i].Status == cond.Status {
+			cond.LastTransitionTime = conditions[i].LastTransitionTime
+		}
+		conditions[i] = cond
+		return conditions
+	}
+	return append(conditions, cond)
+}
+
+// removeCondition drops the condition of type condType
+func removeCondition(conditions []v1beta1.WebTerminalTemplateCondition,
+	condType v1beta1.WebTerminalTemplateConditionType) []v1beta1.WebTerminalTemplateCondition {
+	var result []v1beta1.WebTerminalTemplateCondition
+	for _, cond := range conditions {
+		if cond.Type != condType {
+			result = append(result, cond)
+		}
+	}
+	return result
+}
+
+// podConditionTypes pairs the pod conditions mirrored in the status of a template with their types there
+var podConditionTypes = []struct {
+	pod      corev1.PodConditionType
+	template v1beta1.WebTerminalTemplateConditionType
+}{
+	{pod: corev1.PodScheduled, template: v1beta1.WebTerminalTemplatePodScheduled},
+	{pod: corev1.PodReady, template: v1beta1.WebTerminalTemplatePodReady},
+}
+
+// setPodConditions mirrors the scheduling and readiness of pod along with their transition times, nil when the
+// template has no pod
+func setPodConditions(conditions []v1beta1.WebTerminalTemplateCondition,
+	pod *corev1.Pod) []v1beta1.WebTerminalTemplateCondition {
+	for _, types := range podConditionTypes {
+		cond := v1beta1.WebTerminalTemplateCondition{Type: types.template, Status: corev1.ConditionFalse,
+			Reason: reasonNoPod, Message: "the terminal pod does not exist", LastTransitionTime: metav1.Now()}
+		if pod != nil {
+			cond = v1beta1.WebTerminalTemplateCondition{Type: types.template, Status: corev1.ConditionUnknown,
+				LastTransitionTime: metav1.Now()}
+			for _, c := range pod.Status.Conditions {
+				if c.Type == types.pod {
+					cond.Status, cond.Reason, cond.Message = c.Status, c.Reason, c.Message
+					if !c.LastTransitionTime.IsZero() {
+						cond.LastTransitionTime = c.LastTransitionTime
+					}
+				}
+			}
+		}
+		conditions = setCondition(conditions, cond)
+	}
+	return conditions
+}
+
+// kubeconfigCondition reports the kubeconfig token renewed at renewAt, or the error that prevented issuing it
+func kubeconfigCondition(renewAt time.Time, err error) v1beta1.WebTerminalTemplateCondition {
+	if err != nil {
+		return v1beta1.WebTerminalTemplateCondition{Type: v1beta1.WebTerminalTemplateKubeconfigReady,
+			Status: corev1.ConditionFalse, Reason: ReasonKubeconfigFailed, Message: err.Error(),
+			LastTransitionTime: metav1.Now()}
+	}
+	return v1beta1.WebTerminalTemplateCondition{Type: v1beta1.WebTerminalTemplateKubeconfigReady,
+		Status: corev1.ConditionTrue, Reason: reasonTokenIssued,
+		Message:            fmt.Sprintf("the token is renewed at %s", renewAt.UTC().Format(time.RFC3339)),
+		LastTransitionTime: metav1.Now()}
+}
+
+// expiresAt returns when the pod of obj is removed unless the terminal is used before
+func (r *WebterminalTemplateReconciler) expiresAt(obj *v1beta1.WebterminalTemplate) time.Time {
+	return obj.Spec.RenewTime.Add(r.sessionTimeout(obj))
+}
+
+// expiringCondition tells whether the pod of obj is removed within expiringNotice
+func (r *WebterminalTemplateReconciler) expiringCondition(
+	obj *v1beta1.WebterminalTemplate) v1beta1.WebTerminalTemplateCondition {
+	expiresAt := r.expiresAt(obj)
+	cond := v1beta1.WebTerminalTemplateCondition{Type: v1beta1.WebTerminalTemplateExpiring,
+		Status: corev1.ConditionFalse, Reason: reasonActive, LastTransitionTime: metav1.Now()}
+	if time.Until(expiresAt) <= expiringNotice {
+		cond.Status, cond.Reason = corev1.ConditionTrue, reasonIdle
+		cond.Message = fmt.Sprintf("the terminal pod is removed at %s without activity",
+			expiresAt.UTC().Format(time.RFC3339))
+	}
+	return cond
+}
+
+// podReadyDuration returns how long pod took from its creation until it became ready
+func podReadyDuration(pod *corev1.Pod) time.Duration {
+	readyAt := time.Now()
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue && !c.LastTransitionTime.IsZero() {
+			readyAt = c.LastTransitionTime.Time
+		}
+	}
+	return readyAt.Sub(pod.CreationTimestamp.Time)
+}
+
+// podFailure describes why pod cannot serve a terminal, empty when it can
+func podFailure(pod *corev1.Pod) string {
+	if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		return fmt.Sprintf("pod %s is %s", pod.Name, pod.Status.Phase)
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...),
+		pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if failure := containerFailure(status); failure != "" {
+			return fmt.Sprintf("container %s of pod %s %s", status.Name, pod.Name, failure)
+		}
+	}
+	return ""
+}
+
+// containerFailure describes how a container crashed or why it cannot be started, empty when it did not
+func containerFailure(status corev1.ContainerStatus) string {
+	if waiting := status.State.Waiting; waiting != nil {
+		switch waiting.Reason {
+		case "CrashLoopBackOff", "ErrImagePull", "ImagePullBackOff", "InvalidImageName",
+			"CreateContainerConfigError", "CreateContainerError":
+			return strings.TrimSpace(fmt.Sprintf("is waiting: %s %s", waiting.Reason, waiting.Message))
+		}
+	}
+	if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+		return fmt.Sprintf("exited with code %d: %s", terminated.ExitCode, terminated.Reason)
+	}
+	return ""
+}
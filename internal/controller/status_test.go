@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package controller
+
+import (
+	"context"
+	stdErrors "errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+)
+
+func TestSetCondition(t *testing.T) {
+	before := metav1.NewTime(time.Now().Add(-time.Hour))
+	conditions := []v1beta1.WebTerminalTemplateCondition{{Type: v1beta1.WebTerminalTemplatePodReady,
+		Status: corev1.ConditionFalse, LastTransitionTime: before}}
+
+	// an unchanged status keeps its transition time
+	conditions = setCondition(conditions, v1beta1.WebTerminalTemplateCondition{
+		Type: v1beta1.WebTerminalTemplatePodReady, Status: corev1.ConditionFalse, Reason: "ContainersNotReady",
+		LastTransitionTime: metav1.Now()})
+	require.Len(t, conditions, 1)
+	assert.Equal(t, before, conditions[0].LastTransitionTime)
+	assert.Equal(t, "ContainersNotReady", conditions[0].Reason)
+
+	conditions = setCondition(conditions, v1beta1.WebTerminalTemplateCondition{
+		Type: v1beta1.WebTerminalTemplatePodReady, Status: corev1.ConditionTrue, LastTransitionTime: metav1.Now()})
+	assert.NotEqual(t, before, conditions[0].LastTransitionTime)
+
+	conditions = setCondition(conditions, kubeconfigCondition(time.Now(), nil))
+	require.Len(t, conditions, 2)
+	conditions = removeCondition(conditions, v1beta1.WebTerminalTemplatePodReady)
+	require.Len(t, conditions, 1)
+	assert.Equal(t, v1beta1.WebTerminalTemplateKubeconfigReady, conditions[0].Type)
+}
+
+func TestSetPodConditions(t *testing.T) {
+	conditions := setPodConditions(nil, nil)
+	require.Len(t, conditions, 2)
+	assert.Equal(t, corev1.ConditionFalse, conditions[0].Status)
+	assert.Equal(t, reasonNoPod, conditions[1].Reason)
+
+	pod := newReadyPod("term", "default")
+	readyAt := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	pod.Status.Conditions[0].LastTransitionTime = readyAt
+	conditions = setPodConditions(conditions, pod)
+	assert.Equal(t, v1beta1.WebTerminalTemplatePodScheduled, conditions[0].Type)
+	assert.Equal(t, corev1.ConditionUnknown, conditions[0].Status)
+	assert.Equal(t, v1beta1.WebTerminalTemplatePodReady, conditions[1].Type)
+	assert.Equal(t, corev1.ConditionTrue, conditions[1].Status)
+	assert.Equal(t, readyAt, conditions[1].LastTransitionTime, "the transition time of the pod is kept")
+}
+
+func TestExpiringCondition(t *testing.T) {
+	r := &WebterminalTemplateReconciler{SessionTimeout: time.Hour}
+	tests := []struct {
+		name   string
+		idle   time.Duration
+		want   corev1.ConditionStatus
+		reason string
+	}{
+		{name: "active", idle: time.Minute, want: corev1.ConditionFalse, reason: reasonActive},
+		{name: "expiring", idle: 57 * time.Minute, want: corev1.ConditionTrue, reason: reasonIdle},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &v1beta1.WebterminalTemplate{Spec: v1beta1.WebterminalTemplateSpec{
+				RenewTime: metav1.NewTime(time.Now().Add(-tt.idle))}}
+			got := r.expiringCondition(obj)
+			assert.Equal(t, v1beta1.WebTerminalTemplateExpiring, got.Type)
+			assert.Equal(t, tt.want, got.Status)
+			assert.Equal(t, tt.reason, got.Reason)
+		})
+	}
+}
+
+func TestPodReadyDuration(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-time.Hour))
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: created},
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady,
+			Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(created.Add(12 * time.Second))}}}}
+	assert.Equal(t, 12*time.Second, podReadyDuration(pod))
+
+	// without a transition time the pod counts as ready now
+	pod.Status.Conditions[0].LastTransitionTime = metav1.Time{}
+	assert.InDelta(t, time.Hour.Seconds(), podReadyDuration(pod).Seconds(), 5)
+}
+
+func TestPodFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want string
+	}{
+		{name: "running", pod: newReadyPod("term", "default")},
+		{name: "failed", pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "term"},
+			Status: corev1.PodStatus{Phase: corev1.PodFailed}}, want: "pod term is Failed"},
+		{name: "image pull", pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "term"},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "shell",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull",
+					Message: "not found"}}}}}},
+			want: "container shell of pod term is waiting: ErrImagePull not found"},
+		{name: "exited", pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "term"},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "shell",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137,
+					Reason: "OOMKilled"}}}}}},
+			want: "container shell of pod term exited with code 137: OOMKilled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, podFailure(tt.pod))
+		})
+	}
+}
+
+func TestReconcileReportsKubeconfigFailure(t *testing.T) {
+	scheme := setupScheme()
+	wtTemplate := newStartingTemplate("new-term")
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(wtTemplate).
+		WithStatusSubresource(wtTemplate).WithInterceptorFuncs(interceptor.Funcs{
+		SubResourceCreate: func(ctx context.Context, c client.Client, subResource string, obj client.Object,
+			sub client.Object, opts ...client.SubResourceCreateOption) error {
+			return stdErrors.New("token requests are disabled")
+		},
+	}).Build()
+	recorder := record.NewFakeRecorder(bufferSize)
+	r := &WebterminalTemplateReconciler{Client: fakeClient, Scheme: scheme, Recorder: recorder,
+		APIServer: testAPIServer}
+
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(wtTemplate)}
+	_, err := r.Reconcile(context.Background(), req)
+	assert.Error(t, err)
+	assert.Equal(t, "Warning KubeconfigFailed Failed to issue kubeconfig: token requests are disabled",
+		<-recorder.Events)
+	fetched := &v1beta1.WebterminalTemplate{}
+	require.NoError(t, fakeClient.Get(context.Background(), req.NamespacedName, fetched))
+	require.Len(t, fetched.Status.Conditions, 1)
+	assert.Equal(t, v1beta1.WebTerminalTemplateKubeconfigReady, fetched.Status.Conditions[0].Type)
+	assert.Equal(t, corev1.ConditionFalse, fetched.Status.Conditions[0].Status)
+}
@@ -16,7 +16,11 @@ import (
 	"context"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -28,16 +32,24 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	"openfuyao.com/web-terminal-service/api/v1beta1"
 	terminalv1beta1 "openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/metrics"
+	"openfuyao.com/web-terminal-service/pkg/tracing"
 	"openfuyao.com/web-terminal-service/pkg/zlog"
 )
 
 const (
-	finalizer              = "openfuyao.com.finalizer.webterminal"
-	checkTimePeriod        = 26 * time.Minute
-	defaultUpdateFrequency = 20 * time.Second
+	finalizer = "openfuyao.com.finalizer.webterminal"
+	// podDeletionPeriod is how often the removal of a terminal pod is checked while its template is deleted
+	podDeletionPeriod = 2 * time.Second
+	// minRequeuePeriod bounds how soon a template is reconciled again for its timeouts
+	minRequeuePeriod = time.Second
+	// DefaultSessionTimeout is how long a terminal pod is kept without activity when neither the
+	// template nor the controller configure it
+	DefaultSessionTimeout = 26 * time.Minute
 )
 
 // WebterminalTemplateReconciler reconciles a WebterminalTemplate object
@@ -45,11 +57,25 @@ type WebterminalTemplateReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// SessionTimeout applies to templates without a session timeout, DefaultSessionTimeout when 0
+	SessionTimeout time.Duration
+	// APIServer and CAData locate the API server in the kubeconfigs of terminal pods.
+	// Kubeconfigs are not provisioned without an API server.
+	APIServer string
+	CAData    []byte
+	// TokenTTL is the lifetime of kubeconfig tokens, DefaultTokenTTL when 0
+	TokenTTL time.Duration
+	// Impersonate lets kubeconfigs act as the user of their template. Only the admission webhook keeps users
+	// from writing that user, so it must not be set while the webhook is off.
+	Impersonate bool
+	// HomeRetention is how long home directories are kept after their terminal was removed, 0 keeps them
+	HomeRetention time.Duration
 }
 
 //+kubebuilder:rbac:groups=terminal.openfuyao.com,resources=webterminaltemplates,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=terminal.openfuyao.com,resources=webterminaltemplates/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=terminal.openfuyao.com,resources=webterminaltemplates/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -57,7 +83,10 @@ type WebterminalTemplateReconciler struct {
 // the WebterminalTemplate object against the actual cluster state, and then
 // perform operations to make the cluster state reflect the state specified by
 // the user.
-func (r *WebterminalTemplateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *WebterminalTemplateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracing.Start(ctx, "WebterminalTemplate.Reconcile", trace.WithAttributes(
+		semconv.K8SNamespaceName(req.Namespace), attribute.String("webterminaltemplate", req.Name)))
+	defer func() { tracing.End(span, err) }()
 	wtTemplate := &v1beta1.WebterminalTemplate{}
 	if err := r.Get(ctx, req.NamespacedName, wtTemplate); err != nil {
 		if errors.IsNotFound(err) {
@@ -73,19 +102,36 @@ func (r *WebterminalTemplateReconciler) Reconcile(ctx context.Context, req ctrl.
 		return result, err
 	}
 
-	if shouldReturn, result, err := r.ensurePodRunning(ctx, wtTemplate); shouldReturn {
-		return result, err
+	renewAt, err := r.ensureKubeconfig(ctx, wtTemplate)
+	if err != nil {
+		r.reportKubeconfigFailure(ctx, wtTemplate, err)
+		return ctrl.Result{}, err
+	}
+
+	if err := r.ensureHome(ctx, wtTemplate); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	created, err := r.ensurePodRunning(ctx, wtTemplate)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
 	if shouldReturn, result, err := r.checkTTL(ctx, wtTemplate); shouldReturn {
 		return result, err
 	}
 
-	return r.syncStatus(ctx, wtTemplate)
+	if err := r.syncStatus(ctx, wtTemplate, created, renewAt); err != nil {
+		return ctrl.Result{}, err
+	}
+	// changes of the pod are watched, only the timeouts need another look
+	return ctrl.Result{RequeueAfter: r.nextCheck(wtTemplate, renewAt)}, nil
 }
 
 // handleFinalizer 处理资源的 Finalizer 逻辑
 func (r *WebterminalTemplateReconciler) handleFinalizer(ctx context.Context, wtTemplate *v1beta1.WebterminalTemplate) (bool, ctrl.Result, error) {
+	ctx, span := tracing.Start(ctx, "handleFinalizer")
+	defer span.End()
 	if wtTemplate.ObjectMeta.DeletionTimestamp.IsZero() {
 		// 对象未被删除，确保 Finalizer 存在
 		if !controllerutil.ContainsFinalizer(wtTemplate, finalizer) {
@@ -101,7 +147,19 @@ func (r *WebterminalTemplateReconciler) handleFinalizer(ctx context.Context, wtT
 	} else {
 		// 对象正在删除中，执行清理
 		if controllerutil.ContainsFinalizer(wtTemplate, finalizer) {
-			if err := r.deletePodTemplate(ctx, wtTemplate); err != nil {
+			deleted, err := r.deletePodTemplate(ctx, wtTemplate)
+			if err != nil {
+				return true, ctrl.Result{}, err
+			}
+			if !deleted {
+				// the deletion of the owned pod triggers the next reconcile, the period covers pods of
+				// older releases that are not owned by their template
+				return true, ctrl.Result{RequeueAfter: podDeletionPeriod}, nil
+			}
+			if err := r.deleteKubeconfig(ctx, wtTemplate); err != nil {
+				return true, ctrl.Result{}, err
+			}
+			if err := r.releaseHome(ctx, wtTemplate); err != nil {
 				return true, ctrl.Result{}, err
 			}
 
@@ -121,117 +179,208 @@ func (r *WebterminalTemplateReconciler) handleFinalizer(ctx context.Context, wtT
 	return false, ctrl.Result{}, nil
 }
 
-// ensurePodRunning 检查是否需要创建 Pod 并更新相关时间戳和状态
-func (r *WebterminalTemplateReconciler) ensurePodRunning(ctx context.Context, wtTemplate *v1beta1.WebterminalTemplate) (bool, ctrl.Result, error) {
+// ensurePodRunning 检查是否需要创建 Pod 并更新相关时间戳, it reports whether the pod was created
+func (r *WebterminalTemplateReconciler) ensurePodRunning(ctx context.Context,
+	wtTemplate *v1beta1.WebterminalTemplate) (bool, error) {
+	ctx, span := tracing.Start(ctx, "ensurePodRunning")
+	defer span.End()
 	// 如果未初始化或状态为 Stopped，则启动 Pod
-	if wtTemplate.Spec.ExistsTime.IsZero() || wtTemplate.Status.Phase == v1beta1.WebTerminalTemplateStopped {
-		if err := r.createPodTemplate(ctx, wtTemplate); err != nil {
-			return true, ctrl.Result{}, err
-		}
-
-		wtTemplate.Spec.ExistsTime = metav1.NewTime(time.Now())
-		wtTemplate.Spec.RenewTime = metav1.NewTime(time.Now())
-
-		zlog.LogInfoln("after create pod existtime is: ", wtTemplate.Spec.ExistsTime.Time)
-		zlog.LogInfoln("after create pod renewtime is : ", wtTemplate.Spec.RenewTime.Time)
+	if !wtTemplate.Spec.ExistsTime.IsZero() && wtTemplate.Status.Phase != v1beta1.WebTerminalTemplateStopped {
+		return false, nil
+	}
+	if err := r.createPodTemplate(ctx, wtTemplate); err != nil {
+		return false, err
+	}
 
-		if err := r.Update(ctx, wtTemplate); err != nil {
-			zlog.LogError("Failed to update create time : %v", err)
-			return true, ctrl.Result{}, err
-		}
+	wtTemplate.Spec.ExistsTime = metav1.NewTime(time.Now())
+	wtTemplate.Spec.RenewTime = metav1.NewTime(time.Now())
 
-		wtTemplate.Status.Phase = v1beta1.WebTerminalTemplateRunning
-		if err := r.Status().Update(ctx, wtTemplate); err != nil {
-			zlog.LogError("Failed to update pod status : %v", err)
-			return true, ctrl.Result{}, err
-		}
+	zlog.LogInfoln("after create pod existtime is: ", wtTemplate.Spec.ExistsTime.Time)
+	zlog.LogInfoln("after create pod renewtime is : ", wtTemplate.Spec.RenewTime.Time)
 
-		return true, ctrl.Result{}, nil
+	if err := r.Update(ctx, wtTemplate); err != nil {
+		zlog.LogError("Failed to update create time : %v", err)
+		return false, err
 	}
+	r.event(wtTemplate, corev1.EventTypeNormal, ReasonPodCreated, "Created terminal pod %s",
+		wtTemplate.Spec.PodTemplate.ObjectMeta.Name)
+	return true, nil
+}
 
-	return false, ctrl.Result{}, nil
+// sessionTimeout returns how long the pod of wtTemplate is kept without activity
+func (r *WebterminalTemplateReconciler) sessionTimeout(wtTemplate *v1beta1.WebterminalTemplate) time.Duration {
+	if wtTemplate.Spec.SessionTimeout > 0 {
+		return time.Duration(wtTemplate.Spec.SessionTimeout) * time.Minute
+	}
+	if r.SessionTimeout > 0 {
+		return r.SessionTimeout
+	}
+	return DefaultSessionTimeout
 }
 
-// checkTTL
+// checkTTL deletes the template once its pod has been idle for longer than the session timeout
 func (r *WebterminalTemplateReconciler) checkTTL(ctx context.Context, wtTemplate *v1beta1.WebterminalTemplate) (bool, ctrl.Result, error) {
-	currentTime := time.Now().Add(-checkTimePeriod)
+	ctx, span := tracing.Start(ctx, "checkTTL")
+	defer span.End()
+	currentTime := time.Now().Add(-r.sessionTimeout(wtTemplate))
 
 	if !wtTemplate.Spec.RenewTime.After(currentTime) {
 		zlog.LogInfof(" start to delete cr ! \n")
+		r.event(wtTemplate, corev1.EventTypeNormal, ReasonExpired, "Terminal idle since %s, removing it",
+			wtTemplate.Spec.RenewTime.UTC().Format(time.RFC3339))
 
 		if err := r.Delete(ctx, wtTemplate, &client.DeleteOptions{}); err != nil {
 			zlog.LogError("Failed to delete wrbterminal template : %v", err)
 			return true, ctrl.Result{}, err
 		}
+		metrics.TTLDeletions.Inc()
 		return true, ctrl.Result{}, nil
 	}
 
 	return false, ctrl.Result{}, nil
 }
 
-func (r *WebterminalTemplateReconciler) syncStatus(ctx context.Context, obj *v1beta1.WebterminalTemplate) (ctrl.Result, error) {
+// nextCheck returns when obj has to be reconciled again although neither it nor its pod changed: once
+// its session times out or the token of its kubeconfig has to be renewed at renewAt
+func (r *WebterminalTemplateReconciler) nextCheck(obj *v1beta1.WebterminalTemplate, renewAt time.Time) time.Duration {
+	expiresAt := r.expiresAt(obj)
+	next := time.Until(expiresAt)
+	if expiring := time.Until(expiresAt.Add(-expiringNotice)); expiring > 0 {
+		// the template is reported as expiring before it expires
+		next = expiring
+	}
+	if !renewAt.IsZero() && time.Until(renewAt) < next {
+		next = time.Until(renewAt)
+	}
+	if next < minRequeuePeriod {
+		return minRequeuePeriod
+	}
+	return next
+}
+
+// syncStatus reflects the state of the pod of obj in its status. A pod that was just created may not be
+// visible yet, created keeps the template starting meanwhile. renewAt is when the kubeconfig token of the
+// pod is renewed, zero without kubeconfigs.
+func (r *WebterminalTemplateReconciler) syncStatus(ctx context.Context, obj *v1beta1.WebterminalTemplate,
+	created bool, renewAt time.Time) error {
+	ctx, span := tracing.Start(ctx, "syncStatus")
+	defer span.End()
 	currentStatus := *obj.Status.DeepCopy()
-	currentStatus.Phase = v1beta1.WebTerminalTemplateRunning
 
 	podtpl := &corev1.Pod{}
 	err := r.Get(ctx, types.NamespacedName{Name: obj.Name, Namespace: obj.Namespace}, podtpl)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			currentStatus.Phase = v1beta1.WebTerminalTemplateStopped
-			return r.updateStatus(ctx, obj, currentStatus)
-
-		}
+	if err != nil && !errors.IsNotFound(err) {
 		zlog.LogErrorf("LogError Retrieving user pod: %v", err)
-		return ctrl.Result{}, err
+		return err
 	}
+	if errors.IsNotFound(err) {
+		currentStatus.Phase = v1beta1.WebTerminalTemplateStopped
+		if created {
+			currentStatus.Phase = v1beta1.WebTerminalTemplateStarting
+		}
+		podtpl = nil
+	} else {
+		if err = r.adoptPod(ctx, obj, podtpl); err != nil {
+			return err
+		}
+		currentStatus.Phase = templatePhase(podtpl)
+	}
+	r.recordPhaseChange(obj, currentStatus.Phase, podtpl)
 
-	var tplCondition []v1beta1.WebTerminalTemplateCondition
-	for _, c := range podtpl.Status.Conditions {
-		tplCondition = append(tplCondition, v1beta1.WebTerminalTemplateCondition{
-			Status:             c.Status,
-			Reason:             c.Reason,
-			Message:            c.Message,
-			LastTransitionTime: metav1.Time{Time: c.LastTransitionTime.Time},
-		})
+	currentStatus.Conditions = setPodConditions(currentStatus.Conditions, podtpl)
+	if renewAt.IsZero() {
+		currentStatus.Conditions = removeCondition(currentStatus.Conditions, v1beta1.WebTerminalTemplateKubeconfigReady)
+	} else {
+		currentStatus.Conditions = setCondition(currentStatus.Conditions, kubeconfigCondition(renewAt, nil))
+	}
+	currentStatus.Conditions = setCondition(currentStatus.Conditions, r.expiringCondition(obj))
+	currentStatus.ObservedGeneration = obj.Generation
+	if !obj.Spec.RenewTime.IsZero() {
+		lastActivity := obj.Spec.RenewTime
+		expiresAt := metav1.NewTime(r.expiresAt(obj))
+		currentStatus.LastActivityTime, currentStatus.ExpiresAt = &lastActivity, &expiresAt
 	}
-	currentStatus.Conditions = tplCondition
 
 	return r.updateStatus(ctx, obj, currentStatus) // 更新当前Pod状态到webterminaltemplate的状态
 }
 
-func (r *WebterminalTemplateReconciler) deletePodTemplate(ctx context.Context, obj *v1beta1.WebterminalTemplate) error {
-	currentPod := &corev1.Pod{}
+// recordPhaseChange records the events of obj entering phase
+func (r *WebterminalTemplateReconciler) recordPhaseChange(obj *v1beta1.WebterminalTemplate,
+	phase v1beta1.WebTerminalTemplatePhase, pod *corev1.Pod) {
+	if phase == obj.Status.Phase {
+		return
+	}
+	switch phase {
+	case v1beta1.WebTerminalTemplateRunning:
+		r.event(obj, corev1.EventTypeNormal, ReasonReady, "Terminal pod %s is ready", pod.Name)
+		metrics.UserPodReadyDuration.Observe(podReadyDuration(pod).Seconds())
+	case v1beta1.WebTerminalTemplateError:
+		r.event(obj, corev1.EventTypeWarning, ReasonPodFailed, "Terminal failed: %s", podFailure(pod))
+	}
+}
 
-	err := wait.PollUntilContextTimeout(context.TODO(), time.Second, time.Minute, false,
-		func(context.Context) (done bool, err error) {
-			err = r.Get(ctx, types.NamespacedName{Name: obj.Name, Namespace: obj.Namespace}, currentPod)
-			if err != nil {
-				if errors.IsNotFound(err) {
-					return true, nil
-				}
-				return false, err
-			}
+// reportKubeconfigFailure shows why the kubeconfig of the pod of obj could not be issued
+func (r *WebterminalTemplateReconciler) reportKubeconfigFailure(ctx context.Context, obj *v1beta1.WebterminalTemplate,
+	err error) {
+	r.event(obj, corev1.EventTypeWarning, ReasonKubeconfigFailed, "Failed to issue kubeconfig: %v", err)
+	status := *obj.Status.DeepCopy()
+	status.Conditions = setCondition(status.Conditions, kubeconfigCondition(time.Time{}, err))
+	if updateErr := r.updateStatus(ctx, obj, status); updateErr != nil {
+		zlog.LogErrorf("Failed to report kubeconfig failure of %s: %v", obj.Name, updateErr)
+	}
+}
 
-			if currentPod.DeletionTimestamp.IsZero() {
-				err = r.Client.Delete(ctx, currentPod)
-				if err != nil {
-					zlog.LogErrorf("Deleting user pod failed : %v", err)
-					return false, err
-				}
-			}
+// templatePhase returns the phase of a template whose pod is pod
+func templatePhase(pod *corev1.Pod) v1beta1.WebTerminalTemplatePhase {
+	if !pod.DeletionTimestamp.IsZero() {
+		return v1beta1.WebTerminalTemplateStopping
+	}
+	if failure := podFailure(pod); failure != "" {
+		zlog.LogWarnf("Terminal pod failed: %s", failure)
+		return v1beta1.WebTerminalTemplateError
+	}
+	if isPodReady(pod) {
+		return v1beta1.WebTerminalTemplateRunning
+	}
+	return v1beta1.WebTerminalTemplateStarting
+}
 
-			zlog.LogInfof("Deleting the %s pod.", obj.Name)
-			return false, nil
-		})
-	if err != nil {
-		zlog.LogErrorf("LogError Deleting user pod, timeout : %v", err)
+// adoptPod makes obj the controller of a pod created before templates owned their pods
+func (r *WebterminalTemplateReconciler) adoptPod(ctx context.Context, obj *v1beta1.WebterminalTemplate,
+	pod *corev1.Pod) error {
+	if metav1.GetControllerOf(pod) != nil {
+		return nil
+	}
+	if err := controllerutil.SetControllerReference(obj, pod, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.Update(ctx, pod); err != nil {
+		zlog.LogErrorf("Failed to adopt pod %s: %v", pod.Name, err)
 		return err
 	}
-
 	return nil
+}
 
+// deletePodTemplate deletes the pod of obj and reports whether it is gone
+func (r *WebterminalTemplateReconciler) deletePodTemplate(ctx context.Context,
+	obj *v1beta1.WebterminalTemplate) (bool, error) {
+	key := types.NamespacedName{Name: obj.Name, Namespace: obj.Namespace}
+	currentPod := &corev1.Pod{}
+	if err := r.Get(ctx, key, currentPod); err != nil {
+		return errors.IsNotFound(err), client.IgnoreNotFound(err)
+	}
+	if currentPod.DeletionTimestamp.IsZero() {
+		if err := r.Client.Delete(ctx, currentPod); err != nil && !errors.IsNotFound(err) {
+			zlog.LogErrorf("Deleting user pod failed : %v", err)
+			return false, err
+		}
+		zlog.LogInfof("Deleting the %s pod.", obj.Name)
+	}
+	// a pod without finalizers or grace period may be gone already
+	return errors.IsNotFound(r.Get(ctx, key, currentPod)), nil
 }
 
+// createPodTemplate creates the pod of obj, owned by obj. The pod is watched, its readiness is not waited for.
 func (r *WebterminalTemplateReconciler) createPodTemplate(ctx context.Context, obj *v1beta1.WebterminalTemplate) error {
 	podtpl := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
@@ -240,48 +389,48 @@ func (r *WebterminalTemplateReconciler) createPodTemplate(ctx context.Context, o
 			Labels:    obj.Spec.PodTemplate.ObjectMeta.Labels,
 		},
 		Spec: corev1.PodSpec{
-			InitContainers: obj.Spec.PodTemplate.Spec.InitContainers,
-			Containers:     obj.Spec.PodTemplate.Spec.Containers,
-			Volumes:        obj.Spec.PodTemplate.Spec.Volumes,
-			RestartPolicy:  obj.Spec.PodTemplate.Spec.RestartPolicy,
+			InitContainers:  withDefaultImage(obj.Spec.PodTemplate.Spec.InitContainers, obj.Spec.DefaultImage),
+			Containers:      withDefaultImage(obj.Spec.PodTemplate.Spec.Containers, obj.Spec.DefaultImage),
+			Volumes:         obj.Spec.PodTemplate.Spec.Volumes,
+			RestartPolicy:   obj.Spec.PodTemplate.Spec.RestartPolicy,
+			Tolerations:     obj.Spec.PodTemplate.Spec.Tolerations,
+			NodeSelector:    obj.Spec.PodTemplate.Spec.NodeSelector,
+			SecurityContext: homeSecurityContext(obj),
 		},
 	}
-
-	err := wait.PollUntilContextTimeout(context.TODO(), time.Second, time.Minute, false,
-		func(context.Context) (done bool, err error) {
-			currentPod := &corev1.Pod{}
-			err = r.Get(ctx, types.NamespacedName{Name: obj.Name, Namespace: obj.Namespace}, currentPod)
-			if err != nil {
-				if errors.IsNotFound(err) {
-					if err = r.Create(ctx, podtpl); err != nil {
-						zlog.LogErrorf("Creating %s Pod failed !", obj.Name)
-						return false, err
-					}
-					zlog.LogInfof("Create %s pod sucess !", obj.Name)
-					return false, err
-				}
-				return false, err
-			}
-
-			// check pod status
-			if !isPodReady(currentPod) {
-				zlog.LogWarnf("%s pod status is not ready !", obj.Name)
-				return false, nil
-			}
-
-			zlog.LogInfof("%s pod status now is ready !", obj.Name)
-			return true, nil
-		})
-	if err != nil {
-		zlog.LogErrorf("LogError Creating user pod, timeout : %v", err)
+	if err := controllerutil.SetControllerReference(obj, podtpl, r.Scheme); err != nil {
+		zlog.LogErrorf("Failed to own %s pod: %v", obj.Name, err)
 		return err
 	}
 
+	if err := r.Create(ctx, podtpl); err != nil {
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		zlog.LogErrorf("Creating %s Pod failed : %v", obj.Name, err)
+		return err
+	}
+	zlog.LogInfof("Create %s pod sucess !", obj.Name)
 	return nil
 }
 
+// withDefaultImage returns a copy of containers where those without an image use image
+func withDefaultImage(containers []corev1.Container, image string) []corev1.Container {
+	if containers == nil {
+		return nil
+	}
+	result := make([]corev1.Container, len(containers))
+	for i, container := range containers {
+		result[i] = *container.DeepCopy()
+		if result[i].Image == "" {
+			result[i].Image = image
+		}
+	}
+	return result
+}
+
 func (r *WebterminalTemplateReconciler) updateStatus(ctx context.Context, obj *v1beta1.WebterminalTemplate,
-	newStatus v1beta1.WebterminalTemplateStatus) (ctrl.Result, error) {
+	newStatus v1beta1.WebterminalTemplateStatus) error {
 	// retry avoid conflict update
 	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		wbTpl := v1beta1.WebterminalTemplate{}
@@ -289,19 +438,20 @@ func (r *WebterminalTemplateReconciler) updateStatus(ctx context.Context, obj *v
 			zlog.LogErrorf("LogError Retrieving web-terminal template object : %v", err)
 			return err
 		}
+		if equality.Semantic.DeepEqual(wbTpl.Status, newStatus) {
+			return nil
+		}
 
-		wbTpl.Status.Phase = newStatus.Phase
-		wbTpl.Status.Conditions = newStatus.Conditions
+		wbTpl.Status = newStatus
 
 		return r.Status().Update(ctx, &wbTpl)
 	})
 
 	if err != nil {
 		zlog.LogErrorf("LogError Updating wbtemplate status : %v", err)
-		return ctrl.Result{}, nil
+		return client.IgnoreNotFound(err)
 	}
-
-	return ctrl.Result{RequeueAfter: defaultUpdateFrequency}, nil // 更新 WebterminalTemplate 对象的状态。它通过重试机制避免更新冲突 并在20s定时调谐
+	return nil
 }
 
 func isPodReady(p *corev1.Pod) bool {
@@ -316,7 +466,17 @@ func isPodReady(p *corev1.Pod) bool {
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *WebterminalTemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.HomeRetention > 0 {
+		err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			wait.UntilWithContext(ctx, r.collectHomes, homeCollectPeriod)
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&terminalv1beta1.WebterminalTemplate{}).
+		Owns(&corev1.Pod{}).
 		Complete(r)
 }
@@ -18,7 +18,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -33,6 +39,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 
 	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/metrics"
 )
 
 type reconcileTestCase struct {
@@ -50,7 +57,7 @@ type updateStatusTestCase struct {
 	newStatus      v1beta1.WebterminalTemplateStatus
 	mockUpdateFail bool
 	expectPhase    v1beta1.WebTerminalTemplatePhase
-	expectRequeue  time.Duration
+	expectErr      bool
 }
 
 func setupScheme() *runtime.Scheme {
@@ -210,6 +217,10 @@ func getReconcileTestCases() []reconcileTestCase {
 				assert.NoError(t, err)
 				assert.False(t, fetched.Spec.ExistsTime.IsZero())
 				assert.Equal(t, v1beta1.WebTerminalTemplateRunning, fetched.Status.Phase)
+				pod := &corev1.Pod{}
+				assert.NoError(t, c.Get(context.TODO(), types.NamespacedName{Name: "new-term", Namespace: "default"}, pod))
+				assert.Equal(t, "new-term", metav1.GetControllerOf(pod).Name, "existing pod should be adopted")
+				assert.Positive(t, result.RequeueAfter)
 			},
 		},
 		{
@@ -273,10 +284,13 @@ func executeUpdateStatusTest(t *testing.T, tc updateStatusTestCase, scheme *runt
 		Scheme: scheme,
 	}
 
-	result, err := r.updateStatus(context.Background(), tc.targetObj, tc.newStatus)
+	err := r.updateStatus(context.Background(), tc.targetObj, tc.newStatus)
 
-	assert.NoError(t, err)
-	assert.Equal(t, tc.expectRequeue, result.RequeueAfter)
+	if tc.expectErr {
+		assert.Error(t, err)
+	} else {
+		assert.NoError(t, err)
+	}
 
 	if tc.existingObj != nil && !tc.mockUpdateFail {
 		updatedObj := &v1beta1.WebterminalTemplate{}
@@ -308,8 +322,7 @@ func getUpdateStatusTestCases() []updateStatusTestCase {
 					{Reason: "Test", Status: "True"},
 				},
 			},
-			expectPhase:   v1beta1.WebTerminalTemplateRunning,
-			expectRequeue: defaultUpdateFrequency,
+			expectPhase: v1beta1.WebTerminalTemplateRunning,
 		},
 		{
 			name:        "Failure: Object not found",
@@ -317,8 +330,7 @@ func getUpdateStatusTestCases() []updateStatusTestCase {
 			targetObj: &v1beta1.WebterminalTemplate{
 				ObjectMeta: metav1.ObjectMeta{Name: "term-missing", Namespace: "default"},
 			},
-			newStatus:     v1beta1.WebterminalTemplateStatus{Phase: v1beta1.WebTerminalTemplateRunning},
-			expectRequeue: 0,
+			newStatus: v1beta1.WebterminalTemplateStatus{Phase: v1beta1.WebTerminalTemplateRunning},
 		},
 		{
 			name: "Failure: Update fails",
@@ -330,7 +342,253 @@ func getUpdateStatusTestCases() []updateStatusTestCase {
 			},
 			newStatus:      v1beta1.WebterminalTemplateStatus{Phase: v1beta1.WebTerminalTemplateRunning},
 			mockUpdateFail: true,
-			expectRequeue:  0,
+			expectErr:      true,
+		},
+	}
+}
+
+func TestWebterminalTemplateReconcilerSessionTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured time.Duration
+		minutes    int
+		want       time.Duration
+	}{
+		{name: "built-in default", want: DefaultSessionTimeout},
+		{name: "controller default", configured: time.Hour, want: time.Hour},
+		{name: "template timeout", configured: time.Hour, minutes: 90, want: 90 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &WebterminalTemplateReconciler{SessionTimeout: tt.configured}
+			wtTemplate := &v1beta1.WebterminalTemplate{Spec: v1beta1.WebterminalTemplateSpec{SessionTimeout: tt.minutes}}
+			assert.Equal(t, tt.want, r.sessionTimeout(wtTemplate))
+		})
+	}
+}
+
+func TestWebterminalTemplateReconcilerCheckTTL(t *testing.T) {
+	scheme := setupScheme()
+	tests := []struct {
+		name        string
+		minutes     int
+		wantDeleted bool
+	}{
+		{name: "idle longer than the default", wantDeleted: true},
+		{name: "idle within the template timeout", minutes: 60},
+		{name: "idle longer than the template timeout", minutes: 10, wantDeleted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wtTemplate := &v1beta1.WebterminalTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "idle-term", Namespace: "default", Finalizers: []string{finalizer}},
+				Spec: v1beta1.WebterminalTemplateSpec{
+					SessionTimeout: tt.minutes,
+					RenewTime:      metav1.NewTime(time.Now().Add(updateTime)),
+				},
+			}
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(wtTemplate).Build()
+			r := &WebterminalTemplateReconciler{Client: fakeClient, Scheme: scheme}
+
+			deletions := testutil.ToFloat64(metrics.TTLDeletions)
+			deleted, _, err := r.checkTTL(context.Background(), wtTemplate)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantDeleted, deleted)
+			if tt.wantDeleted {
+				deletions++
+			}
+			assert.Equal(t, deletions, testutil.ToFloat64(metrics.TTLDeletions))
+			fetched := &v1beta1.WebterminalTemplate{}
+			assert.NoError(t, fakeClient.Get(context.Background(), client.ObjectKeyFromObject(wtTemplate), fetched))
+			assert.Equal(t, tt.wantDeleted, !fetched.DeletionTimestamp.IsZero())
+		})
+	}
+}
+
+func TestWithDefaultImage(t *testing.T) {
+	containers := []corev1.Container{{Name: "shell"}, {Name: "sidecar", Image: "envoy"}}
+	got := withDefaultImage(containers, "toolbox")
+	assert.Equal(t, []corev1.Container{{Name: "shell", Image: "toolbox"}, {Name: "sidecar", Image: "envoy"}}, got)
+	assert.Empty(t, containers[0].Image, "the template must not be modified")
+	assert.Nil(t, withDefaultImage(nil, "toolbox"))
+}
+
+func newStartingTemplate(name string) *v1beta1.WebterminalTemplate {
+	return &v1beta1.WebterminalTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Finalizers: []string{finalizer}},
+		Spec: v1beta1.WebterminalTemplateSpec{
+			DefaultImage: "toolbox",
+			PodTemplate: v1beta1.PodTemplate{
+				ObjectMeta: v1beta1.PodTemplateObjectMeta{Name: name, Namespace: "default"},
+				Spec:       v1beta1.PodTemplateSpec{Containers: []corev1.Container{{Name: "shell"}}},
+			},
 		},
 	}
 }
+
+func TestWebterminalTemplateReconcilerCreatesOwnedPod(t *testing.T) {
+	scheme := setupScheme()
+	wtTemplate := newStartingTemplate("new-term")
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(wtTemplate).
+		WithStatusSubresource(wtTemplate).Build()
+	recorder := record.NewFakeRecorder(bufferSize)
+	r := &WebterminalTemplateReconciler{Client: fakeClient, Scheme: scheme, Recorder: recorder}
+
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(wtTemplate)}
+	result, err := r.Reconcile(context.Background(), req)
+	assert.NoError(t, err)
+	assert.InDelta(t, DefaultSessionTimeout-expiringNotice, result.RequeueAfter, float64(time.Second))
+	assert.Equal(t, "Normal PodCreated Created terminal pod new-term", <-recorder.Events)
+
+	pod := &corev1.Pod{}
+	assert.NoError(t, fakeClient.Get(context.Background(), req.NamespacedName, pod))
+	owner := metav1.GetControllerOf(pod)
+	if assert.NotNil(t, owner) {
+		assert.Equal(t, "WebterminalTemplate", owner.Kind)
+		assert.Equal(t, "new-term", owner.Name)
+	}
+	assert.Equal(t, "toolbox", pod.Spec.Containers[0].Image)
+	fetched := &v1beta1.WebterminalTemplate{}
+	assert.NoError(t, fakeClient.Get(context.Background(), req.NamespacedName, fetched))
+	assert.Equal(t, v1beta1.WebTerminalTemplateStarting, fetched.Status.Phase)
+	assert.NotNil(t, fetched.Status.LastActivityTime)
+	assert.WithinDuration(t, time.Now().Add(DefaultSessionTimeout), fetched.Status.ExpiresAt.Time, 2*time.Second)
+	assert.Equal(t, []v1beta1.WebTerminalTemplateConditionType{v1beta1.WebTerminalTemplatePodScheduled,
+		v1beta1.WebTerminalTemplatePodReady, v1beta1.WebTerminalTemplateExpiring}, conditionTypes(fetched))
+
+	// a crash of the pod shows in the status with the next reconcile
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "shell", State: corev1.ContainerState{
+		Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}}}
+	assert.NoError(t, fakeClient.Status().Update(context.Background(), pod))
+	_, err = r.Reconcile(context.Background(), req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(context.Background(), req.NamespacedName, fetched))
+	assert.Equal(t, v1beta1.WebTerminalTemplateError, fetched.Status.Phase)
+	assert.Equal(t, "Warning PodFailed Terminal failed: container shell of pod new-term is waiting: CrashLoopBackOff",
+		<-recorder.Events)
+}
+
+func conditionTypes(obj *v1beta1.WebterminalTemplate) []v1beta1.WebTerminalTemplateConditionType {
+	var result []v1beta1.WebTerminalTemplateConditionType
+	for _, cond := range obj.Status.Conditions {
+		result = append(result, cond.Type)
+	}
+	return result
+}
+
+func TestWebterminalTemplateReconcilerWaitsForPodDeletion(t *testing.T) {
+	scheme := setupScheme()
+	now := metav1.Now()
+	wtTemplate := newStartingTemplate("deleting-term")
+	wtTemplate.DeletionTimestamp = &now
+	pod := newReadyPod("deleting-term", "default")
+	pod.Finalizers = []string{"example.com/keep"}
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(wtTemplate, pod).Build()
+	r := &WebterminalTemplateReconciler{Client: fakeClient, Scheme: scheme}
+
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(wtTemplate)}
+	result, err := r.Reconcile(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, podDeletionPeriod, result.RequeueAfter)
+	fetched := &v1beta1.WebterminalTemplate{}
+	assert.NoError(t, fakeClient.Get(context.Background(), req.NamespacedName, fetched))
+	assert.Contains(t, fetched.Finalizers, finalizer)
+	assert.NoError(t, fakeClient.Get(context.Background(), req.NamespacedName, pod))
+	assert.False(t, pod.DeletionTimestamp.IsZero())
+}
+
+func TestWebterminalTemplateReconcilerTraces(t *testing.T) {
+	provider := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(provider)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	scheme := setupScheme()
+	wtTemplate := newStartingTemplate("traced-term")
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(wtTemplate).
+		WithStatusSubresource(wtTemplate).Build()
+	r := &WebterminalTemplateReconciler{Client: fakeClient, Scheme: scheme,
+		Recorder: record.NewFakeRecorder(bufferSize)}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(wtTemplate)})
+	assert.NoError(t, err)
+
+	spans := recorder.Ended()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	assert.Equal(t, []string{"handleFinalizer", "ensureKubeconfig", "ensureHome", "ensurePodRunning", "checkTTL",
+		"syncStatus", "WebterminalTemplate.Reconcile"}, names)
+	root := spans[len(spans)-1]
+	for _, span := range spans[:len(spans)-1] {
+		assert.Equal(t, root.SpanContext().SpanID(), span.Parent().SpanID(), span.Name())
+	}
+	assert.Contains(t, root.Attributes(), attribute.String("webterminaltemplate", "traced-term"))
+
+	// a failed reconcile marks its span failed
+	failing := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+			opts ...client.GetOption) error {
+			return stdErrors.New("connection refused")
+		},
+	}).Build()
+	r = &WebterminalTemplateReconciler{Client: failing, Scheme: scheme}
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(wtTemplate)})
+	assert.Error(t, err)
+	spans = recorder.Ended()
+	assert.Equal(t, codes.Error, spans[len(spans)-1].Status().Code)
+}
+
+func TestTemplatePhase(t *testing.T) {
+	now := metav1.Now()
+	waiting := func(reason string) corev1.ContainerState {
+		return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}
+	}
+	tests := []struct {
+		name string
+		pod  corev1.Pod
+		want v1beta1.WebTerminalTemplatePhase
+	}{
+		{name: "ready", pod: *newReadyPod("p", "default"), want: v1beta1.WebTerminalTemplateRunning},
+		{name: "creating", pod: corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{State: waiting("ContainerCreating")}}}}, want: v1beta1.WebTerminalTemplateStarting},
+		{name: "crash loop", pod: corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{State: waiting("CrashLoopBackOff")}}}}, want: v1beta1.WebTerminalTemplateError},
+		{name: "image pull", pod: corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{State: waiting("ImagePullBackOff")}}}}, want: v1beta1.WebTerminalTemplateError},
+		{name: "init container failed", pod: corev1.Pod{Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}}}}}},
+			want: v1beta1.WebTerminalTemplateError},
+		{name: "init container done", pod: corev1.Pod{Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{}}}}}},
+			want: v1beta1.WebTerminalTemplateStarting},
+		{name: "failed", pod: corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodFailed}},
+			want: v1beta1.WebTerminalTemplateError},
+		{name: "deleting", pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &now}},
+			want: v1beta1.WebTerminalTemplateStopping},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, templatePhase(&tt.pod))
+		})
+	}
+}
+
+func TestWebterminalTemplateReconcilerNextCheck(t *testing.T) {
+	r := &WebterminalTemplateReconciler{SessionTimeout: time.Hour}
+	renewed := &v1beta1.WebterminalTemplate{Spec: v1beta1.WebterminalTemplateSpec{RenewTime: metav1.Now()}}
+	expired := &v1beta1.WebterminalTemplate{Spec: v1beta1.WebterminalTemplateSpec{
+		RenewTime: metav1.NewTime(time.Now().Add(-2 * time.Hour))}}
+
+	expiring := &v1beta1.WebterminalTemplate{Spec: v1beta1.WebterminalTemplateSpec{
+		RenewTime: metav1.NewTime(time.Now().Add(-58 * time.Minute))}}
+
+	assert.InDelta(t, time.Hour-expiringNotice, r.nextCheck(renewed, time.Time{}), float64(time.Second))
+	assert.InDelta(t, 10*time.Minute, r.nextCheck(renewed, time.Now().Add(10*time.Minute)), float64(time.Second))
+	assert.InDelta(t, time.Hour-expiringNotice, r.nextCheck(renewed, time.Now().Add(2*time.Hour)),
+		float64(time.Second))
+	assert.InDelta(t, 2*time.Minute, r.nextCheck(expiring, time.Time{}), float64(time.Second))
+	assert.Equal(t, minRequeuePeriod, r.nextCheck(expired, time.Time{}))
+}
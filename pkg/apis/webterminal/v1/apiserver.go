@@ -22,17 +22,24 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/emicklei/go-restful/v3"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authn"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/client/k8s"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/config"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/filters"
+	"openfuyao.com/web-terminal-service/pkg/audit"
 	"openfuyao.com/web-terminal-service/pkg/zlog"
 )
 
+// auditCloseTimeout bounds writing the queued audit events on shutdown
+const auditCloseTimeout = 10 * time.Second
+
 // APIServer defines the structure for an API server that includes an HTTP server,
 // a client for interacting with Kubernetes, a client for interacting with controller manager
 // and a restful API web server.
@@ -51,6 +58,11 @@ type APIServer struct {
 
 	// http.client
 	ApiClient *APIClient
+
+	cfg *config.RunConfig
+
+	// auditor writes the audit log of the handlers, nil when auditing is disabled
+	auditor *audit.Logger
 }
 
 // NewServer creates an cServer instance using given options
@@ -58,6 +70,7 @@ func NewServer(cfg *config.RunConfig, ctx context.Context, client client.Client)
 	server := &APIServer{
 		MgrClient: client,
 		ApiClient: NewAPIClient(),
+		cfg:       cfg,
 	}
 
 	httpServer, err := initServer(cfg)
@@ -66,14 +79,22 @@ func NewServer(cfg *config.RunConfig, ctx context.Context, client client.Client)
 	}
 	server.Server = httpServer
 
+	auditor, err := audit.New(cfg.Audit)
+	if err != nil {
+		return nil, fmt.Errorf("creating audit log: %w", err)
+	}
+	server.auditor = auditor
+
 	// 初始化 Container
 	server.container = restful.NewContainer() // 创建一个新的 restful.Container，它用于管理 RESTful API 的路由和处理逻辑。
 	// 为容器设置路由策略，这里使用 CurlyRouter，它支持通过花括号 {} 来定义 URL 路由参数（例如 /pods/{podName}）
 	server.container.Router(restful.CurlyRouter{})
+	// 为容器添加链路追踪过滤器，延续请求头中 W3C trace context 所属的链路，后续过滤器与处理逻辑的 span 都挂在该请求的 span 下
+	server.container.Filter(filters.TraceRequests)
 	// 为容器添加一个过滤器，用于记录访问日志。过滤器会在每个请求之前或之后执行某些操作（如日志记录）
 	server.container.Filter(filters.RecordAccessLogs)
-	// 为容器添加另一个过滤器，用于处理身份验证相关的逻辑:提取 JWT token 并将 subject 存入上下文中。chain.ProcessFilter 会调用下一个过滤器直到请求被完全处理。
-	server.container.Filter(filters.ExactSubjectAccess)
+	// 为容器添加另一个过滤器，用于处理身份验证相关的逻辑:提取并校验 JWT token，将 subject 和 groups 存入上下文中，校验失败返回 401。chain.ProcessFilter 会调用下一个过滤器直到请求被完全处理。
+	server.container.Filter(filters.NewExactSubjectAccess(newTokenVerifier(cfg)))
 
 	// 初始化client和informers
 	kubernetesClient, err := k8s.NewKubernetesClient(cfg.KubernetesCfg)
@@ -85,6 +106,14 @@ func NewServer(cfg *config.RunConfig, ctx context.Context, client client.Client)
 	return server, nil
 }
 
+func newTokenVerifier(cfg *config.RunConfig) authn.TokenVerifier {
+	if cfg.Authentication == nil || !cfg.Authentication.Enabled() {
+		zlog.LogWarn("No token verification keys configured, all bearer tokens will be rejected")
+		return authn.NewTokenVerifier(&authn.AuthenticationCfg{})
+	}
+	return authn.NewTokenVerifier(cfg.Authentication)
+}
+
 func initServer(cfg *config.RunConfig) (*http.Server, error) {
 	// 初始化 cServer
 	httpServer := &http.Server{
@@ -147,7 +176,19 @@ func (s *APIServer) Run(ctx context.Context) error {
 }
 
 func (s *APIServer) registerAPI() {
-	runtime.Must(AddToContainer(s.container, s.MgrClient))
+	runtime.Must(AddToContainer(s.container, s.MgrClient, s.cfg, s.auditor))
+}
+
+// closeAuditLog writes the queued audit events and closes the audit log once ctx is done
+func (s *APIServer) closeAuditLog(ctx context.Context) error {
+	<-ctx.Done()
+	closeCtx, cancel := context.WithTimeout(context.Background(), auditCloseTimeout)
+	defer cancel()
+	if err := s.auditor.Close(closeCtx); err != nil {
+		zlog.LogErrorf("Failed to close the audit log: %v", err)
+		return err
+	}
+	return nil
 }
 
 func addSecurityHeader(next http.Handler) http.Handler {
@@ -163,8 +204,9 @@ func addSecurityHeader(next http.Handler) http.Handler {
 	})
 }
 
-// StartAPIServer initializes and starts an API server using the provided configurations.
-func StartAPIServer(client client.Client) {
+// StartAPIServer initializes and starts an API server using the provided configurations. The manager closes
+// its audit log when it stops.
+func StartAPIServer(mgr manager.Manager) {
 	runOptions := config.NewRunConfig()
 	// 校验server和k8s配置
 	if errs := runOptions.Validate(); len(errs) != 0 {
@@ -172,10 +214,13 @@ func StartAPIServer(client client.Client) {
 	}
 
 	ctx := context.TODO()
-	apiServer, err := NewServer(runOptions, ctx, client)
+	apiServer, err := NewServer(runOptions, ctx, mgr.GetClient())
 	if err != nil {
 		zlog.LogFatalf("Failed to Init Web-Terminal API Service : %v", err)
 	}
+	if err = mgr.Add(manager.RunnableFunc(apiServer.closeAuditLog)); err != nil {
+		zlog.LogFatalf("Failed to register the audit log with the manager: %v", err)
+	}
 
 	go func() {
 		err = apiServer.Run(ctx)
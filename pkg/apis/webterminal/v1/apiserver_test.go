@@ -27,10 +27,12 @@ import (
 	"github.com/agiledragon/gomonkey/v2"
 	"github.com/emicklei/go-restful/v3"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/config"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/runtime"
+	"openfuyao.com/web-terminal-service/pkg/audit"
 )
 
 func TestInitServer(t *testing.T) {
@@ -187,7 +189,8 @@ func TestAPIServerRun(t *testing.T) {
 	}
 
 	// Monkey Patch AddToContainer
-	patch := gomonkey.ApplyFunc(AddToContainer, func(container *restful.Container, client client.Client) error {
+	patch := gomonkey.ApplyFunc(AddToContainer, func(container *restful.Container, client client.Client,
+		cfg *config.RunConfig, auditor *audit.Logger) error {
 		webService := new(restful.WebService)
 		container.Add(webService)
 		return nil
@@ -208,3 +211,24 @@ func TestAPIServerRun(t *testing.T) {
 		t.Errorf("Unexpected error: %v", err)
 	}
 }
+
+func TestAPIServerCloseAuditLog(t *testing.T) {
+	sink := &memorySink{}
+	apiServer := &APIServer{auditor: audit.NewLogger(sink, 8, 0, 0)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- apiServer.closeAuditLog(ctx) }()
+
+	apiServer.auditor.Log(audit.Event{Type: audit.TypeSessionClose, SessionID: "s-1"})
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the audit log was not closed")
+	}
+	require.Len(t, sink.events, 1)
+	assert.True(t, sink.closed)
+
+	assert.NoError(t, (&APIServer{}).closeAuditLog(ctx), "auditing is disabled")
+}
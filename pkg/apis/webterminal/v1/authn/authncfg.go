@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+// Package authn verifies the bearer tokens presented to the web terminal service.
+package authn
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	defaultJWKSRefreshInterval = 10 * time.Minute
+
+	envKeyFile             = "JWT_KEY_FILE"
+	envHMACSecretFile      = "JWT_HMAC_SECRET_FILE"
+	envJWKSURL             = "JWT_JWKS_URL"
+	envJWKSRefreshInterval = "JWT_JWKS_REFRESH_INTERVAL"
+	envIssuer              = "JWT_ISSUER"
+	envAudience            = "JWT_AUDIENCE"
+)
+
+// AuthenticationCfg holds the key sources and claim expectations used to verify tokens
+type AuthenticationCfg struct {
+	// PEM encoded public keys/certificates or a JWKS document on disk
+	KeyFile string
+	// file holding the shared secret of HMAC signed tokens
+	HMACSecretFile string
+	// JWKS endpoint, usually served by the platform's auth service
+	JWKSURL string
+	// how often keys are re-read from KeyFile or JWKSURL
+	JWKSRefreshInterval time.Duration
+	// expected iss claim, not checked when empty
+	Issuer string
+	// expected aud claim, not checked when empty
+	Audience string
+}
+
+// NewAuthenticationCfg returns the authentication config read from the environment
+func NewAuthenticationCfg() *AuthenticationCfg {
+	c := &AuthenticationCfg{
+		KeyFile:             os.Getenv(envKeyFile),
+		HMACSecretFile:      os.Getenv(envHMACSecretFile),
+		JWKSURL:             os.Getenv(envJWKSURL),
+		JWKSRefreshInterval: defaultJWKSRefreshInterval,
+		Issuer:              os.Getenv(envIssuer),
+		Audience:            os.Getenv(envAudience),
+	}
+	if v := os.Getenv(envJWKSRefreshInterval); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			zlog.LogWarnf("invalid %s %q, use default %s", envJWKSRefreshInterval, v, defaultJWKSRefreshInterval)
+		} else {
+			c.JWKSRefreshInterval = interval
+		}
+	}
+	return c
+}
+
+// Validate validate authentication config
+func (c *AuthenticationCfg) Validate() []error {
+	var errs []error
+	if c.KeyFile != "" {
+		if _, err := os.Stat(c.KeyFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.HMACSecretFile != "" {
+		if _, err := os.Stat(c.HMACSecretFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.JWKSURL != "" {
+		if u, err := url.Parse(c.JWKSURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid jwks url %q", c.JWKSURL))
+		}
+	}
+	if c.JWKSRefreshInterval <= 0 {
+		errs = append(errs, errors.New("jwks refresh interval must be positive"))
+	}
+	return errs
+}
+
+// Enabled reports whether at least one key source is configured
+func (c *AuthenticationCfg) Enabled() bool {
+	return c.KeyFile != "" || c.HMACSecretFile != "" || c.JWKSURL != ""
+}
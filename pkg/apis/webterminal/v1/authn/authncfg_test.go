@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package authn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAuthenticationCfg(t *testing.T) {
+	t.Setenv(envJWKSURL, "http://127.0.0.1:8080/jwks")
+	t.Setenv(envIssuer, "openfuyao")
+	t.Setenv(envJWKSRefreshInterval, "1m")
+
+	got := NewAuthenticationCfg()
+	assert.Equal(t, &AuthenticationCfg{
+		JWKSURL:             "http://127.0.0.1:8080/jwks",
+		JWKSRefreshInterval: time.Minute,
+		Issuer:              "openfuyao",
+	}, got)
+	assert.True(t, got.Enabled())
+
+	t.Setenv(envJWKSRefreshInterval, "soon")
+	assert.Equal(t, defaultJWKSRefreshInterval, NewAuthenticationCfg().JWKSRefreshInterval)
+}
+
+func TestAuthenticationCfgValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     AuthenticationCfg
+		wantErr int
+	}{
+		{
+			name: "ok",
+			cfg:  AuthenticationCfg{JWKSURL: "https://auth.local/jwks", JWKSRefreshInterval: time.Minute},
+		},
+		{
+			name: "missing files",
+			cfg: AuthenticationCfg{KeyFile: "/nonexistent/k", HMACSecretFile: "/nonexistent/s",
+				JWKSRefreshInterval: time.Minute},
+			wantErr: 2,
+		},
+		{
+			name:    "bad url and interval",
+			cfg:     AuthenticationCfg{JWKSURL: "jwks"},
+			wantErr: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, tt.cfg.Validate(), tt.wantErr)
+		})
+	}
+}
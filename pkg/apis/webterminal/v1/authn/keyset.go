@@ -0,0 +1,343 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package authn
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	// minRefreshInterval limits how often an unknown kid may trigger a reload
+	minRefreshInterval = 10 * time.Second
+	maxJWKSSize        = 1 << 20
+	jwksRequestTimeout = 10 * time.Second
+)
+
+// verificationKey is a single key usable to check token signatures
+type verificationKey struct {
+	kid string
+	// key is one of *rsa.PublicKey, *ecdsa.PublicKey or []byte for HMAC secrets
+	key interface{}
+}
+
+type keySource interface {
+	load(ctx context.Context) ([]verificationKey, error)
+}
+
+// fileSource reads PEM encoded keys, a JWKS document or an HMAC secret from disk
+type fileSource struct {
+	path string
+	hmac bool
+}
+
+func (f *fileSource) load(_ context.Context) ([]verificationKey, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if f.hmac {
+		secret := bytes.TrimSpace(data)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("hmac secret file %s is empty", f.path)
+		}
+		return []verificationKey{{key: secret}}, nil
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return parseJWKS(trimmed)
+	}
+	return parsePEMKeys(data)
+}
+
+// jwksSource fetches a JWKS document over http
+type jwksSource struct {
+	url    string
+	client *http.Client
+}
+
+func (j *jwksSource) load(ctx context.Context) ([]verificationKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks %s failed with status code %d", j.url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+// KeySet caches verification keys and reloads them periodically, or earlier when
+// a token references a key id that is not known yet, so that rotated keys are picked up.
+type KeySet struct {
+	sources         []keySource
+	refreshInterval time.Duration
+
+	mu          sync.Mutex
+	keys        []verificationKey
+	lastRefresh time.Time
+	// loading is closed when the reload in progress finishes, nil while none runs
+	loading chan struct{}
+}
+
+// NewKeySet builds the key set described by cfg
+func NewKeySet(cfg *AuthenticationCfg) *KeySet {
+	ks := &KeySet{refreshInterval: cfg.JWKSRefreshInterval}
+	if cfg.KeyFile != "" {
+		ks.sources = append(ks.sources, &fileSource{path: cfg.KeyFile})
+	}
+	if cfg.HMACSecretFile != "" {
+		ks.sources = append(ks.sources, &fileSource{path: cfg.HMACSecretFile, hmac: true})
+	}
+	if cfg.JWKSURL != "" {
+		ks.sources = append(ks.sources, &jwksSource{
+			url:    cfg.JWKSURL,
+			client: &http.Client{Timeout: jwksRequestTimeout},
+		})
+	}
+	return ks
+}
+
+// candidates returns the keys that may have signed a token with the given kid
+func (k *KeySet) candidates(ctx context.Context, kid string) ([]interface{}, error) {
+	if len(k.sources) == 0 {
+		return nil, errors.New("no token verification keys configured")
+	}
+	k.refresh(ctx, k.refreshInterval)
+	found := k.match(kid)
+	if len(found) == 0 {
+		k.refresh(ctx, minRefreshInterval)
+		found = k.match(kid)
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no verification key found for kid %q", kid)
+	}
+	return found, nil
+}
+
+func (k *KeySet) match(kid string) []interface{} {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var found []interface{}
+	for _, vk := range k.keys {
+		if kid == "" || vk.kid == "" || vk.kid == kid {
+			found = append(found, vk.key)
+		}
+	}
+	return found
+}
+
+// refresh reloads all sources when the last reload finished more than maxAge ago. Callers arriving while
+// a reload runs wait for it instead of starting another one, the sources are read without holding mu.
+// Keys of a failing source are kept until it recovers.
+func (k *KeySet) refresh(ctx context.Context, maxAge time.Duration) {
+	k.mu.Lock()
+	if time.Since(k.lastRefresh) <= maxAge {
+		k.mu.Unlock()
+		return
+	}
+	if loading := k.loading; loading != nil {
+		k.mu.Unlock()
+		select {
+		case <-loading:
+		case <-ctx.Done():
+		}
+		return
+	}
+	loading := make(chan struct{})
+	k.loading = loading
+	k.mu.Unlock()
+	defer close(loading)
+
+	var keys []verificationKey
+	failed := false
+	for _, src := range k.sources {
+		loaded, err := src.load(ctx)
+		if err != nil {
+			zlog.LogWarnf("Failed to load token verification keys: %v", err)
+			failed = true
+			continue
+		}
+		keys = append(keys, loaded...)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.loading = nil
+	k.lastRefresh = time.Now()
+	if failed && len(k.keys) > 0 {
+		return
+	}
+	k.keys = keys
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func parseJWKS(data []byte) ([]verificationKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing jwks: %w", err)
+	}
+	var keys []verificationKey
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			zlog.LogWarnf("Skipping jwk %q: %v", jwk.Kid, err)
+			continue
+		}
+		keys = append(keys, verificationKey{kid: jwk.Kid, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (j *jsonWebKey) publicKey() (interface{}, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, err := curveByName(j.Crv)
+		if err != nil {
+			return nil, err
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ec point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(j.K)
+		if err != nil {
+			return nil, err
+		}
+		return secret, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing key parameter")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func curveByName(name string) (elliptic.Curve, error) {
+	switch name {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", name)
+	}
+}
+
+func parsePEMKeys(data []byte) ([]verificationKey, error) {
+	var keys []verificationKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s block: %w", block.Type, err)
+		}
+		keys = append(keys, verificationKey{key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found in pem data")
+	}
+	return keys, nil
+}
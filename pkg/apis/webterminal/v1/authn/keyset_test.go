@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package authn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rsaJWK(kid string, key *rsa.PublicKey) jsonWebKey {
+	return jsonWebKey{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) jsonWebKey {
+	return jsonWebKey{
+		Kty: "EC",
+		Kid: kid,
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+	}
+}
+
+// jwksServer serves whatever key set is currently stored and counts the fetches.
+// While blocked is set, requests wait until it is closed.
+type jwksServer struct {
+	mu       sync.Mutex
+	keys     []jsonWebKey
+	blocked  chan struct{}
+	requests int32
+}
+
+func (s *jwksServer) set(keys ...jsonWebKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	atomic.AddInt32(&s.requests, 1)
+	s.mu.Lock()
+	blocked := s.blocked
+	s.mu.Unlock()
+	if blocked != nil {
+		<-blocked
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+}
+
+func TestKeySetJWKSRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jwks := &jwksServer{}
+	jwks.set(rsaJWK("old", &oldKey.PublicKey))
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+
+	cfg := &AuthenticationCfg{JWKSURL: server.URL, JWKSRefreshInterval: time.Hour}
+	verifier := NewTokenVerifier(cfg).(*jwtVerifier)
+	ctx := context.Background()
+
+	_, err = verifier.Verify(ctx, signToken(t, jwt.SigningMethodRS256, oldKey, "old", validClaims()))
+	require.NoError(t, err)
+	_, err = verifier.Verify(ctx, signToken(t, jwt.SigningMethodRS256, oldKey, "old", validClaims()))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&jwks.requests), "keys should be served from cache")
+
+	// rotate: the unknown kid forces a reload once the minimum refresh interval passed
+	jwks.set(ecJWK("new", &newKey.PublicKey))
+	newToken := signToken(t, jwt.SigningMethodES256, newKey, "new", validClaims())
+	_, err = verifier.Verify(ctx, newToken)
+	assert.Error(t, err, "reload must be rate limited")
+
+	verifier.keys.lastRefresh = time.Now().Add(-2 * minRefreshInterval)
+	_, err = verifier.Verify(ctx, newToken)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&jwks.requests))
+
+	_, err = verifier.Verify(ctx, signToken(t, jwt.SigningMethodRS256, oldKey, "old", validClaims()))
+	assert.Error(t, err, "retired key must no longer verify")
+}
+
+func TestKeySetUnknownKidReloadsOnce(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks := &jwksServer{}
+	jwks.set(rsaJWK("k1", &key.PublicKey))
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+
+	ks := NewKeySet(&AuthenticationCfg{JWKSURL: server.URL, JWKSRefreshInterval: time.Hour})
+	ctx := context.Background()
+	_, err = ks.candidates(ctx, "k1")
+	require.NoError(t, err)
+	ks.lastRefresh = time.Now().Add(-2 * minRefreshInterval)
+
+	// while the reload for the unknown kids hangs, known keys are still served
+	blocked := make(chan struct{})
+	jwks.mu.Lock()
+	jwks.blocked = blocked
+	jwks.mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ks.candidates(ctx, "unknown")
+			assert.Error(t, err)
+		}()
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&jwks.requests) == 2 }, time.Second,
+		10*time.Millisecond)
+	got, err := ks.candidates(ctx, "k1")
+	require.NoError(t, err)
+	assert.Len(t, got, 1)
+
+	close(blocked)
+	wg.Wait()
+	_, err = ks.candidates(ctx, "unknown")
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&jwks.requests), "unknown kids reload once per interval")
+}
+
+func TestKeySetKeepsKeysWhenSourceFails(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks := &jwksServer{}
+	jwks.set(rsaJWK("k1", &key.PublicKey))
+	server := httptest.NewServer(jwks)
+
+	ks := NewKeySet(&AuthenticationCfg{JWKSURL: server.URL, JWKSRefreshInterval: time.Hour})
+	_, err = ks.candidates(context.Background(), "k1")
+	require.NoError(t, err)
+
+	server.Close()
+	ks.lastRefresh = time.Time{}
+	got, err := ks.candidates(context.Background(), "k1")
+	require.NoError(t, err)
+	assert.Len(t, got, 1)
+}
+
+func TestParseJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	valid, err := json.Marshal(map[string]interface{}{"keys": []jsonWebKey{
+		rsaJWK("sig", &key.PublicKey),
+		{Kty: "RSA", Kid: "enc", Use: "enc"},
+		{Kty: "oct", Kid: "hmac", K: base64.RawURLEncoding.EncodeToString([]byte("secret"))},
+		{Kty: "OKP", Kid: "unsupported"},
+	}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []string
+		wantErr bool
+	}{
+		{name: "mixed key set", data: valid, want: []string{"sig", "hmac"}},
+		{name: "not json", data: []byte("{"), wantErr: true},
+		{name: "no usable keys", data: []byte(`{"keys":[{"kty":"OKP"}]}`), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJWKS(tt.data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var kids []string
+			for _, k := range got {
+				kids = append(kids, k.kid)
+			}
+			assert.Equal(t, tt.want, kids)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package authn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var validMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"HS256", "HS384", "HS512",
+}
+
+// Claims are the token claims the service relies on
+type Claims struct {
+	jwt.RegisteredClaims
+	Groups []string `json:"groups,omitempty"`
+}
+
+// TokenVerifier checks a raw bearer token and returns its claims when it can be trusted
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*Claims, error)
+}
+
+type jwtVerifier struct {
+	keys     *KeySet
+	issuer   string
+	audience string
+}
+
+// NewTokenVerifier creates a TokenVerifier checking signatures against the keys in cfg
+// and the exp, nbf, iss and aud claims of the token. Tokens without exp never expire and are rejected.
+func NewTokenVerifier(cfg *AuthenticationCfg) TokenVerifier {
+	return &jwtVerifier{
+		keys:     NewKeySet(cfg),
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+	}
+}
+
+// Verify implements TokenVerifier
+func (v *jwtVerifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods(validMethods))
+	unverified := &Claims{}
+	parsed, _, err := parser.ParseUnverified(token, unverified)
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	candidates, err := v.keys.candidates(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, key := range candidates {
+		if !keyMatchesMethod(key, parsed.Method) {
+			continue
+		}
+		claims := &Claims{}
+		_, err = parser.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+			return key, nil
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if err = v.checkClaims(claims); err != nil {
+			return nil, err
+		}
+		return claims, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no verification key matches signing method %s", parsed.Method.Alg())
+	}
+	return nil, lastErr
+}
+
+func (v *jwtVerifier) checkClaims(claims *Claims) error {
+	if claims.Subject == "" {
+		return errors.New("token has no subject")
+	}
+	// the parser only checks exp when it is present
+	if claims.ExpiresAt == nil {
+		return errors.New("token has no expiry")
+	}
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return errors.New("token audience does not match")
+	}
+	return nil
+}
+
+func keyMatchesMethod(key interface{}, method jwt.SigningMethod) bool {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		_, ok := key.(*ecdsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodHMAC:
+		_, ok := key.([]byte)
+		return ok
+	default:
+		return false
+	}
+}
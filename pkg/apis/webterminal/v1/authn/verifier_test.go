@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package authn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEMPublicKey(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+	return path
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims *Claims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() *Claims {
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "alice",
+			Issuer:    "openfuyao",
+			Audience:  jwt.ClaimStrings{"web-terminal"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Groups: []string{"sre"},
+	}
+}
+
+func TestJWTVerifierVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	hmacFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(hmacFile, []byte("s3cr3t\n"), 0600))
+
+	expired := validClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	noExpiry := validClaims()
+	noExpiry.ExpiresAt = nil
+	notYet := validClaims()
+	notYet.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "someone-else"
+	wrongAudience := validClaims()
+	wrongAudience.Audience = jwt.ClaimStrings{"other"}
+
+	rsaCfg := &AuthenticationCfg{KeyFile: writePEMPublicKey(t, &rsaKey.PublicKey),
+		JWKSRefreshInterval: time.Minute, Issuer: "openfuyao", Audience: "web-terminal"}
+	ecCfg := &AuthenticationCfg{KeyFile: writePEMPublicKey(t, &ecKey.PublicKey), JWKSRefreshInterval: time.Minute}
+	hmacCfg := &AuthenticationCfg{HMACSecretFile: hmacFile, JWKSRefreshInterval: time.Minute}
+
+	tests := []struct {
+		name    string
+		cfg     *AuthenticationCfg
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "rsa signed",
+			cfg:   rsaCfg,
+			token: signToken(t, jwt.SigningMethodRS256, rsaKey, "", validClaims()),
+		},
+		{
+			name:  "ecdsa signed",
+			cfg:   ecCfg,
+			token: signToken(t, jwt.SigningMethodES256, ecKey, "", validClaims()),
+		},
+		{
+			name:  "hmac signed",
+			cfg:   hmacCfg,
+			token: signToken(t, jwt.SigningMethodHS256, []byte("s3cr3t"), "", validClaims()),
+		},
+		{
+			name:    "forged signature",
+			cfg:     rsaCfg,
+			token:   signToken(t, jwt.SigningMethodRS256, otherRSAKey, "", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "hmac with wrong secret",
+			cfg:     hmacCfg,
+			token:   signToken(t, jwt.SigningMethodHS256, []byte("guess"), "", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "hmac token against rsa keys",
+			cfg:     rsaCfg,
+			token:   signToken(t, jwt.SigningMethodHS256, []byte("s3cr3t"), "", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "unsigned token",
+			cfg:     rsaCfg,
+			token:   signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			cfg:     rsaCfg,
+			token:   signToken(t, jwt.SigningMethodRS256, rsaKey, "", expired),
+			wantErr: true,
+		},
+		{
+			name:    "no expiry",
+			cfg:     rsaCfg,
+			token:   signToken(t, jwt.SigningMethodRS256, rsaKey, "", noExpiry),
+			wantErr: true,
+		},
+		{
+			name:    "not yet valid",
+			cfg:     rsaCfg,
+			token:   signToken(t, jwt.SigningMethodRS256, rsaKey, "", notYet),
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			cfg:     rsaCfg,
+			token:   signToken(t, jwt.SigningMethodRS256, rsaKey, "", wrongIssuer),
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			cfg:     rsaCfg,
+			token:   signToken(t, jwt.SigningMethodRS256, rsaKey, "", wrongAudience),
+			wantErr: true,
+		},
+		{
+			name:    "no keys configured",
+			cfg:     &AuthenticationCfg{JWKSRefreshInterval: time.Minute},
+			token:   signToken(t, jwt.SigningMethodRS256, rsaKey, "", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			cfg:     rsaCfg,
+			token:   "not-a-jwt",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewTokenVerifier(tt.cfg).Verify(context.Background(), tt.token)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "alice", got.Subject)
+			assert.Equal(t, []string{"sre"}, got.Groups)
+		})
+	}
+}
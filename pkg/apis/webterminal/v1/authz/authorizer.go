@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package authz
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const maxCacheEntries = 4096
+
+// Attributes describe who wants to do what on which object
+type Attributes struct {
+	User        string
+	Groups      []string
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	Namespace   string
+	Name        string
+}
+
+// Decision is the outcome of an authorization check
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+// Authorizer decides whether a user may perform the action described by Attributes
+type Authorizer interface {
+	Authorize(ctx context.Context, attrs Attributes) (Decision, error)
+}
+
+// PodExecAttributes returns the attributes needed to exec into a container of a pod
+func PodExecAttributes(user string, groups []string, namespace, pod string) Attributes {
+	return Attributes{
+		User:        user,
+		Groups:      groups,
+		Verb:        "create",
+		Resource:    "pods",
+		Subresource: "exec",
+		Namespace:   namespace,
+		Name:        pod,
+	}
+}
+
+// PodViewAttributes returns the attributes needed to watch a terminal session in a pod
+func PodViewAttributes(user string, groups []string, namespace, pod string) Attributes {
+	return Attributes{
+		User:      user,
+		Groups:    groups,
+		Verb:      "get",
+		Resource:  "pods",
+		Namespace: namespace,
+		Name:      pod,
+	}
+}
+
+// ClusterTerminalAttributes returns the attributes needed to open a cluster terminal,
+// which is granted by being allowed to create the user's WebterminalTemplate.
+func ClusterTerminalAttributes(user string, groups []string, namespace, name string) Attributes {
+	return Attributes{
+		User:      user,
+		Groups:    groups,
+		Verb:      "create",
+		Group:     "terminal.openfuyao.com",
+		Resource:  "webterminaltemplates",
+		Namespace: namespace,
+		Name:      name,
+	}
+}
+
+// RecordingAdminAttributes returns the attributes needed to read the session recordings of every user.
+// Users can always read their own recordings.
+func RecordingAdminAttributes(user string, groups []string) Attributes {
+	return Attributes{
+		User:     user,
+		Groups:   groups,
+		Verb:     "get",
+		Group:    "terminal.openfuyao.com",
+		Resource: "sessionrecordings",
+	}
+}
+
+// SessionAdminAttributes returns the attributes needed to list ("list") or terminate ("delete")
+// the live sessions of every user.
+func SessionAdminAttributes(user string, groups []string, verb string) Attributes {
+	return Attributes{
+		User:     user,
+		Groups:   groups,
+		Verb:     verb,
+		Group:    "terminal.openfuyao.com",
+		Resource: "terminalsessions",
+	}
+}
+
+// NewAuthorizer creates the Authorizer selected by cfg.Mode
+func NewAuthorizer(client kubernetes.Interface, cfg *AuthorizationCfg) Authorizer {
+	var authorizer Authorizer
+	switch cfg.Mode {
+	case ModeLegacy:
+		authorizer = &legacyAuthorizer{client: client}
+	case ModeSubjectAccessReviewWithLegacy:
+		authorizer = &fallbackAuthorizer{
+			primary:  &sarAuthorizer{client: client},
+			fallback: &legacyAuthorizer{client: client},
+		}
+	default:
+		authorizer = &sarAuthorizer{client: client}
+	}
+	if cfg.CacheTTL <= 0 {
+		return authorizer
+	}
+	return newCachingAuthorizer(authorizer, cfg.CacheTTL)
+}
+
+// sarAuthorizer asks the API server through a SubjectAccessReview
+type sarAuthorizer struct {
+	client kubernetes.Interface
+}
+
+// Authorize implements Authorizer
+func (a *sarAuthorizer) Authorize(ctx context.Context, attrs Attributes) (Decision, error) {
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   attrs.User,
+			Groups: attrs.Groups,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:        attrs.Verb,
+				Group:       attrs.Group,
+				Resource:    attrs.Resource,
+				Subresource: attrs.Subresource,
+				Namespace:   attrs.Namespace,
+				Name:        attrs.Name,
+			},
+		},
+	}
+	result, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return Decision{}, fmt.Errorf("creating subject access review: %w", err)
+	}
+	reason := result.Status.Reason
+	if result.Status.EvaluationError != "" {
+		zlog.LogWarnf("Subject access review for %s evaluated with error: %s", attrs.User, result.Status.EvaluationError)
+	}
+	if reason == "" && !result.Status.Allowed {
+		reason = fmt.Sprintf("user %s can not %s %s", attrs.User, attrs.Verb, attrs.resourceString())
+	}
+	return Decision{Allowed: result.Status.Allowed && !result.Status.Denied, Reason: reason}, nil
+}
+
+// legacyAuthorizer grants access to users owning a ClusterRoleBinding named
+// <user>-platform-admin or <user>-cluster-admin.
+type legacyAuthorizer struct {
+	client kubernetes.Interface
+}
+
+// Authorize implements Authorizer
+func (a *legacyAuthorizer) Authorize(ctx context.Context, attrs Attributes) (Decision, error) {
+	pAdminName := fmt.Sprintf("%s-%s", attrs.User, "platform-admin")
+	cAdminName := fmt.Sprintf("%s-%s", attrs.User, "cluster-admin")
+
+	clusterroleList, err := a.client.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return Decision{}, fmt.Errorf("listing cluster role bindings: %w", err)
+	}
+	for _, item := range clusterroleList.Items {
+		if item.Name == pAdminName || item.Name == cAdminName {
+			return Decision{Allowed: true, Reason: fmt.Sprintf("legacy role binding %s", item.Name)}, nil
+		}
+	}
+	return Decision{Reason: "no legacy admin role binding"}, nil
+}
+
+// fallbackAuthorizer consults fallback when primary denies or fails
+type fallbackAuthorizer struct {
+	primary  Authorizer
+	fallback Authorizer
+}
+
+// Authorize implements Authorizer
+func (a *fallbackAuthorizer) Authorize(ctx context.Context, attrs Attributes) (Decision, error) {
+	decision, err := a.primary.Authorize(ctx, attrs)
+	if err == nil && decision.Allowed {
+		return decision, nil
+	}
+	if err != nil {
+		zlog.LogWarnf("Primary authorization failed, using legacy check: %v", err)
+	}
+	return a.fallback.Authorize(ctx, attrs)
+}
+
+type cacheEntry struct {
+	decision Decision
+	expires  time.Time
+}
+
+// cachingAuthorizer remembers decisions for a short time so reconnecting clients
+// don't cost an API round trip each time. Errors are never cached.
+type cachingAuthorizer struct {
+	delegate Authorizer
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newCachingAuthorizer(delegate Authorizer, ttl time.Duration) *cachingAuthorizer {
+	return &cachingAuthorizer{delegate: delegate, ttl: ttl, entries: map[string]cacheEntry{}}
+}
+
+// Authorize implements Authorizer
+func (a *cachingAuthorizer) Authorize(ctx context.Context, attrs Attributes) (Decision, error) {
+	key := attrs.cacheKey()
+	now := time.Now()
+
+	a.mu.Lock()
+	entry, ok := a.entries[key]
+	a.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.decision, nil
+	}
+
+	decision, err := a.delegate.Authorize(ctx, attrs)
+	if err != nil {
+		return decision, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.entries) >= maxCacheEntries {
+		for k, e := range a.entries {
+			if now.After(e.expires) {
+				delete(a.entries, k)
+			}
+		}
+	}
+	if len(a.entries) < maxCacheEntries {
+		a.entries[key] = cacheEntry{decision: decision, expires: now.Add(a.ttl)}
+	}
+	return decision, nil
+}
+
+func (a Attributes) resourceString() string {
+	resource := a.Resource
+	if a.Subresource != "" {
+		resource += "/" + a.Subresource
+	}
+	if a.Group != "" {
+		resource += "." + a.Group
+	}
+	return fmt.Sprintf("%s %s/%s", resource, a.Namespace, a.Name)
+}
+
+func (a Attributes) cacheKey() string {
+	groups := append([]string(nil), a.Groups...)
+	sort.Strings(groups)
+	return strings.Join([]string{a.User, strings.Join(groups, ","), a.Verb, a.Group, a.Resource,
+		a.Subresource, a.Namespace, a.Name}, "\x00")
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package authz
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newSARClient returns a fake clientset whose SubjectAccessReviews allow only the
+// given user to exec into pods of the given namespace.
+func newSARClient(user, namespace string, calls *int32, objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("create", "subjectaccessreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			atomic.AddInt32(calls, 1)
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+			attrs := review.Spec.ResourceAttributes
+			review.Status.Allowed = review.Spec.User == user && attrs.Namespace == namespace &&
+				attrs.Verb == "create" && attrs.Resource == "pods" && attrs.Subresource == "exec"
+			return true, review, nil
+		})
+	return client
+}
+
+func TestSARAuthorizer(t *testing.T) {
+	var calls int32
+	authorizer := NewAuthorizer(newSARClient("alice", "team-a", &calls),
+		&AuthorizationCfg{Mode: ModeSubjectAccessReview})
+
+	tests := []struct {
+		name  string
+		attrs Attributes
+		want  bool
+	}{
+		{name: "allowed", attrs: PodExecAttributes("alice", nil, "team-a", "web"), want: true},
+		{name: "other namespace", attrs: PodExecAttributes("alice", nil, "team-b", "web")},
+		{name: "other user", attrs: PodExecAttributes("bob", nil, "team-a", "web")},
+		{name: "cluster terminal", attrs: ClusterTerminalAttributes("alice", nil, "openfuyao-system", "openfuyao-alice")},
+		{name: "recording admin", attrs: RecordingAdminAttributes("alice", nil)},
+		{name: "session admin", attrs: SessionAdminAttributes("alice", nil, "delete")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := authorizer.Authorize(context.Background(), tt.attrs)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Allowed)
+			if !got.Allowed {
+				assert.NotEmpty(t, got.Reason)
+			}
+		})
+	}
+}
+
+func TestCachingAuthorizer(t *testing.T) {
+	var calls int32
+	authorizer := NewAuthorizer(newSARClient("alice", "team-a", &calls),
+		&AuthorizationCfg{Mode: ModeSubjectAccessReview, CacheTTL: time.Minute})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		got, err := authorizer.Authorize(ctx, PodExecAttributes("alice", []string{"b", "a"}, "team-a", "web"))
+		require.NoError(t, err)
+		assert.True(t, got.Allowed)
+	}
+	_, err := authorizer.Authorize(ctx, PodExecAttributes("alice", []string{"a", "b"}, "team-a", "web"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "group order must not defeat the cache")
+
+	_, err = authorizer.Authorize(ctx, PodExecAttributes("alice", nil, "team-a", "other"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	caching := authorizer.(*cachingAuthorizer)
+	caching.ttl = -time.Second
+	caching.entries = map[string]cacheEntry{}
+	_, _ = authorizer.Authorize(ctx, PodExecAttributes("alice", nil, "team-a", "web"))
+	_, _ = authorizer.Authorize(ctx, PodExecAttributes("alice", nil, "team-a", "web"))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls), "expired entries must be re-evaluated")
+}
+
+func TestCachingAuthorizerDoesNotCacheErrors(t *testing.T) {
+	var calls int32
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "subjectaccessreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			atomic.AddInt32(&calls, 1)
+			return true, nil, errors.New("api server unavailable")
+		})
+	authorizer := NewAuthorizer(client, &AuthorizationCfg{Mode: ModeSubjectAccessReview, CacheTTL: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		_, err := authorizer.Authorize(context.Background(), PodExecAttributes("alice", nil, "team-a", "web"))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestLegacyModes(t *testing.T) {
+	binding := &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "carol-platform-admin"}}
+	tests := []struct {
+		name  string
+		mode  string
+		attrs Attributes
+		want  bool
+	}{
+		{name: "legacy allows admin", mode: ModeLegacy, attrs: PodExecAttributes("carol", nil, "x", "y"), want: true},
+		{name: "legacy denies others", mode: ModeLegacy, attrs: PodExecAttributes("alice", nil, "team-a", "web")},
+		{name: "sar only ignores legacy binding", mode: ModeSubjectAccessReview,
+			attrs: PodExecAttributes("carol", nil, "x", "y")},
+		{name: "fallback to legacy", mode: ModeSubjectAccessReviewWithLegacy,
+			attrs: PodExecAttributes("carol", nil, "x", "y"), want: true},
+		{name: "fallback keeps sar allow", mode: ModeSubjectAccessReviewWithLegacy,
+			attrs: PodExecAttributes("alice", nil, "team-a", "web"), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			authorizer := NewAuthorizer(newSARClient("alice", "team-a", &calls, binding), &AuthorizationCfg{Mode: tt.mode})
+			got, err := authorizer.Authorize(context.Background(), tt.attrs)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Allowed)
+		})
+	}
+}
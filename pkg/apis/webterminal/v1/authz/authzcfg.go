@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+// Package authz decides whether an authenticated user may open a terminal.
+package authz
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	// ModeSubjectAccessReview asks the API server through SubjectAccessReview
+	ModeSubjectAccessReview = "sar"
+	// ModeSubjectAccessReviewWithLegacy falls back to the legacy role binding naming check when SAR denies
+	ModeSubjectAccessReviewWithLegacy = "sar-legacy-fallback"
+	// ModeLegacy only checks for <user>-platform-admin or <user>-cluster-admin ClusterRoleBindings
+	ModeLegacy = "legacy"
+
+	defaultCacheTTL = 10 * time.Second
+
+	envMode     = "AUTHZ_MODE"
+	envCacheTTL = "AUTHZ_CACHE_TTL"
+)
+
+// AuthorizationCfg holds the authorization mode and decision cache settings
+type AuthorizationCfg struct {
+	Mode string
+	// how long allow and deny decisions are cached, 0 disables caching
+	CacheTTL time.Duration
+}
+
+// NewAuthorizationCfg returns the authorization config read from the environment
+func NewAuthorizationCfg() *AuthorizationCfg {
+	c := &AuthorizationCfg{
+		Mode:     ModeSubjectAccessReview,
+		CacheTTL: defaultCacheTTL,
+	}
+	if v := os.Getenv(envMode); v != "" {
+		c.Mode = v
+	}
+	if v := os.Getenv(envCacheTTL); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			zlog.LogWarnf("invalid %s %q, use default %s", envCacheTTL, v, defaultCacheTTL)
+		} else {
+			c.CacheTTL = ttl
+		}
+	}
+	return c
+}
+
+// Validate validate authorization config
+func (c *AuthorizationCfg) Validate() []error {
+	var errs []error
+	switch c.Mode {
+	case ModeSubjectAccessReview, ModeSubjectAccessReviewWithLegacy, ModeLegacy:
+	default:
+		errs = append(errs, fmt.Errorf("unknown authorization mode %q", c.Mode))
+	}
+	if c.CacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("authorization cache ttl can not be negative"))
+	}
+	return errs
+}
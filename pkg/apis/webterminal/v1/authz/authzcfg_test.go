@@ -0,0 +1,46 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package authz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAuthorizationCfg(t *testing.T) {
+	assert.Equal(t, &AuthorizationCfg{Mode: ModeSubjectAccessReview, CacheTTL: defaultCacheTTL}, NewAuthorizationCfg())
+
+	t.Setenv(envMode, ModeSubjectAccessReviewWithLegacy)
+	t.Setenv(envCacheTTL, "3s")
+	assert.Equal(t, &AuthorizationCfg{Mode: ModeSubjectAccessReviewWithLegacy, CacheTTL: 3 * time.Second},
+		NewAuthorizationCfg())
+}
+
+func TestAuthorizationCfgValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     AuthorizationCfg
+		wantErr int
+	}{
+		{name: "sar", cfg: AuthorizationCfg{Mode: ModeSubjectAccessReview}},
+		{name: "legacy", cfg: AuthorizationCfg{Mode: ModeLegacy, CacheTTL: time.Second}},
+		{name: "unknown mode", cfg: AuthorizationCfg{Mode: "rbac"}, wantErr: 1},
+		{name: "negative ttl", cfg: AuthorizationCfg{Mode: ModeLegacy, CacheTTL: -time.Second}, wantErr: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, tt.cfg.Validate(), tt.wantErr)
+		})
+	}
+}
@@ -14,21 +14,40 @@
 package config
 
 import (
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authn"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/client/k8s"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/runtime"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/recording"
+	"openfuyao.com/web-terminal-service/pkg/webterminal"
 )
 
 // RunConfig holds config for the server
 type RunConfig struct {
-	Server        *runtime.ServerConfig
-	KubernetesCfg *k8s.KubernetesCfg
+	Server         *runtime.ServerConfig
+	KubernetesCfg  *k8s.KubernetesCfg
+	Authentication *authn.AuthenticationCfg
+	Authorization  *authz.AuthorizationCfg
+	Recording      *recording.RecordingCfg
+	Session        *webterminal.SessionCfg
+	Home           *webterminal.HomeCfg
+	Audit          *audit.AuditCfg
+	Exec           *webterminal.ExecCfg
 }
 
 // NewRunConfig creates a new RunConfig with default values
 func NewRunConfig() *RunConfig {
 	return &RunConfig{
-		Server:        runtime.NewServerConfig(),
-		KubernetesCfg: k8s.NewKubernetesCfg(),
+		Server:         runtime.NewServerConfig(),
+		KubernetesCfg:  k8s.NewKubernetesCfg(),
+		Authentication: authn.NewAuthenticationCfg(),
+		Authorization:  authz.NewAuthorizationCfg(),
+		Recording:      recording.NewRecordingCfg(),
+		Session:        webterminal.NewSessionCfg(),
+		Home:           webterminal.NewHomeCfg(),
+		Audit:          audit.NewAuditCfg(),
+		Exec:           webterminal.NewExecCfg(),
 	}
 }
 
@@ -37,5 +56,26 @@ func (cfg *RunConfig) Validate() []error {
 	var errs []error
 	errs = append(errs, cfg.Server.Validate()...)
 	errs = append(errs, cfg.KubernetesCfg.Validate()...)
+	if cfg.Authentication != nil {
+		errs = append(errs, cfg.Authentication.Validate()...)
+	}
+	if cfg.Authorization != nil {
+		errs = append(errs, cfg.Authorization.Validate()...)
+	}
+	if cfg.Recording != nil {
+		errs = append(errs, cfg.Recording.Validate()...)
+	}
+	if cfg.Session != nil {
+		errs = append(errs, cfg.Session.Validate()...)
+	}
+	if cfg.Home != nil {
+		errs = append(errs, cfg.Home.Validate()...)
+	}
+	if cfg.Audit != nil {
+		errs = append(errs, cfg.Audit.Validate()...)
+	}
+	if cfg.Exec != nil {
+		errs = append(errs, cfg.Exec.Validate()...)
+	}
 	return errs
 }
@@ -19,8 +19,13 @@ import (
 
 	"github.com/agiledragon/gomonkey/v2"
 
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authn"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/client/k8s"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/runtime"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/recording"
+	"openfuyao.com/web-terminal-service/pkg/webterminal"
 )
 
 func TestNewRunConfig(t *testing.T) {
@@ -33,8 +38,15 @@ func TestNewRunConfig(t *testing.T) {
 		{
 			name: "ok",
 			want: &RunConfig{
-				Server:        &runtime.ServerConfig{},
-				KubernetesCfg: &k8s.KubernetesCfg{},
+				Server:         &runtime.ServerConfig{},
+				KubernetesCfg:  &k8s.KubernetesCfg{},
+				Authentication: &authn.AuthenticationCfg{},
+				Authorization:  &authz.AuthorizationCfg{},
+				Recording:      &recording.RecordingCfg{},
+				Session:        &webterminal.SessionCfg{},
+				Home:           &webterminal.HomeCfg{},
+				Audit:          &audit.AuditCfg{},
+				Exec:           &webterminal.ExecCfg{},
 			},
 			mockServer: &runtime.ServerConfig{},
 			mockCfg:    &k8s.KubernetesCfg{},
@@ -48,8 +60,36 @@ func TestNewRunConfig(t *testing.T) {
 			patch2 := gomonkey.ApplyFunc(k8s.NewKubernetesCfg, func() *k8s.KubernetesCfg {
 				return &k8s.KubernetesCfg{}
 			})
+			patch3 := gomonkey.ApplyFunc(authn.NewAuthenticationCfg, func() *authn.AuthenticationCfg {
+				return &authn.AuthenticationCfg{}
+			})
+			patch4 := gomonkey.ApplyFunc(authz.NewAuthorizationCfg, func() *authz.AuthorizationCfg {
+				return &authz.AuthorizationCfg{}
+			})
+			patch5 := gomonkey.ApplyFunc(recording.NewRecordingCfg, func() *recording.RecordingCfg {
+				return &recording.RecordingCfg{}
+			})
+			patch6 := gomonkey.ApplyFunc(webterminal.NewSessionCfg, func() *webterminal.SessionCfg {
+				return &webterminal.SessionCfg{}
+			})
+			patch7 := gomonkey.ApplyFunc(webterminal.NewHomeCfg, func() *webterminal.HomeCfg {
+				return &webterminal.HomeCfg{}
+			})
+			patch8 := gomonkey.ApplyFunc(audit.NewAuditCfg, func() *audit.AuditCfg {
+				return &audit.AuditCfg{}
+			})
+			patch9 := gomonkey.ApplyFunc(webterminal.NewExecCfg, func() *webterminal.ExecCfg {
+				return &webterminal.ExecCfg{}
+			})
 			defer patch1.Reset()
 			defer patch2.Reset()
+			defer patch3.Reset()
+			defer patch4.Reset()
+			defer patch5.Reset()
+			defer patch6.Reset()
+			defer patch7.Reset()
+			defer patch8.Reset()
+			defer patch9.Reset()
 			if got := NewRunConfig(); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewRunConfig() = %v, want %v", got, tt.want)
 			}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/emicklei/go-restful/v3"
+
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/responsehandlers"
+	"openfuyao.com/web-terminal-service/pkg/webterminal"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+// parseExecRequest decodes the body of req, which may be at most maxSize bytes unless maxSize is 0
+func parseExecRequest(req *restful.Request, resp *restful.Response, maxSize int64) (webterminal.ExecRequest,
+	error) {
+	var body webterminal.ExecRequest
+	if maxSize > 0 {
+		req.Request.Body = http.MaxBytesReader(resp.ResponseWriter, req.Request.Body, maxSize)
+	}
+	if err := json.NewDecoder(req.Request.Body).Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return body, fmt.Errorf("exec request larger than %d bytes", tooLarge.Limit)
+		}
+		return body, fmt.Errorf("invalid exec request: %w", err)
+	}
+	return body, nil
+}
+
+// ExecInContainer runs a command in a container without a terminal and returns its output and exit code
+func (h *Handler) ExecInContainer(req *restful.Request, resp *restful.Response) {
+	namespace := req.PathParameter("namespace")
+	podName := req.PathParameter("pod")
+	containerName := req.PathParameter("container")
+
+	if !isAuthenticated(req) {
+		responsehandlers.SendStatusUnauthorized(resp, "Unauthenticated request", nil)
+		return
+	}
+	var maxSize int64
+	if h.execCfg != nil {
+		maxSize = int64(h.execCfg.MaxInputSize)
+	}
+	body, err := parseExecRequest(req, resp, maxSize)
+	if err != nil {
+		responsehandlers.SendStatusBadRequest(resp, err.Error(), err)
+		return
+	}
+
+	ctx := req.Request.Context()
+	ctx = context.WithValue(ctx, "path", req.Request.URL.Path)
+	ctx = context.WithValue(ctx, "clientIP", clientIP(req.Request, h.trustedProxies))
+	ctx = withSessionID(ctx, req)
+	permission, err := h.checkUserAccess(ctx, req, nil, authz.PodExecAttributes("", nil, namespace, podName))
+	if !permission {
+		if err != nil {
+			responsehandlers.SendStatusServerError(resp, "Failed to check user access", err)
+		} else {
+			responsehandlers.SendStatusForbidden(resp, "User has no access")
+		}
+		return
+	}
+
+	result, err := h.terminal.Exec(ctx, namespace, podName, containerName, body)
+	switch {
+	case errors.Is(err, webterminal.ErrInvalidExec):
+		responsehandlers.SendStatusBadRequest(resp, err.Error(), err)
+	case errors.Is(err, webterminal.ErrCommandDenied):
+		zlog.LogInfof("User %s may not exec in %s/%s: %v", subjectOf(req), namespace, podName, err)
+		responsehandlers.SendStatusForbidden(resp, err.Error())
+	case err != nil:
+		responsehandlers.SendStatusServerError(resp, "Failed to exec in container", err)
+	default:
+		_ = resp.WriteHeaderAndEntity(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/runtime"
+	"openfuyao.com/web-terminal-service/pkg/webterminal"
+)
+
+// podExecAuthorizer allows the listed users to exec into pods
+type podExecAuthorizer map[string]bool
+
+func (a podExecAuthorizer) Authorize(_ context.Context, attrs authz.Attributes) (authz.Decision, error) {
+	return authz.Decision{Allowed: attrs.Subresource == "exec" && a[attrs.User]}, nil
+}
+
+// fakeExecTerminal answers execs with a fixed result
+type fakeExecTerminal struct {
+	webterminal.HandleInterface
+	result *webterminal.ExecResult
+	err    error
+	got    webterminal.ExecRequest
+}
+
+func (f *fakeExecTerminal) Exec(_ context.Context, namespace, podName, containerName string,
+	req webterminal.ExecRequest) (*webterminal.ExecResult, error) {
+	f.got = req
+	return f.result, f.err
+}
+
+func TestExecInContainer(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		body     string
+		err      error
+		wantCode int
+		wantBody string
+	}{
+		{name: "unauthenticated", body: `{"command":["ls"]}`, wantCode: http.StatusUnauthorized},
+		{name: "forbidden", user: "bob", body: `{"command":["ls"]}`, wantCode: http.StatusForbidden},
+		{name: "malformed", user: "alice", body: `{"command":`, wantCode: http.StatusBadRequest},
+		{name: "too large", user: "alice", body: `{"command":["cat"],"stdin":"` + strings.Repeat("x", 64) + `"}`,
+			wantCode: http.StatusBadRequest},
+		{name: "invalid", user: "alice", body: `{"command":[]}`,
+			err: fmt.Errorf("%w: command is required", webterminal.ErrInvalidExec), wantCode: http.StatusBadRequest},
+		{name: "denied", user: "alice", body: `{"command":["rm","-rf","/"]}`,
+			err: fmt.Errorf("%w: blocked", webterminal.ErrCommandDenied), wantCode: http.StatusForbidden},
+		{name: "failed", user: "alice", body: `{"command":["ls"]}`, err: errors.New("upgrade failed"),
+			wantCode: http.StatusInternalServerError},
+		{name: "ok", user: "alice", body: `{"command":["cat","/etc/resolv.conf"],"timeout":"5s"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"stdout":"nameserver 1.1\n","stderr":"","exitCode":0,"duration":"12ms"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			terminal := &fakeExecTerminal{err: tt.err,
+				result: &webterminal.ExecResult{Stdout: "nameserver 1.1\n", Duration: "12ms"}}
+			h := &Handler{authorizer: podExecAuthorizer{"alice": true}, terminal: terminal,
+				execCfg: &webterminal.ExecCfg{MaxInputSize: 64}}
+			ws := runtime.NewWebService()
+			podExec(ws, h)
+			container := restful.NewContainer()
+			container.Filter(func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+				if user := req.HeaderParameter(testUserHeader); user != "" {
+					req.Request = req.Request.WithContext(context.WithValue(req.Request.Context(), "user", user))
+				}
+				chain.ProcessFilter(req, resp)
+			})
+			container.Add(ws)
+
+			path := runtime.WebTerminalBasePath + "/namespace/default/pod/nginx/container/nginx/exec"
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", restful.MIME_JSON)
+			if tt.user != "" {
+				req.Header.Set(testUserHeader, tt.user)
+			}
+			recorder := httptest.NewRecorder()
+			container.ServeHTTP(recorder, req)
+			require.Equal(t, tt.wantCode, recorder.Code, recorder.Body.String())
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, recorder.Body.String())
+				assert.Equal(t, webterminal.ExecRequest{Command: []string{"cat", "/etc/resolv.conf"}, Timeout: "5s"},
+					terminal.got)
+			}
+		})
+	}
+}
@@ -14,63 +14,71 @@ package filters
 
 import (
 	"context"
+	"errors"
 	"strings"
 
 	"github.com/emicklei/go-restful/v3"
-	"github.com/golang-jwt/jwt/v4"
+	"go.opentelemetry.io/otel/attribute"
 
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authn"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/responsehandlers"
+	"openfuyao.com/web-terminal-service/pkg/tracing"
 	"openfuyao.com/web-terminal-service/pkg/zlog"
 )
 
-// JWTAccessClaims structure
-type JWTAccessClaims struct {
-	jwt.StandardClaims
-}
-
 const (
 	defaultAuthHeader   = "Authorization"
 	openFuyaoAuthHeader = "X-OpenFuyao-Authorization"
 )
 
-// ExactSubjectAccess checks the authorization header for a beaer token,
-// extracts the subject from the token, and attaches it to teh request context.
-func ExactSubjectAccess(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
-	var token string
-	// first exact defaultauthheader
-	authInfo := req.Request.Header.Get(defaultAuthHeader)
-	if authInfo == "" {
-		// second exact openFuyaoAuthHeader
-		authInfo = req.Request.Header.Get(openFuyaoAuthHeader)
-	}
+// NewExactSubjectAccess returns a filter that checks the authorization header for a bearer token,
+// verifies it with verifier, and attaches its subject and groups to the request context.
+// Requests carrying a token that fails verification are rejected with 401 before reaching any handler.
+func NewExactSubjectAccess(verifier authn.TokenVerifier) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		// first exact defaultauthheader
+		authInfo := req.Request.Header.Get(defaultAuthHeader)
+		if authInfo == "" {
+			// second exact openFuyaoAuthHeader
+			authInfo = req.Request.Header.Get(openFuyaoAuthHeader)
+		}
 
-	if authInfo != "" {
-		token = strings.TrimPrefix(authInfo, "Bearer ")
+		if authInfo != "" {
+			token := strings.TrimPrefix(authInfo, "Bearer ")
 
-		subject, err := getSubject(resp, token)
-		if err != nil {
-			return
-		}
+			verifyCtx, span := tracing.Start(req.Request.Context(), "ExactSubjectAccess")
+			claims, err := getClaims(verifyCtx, resp, verifier, token)
+			if err == nil {
+				span.SetAttributes(attribute.String("enduser.id", claims.Subject))
+			}
+			tracing.End(span, err)
+			if err != nil {
+				return
+			}
 
-		ctx := context.WithValue(req.Request.Context(), "user", subject)
-		zlog.LogInfof("User subject: %v", subject)
-		req.Request = req.Request.WithContext(ctx)
-	} else {
-		zlog.LogInfof("authInfo is nil! ")
+			ctx := context.WithValue(req.Request.Context(), "user", claims.Subject)
+			ctx = context.WithValue(ctx, "groups", claims.Groups)
+			zlog.LogInfof("User subject: %v", claims.Subject)
+			req.Request = req.Request.WithContext(ctx)
+		} else {
+			zlog.LogInfof("authInfo is nil! ")
+		}
+		chain.ProcessFilter(req, resp) // 继续调用链中的下一个过滤器
 	}
-	chain.ProcessFilter(req, resp) // 继续调用链中的下一个过滤器
 }
 
-func getSubject(resp *restful.Response, token string) (string, error) {
-	// parse JWT
-	var claims = JWTAccessClaims{
-		StandardClaims: jwt.StandardClaims{},
+func getClaims(ctx context.Context, resp *restful.Response, verifier authn.TokenVerifier,
+	token string) (*authn.Claims, error) {
+	if verifier == nil {
+		err := errors.New("no token verifier configured")
+		responsehandlers.SendStatusUnauthorized(resp, "Invalid token", err)
+		return nil, err
 	}
-	_, _, err := jwt.NewParser().ParseUnverified(token, &claims) // 是否需要验证token的签名？
+	claims, err := verifier.Verify(ctx, token)
 	if err != nil {
-		zlog.LogError("LogError Paring tokenJWT: %v", err)
-		responsehandlers.SendStatusServerError(resp, "LogError Paring token", err)
-		return "", err
+		zlog.LogWarnf("Rejecting token: %v", err)
+		responsehandlers.SendStatusUnauthorized(resp, "Invalid token", err)
+		return nil, err
 	}
-	return claims.Subject, nil
+	return claims, nil
 }
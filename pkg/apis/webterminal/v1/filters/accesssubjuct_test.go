@@ -12,92 +12,107 @@
 package filters
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
-	"reflect"
 	"testing"
 
-	"github.com/agiledragon/gomonkey/v2"
 	"github.com/emicklei/go-restful/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
 
-	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/responsehandlers"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authn"
 )
 
-func TestExactSubjectAccess(t *testing.T) {
-	type args struct {
-		req   *restful.Request
-		resp  *restful.Response
-		chain *restful.FilterChain
-	}
-	tests := []struct {
-		name string
-		args args
-	}{
-		{
-			name: "success",
-			args: args{
-				req:   restful.NewRequest(httptest.NewRequest("GET", "/Access", nil)),
-				resp:  restful.NewResponse(httptest.NewRecorder()),
-				chain: &restful.FilterChain{},
-			},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			patch3 := gomonkey.ApplyMethod(reflect.TypeOf(tt.args.resp), "StatusCode", func(_ *restful.Response) int {
-				return http.StatusOK
-			})
+// stubVerifier accepts only the token "valid"
+type stubVerifier struct{}
 
-			// 模拟 restful.FilterChain.ProcessFilter
-			patch4 := gomonkey.ApplyMethod(reflect.TypeOf(tt.args.chain), "ProcessFilter",
-				func(_ *restful.FilterChain, req *restful.Request, resp *restful.Response) {
-					resp.WriteHeader(http.StatusOK)
-				})
-
-			defer patch3.Reset()
-			defer patch4.Reset()
-			ExactSubjectAccess(tt.args.req, tt.args.resp, tt.args.chain)
-		})
+func (stubVerifier) Verify(_ context.Context, token string) (*authn.Claims, error) {
+	if token != "valid" {
+		return nil, errors.New("signature is invalid")
 	}
+	return &authn.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "myuser"},
+		Groups:           []string{"sre"},
+	}, nil
 }
 
-func TestGetSubject(t *testing.T) {
-	type args struct {
-		token string
-		resp  *restful.Response
-	}
+func TestExactSubjectAccess(t *testing.T) {
 	tests := []struct {
-		name    string
-		args    args
-		want    string
-		wantErr bool
+		name       string
+		verifier   authn.TokenVerifier
+		header     string
+		value      string
+		wantCode   int
+		wantCalled bool
+		wantUser   interface{}
 	}{
 		{
-			name: "yes",
-			args: args{
-				token: "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." +
-					"eyJzdWIiOiJteXVzZXIifQ.sflKxwRJSMeKKF2QT4fwpMeJf36POk6yJV_adQssw5c", // 伪造但合法的 JWT 令牌
-				resp: restful.NewResponse(httptest.NewRecorder()),
-			},
-			want:    "myuser", // 示例期望的解码结果
-			wantErr: false,
+			name:       "no token",
+			verifier:   stubVerifier{},
+			wantCode:   http.StatusOK,
+			wantCalled: true,
+		},
+		{
+			name:       "valid token",
+			verifier:   stubVerifier{},
+			header:     defaultAuthHeader,
+			value:      "Bearer valid",
+			wantCode:   http.StatusOK,
+			wantCalled: true,
+			wantUser:   "myuser",
+		},
+		{
+			name:       "valid token in openFuyao header",
+			verifier:   stubVerifier{},
+			header:     openFuyaoAuthHeader,
+			value:      "Bearer valid",
+			wantCode:   http.StatusOK,
+			wantCalled: true,
+			wantUser:   "myuser",
+		},
+		{
+			name:     "forged token",
+			verifier: stubVerifier{},
+			header:   defaultAuthHeader,
+			value: "Bearer eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." +
+				"eyJzdWIiOiJteXVzZXIifQ.sflKxwRJSMeKKF2QT4fwpMeJf36POk6yJV_adQssw5c", // 伪造的 JWT 令牌
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "no verifier",
+			header:   defaultAuthHeader,
+			value:    "Bearer valid",
+			wantCode: http.StatusUnauthorized,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			patch := gomonkey.ApplyFunc(responsehandlers.SendStatusServerError,
-				func(_ *restful.Response, message string, err error) {
-					fmt.Println(http.StatusForbidden)
-				})
-			defer patch.Reset()
-			got, err := getSubject(tt.args.resp, tt.args.token)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("getSubject() error = %v, wantErr %v", err, tt.wantErr)
-				return
+			called := false
+			var gotUser, gotGroups interface{}
+			ws := new(restful.WebService).Produces(restful.MIME_JSON)
+			ws.Route(ws.GET("/access").Filter(NewExactSubjectAccess(tt.verifier)).
+				To(func(req *restful.Request, resp *restful.Response) {
+					called = true
+					gotUser = req.Request.Context().Value("user")
+					gotGroups = req.Request.Context().Value("groups")
+				}))
+			container := restful.NewContainer()
+			container.Add(ws)
+
+			req := httptest.NewRequest("GET", "/access", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
 			}
-			if got != tt.want {
-				t.Errorf("getSubject() got = %v, want %v", got, tt.want)
+			recorder := httptest.NewRecorder()
+			container.ServeHTTP(recorder, req)
+
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			assert.Equal(t, tt.wantCalled, called)
+			assert.Equal(t, tt.wantUser, gotUser)
+			if tt.wantUser != nil {
+				assert.Equal(t, []string{"sre"}, gotGroups)
 			}
 		})
 	}
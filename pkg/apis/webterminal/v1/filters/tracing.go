@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package filters
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/emicklei/go-restful/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"openfuyao.com/web-terminal-service/pkg/tracing"
+)
+
+// TraceRequests starts a span for each request and passes it on in the request context. The span continues
+// the trace of the caller when the request carries a W3C trace context. Terminal requests are traced until
+// their websocket closes.
+func TraceRequests(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	ctx := otel.GetTextMapPropagator().Extract(req.Request.Context(),
+		propagation.HeaderCarrier(req.Request.Header))
+	route := req.SelectedRoutePath()
+	ctx, span := tracing.Start(ctx, req.Request.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Request.Method),
+			semconv.HTTPRoute(route),
+			semconv.URLPath(req.Request.URL.Path),
+		))
+	defer span.End()
+	req.Request = req.Request.WithContext(ctx)
+
+	chain.ProcessFilter(req, resp)
+
+	statusCode := resp.StatusCode()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(statusCode))
+	if statusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", statusCode))
+	}
+}
@@ -18,22 +18,32 @@ package v1
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/responsehandlers"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/metrics"
+	"openfuyao.com/web-terminal-service/pkg/recording"
+	"openfuyao.com/web-terminal-service/pkg/tracing"
 	"openfuyao.com/web-terminal-service/pkg/webterminal"
 	"openfuyao.com/web-terminal-service/pkg/zlog"
 )
 
 var upgrade = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: webterminal.Subprotocols,
 }
 
 // Handler centralizes the clients used to interface with different kubernetes APIs.
@@ -45,14 +55,27 @@ type Handler struct {
 	// MgrClient is the controller client to interact with CRD, not specific to any kubernetes version.
 	MgrClient client.Client
 	terminal  webterminal.HandleInterface
+	// authorizer decides who may open which terminal
+	authorizer authz.Authorizer
+	// recordings holds the session recordings, nil when recording is disabled
+	recordings recording.RecordingStore
+	// sessions tracks the live sessions of the terminal
+	sessions *webterminal.SessionManager
+	// auditor writes the audit log, nil when auditing is disabled
+	auditor *audit.Logger
+	// execCfg bounds the commands run without a terminal, nil leaves their requests unbounded
+	execCfg *webterminal.ExecCfg
+	// trustedProxies are the networks of the proxies whose X-Forwarded-For header is trusted
+	trustedProxies []*net.IPNet
 }
 
 // NewHandler defines a new handler structure.
-func NewHandler(client kubernetes.Interface, config *rest.Config, mgrclient client.Client) *Handler {
+func NewHandler(client kubernetes.Interface, config *rest.Config, mgrclient client.Client,
+	opts ...webterminal.Option) *Handler {
 	return &Handler{
 		client:   client,
 		config:   config,
-		terminal: webterminal.NewTerminal(client, config, mgrclient),
+		terminal: webterminal.NewTerminal(client, config, mgrclient, opts...),
 	}
 }
 
@@ -66,9 +89,16 @@ func (h *Handler) HandlePodTerminal(req *restful.Request, resp *restful.Response
 	podName := req.PathParameter("pod")
 	containerName := req.PathParameter("container")
 
+	if !isAuthenticated(req) {
+		responsehandlers.SendStatusUnauthorized(resp, "Unauthenticated request", nil)
+		return
+	}
+
 	// 更新 websocket
 	ctx := req.Request.Context()
 	ctx = context.WithValue(ctx, "path", req.Request.URL.Path)
+	ctx = context.WithValue(ctx, "clientIP", clientIP(req.Request, h.trustedProxies))
+	ctx = withSessionID(ctx, req)
 
 	conn, err := upgrade.Upgrade(resp.ResponseWriter, req.Request, nil)
 	if err != nil {
@@ -76,12 +106,16 @@ func (h *Handler) HandlePodTerminal(req *restful.Request, resp *restful.Response
 		return
 	}
 	// 用户权限校验
-	permission, err := checkUserAccess(h.client, ctx, req, conn)
+	permission, err := h.checkUserAccess(ctx, req, conn, authz.PodExecAttributes("", nil, namespace, podName))
 	if !permission {
 		fmt.Println("User has no access:", err)
 		return
 	}
 	fmt.Println("User has access ! ")
+	if token := req.QueryParameter("resume"); token != "" {
+		h.resumeTerminal(req, conn, token, namespace, podName, containerName)
+		return
+	}
 
 	// 调用 HandleTerminal 方法
 	h.terminal.HandleTerminal(ctx, namespace, podName, containerName, conn)
@@ -95,56 +129,156 @@ func (h *Handler) HandleClusterTerminal(req *restful.Request, resp *restful.Resp
 		resp.WriteErrorString(http.StatusUnauthorized, "username not found in context")
 		return
 	}
+	if !isAuthenticated(req) {
+		responsehandlers.SendStatusUnauthorized(resp, "Unauthenticated request", nil)
+		return
+	}
+
+	ctx = context.WithValue(ctx, "clientIP", clientIP(req.Request, h.trustedProxies))
+	ctx = withSessionID(ctx, req)
 
 	conn, Err := upgrade.Upgrade(resp.ResponseWriter, req.Request, nil)
 	if Err != nil {
 		zlog.LogWarnf("Failed to upgrade WebSocket: %v", Err)
 		return
 	}
-	permission, err := checkUserAccess(h.client, ctx, req, conn)
+	attrs := authz.ClusterTerminalAttributes("", nil, webterminal.UserPodNamespace, webterminal.UserPodName(username))
+	if subject, _ := req.Request.Context().Value("user").(string); subject != username {
+		zlog.LogWarnf("User %s requested the cluster terminal of %s", subject, username)
+		sendWebSocketError(conn, "User has no access")
+		metrics.AuthorizationDenials.WithLabelValues(metrics.DenialForbidden).Inc()
+		h.auditAuthorization(ctx, req, attrs, false, "cluster terminal of another user")
+		return
+	}
+	permission, err := h.checkUserAccess(ctx, req, conn, attrs)
 	if !permission {
 		fmt.Println("User has no access:", err)
 		return
 	}
 	fmt.Println("User has access ! ")
+	if token := req.QueryParameter("resume"); token != "" {
+		h.resumeTerminal(req, conn, token, webterminal.UserPodNamespace, webterminal.UserPodName(username),
+			webterminal.UserContainerName)
+		return
+	}
+
+	h.terminal.HandleCusterTerminal(ctx, username, req.QueryParameter("profile"), conn)
+
+}
+
+// resumeTerminal attaches conn to the session that token resumes, which must be a session of the user
+// on the same container.
+func (h *Handler) resumeTerminal(req *restful.Request, conn *websocket.Conn, token, namespace, podName,
+	containerName string) {
+	session, ok := h.sessions.Resumable(token)
+	if ok {
+		info := session.Info()
+		ok = info.User == subjectOf(req) && info.Namespace == namespace && info.Pod == podName &&
+			info.Container == containerName
+	}
+	if !ok {
+		zlog.LogInfof("User %s presented an unknown resume token for %s/%s", subjectOf(req), namespace, podName)
+		sendWebSocketError(conn, "Session not found")
+		_ = conn.Close()
+		return
+	}
+	if err := session.Resume(conn); err != nil {
+		zlog.LogWarnf("Failed to resume session %s: %v", session.Info().ID, err)
+		sendWebSocketError(conn, err.Error())
+		_ = conn.Close()
+	}
+}
 
-	h.terminal.HandleCusterTerminal(ctx, username, conn)
+// withSessionID assigns the session a request opens its id ahead of the authorization, so that the audit
+// events of both share it. Requests resuming a session get none, their session is only known once resumed.
+func withSessionID(ctx context.Context, req *restful.Request) context.Context {
+	if req.QueryParameter("resume") != "" {
+		return ctx
+	}
+	return context.WithValue(ctx, "sessionID", uuid.NewString())
+}
 
+// isAuthenticated reports whether ExactSubjectAccess attached a verified subject to the request
+func isAuthenticated(req *restful.Request) bool {
+	username, ok := req.Request.Context().Value("user").(string)
+	return ok && username != ""
 }
 
-func checkUserAccess(c kubernetes.Interface, ctx context.Context, req *restful.Request, conn *websocket.Conn) (bool, error) {
+// checkUserAccess asks the authorizer whether the authenticated user may perform attrs,
+// and reports the outcome to the client over the websocket.
+func (h *Handler) checkUserAccess(ctx context.Context, req *restful.Request, conn *websocket.Conn,
+	attrs authz.Attributes) (allowed bool, err error) {
+	ctx, span := tracing.Start(ctx, "checkUserAccess", trace.WithAttributes(
+		attribute.String("authz.verb", attrs.Verb),
+		attribute.String("authz.resource", attrs.Resource),
+		semconv.K8SNamespaceName(attrs.Namespace),
+		attribute.String("authz.name", attrs.Name),
+	))
+	var reason string
+	defer func() {
+		span.SetAttributes(attribute.Bool("authz.allowed", allowed))
+		tracing.End(span, err)
+		if err != nil {
+			reason = err.Error()
+		}
+		h.auditAuthorization(ctx, req, attrs, allowed, reason)
+	}()
 	// 从上下文中获取用户名
 	username, ok := req.Request.Context().Value("user").(string)
 	if !ok {
 		zlog.LogErrorf("LogError retrieving user information")
 		sendWebSocketError(conn, "LogError retrieving user information")
+		metrics.AuthorizationDenials.WithLabelValues(metrics.DenialNoUser).Inc()
 		return false, fmt.Errorf("error retrieving user information")
 	}
 	zlog.LogInfof("Retrieving user -- %s -- info", username)
+	if h.authorizer == nil {
+		sendWebSocketError(conn, "No authorizer configured")
+		metrics.AuthorizationDenials.WithLabelValues(metrics.DenialNoAuthorizer).Inc()
+		return false, fmt.Errorf("no authorizer configured")
+	}
 
-	pAdminName := fmt.Sprintf("%s-%s", username, "platform-admin")
-	cAdminName := fmt.Sprintf("%s-%s", username, "cluster-admin")
-
-	clusterroleList, err := c.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	attrs.User = username
+	attrs.Groups, _ = req.Request.Context().Value("groups").([]string)
+	decision, err := h.authorizer.Authorize(ctx, attrs)
 	if err != nil {
-		zlog.LogErrorf("LogError retrieving clusterrolebinding: %v", err)
-		sendWebSocketError(conn, "LogError retrieving cluster role bindings")
+		zlog.LogErrorf("LogError authorizing user %s: %v", username, err)
+		sendWebSocketError(conn, "LogError checking user access")
+		metrics.AuthorizationDenials.WithLabelValues(metrics.DenialError).Inc()
 		return false, err
 	}
 
-	for _, item := range clusterroleList.Items {
-		if item.Name == pAdminName || item.Name == cAdminName {
-			zlog.LogInfof("User %s has access via role %s", username, item.Name)
-			sendWebSocketMessage(conn, "User has access")
-			return true, nil
-		}
+	reason = decision.Reason
+	if decision.Allowed {
+		zlog.LogInfof("User %s has access: %s", username, decision.Reason)
+		sendWebSocketMessage(conn, "User has access")
+		return true, nil
 	}
 
-	zlog.LogInfof("User %s has no access", username)
+	zlog.LogInfof("User %s has no access: %s", username, decision.Reason)
 	sendWebSocketError(conn, "User has no access")
+	metrics.AuthorizationDenials.WithLabelValues(metrics.DenialForbidden).Inc()
 	return false, nil
 }
 
+// auditAuthorization logs whether the user of req may perform attrs
+func (h *Handler) auditAuthorization(ctx context.Context, req *restful.Request, attrs authz.Attributes,
+	allowed bool, reason string) {
+	sessionID, _ := ctx.Value("sessionID").(string)
+	groups, _ := req.Request.Context().Value("groups").([]string)
+	h.auditor.Log(audit.Event{
+		Type:      audit.TypeAuthorization,
+		SessionID: sessionID,
+		User:      subjectOf(req),
+		Groups:    groups,
+		SourceIP:  clientIP(req.Request, h.trustedProxies),
+		Target:    audit.Target{Namespace: attrs.Namespace, Pod: attrs.Name},
+		Allowed:   &allowed,
+		Reason:    reason,
+		Details:   map[string]string{"verb": attrs.Verb, "resource": attrs.Resource, "subresource": attrs.Subresource},
+	})
+}
+
 func sendWebSocketError(conn *websocket.Conn, errorMessage string) {
 	if conn != nil {
 		err := conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("LogError: %s", errorMessage)))
@@ -17,24 +17,31 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sync"
 	"testing"
 
-	"github.com/agiledragon/gomonkey/v2"
 	"github.com/emicklei/go-restful/v3"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	k8sv1 "k8s.io/api/core/v1"
-	rbacv1 "k8s.io/api/rbac/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
-	fake2 "k8s.io/client-go/kubernetes/typed/rbac/v1/fake"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	faker "sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/metrics"
 	"openfuyao.com/web-terminal-service/pkg/webterminal"
 )
 
@@ -47,48 +54,163 @@ func FakeClient() client.Client {
 }
 
 func TestCheckUserAccess(t *testing.T) {
-	fakeClient := fake.NewSimpleClientset()
-
-	// 使用 monkey.Patch 替换 ClusterRoleBindings().List
-	patch := gomonkey.ApplyMethod(
-		reflect.TypeOf(fakeClient.RbacV1().ClusterRoleBindings()),
-		"List",
-		func(_ *fake2.FakeClusterRoleBindings, ctx context.Context,
-			opts v1.ListOptions) (*rbacv1.ClusterRoleBindingList, error) {
-			return &rbacv1.ClusterRoleBindingList{
-				Items: []rbacv1.ClusterRoleBinding{
-					{
-						ObjectMeta: v1.ObjectMeta{Name: "test-user-platform-admin"},
-					},
-					{
-						ObjectMeta: v1.ObjectMeta{Name: "non-matching-role"},
-					},
-				},
-			}, nil
+	tests := []struct {
+		name       string
+		user       interface{}
+		authorizer authz.Authorizer
+		want       bool
+		wantMsg    string
+		wantDenial string
+		wantFailed bool
+	}{
+		{
+			name:       "allowed",
+			user:       "test-user",
+			authorizer: stubAuthorizer{allowed: true},
+			want:       true,
+			wantMsg:    "User has access",
+		},
+		{
+			name:       "denied",
+			user:       "test-user",
+			authorizer: stubAuthorizer{},
+			want:       false,
+			wantMsg:    "User has no access",
+			wantDenial: metrics.DenialForbidden,
+		},
+		{
+			name:       "authorizer error",
+			user:       "test-user",
+			authorizer: stubAuthorizer{err: fmt.Errorf("api server down")},
+			want:       false,
+			wantMsg:    "LogError checking user access",
+			wantDenial: metrics.DenialError,
+			wantFailed: true,
+		},
+		{
+			name:       "no user",
+			authorizer: stubAuthorizer{allowed: true},
+			want:       false,
+			wantMsg:    "LogError retrieving user information",
+			wantDenial: metrics.DenialNoUser,
+			wantFailed: true,
 		},
-	)
-	defer patch.Reset()
+	}
+	provider := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(provider)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var allowed bool
+			var gotAttrs authz.Attributes
+			denials := map[string]float64{}
+			for _, reason := range []string{metrics.DenialForbidden, metrics.DenialError, metrics.DenialNoUser} {
+				denials[reason] = testutil.ToFloat64(metrics.AuthorizationDenials.WithLabelValues(reason))
+			}
+			sink := &memorySink{}
+			auditor := audit.NewLogger(sink, 1, 0, 0)
+			done := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer close(done)
+				upgrader := websocket.Upgrader{}
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				ctx := r.Context()
+				if tt.user != nil {
+					ctx = context.WithValue(ctx, "user", tt.user)
+					ctx = context.WithValue(ctx, "groups", []string{"sre"})
+				}
+				req := &restful.Request{Request: r.WithContext(ctx)}
+				h := &Handler{authorizer: recordingAuthorizer{tt.authorizer, &gotAttrs}, auditor: auditor}
+				allowed, _ = h.checkUserAccess(context.WithValue(context.TODO(), "sessionID", "s-1"), req, conn,
+					authz.PodExecAttributes("", nil, "default", "test-pod"))
+			}))
+			defer server.Close()
 
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		upgrader := websocket.Upgrader{}
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			fmt.Println(err)
-		}
-		req := &restful.Request{
-			Request: r.WithContext(context.WithValue(r.Context(), "user", "test-user")),
-		}
-		// 调用被测试函数
-		allowed, err := checkUserAccess(fakeClient, context.TODO(), req, conn)
-		if allowed != true {
-			fmt.Println("not ok")
-		}
-	}))
-	// 创建 WebSocket 客户端连接
-	dialer := websocket.DefaultDialer
-	_, _, err := dialer.Dial(fmt.Sprintf("ws://%s", server.Listener.Addr().String()), nil)
-	fmt.Println(err)
-	defer server.Close()
+			conn, _, _ := MockWeb(t, server)
+			defer conn.Close()
+			_, message, err := conn.ReadMessage()
+			assert.NoError(t, err)
+			<-done
+			assert.Equal(t, tt.want, allowed)
+			assert.Contains(t, string(message), tt.wantMsg)
+			if tt.user != nil {
+				assert.Equal(t, "test-user", gotAttrs.User)
+				assert.Equal(t, []string{"sre"}, gotAttrs.Groups)
+				assert.Equal(t, "exec", gotAttrs.Subresource)
+			}
+			for reason, before := range denials {
+				want := before
+				if reason == tt.wantDenial {
+					want++
+				}
+				assert.Equal(t, want, testutil.ToFloat64(metrics.AuthorizationDenials.WithLabelValues(reason)), reason)
+			}
+			spans := recorder.Ended()
+			span := spans[len(spans)-1]
+			assert.Equal(t, "checkUserAccess", span.Name())
+			assert.Contains(t, span.Attributes(), attribute.Bool("authz.allowed", tt.want))
+			assert.Equal(t, tt.wantFailed, span.Status().Code == codes.Error)
+
+			require.NoError(t, auditor.Close(context.Background()))
+			require.Len(t, sink.events, 1)
+			event := sink.events[0]
+			assert.Equal(t, audit.TypeAuthorization, event.Type)
+			assert.Equal(t, "s-1", event.SessionID)
+			assert.Equal(t, tt.want, *event.Allowed)
+			assert.Equal(t, audit.Target{Namespace: "default", Pod: "test-pod"}, event.Target)
+			assert.Equal(t, "127.0.0.1", event.SourceIP)
+			assert.Equal(t, tt.wantFailed, event.Reason != "")
+			if tt.user != nil {
+				assert.Equal(t, "test-user", event.User)
+				assert.Equal(t, []string{"sre"}, event.Groups)
+			}
+		})
+	}
+}
+
+// memorySink keeps the audit events written to it
+type memorySink struct {
+	mu     sync.Mutex
+	events []audit.Event
+	closed bool
+}
+
+func (s *memorySink) Write(_ context.Context, event audit.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+type stubAuthorizer struct {
+	allowed bool
+	err     error
+}
+
+func (s stubAuthorizer) Authorize(_ context.Context, _ authz.Attributes) (authz.Decision, error) {
+	return authz.Decision{Allowed: s.allowed}, s.err
+}
+
+type recordingAuthorizer struct {
+	authz.Authorizer
+	attrs *authz.Attributes
+}
+
+func (r recordingAuthorizer) Authorize(ctx context.Context, attrs authz.Attributes) (authz.Decision, error) {
+	*r.attrs = attrs
+	return r.Authorizer.Authorize(ctx, attrs)
 }
 
 func TestNewHandler(t *testing.T) {
@@ -265,3 +387,39 @@ func TestSendWebSocketMessage(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, string(message), "Test  message")
 }
+
+func TestHandlePodTerminalUnauthenticated(t *testing.T) {
+	h := &Handler{}
+	recorder := httptest.NewRecorder()
+	req := restful.NewRequest(httptest.NewRequest("GET",
+		"/namespace/default/pod/test-pod/container/test-container/terminal", nil))
+	resp := restful.NewResponse(recorder)
+	resp.SetRequestAccepts(restful.MIME_JSON)
+
+	h.HandlePodTerminal(req, resp)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestResumeTerminalUnknownToken(t *testing.T) {
+	h := &Handler{sessions: webterminal.NewSessionManager()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			http.Error(w, "Failed to upgrade WebSocket", http.StatusBadRequest)
+			return
+		}
+		req := restful.NewRequest(r.WithContext(context.WithValue(r.Context(), "user", "alice")))
+		h.resumeTerminal(req, conn, "guess", "default", "test-pod", "test-container")
+	}))
+	defer server.Close()
+
+	conn, _, _ := MockWeb(t, server)
+	defer conn.Close()
+	_, message, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "LogError: Session not found", string(message))
+	_, _, err = conn.ReadMessage()
+	assert.Error(t, err, "the connection is closed")
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/responsehandlers"
+	"openfuyao.com/web-terminal-service/pkg/recording"
+	"openfuyao.com/web-terminal-service/pkg/webterminal"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	// MIMEAsciicast is the content type of asciicast recordings
+	MIMEAsciicast = "application/x-asciicast"
+
+	defaultReplaySpeed = 1.0
+	maxReplaySpeed     = 64.0
+)
+
+// recordingFilter selects recordings in a listing; zero fields match everything
+type recordingFilter struct {
+	user      string
+	namespace string
+	pod       string
+	since     time.Time
+	until     time.Time
+}
+
+func parseRecordingFilter(req *restful.Request) (recordingFilter, error) {
+	filter := recordingFilter{
+		user:      req.QueryParameter("user"),
+		namespace: req.QueryParameter("namespace"),
+		pod:       req.QueryParameter("pod"),
+	}
+	var err error
+	if v := req.QueryParameter("since"); v != "" {
+		if filter.since, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("invalid since %q: %w", v, err)
+		}
+	}
+	if v := req.QueryParameter("until"); v != "" {
+		if filter.until, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("invalid until %q: %w", v, err)
+		}
+	}
+	return filter, nil
+}
+
+func (f recordingFilter) match(meta *recording.Metadata) bool {
+	switch {
+	case f.user != "" && meta.User != f.user,
+		f.namespace != "" && meta.Namespace != f.namespace,
+		f.pod != "" && meta.Pod != f.pod,
+		!f.since.IsZero() && meta.StartTime.Before(f.since),
+		!f.until.IsZero() && meta.StartTime.After(f.until):
+		return false
+	}
+	return true
+}
+
+// ListRecordings lists the recordings visible to the user. Admins see every recording,
+// other users only their own.
+func (h *Handler) ListRecordings(req *restful.Request, resp *restful.Response) {
+	if !h.recordingsAvailable(req, resp) {
+		return
+	}
+	filter, err := parseRecordingFilter(req)
+	if err != nil {
+		responsehandlers.SendStatusBadRequest(resp, err.Error(), err)
+		return
+	}
+	admin, err := h.isRecordingAdmin(req)
+	if err != nil {
+		responsehandlers.SendStatusServerError(resp, "Failed to check user access", err)
+		return
+	}
+	if !admin {
+		user := subjectOf(req)
+		if filter.user != "" && filter.user != user {
+			responsehandlers.SendStatusForbidden(resp, "Only admins can list recordings of other users")
+			return
+		}
+		filter.user = user
+	}
+
+	items, err := h.recordings.List(req.Request.Context())
+	if err != nil {
+		responsehandlers.SendStatusServerError(resp, "Failed to list recordings", err)
+		return
+	}
+	result := make([]recording.Metadata, 0, len(items))
+	for i := range items {
+		if filter.match(&items[i]) {
+			result = append(result, items[i])
+		}
+	}
+	_ = resp.WriteHeaderAndEntity(http.StatusOK, result)
+}
+
+// GetRecording returns the asciicast file of a recording
+func (h *Handler) GetRecording(req *restful.Request, resp *restful.Response) {
+	meta, ok := h.accessibleRecording(req, resp)
+	if !ok {
+		return
+	}
+	content, err := h.recordings.Open(req.Request.Context(), meta.ID)
+	if err != nil {
+		h.sendRecordingError(resp, err)
+		return
+	}
+	defer content.Close()
+
+	resp.Header().Set("Content-Type", MIMEAsciicast)
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", meta.ID+".cast"))
+	resp.WriteHeader(http.StatusOK)
+	if _, err = io.Copy(resp, content); err != nil {
+		zlog.LogWarnf("Failed to send recording %s: %v", meta.ID, err)
+	}
+}
+
+// ReplayRecording streams a recording over a websocket, optionally accelerated by the speed parameter
+func (h *Handler) ReplayRecording(req *restful.Request, resp *restful.Response) {
+	speed := defaultReplaySpeed
+	if v := req.QueryParameter("speed"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed <= 0 || parsed > maxReplaySpeed {
+			responsehandlers.SendStatusBadRequest(resp,
+				fmt.Sprintf("speed must be a number greater than 0 and at most %v", maxReplaySpeed), err)
+			return
+		}
+		speed = parsed
+	}
+	meta, ok := h.accessibleRecording(req, resp)
+	if !ok {
+		return
+	}
+	content, err := h.recordings.Open(req.Request.Context(), meta.ID)
+	if err != nil {
+		h.sendRecordingError(resp, err)
+		return
+	}
+	defer content.Close()
+
+	conn, err := upgrade.Upgrade(resp.ResponseWriter, req.Request, nil)
+	if err != nil {
+		zlog.LogWarn(err)
+		return
+	}
+	defer conn.Close()
+	zlog.LogInfof("User %s replays recording %s of %s", subjectOf(req), meta.ID, meta.User)
+	err = webterminal.Replay(req.Request.Context(), conn, content, speed)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		zlog.LogWarnf("Replay of recording %s failed: %v", meta.ID, err)
+	}
+}
+
+// accessibleRecording returns the metadata of the requested recording if the user is its owner
+// or an admin, otherwise it writes the error response.
+func (h *Handler) accessibleRecording(req *restful.Request, resp *restful.Response) (*recording.Metadata, bool) {
+	if !h.recordingsAvailable(req, resp) {
+		return nil, false
+	}
+	meta, err := h.recordings.Stat(req.Request.Context(), req.PathParameter("id"))
+	if err != nil {
+		h.sendRecordingError(resp, err)
+		return nil, false
+	}
+	if meta.User == subjectOf(req) {
+		return meta, true
+	}
+	admin, err := h.isRecordingAdmin(req)
+	if err != nil {
+		responsehandlers.SendStatusServerError(resp, "Failed to check user access", err)
+		return nil, false
+	}
+	if !admin {
+		// not revealing whether recordings of other users exist
+		responsehandlers.SendStatusNotFound(resp, "Recording not found")
+		return nil, false
+	}
+	return meta, true
+}
+
+func (h *Handler) recordingsAvailable(req *restful.Request, resp *restful.Response) bool {
+	if !isAuthenticated(req) {
+		responsehandlers.SendStatusUnauthorized(resp, "Unauthenticated request", nil)
+		return false
+	}
+	if h.recordings == nil {
+		responsehandlers.SendStatusNotFound(resp, "Session recording is disabled")
+		return false
+	}
+	return true
+}
+
+func (h *Handler) isRecordingAdmin(req *restful.Request) (bool, error) {
+	if h.authorizer == nil {
+		return false, nil
+	}
+	groups, _ := req.Request.Context().Value("groups").([]string)
+	decision, err := h.authorizer.Authorize(req.Request.Context(),
+		authz.RecordingAdminAttributes(subjectOf(req), groups))
+	if err != nil {
+		return false, err
+	}
+	return decision.Allowed, nil
+}
+
+func (h *Handler) sendRecordingError(resp *restful.Response, err error) {
+	if errors.Is(err, recording.ErrNotFound) || errors.Is(err, recording.ErrInvalidID) {
+		responsehandlers.SendStatusNotFound(resp, "Recording not found")
+		return
+	}
+	responsehandlers.SendStatusServerError(resp, "Failed to read recording", err)
+}
+
+// subjectOf returns the authenticated user of req
+func subjectOf(req *restful.Request) string {
+	user, _ := req.Request.Context().Value("user").(string)
+	return user
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/runtime"
+	"openfuyao.com/web-terminal-service/pkg/recording"
+	"openfuyao.com/web-terminal-service/pkg/webterminal"
+)
+
+const testUserHeader = "X-Test-User"
+
+// adminAuthorizer treats the listed users as recording admins
+type adminAuthorizer map[string]bool
+
+func (a adminAuthorizer) Authorize(_ context.Context, attrs authz.Attributes) (authz.Decision, error) {
+	return authz.Decision{Allowed: attrs.Resource == "sessionrecordings" && a[attrs.User]}, nil
+}
+
+// newRecordingServer serves the recording routes, authenticating requests by testUserHeader
+func newRecordingServer(t *testing.T, store recording.RecordingStore) *httptest.Server {
+	h := &Handler{authorizer: adminAuthorizer{"root": true}, recordings: store}
+	ws := runtime.NewWebService()
+	sessionRecordings(ws, h)
+	container := restful.NewContainer()
+	container.Filter(func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		if user := req.HeaderParameter(testUserHeader); user != "" {
+			req.Request = req.Request.WithContext(context.WithValue(req.Request.Context(), "user", user))
+		}
+		chain.ProcessFilter(req, resp)
+	})
+	container.Add(ws)
+	server := httptest.NewServer(container)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newRecordingFixtures(t *testing.T) recording.RecordingStore {
+	store, err := recording.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	start := time.Date(2024, time.May, 1, 10, 0, 0, 0, time.UTC)
+	fixtures := []recording.Metadata{
+		{ID: "a1", User: "alice", Namespace: "team-a", Pod: "web", StartTime: start},
+		{ID: "a2", User: "alice", Namespace: "team-a", Pod: "db", StartTime: start.Add(time.Hour)},
+		{ID: "b1", User: "bob", Namespace: "team-b", Pod: "web", StartTime: start.Add(2 * time.Hour)},
+	}
+	for _, meta := range fixtures {
+		w, err := store.Create(context.Background(), meta)
+		require.NoError(t, err)
+		live := meta
+		live.StartTime = time.Now()
+		recorder, err := recording.NewRecorder(w, live)
+		require.NoError(t, err)
+		recorder.Output([]byte("output of " + meta.ID))
+		require.NoError(t, recorder.Close())
+	}
+	return store
+}
+
+func getAs(t *testing.T, server *httptest.Server, user, path string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, server.URL+runtime.WebTerminalBasePath+path, nil)
+	require.NoError(t, err)
+	if user != "" {
+		req.Header.Set(testUserHeader, user)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestListRecordings(t *testing.T) {
+	server := newRecordingServer(t, newRecordingFixtures(t))
+	tests := []struct {
+		name     string
+		user     string
+		query    string
+		wantCode int
+		wantIDs  []string
+	}{
+		{name: "owner sees own", user: "alice", wantCode: http.StatusOK, wantIDs: []string{"a2", "a1"}},
+		{name: "owner filters pod", user: "alice", query: "?pod=db", wantCode: http.StatusOK, wantIDs: []string{"a2"}},
+		{name: "owner can not list others", user: "alice", query: "?user=bob", wantCode: http.StatusForbidden},
+		{name: "admin sees all", user: "root", wantCode: http.StatusOK, wantIDs: []string{"b1", "a2", "a1"}},
+		{name: "admin filters namespace", user: "root", query: "?namespace=team-b", wantCode: http.StatusOK,
+			wantIDs: []string{"b1"}},
+		{name: "admin filters time range", user: "root",
+			query: "?since=2024-05-01T10:30:00Z&until=2024-05-01T11:30:00Z", wantCode: http.StatusOK,
+			wantIDs: []string{"a2"}},
+		{name: "bad time", user: "root", query: "?since=yesterday", wantCode: http.StatusBadRequest},
+		{name: "unauthenticated", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := getAs(t, server, tt.user, "/sessions/recordings"+tt.query)
+			require.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var items []recording.Metadata
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&items))
+			ids := make([]string, 0, len(items))
+			for _, item := range items {
+				ids = append(ids, item.ID)
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+		})
+	}
+}
+
+func TestGetRecording(t *testing.T) {
+	server := newRecordingServer(t, newRecordingFixtures(t))
+	tests := []struct {
+		name     string
+		user     string
+		id       string
+		wantCode int
+	}{
+		{name: "owner", user: "alice", id: "a1", wantCode: http.StatusOK},
+		{name: "admin", user: "root", id: "a1", wantCode: http.StatusOK},
+		{name: "other user", user: "bob", id: "a1", wantCode: http.StatusNotFound},
+		{name: "missing", user: "root", id: "zz", wantCode: http.StatusNotFound},
+		{name: "invalid id", user: "root", id: "..", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := getAs(t, server, tt.user, "/sessions/recordings/"+tt.id)
+			require.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, MIMEAsciicast, resp.Header.Get("Content-Type"))
+				reader, err := recording.NewReader(resp.Body)
+				require.NoError(t, err)
+				event, err := reader.Next()
+				require.NoError(t, err)
+				assert.Equal(t, "output of a1", event.Data)
+			}
+		})
+	}
+}
+
+func TestRecordingsDisabled(t *testing.T) {
+	server := newRecordingServer(t, nil)
+	resp := getAs(t, server, "alice", "/sessions/recordings")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestReplayRecording(t *testing.T) {
+	server := newRecordingServer(t, newRecordingFixtures(t))
+	url := strings.Replace(server.URL, "http", "ws", 1) + runtime.WebTerminalBasePath + "/sessions/recordings/"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"a1/replay?speed=2", http.Header{testUserHeader: {"bob"}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	_, resp, err = websocket.DefaultDialer.Dial(url+"a1/replay?speed=0", http.Header{testUserHeader: {"alice"}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"a1/replay?speed=64", http.Header{testUserHeader: {"alice"}})
+	require.NoError(t, err)
+	defer conn.Close()
+	var msg webterminal.Message
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, webterminal.Message{Op: "stdout", Data: "output of a1", Rows: 24, Cols: 80}, msg)
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "disconnect", msg.Op)
+}
@@ -17,14 +17,21 @@ package v1
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 
 	"github.com/emicklei/go-restful/v3"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/client/k8s"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/config"
 	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/runtime"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/recording"
+	"openfuyao.com/web-terminal-service/pkg/webterminal"
 	"openfuyao.com/web-terminal-service/pkg/zlog"
 )
 
@@ -33,6 +40,10 @@ const (
 	KeyOpenApiTags = "openapi.tags"
 	// TagTerminal is a tag
 	TagTerminal = "Web Terminal"
+	// TagRecording is a tag
+	TagRecording = "Session Recording"
+	// TagSession is a tag
+	TagSession = "Session"
 )
 
 // NewClientandConfig reads the kubeconfig file and returns a rest.Config and a kubernetes.Clientset.
@@ -45,19 +56,41 @@ func NewClientandConfig() (*rest.Config, kubernetes.Interface) {
 	return config, clientset
 }
 
-// AddToContainer initializes and adds routes to a RESTful container for mcs API service.
-func AddToContainer(container *restful.Container, client client.Client) error {
+// AddToContainer initializes and adds routes to a RESTful container for mcs API service. The handlers write
+// their audit events to auditor.
+func AddToContainer(container *restful.Container, client client.Client, cfg *config.RunConfig,
+	auditor *audit.Logger) error {
 	ws := runtime.NewWebService()
 	k8sconfig, k8sclient := NewClientandConfig()
-	handler := NewHandler(k8sclient, k8sconfig, client)
+	recordings, err := recording.NewStore(cfg.Recording)
+	if err != nil {
+		return fmt.Errorf("creating recording store: %w", err)
+	}
+	trustedProxies, err := cfg.Server.TrustedProxyNets()
+	if err != nil {
+		return err
+	}
+	sessions := webterminal.NewSessionManager()
+	handler := NewHandler(k8sclient, k8sconfig, client, webterminal.WithRecordingStore(recordings),
+		webterminal.WithSessionManager(sessions), webterminal.WithSessionCfg(cfg.Session),
+		webterminal.WithHomeCfg(cfg.Home), webterminal.WithAuditLogger(auditor), webterminal.WithExecCfg(cfg.Exec))
 	handler.ApiClient = NewAPIClient()
 	handler.MgrClient = client
+	handler.authorizer = authz.NewAuthorizer(k8sclient, cfg.Authorization)
+	handler.recordings = recordings
+	handler.sessions = sessions
+	handler.auditor = auditor
+	handler.execCfg = cfg.Exec
+	handler.trustedProxies = trustedProxies
 
 	// 调用接口注册
 	sayHello(ws, handler)
 
 	terminalPod(ws, handler)
+	podExec(ws, handler)
 	terminalCluster(ws, handler)
+	sessionRecordings(ws, handler)
+	liveSessions(ws, handler)
 
 	container.Add(ws)
 	return nil
@@ -75,7 +108,22 @@ func terminalPod(ws *restful.WebService, h *Handler) {
 		Metadata(KeyOpenApiTags, []string{TagTerminal}).
 		Operation("create-pod-exec").
 		Param(ws.PathParameter("namespace", "Namespace")).
-		Param(ws.PathParameter("pod", "pod")))
+		Param(ws.PathParameter("pod", "pod")).
+		Param(ws.QueryParameter("resume", "resume token of a disconnected session")))
+}
+
+// 在容器中非交互执行命令的接口
+func podExec(ws *restful.WebService, h *Handler) {
+	ws.Route(ws.POST("/namespace/{namespace}/pod/{pod}/container/{container}/exec").
+		To(h.ExecInContainer).
+		Doc("Run a command in a container without a terminal").
+		Metadata(KeyOpenApiTags, []string{TagTerminal}).
+		Operation("exec-in-container").
+		Param(ws.PathParameter("namespace", "Namespace")).
+		Param(ws.PathParameter("pod", "pod")).
+		Param(ws.PathParameter("container", "container")).
+		Reads(webterminal.ExecRequest{}).
+		Returns(http.StatusOK, "OK", webterminal.ExecResult{}))
 }
 
 // 创建集群命令行的交互接口
@@ -90,5 +138,81 @@ func terminalCluster(ws *restful.WebService, h *Handler) {
 		Doc("Create Web Terminal Template").
 		Metadata(KeyOpenApiTags, []string{TagTerminal}).
 		Param(ws.PathParameter("user", "username")).
+		Param(ws.QueryParameter("profile", "WebTerminalProfile the terminal pod is created from")).
+		Param(ws.QueryParameter("resume", "resume token of a disconnected session")).
 		Operation("create-web-terminal-template"))
 }
+
+// 会话录像的查询与回放接口
+func sessionRecordings(ws *restful.WebService, h *Handler) {
+	ws.Route(ws.GET("/sessions/recordings").
+		To(h.ListRecordings).
+		Doc("List session recordings, admins see all recordings and other users their own").
+		Metadata(KeyOpenApiTags, []string{TagRecording}).
+		Param(ws.QueryParameter("user", "only recordings of this user")).
+		Param(ws.QueryParameter("namespace", "only recordings of pods in this namespace")).
+		Param(ws.QueryParameter("pod", "only recordings of this pod")).
+		Param(ws.QueryParameter("since", "only sessions started at or after this RFC3339 time")).
+		Param(ws.QueryParameter("until", "only sessions started at or before this RFC3339 time")).
+		Returns(http.StatusOK, "OK", []recording.Metadata{}).
+		Operation("list-session-recordings"))
+
+	ws.Route(ws.GET("/sessions/recordings/{id}").
+		To(h.GetRecording).
+		Doc("Download a session recording in asciicast v2 format").
+		Metadata(KeyOpenApiTags, []string{TagRecording}).
+		Produces(MIMEAsciicast, restful.MIME_JSON).
+		Param(ws.PathParameter("id", "session id")).
+		Operation("get-session-recording"))
+
+	ws.Route(ws.GET("/sessions/recordings/{id}/replay").
+		To(h.ReplayRecording).
+		Doc("Replay a session recording over a websocket").
+		Metadata(KeyOpenApiTags, []string{TagRecording}).
+		Param(ws.PathParameter("id", "session id")).
+		Param(ws.QueryParameter("speed", "playback speed factor, 1 is real time").DataType("number")).
+		Operation("replay-session-recording"))
+}
+
+// 在线会话的查询与强制断开接口
+func liveSessions(ws *restful.WebService, h *Handler) {
+	ws.Route(ws.GET("/sessions").
+		To(h.ListSessions).
+		Doc("List the live terminal sessions").
+		Metadata(KeyOpenApiTags, []string{TagSession}).
+		Returns(http.StatusOK, "OK", []webterminal.SessionInfo{}).
+		Operation("list-sessions"))
+
+	ws.Route(ws.DELETE("/sessions/{id}").
+		To(h.TerminateSession).
+		Doc("Terminate a live terminal session").
+		Metadata(KeyOpenApiTags, []string{TagSession}).
+		Param(ws.PathParameter("id", "session id")).
+		Returns(http.StatusNoContent, "Terminated", nil).
+		Operation("terminate-session"))
+
+	ws.Route(ws.POST("/sessions/{id}/shares").
+		To(h.CreateShare).
+		Doc("Share a live terminal session of the user").
+		Metadata(KeyOpenApiTags, []string{TagSession}).
+		Param(ws.PathParameter("id", "session id")).
+		Reads(ShareRequest{}).
+		Returns(http.StatusCreated, "Created", ShareResponse{}).
+		Operation("create-session-share"))
+
+	ws.Route(ws.DELETE("/sessions/{id}/shares").
+		To(h.RevokeShares).
+		Doc("Stop sharing a live terminal session and disconnect its viewers").
+		Metadata(KeyOpenApiTags, []string{TagSession}).
+		Param(ws.PathParameter("id", "session id")).
+		Returns(http.StatusNoContent, "Revoked", nil).
+		Operation("revoke-session-shares"))
+
+	ws.Route(ws.GET("/sessions/{id}/join").
+		To(h.JoinSession).
+		Doc("Join a shared terminal session").
+		Metadata(KeyOpenApiTags, []string{TagSession}).
+		Param(ws.PathParameter("id", "session id")).
+		Param(ws.QueryParameter("token", "share token")).
+		Operation("join-session"))
+}
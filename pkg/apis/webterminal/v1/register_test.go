@@ -22,6 +22,7 @@ import (
 	"github.com/agiledragon/gomonkey/v2"
 	"github.com/emicklei/go-restful/v3"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
@@ -149,3 +150,45 @@ func TestTerminalCluster(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, recorder.Code, "pass")
 }
+
+func TestSessionRecordings(t *testing.T) {
+	ws := new(restful.WebService)
+	sessionRecordings(ws, &Handler{})
+
+	var paths []string
+	for _, route := range ws.Routes() {
+		assert.Equal(t, "GET", route.Method)
+		paths = append(paths, route.Path)
+	}
+	assert.Equal(t, []string{"/sessions/recordings", "/sessions/recordings/{id}", "/sessions/recordings/{id}/replay"},
+		paths)
+}
+
+func TestLiveSessions(t *testing.T) {
+	ws := new(restful.WebService)
+	liveSessions(ws, &Handler{})
+
+	routes := ws.Routes()
+	assert.Len(t, routes, 5)
+	assert.Equal(t, "GET", routes[0].Method)
+	assert.Equal(t, "/sessions", routes[0].Path)
+	assert.Equal(t, "DELETE", routes[1].Method)
+	assert.Equal(t, "/sessions/{id}", routes[1].Path)
+	assert.Equal(t, "POST", routes[2].Method)
+	assert.Equal(t, "/sessions/{id}/shares", routes[2].Path)
+	assert.Equal(t, "DELETE", routes[3].Method)
+	assert.Equal(t, "/sessions/{id}/shares", routes[3].Path)
+	assert.Equal(t, "GET", routes[4].Method)
+	assert.Equal(t, "/sessions/{id}/join", routes[4].Path)
+}
+
+func TestPodExec(t *testing.T) {
+	ws := new(restful.WebService)
+	podExec(ws, &Handler{})
+
+	routes := ws.Routes()
+	require.Len(t, routes, 1)
+	assert.Equal(t, "POST", routes[0].Method)
+	assert.Equal(t, "/namespace/{namespace}/pod/{pod}/container/{container}/exec", routes[0].Path)
+	assert.Equal(t, "exec-in-container", routes[0].Operation)
+}
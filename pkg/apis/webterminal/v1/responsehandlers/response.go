@@ -66,3 +66,20 @@ func SendStatusForbidden(resp *restful.Response, message string) {
 		Message: message,
 	})
 }
+
+// SendStatusNotFound writes http.StatusNotFound.
+func SendStatusNotFound(resp *restful.Response, message string) {
+	resp.WriteHeaderAndEntity(http.StatusNotFound, restful.ServiceError{
+		Code:    http.StatusNotFound,
+		Message: message,
+	})
+}
+
+// SendStatusUnauthorized writes http.StatusUnauthorized and log error.
+func SendStatusUnauthorized(resp *restful.Response, message string, err error) {
+	logErrorInfo(err)
+	resp.WriteHeaderAndEntity(http.StatusUnauthorized, restful.ServiceError{
+		Code:    http.StatusUnauthorized,
+		Message: message,
+	})
+}
@@ -13,6 +13,7 @@ package responsehandlers
 
 import (
 	"errors"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 
@@ -140,3 +141,27 @@ func TestSendStatusServerError(t *testing.T) {
 		})
 	}
 }
+
+func TestSendStatusUnauthorized(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	resp := restful.NewResponse(recorder)
+	resp.SetRequestAccepts(restful.MIME_JSON)
+
+	SendStatusUnauthorized(resp, "Invalid token", errors.New("bad signature"))
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("SendStatusUnauthorized() code = %v, want %v", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestSendStatusNotFound(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	resp := restful.NewResponse(recorder)
+	resp.SetRequestAccepts(restful.MIME_JSON)
+
+	SendStatusNotFound(resp, "Recording not found")
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("SendStatusNotFound() code = %v, want %v", recorder.Code, http.StatusNotFound)
+	}
+}
@@ -15,8 +15,10 @@ package runtime
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 
 	"openfuyao.com/web-terminal-service/pkg/zlog"
 )
@@ -27,6 +29,8 @@ const (
 	tlsCAPath          = "/ssl/ca.pem"
 	tlsCertPath        = "/ssl/server.crt"
 	tlsKeyPath         = "/ssl/server.key"
+
+	envTrustedProxies = "TRUSTED_PROXIES"
 )
 
 // ServerConfig 定义一个 http.server 结构
@@ -48,6 +52,10 @@ type ServerConfig struct {
 
 	// tls CA file
 	CAFile string
+
+	// TrustedProxies are the addresses or CIDRs of the proxies in front of the service. Only their
+	// X-Forwarded-For header is trusted.
+	TrustedProxies []string
 }
 
 // NewServerConfig create new server config
@@ -64,6 +72,11 @@ func NewServerConfig() *ServerConfig {
 		CertFile:     "",
 		PrivateKey:   "",
 	}
+	for _, proxy := range strings.Split(os.Getenv(envTrustedProxies), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			s.TrustedProxies = append(s.TrustedProxies, proxy)
+		}
+	}
 	if _, err := os.Stat(tlsCertPath); os.IsNotExist(err) {
 		s.InsecurePort = port
 		return &s
@@ -106,5 +119,29 @@ func (s *ServerConfig) Validate() []error {
 			}
 		}
 	}
+	if _, err := s.TrustedProxyNets(); err != nil {
+		errs = append(errs, err)
+	}
 	return errs
 }
+
+// TrustedProxyNets parses TrustedProxies, an address stands for a network of that address alone
+func (s *ServerConfig) TrustedProxyNets() ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(s.TrustedProxies))
+	for _, proxy := range s.TrustedProxies {
+		if ip := net.ParseIP(proxy); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
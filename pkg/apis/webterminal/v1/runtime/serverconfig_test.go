@@ -133,3 +133,36 @@ func TestServerConfigValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestServerConfigTrustedProxyNets(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none", want: []string{}},
+		{name: "addresses and networks", proxies: []string{"10.0.0.1", "fd00::1", "192.168.0.0/16"},
+			want: []string{"10.0.0.1/32", "fd00::1/128", "192.168.0.0/16"}},
+		{name: "invalid", proxies: []string{"10.0.0.0/33"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &ServerConfig{InsecurePort: defaultServicePort, TrustedProxies: tt.proxies}
+			nets, err := s.TrustedProxyNets()
+			if tt.wantErr {
+				if err == nil || len(s.Validate()) != 1 {
+					t.Errorf("TrustedProxyNets() error = %v, want an error", err)
+				}
+				return
+			}
+			got := []string{}
+			for _, network := range nets {
+				got = append(got, network.String())
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TrustedProxyNets() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
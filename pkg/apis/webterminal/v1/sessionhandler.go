@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/emicklei/go-restful/v3"
+
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/responsehandlers"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/webterminal"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+// ListSessions lists the live terminal sessions of this replica, admins only
+func (h *Handler) ListSessions(req *restful.Request, resp *restful.Response) {
+	if !h.isSessionAdmin(req, resp, "list") {
+		return
+	}
+	_ = resp.WriteHeaderAndEntity(http.StatusOK, h.sessions.List())
+}
+
+// TerminateSession disconnects a live terminal session, admins only
+func (h *Handler) TerminateSession(req *restful.Request, resp *restful.Response) {
+	if !h.isSessionAdmin(req, resp, "delete") {
+		return
+	}
+	id := req.PathParameter("id")
+	reason := fmt.Sprintf("Session terminated by administrator %s", subjectOf(req))
+	session, ok := h.sessions.Get(id)
+	if !ok {
+		responsehandlers.SendStatusNotFound(resp, "Session not found")
+		return
+	}
+	event := h.sessionEvent(req, audit.TypeSessionKill, session.Info())
+	event.Reason = reason
+	h.auditor.Log(event)
+	if err := h.sessions.Terminate(id, reason); err != nil {
+		if errors.Is(err, webterminal.ErrSessionNotFound) {
+			responsehandlers.SendStatusNotFound(resp, "Session not found")
+			return
+		}
+		responsehandlers.SendStatusServerError(resp, "Failed to terminate session", err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// isSessionAdmin checks that the user may perform verb on the sessions of every user,
+// otherwise it writes the error response.
+func (h *Handler) isSessionAdmin(req *restful.Request, resp *restful.Response, verb string) bool {
+	if !isAuthenticated(req) {
+		responsehandlers.SendStatusUnauthorized(resp, "Unauthenticated request", nil)
+		return false
+	}
+	if h.authorizer == nil {
+		responsehandlers.SendStatusForbidden(resp, "No authorizer configured")
+		return false
+	}
+	groups, _ := req.Request.Context().Value("groups").([]string)
+	decision, err := h.authorizer.Authorize(req.Request.Context(),
+		authz.SessionAdminAttributes(subjectOf(req), groups, verb))
+	if err != nil {
+		responsehandlers.SendStatusServerError(resp, "Failed to check user access", err)
+		return false
+	}
+	if !decision.Allowed {
+		zlog.LogInfof("User %s may not %s sessions: %s", subjectOf(req), verb, decision.Reason)
+		responsehandlers.SendStatusForbidden(resp, "Only admins can manage sessions")
+		return false
+	}
+	return true
+}
+
+// sessionEvent returns an audit event of type typ on the session of info, done by the user of req
+func (h *Handler) sessionEvent(req *restful.Request, typ string, info webterminal.SessionInfo) audit.Event {
+	groups, _ := req.Request.Context().Value("groups").([]string)
+	return audit.Event{
+		Type:      typ,
+		SessionID: info.ID,
+		User:      subjectOf(req),
+		Groups:    groups,
+		SourceIP:  clientIP(req.Request, h.trustedProxies),
+		Target:    audit.Target{Namespace: info.Namespace, Pod: info.Pod, Container: info.Container, Kind: info.Kind},
+		Details:   map[string]string{"owner": info.User},
+	}
+}
+
+// clientIP returns the address of the client. The X-Forwarded-For header only counts when the peer is one of
+// the trusted proxies, the client is then the right-most hop that is not a trusted proxy.
+func clientIP(req *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if !isTrustedProxy(host, trusted) {
+		return host
+	}
+	hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if net.ParseIP(hop) == nil {
+			// whatever the proxies forwarded before an entry they did not write cannot be told apart
+			break
+		}
+		host = hop
+		if !isTrustedProxy(hop, trusted) {
+			break
+		}
+	}
+	return host
+}
+
+// isTrustedProxy reports whether addr is in one of the networks of trusted
+func isTrustedProxy(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package v1
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/runtime"
+	"openfuyao.com/web-terminal-service/pkg/webterminal"
+)
+
+// sessionAdminAuthorizer allows the listed users to manage sessions
+type sessionAdminAuthorizer map[string]bool
+
+func (a sessionAdminAuthorizer) Authorize(_ context.Context, attrs authz.Attributes) (authz.Decision, error) {
+	return authz.Decision{Allowed: attrs.Resource == "terminalsessions" && a[attrs.User]}, nil
+}
+
+func TestSessionEndpoints(t *testing.T) {
+	h := &Handler{authorizer: sessionAdminAuthorizer{"root": true}, sessions: webterminal.NewSessionManager()}
+	ws := runtime.NewWebService()
+	liveSessions(ws, h)
+	container := restful.NewContainer()
+	container.Filter(func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		if user := req.HeaderParameter(testUserHeader); user != "" {
+			req.Request = req.Request.WithContext(context.WithValue(req.Request.Context(), "user", user))
+		}
+		chain.ProcessFilter(req, resp)
+	})
+	container.Add(ws)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		user     string
+		wantCode int
+	}{
+		{name: "admin lists", method: http.MethodGet, path: "/sessions", user: "root", wantCode: http.StatusOK},
+		{name: "user can not list", method: http.MethodGet, path: "/sessions", user: "alice",
+			wantCode: http.StatusForbidden},
+		{name: "unauthenticated", method: http.MethodGet, path: "/sessions", wantCode: http.StatusUnauthorized},
+		{name: "terminate unknown", method: http.MethodDelete, path: "/sessions/s1", user: "root",
+			wantCode: http.StatusNotFound},
+		{name: "user can not terminate", method: http.MethodDelete, path: "/sessions/s1", user: "alice",
+			wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, runtime.WebTerminalBasePath+tt.path, nil)
+			if tt.user != "" {
+				req.Header.Set(testUserHeader, tt.user)
+			}
+			recorder := httptest.NewRecorder()
+			container.ServeHTTP(recorder, req)
+			require.Equal(t, tt.wantCode, recorder.Code)
+			if tt.wantCode == http.StatusOK {
+				assert.JSONEq(t, "[]", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/24")
+	require.NoError(t, err)
+	trusted := []*net.IPNet{proxies}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		trusted    []*net.IPNet
+		want       string
+	}{
+		{name: "direct", remoteAddr: "10.0.0.7:51234", trusted: trusted, want: "10.0.0.7"},
+		{name: "no trusted proxies", remoteAddr: "10.0.0.7:51234", forwarded: []string{"203.0.113.9"},
+			want: "10.0.0.7"},
+		{name: "untrusted peer", remoteAddr: "198.51.100.4:51234", forwarded: []string{"203.0.113.9"},
+			trusted: trusted, want: "198.51.100.4"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.7:51234", forwarded: []string{"203.0.113.9"},
+			trusted: trusted, want: "203.0.113.9"},
+		{name: "spoofed entries", remoteAddr: "10.0.0.7:51234",
+			forwarded: []string{"192.0.2.1, 203.0.113.9, 10.0.0.8"}, trusted: trusted, want: "203.0.113.9"},
+		{name: "several headers", remoteAddr: "10.0.0.7:51234", forwarded: []string{"192.0.2.1", "203.0.113.9"},
+			trusted: trusted, want: "203.0.113.9"},
+		{name: "only trusted hops", remoteAddr: "10.0.0.7:51234", forwarded: []string{"10.0.0.8"},
+			trusted: trusted, want: "10.0.0.8"},
+		{name: "malformed hop", remoteAddr: "10.0.0.7:51234", forwarded: []string{"203.0.113.9, unknown"},
+			trusted: trusted, want: "10.0.0.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, forwarded := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", forwarded)
+			}
+			assert.Equal(t, tt.want, clientIP(req, tt.trusted))
+		})
+	}
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/responsehandlers"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/webterminal"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	defaultShareTTL = time.Hour
+	maxShareTTL     = 24 * time.Hour
+)
+
+// ShareRequest is the body of a share creation, both fields are optional
+type ShareRequest struct {
+	// Mode is view (the default) or drive
+	Mode string `json:"mode"`
+	// TTL is a Go duration such as 30m, one hour by default
+	TTL string `json:"ttl"`
+}
+
+// ShareResponse describes a created share
+type ShareResponse struct {
+	webterminal.Share
+	// Path is the websocket path viewers join the session with
+	Path string `json:"path"`
+}
+
+func parseShareRequest(req *restful.Request) (mode string, ttl time.Duration, err error) {
+	var body ShareRequest
+	if err = json.NewDecoder(req.Request.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		return "", 0, fmt.Errorf("invalid share request: %w", err)
+	}
+	mode, ttl = webterminal.ShareModeView, defaultShareTTL
+	if body.Mode != "" {
+		mode = body.Mode
+	}
+	if mode != webterminal.ShareModeView && mode != webterminal.ShareModeDrive {
+		return "", 0, fmt.Errorf("mode must be %s or %s", webterminal.ShareModeView, webterminal.ShareModeDrive)
+	}
+	if body.TTL != "" {
+		ttl, err = time.ParseDuration(body.TTL)
+		if err != nil || ttl <= 0 || ttl > maxShareTTL {
+			return "", 0, fmt.Errorf("ttl must be a duration greater than 0 and at most %v", maxShareTTL)
+		}
+	}
+	return mode, ttl, nil
+}
+
+// CreateShare creates a link the owner of a session hands out to let other users join it
+func (h *Handler) CreateShare(req *restful.Request, resp *restful.Response) {
+	session, ok := h.ownSession(req, resp)
+	if !ok {
+		return
+	}
+	mode, ttl, err := parseShareRequest(req)
+	if err != nil {
+		responsehandlers.SendStatusBadRequest(resp, err.Error(), err)
+		return
+	}
+	share, err := session.CreateShare(mode, ttl)
+	if err != nil {
+		responsehandlers.SendStatusServerError(resp, "Failed to share session", err)
+		return
+	}
+	zlog.LogInfof("User %s shared session %s (%s) until %s", subjectOf(req), session.Info().ID, mode,
+		share.ExpiresAt.Format(time.RFC3339))
+	event := h.sessionEvent(req, audit.TypeShareCreate, session.Info())
+	event.Details["mode"] = mode
+	event.Details["expiresAt"] = share.ExpiresAt.Format(time.RFC3339)
+	h.auditor.Log(event)
+	path := strings.TrimSuffix(req.Request.URL.Path, "/shares") + "/join?token=" + url.QueryEscape(share.Token)
+	_ = resp.WriteHeaderAndEntity(http.StatusCreated, ShareResponse{Share: share, Path: path})
+}
+
+// RevokeShares invalidates the share links of a session and disconnects the users who joined it
+func (h *Handler) RevokeShares(req *restful.Request, resp *restful.Response) {
+	session, ok := h.ownSession(req, resp)
+	if !ok {
+		return
+	}
+	session.RevokeShares()
+	zlog.LogInfof("User %s stopped sharing session %s", subjectOf(req), session.Info().ID)
+	h.auditor.Log(h.sessionEvent(req, audit.TypeShareRevoke, session.Info()))
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// JoinSession attaches the websocket of a user holding a share link to a live session.
+// Viewers additionally need to be allowed to get the pod of the session, co-drivers to exec into it.
+func (h *Handler) JoinSession(req *restful.Request, resp *restful.Response) {
+	if !isAuthenticated(req) {
+		responsehandlers.SendStatusUnauthorized(resp, "Unauthenticated request", nil)
+		return
+	}
+	session, ok := h.sessions.Get(req.PathParameter("id"))
+	if !ok {
+		responsehandlers.SendStatusNotFound(resp, "Session not found")
+		return
+	}
+	token := req.QueryParameter("token")
+	share, ok := session.Share(token)
+	if !ok {
+		// not revealing whether the session exists to users without a valid link
+		responsehandlers.SendStatusNotFound(resp, "Session not found")
+		return
+	}
+	if !h.mayJoin(req, resp, session.Info(), share.Mode) {
+		return
+	}
+
+	conn, err := upgrade.Upgrade(resp.ResponseWriter, req.Request, nil)
+	if err != nil {
+		zlog.LogWarn(err)
+		return
+	}
+	ctx := context.WithValue(req.Request.Context(), "clientIP", clientIP(req.Request, h.trustedProxies))
+	if err = session.Join(ctx, token, subjectOf(req), conn); err != nil {
+		sendWebSocketError(conn, err.Error())
+		_ = conn.Close()
+	}
+}
+
+// ownSession returns the requested session if the user owns it, otherwise it writes the error response
+func (h *Handler) ownSession(req *restful.Request, resp *restful.Response) (*webterminal.Session, bool) {
+	if !isAuthenticated(req) {
+		responsehandlers.SendStatusUnauthorized(resp, "Unauthenticated request", nil)
+		return nil, false
+	}
+	session, ok := h.sessions.Get(req.PathParameter("id"))
+	if !ok || session.Info().User != subjectOf(req) {
+		responsehandlers.SendStatusNotFound(resp, "Session not found")
+		return nil, false
+	}
+	return session, true
+}
+
+// mayJoin reports whether the user may join the session of info in mode, otherwise it writes the error response
+func (h *Handler) mayJoin(req *restful.Request, resp *restful.Response, info webterminal.SessionInfo,
+	mode string) bool {
+	if h.authorizer == nil {
+		responsehandlers.SendStatusForbidden(resp, "No authorizer configured")
+		return false
+	}
+	groups, _ := req.Request.Context().Value("groups").([]string)
+	attrs := authz.PodViewAttributes(subjectOf(req), groups, info.Namespace, info.Pod)
+	if mode == webterminal.ShareModeDrive {
+		attrs = authz.PodExecAttributes(subjectOf(req), groups, info.Namespace, info.Pod)
+	}
+	decision, err := h.authorizer.Authorize(req.Request.Context(), attrs)
+	if err != nil {
+		responsehandlers.SendStatusServerError(resp, "Failed to check user access", err)
+		return false
+	}
+	if !decision.Allowed {
+		zlog.LogInfof("User %s may not join session %s in %s mode: %s", subjectOf(req), info.ID, mode,
+			decision.Reason)
+		responsehandlers.SendStatusForbidden(resp, "Joining requires access to the pod of the session")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/authz"
+	"openfuyao.com/web-terminal-service/pkg/apis/webterminal/v1/runtime"
+	"openfuyao.com/web-terminal-service/pkg/webterminal"
+)
+
+func TestParseShareRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantMode string
+		wantTTL  time.Duration
+		wantErr  bool
+	}{
+		{name: "defaults", wantMode: webterminal.ShareModeView, wantTTL: defaultShareTTL},
+		{name: "empty object", body: "{}", wantMode: webterminal.ShareModeView, wantTTL: defaultShareTTL},
+		{name: "drive", body: `{"mode":"drive","ttl":"30m"}`, wantMode: webterminal.ShareModeDrive,
+			wantTTL: 30 * time.Minute},
+		{name: "unknown mode", body: `{"mode":"edit"}`, wantErr: true},
+		{name: "ttl too long", body: `{"ttl":"48h"}`, wantErr: true},
+		{name: "negative ttl", body: `{"ttl":"-1h"}`, wantErr: true},
+		{name: "malformed", body: `{"mode":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := restful.NewRequest(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+			mode, ttl, err := parseShareRequest(req)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMode, mode)
+			assert.Equal(t, tt.wantTTL, ttl)
+		})
+	}
+}
+
+func TestShareEndpoints(t *testing.T) {
+	h := &Handler{authorizer: sessionAdminAuthorizer{}, sessions: webterminal.NewSessionManager()}
+	ws := runtime.NewWebService()
+	liveSessions(ws, h)
+	container := restful.NewContainer()
+	container.Filter(func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		if user := req.HeaderParameter(testUserHeader); user != "" {
+			req.Request = req.Request.WithContext(context.WithValue(req.Request.Context(), "user", user))
+		}
+		chain.ProcessFilter(req, resp)
+	})
+	container.Add(ws)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		user     string
+		wantCode int
+	}{
+		{name: "share unauthenticated", method: http.MethodPost, path: "/sessions/s1/shares",
+			wantCode: http.StatusUnauthorized},
+		{name: "share unknown session", method: http.MethodPost, path: "/sessions/s1/shares", user: "alice",
+			wantCode: http.StatusNotFound},
+		{name: "revoke unknown session", method: http.MethodDelete, path: "/sessions/s1/shares", user: "alice",
+			wantCode: http.StatusNotFound},
+		{name: "join unauthenticated", method: http.MethodGet, path: "/sessions/s1/join?token=t",
+			wantCode: http.StatusUnauthorized},
+		{name: "join unknown session", method: http.MethodGet, path: "/sessions/s1/join?token=t", user: "bob",
+			wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, runtime.WebTerminalBasePath+tt.path, strings.NewReader("{}"))
+			req.Header.Set("Content-Type", restful.MIME_JSON)
+			if tt.user != "" {
+				req.Header.Set(testUserHeader, tt.user)
+			}
+			recorder := httptest.NewRecorder()
+			container.ServeHTTP(recorder, req)
+			assert.Equal(t, tt.wantCode, recorder.Code)
+		})
+	}
+}
+
+// podAccessAuthorizer allows each listed user the verb on pods
+type podAccessAuthorizer map[string]string
+
+func (a podAccessAuthorizer) Authorize(_ context.Context, attrs authz.Attributes) (authz.Decision, error) {
+	verb, ok := a[attrs.User]
+	return authz.Decision{Allowed: ok && attrs.Resource == "pods" && attrs.Verb == verb}, nil
+}
+
+func TestMayJoin(t *testing.T) {
+	authorizer := podAccessAuthorizer{"viewer": "get", "driver": "create"}
+	info := webterminal.SessionInfo{ID: "s1", Namespace: "default", Pod: "web"}
+	tests := []struct {
+		name       string
+		authorizer authz.Authorizer
+		user       string
+		mode       string
+		wantCode   int
+	}{
+		{name: "view", authorizer: authorizer, user: "viewer", mode: webterminal.ShareModeView},
+		{name: "view without access", authorizer: authorizer, user: "stranger", mode: webterminal.ShareModeView,
+			wantCode: http.StatusForbidden},
+		{name: "drive", authorizer: authorizer, user: "driver", mode: webterminal.ShareModeDrive},
+		{name: "drive with view access", authorizer: authorizer, user: "viewer", mode: webterminal.ShareModeDrive,
+			wantCode: http.StatusForbidden},
+		{name: "no authorizer", user: "viewer", mode: webterminal.ShareModeView, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{authorizer: tt.authorizer}
+			httpReq := httptest.NewRequest(http.MethodGet, "/", nil)
+			httpReq = httpReq.WithContext(context.WithValue(httpReq.Context(), "user", tt.user))
+			recorder := httptest.NewRecorder()
+			resp := restful.NewResponse(recorder)
+			resp.SetRequestAccepts(restful.MIME_JSON)
+
+			allowed := h.mayJoin(restful.NewRequest(httpReq), resp, info, tt.mode)
+			assert.Equal(t, tt.wantCode == 0, allowed)
+			if tt.wantCode != 0 {
+				assert.Equal(t, tt.wantCode, recorder.Code)
+			}
+		})
+	}
+}
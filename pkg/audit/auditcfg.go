@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+// Package audit writes the audit log of terminal sessions, a stream of JSON events kept apart from the
+// debug output of zlog.
+package audit
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	// SinkFile appends the events to a rotated local file
+	SinkFile = "file"
+	// SinkSyslog sends the events to a syslog server over TCP
+	SinkSyslog = "syslog"
+	// SinkWebhook posts the events to an HTTP endpoint
+	SinkWebhook = "webhook"
+
+	defaultFilePath       = "/var/log/webterminal-service/audit/audit.log"
+	defaultFileMaxSize    = 100
+	defaultFileMaxBackups = 10
+	defaultFileMaxAge     = 30
+	defaultBufferSize     = 1024
+	defaultMaxRetries     = 5
+	defaultRetryBackoff   = time.Second
+
+	envEnabled        = "AUDIT_ENABLED"
+	envSink           = "AUDIT_SINK"
+	envFilePath       = "AUDIT_FILE_PATH"
+	envFileMaxSize    = "AUDIT_FILE_MAX_SIZE"
+	envFileMaxBackups = "AUDIT_FILE_MAX_BACKUPS"
+	envFileMaxAge     = "AUDIT_FILE_MAX_AGE"
+	envSyslogAddress  = "AUDIT_SYSLOG_ADDRESS"
+	envWebhookURL     = "AUDIT_WEBHOOK_URL"
+	envWebhookToken   = "AUDIT_WEBHOOK_TOKEN"
+	envBufferSize     = "AUDIT_BUFFER_SIZE"
+	envMaxRetries     = "AUDIT_MAX_RETRIES"
+	envRetryBackoff   = "AUDIT_RETRY_BACKOFF"
+	envRedactPatterns = "AUDIT_REDACT_PATTERNS"
+)
+
+// defaultRedactPatterns mask passwords and tokens passed as flags, secrets assigned to variables such as
+// AWS_SECRET_ACCESS_KEY and bearer tokens
+var defaultRedactPatterns = []string{
+	`(?i)--(?:password|passwd|token|secret)(?:=|\s+)(\S+)`,
+	`(?i)\b\w*(?:secret|token|passw(?:or)?d)\w*=(\S+)`,
+	`(?i)\bbearer\s+(\S+)`,
+}
+
+// FileCfg holds the settings of the file sink
+type FileCfg struct {
+	Path string
+	// MaxSize is the size in megabytes at which the file is rotated
+	MaxSize int
+	// MaxBackups is how many rotated files are kept, MaxAge for how many days
+	MaxBackups int
+	MaxAge     int
+}
+
+// AuditCfg holds the audit log settings
+type AuditCfg struct {
+	Enabled bool
+	Sink    string
+	File    FileCfg
+	// SyslogAddress is the host:port of the syslog server
+	SyslogAddress string
+	WebhookURL    string
+	// WebhookToken is sent as bearer token to the webhook, empty sends none
+	WebhookToken string
+	// BufferSize bounds the events waiting for the sink
+	BufferSize int
+	// MaxRetries is how often a failed write is retried, RetryBackoff the wait before the first retry
+	// which doubles with every retry
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// RedactPatterns are the regular expressions of the secrets masked in command lines
+	RedactPatterns []string
+}
+
+// NewAuditCfg returns the audit config read from the environment
+func NewAuditCfg() *AuditCfg {
+	c := &AuditCfg{
+		Enabled: boolFromEnv(envEnabled, false),
+		Sink:    SinkFile,
+		File: FileCfg{
+			Path:       defaultFilePath,
+			MaxSize:    intFromEnv(envFileMaxSize, defaultFileMaxSize),
+			MaxBackups: intFromEnv(envFileMaxBackups, defaultFileMaxBackups),
+			MaxAge:     intFromEnv(envFileMaxAge, defaultFileMaxAge),
+		},
+		SyslogAddress:  os.Getenv(envSyslogAddress),
+		WebhookURL:     os.Getenv(envWebhookURL),
+		WebhookToken:   os.Getenv(envWebhookToken),
+		BufferSize:     intFromEnv(envBufferSize, defaultBufferSize),
+		MaxRetries:     intFromEnv(envMaxRetries, defaultMaxRetries),
+		RetryBackoff:   durationFromEnv(envRetryBackoff, defaultRetryBackoff),
+		RedactPatterns: defaultRedactPatterns,
+	}
+	if v := os.Getenv(envSink); v != "" {
+		c.Sink = v
+	}
+	if v := os.Getenv(envFilePath); v != "" {
+		c.File.Path = v
+	}
+	if v, ok := os.LookupEnv(envRedactPatterns); ok {
+		c.RedactPatterns = splitLines(v)
+	}
+	return c
+}
+
+// Validate validate audit config
+func (c *AuditCfg) Validate() []error {
+	if !c.Enabled {
+		return nil
+	}
+	var errs []error
+	switch c.Sink {
+	case SinkFile:
+		errs = append(errs, c.File.validate()...)
+	case SinkSyslog:
+		if _, _, err := net.SplitHostPort(c.SyslogAddress); err != nil {
+			errs = append(errs, fmt.Errorf("invalid audit syslog address %q", c.SyslogAddress))
+		}
+	case SinkWebhook:
+		if u, err := url.Parse(c.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+			u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid audit webhook url %q", c.WebhookURL))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown audit sink %q", c.Sink))
+	}
+	if c.BufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("audit buffer size must be positive"))
+	}
+	if c.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("audit max retries must not be negative"))
+	}
+	if c.RetryBackoff < 0 {
+		errs = append(errs, fmt.Errorf("audit retry backoff must not be negative"))
+	}
+	if _, err := NewRedactor(c.RedactPatterns); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func (c *FileCfg) validate() []error {
+	var errs []error
+	if c.Path == "" {
+		errs = append(errs, fmt.Errorf("audit file path is required for the file sink"))
+	}
+	if c.MaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("audit file max size must be positive"))
+	}
+	if c.MaxBackups < 0 || c.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("audit file max backups and max age must not be negative"))
+	}
+	return errs
+}
+
+// splitLines returns the non-empty lines of v, regular expressions may contain any other separator
+func splitLines(v string) []string {
+	var lines []string
+	for _, line := range strings.Split(v, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func boolFromEnv(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		zlog.LogWarnf("invalid %s %q, use default %t", name, v, def)
+		return def
+	}
+	return b
+}
+
+func intFromEnv(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		zlog.LogWarnf("invalid %s %q, use default %d", name, v, def)
+		return def
+	}
+	return i
+}
+
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		zlog.LogWarnf("invalid %s %q, use default %v", name, v, def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuditCfg(t *testing.T) {
+	got := NewAuditCfg()
+	assert.Equal(t, &AuditCfg{Sink: SinkFile, File: FileCfg{Path: defaultFilePath, MaxSize: defaultFileMaxSize,
+		MaxBackups: defaultFileMaxBackups, MaxAge: defaultFileMaxAge}, BufferSize: defaultBufferSize,
+		MaxRetries: defaultMaxRetries, RetryBackoff: defaultRetryBackoff, RedactPatterns: defaultRedactPatterns}, got)
+
+	t.Setenv(envEnabled, "true")
+	t.Setenv(envSink, SinkWebhook)
+	t.Setenv(envWebhookURL, "https://siem.example.com/events")
+	t.Setenv(envWebhookToken, "secret")
+	t.Setenv(envBufferSize, "lots")
+	t.Setenv(envRetryBackoff, "250ms")
+	t.Setenv(envRedactPatterns, "api[-_]key=(\\S+)\n\n  ghp_\\w+  \n")
+	got = NewAuditCfg()
+	assert.True(t, got.Enabled)
+	assert.Equal(t, SinkWebhook, got.Sink)
+	assert.Equal(t, "https://siem.example.com/events", got.WebhookURL)
+	assert.Equal(t, "secret", got.WebhookToken)
+	assert.Equal(t, defaultBufferSize, got.BufferSize)
+	assert.Equal(t, 250*time.Millisecond, got.RetryBackoff)
+	assert.Equal(t, []string{`api[-_]key=(\S+)`, `ghp_\w+`}, got.RedactPatterns)
+}
+
+func TestAuditCfgValidate(t *testing.T) {
+	valid := AuditCfg{Enabled: true, BufferSize: 1, RetryBackoff: time.Second}
+	file := FileCfg{Path: "/tmp/audit.log", MaxSize: 1}
+	tests := []struct {
+		name    string
+		cfg     func(c *AuditCfg)
+		wantErr int
+	}{
+		{name: "disabled", cfg: func(c *AuditCfg) { *c = AuditCfg{Sink: "tape"} }},
+		{name: "file", cfg: func(c *AuditCfg) { c.Sink, c.File = SinkFile, file }},
+		{name: "syslog", cfg: func(c *AuditCfg) { c.Sink, c.SyslogAddress = SinkSyslog, "syslog:601" }},
+		{name: "webhook", cfg: func(c *AuditCfg) { c.Sink, c.WebhookURL = SinkWebhook, "http://siem:8080" }},
+		{name: "unknown sink", cfg: func(c *AuditCfg) { c.Sink = "tape" }, wantErr: 1},
+		{name: "incomplete file", cfg: func(c *AuditCfg) { c.Sink, c.File = SinkFile, FileCfg{MaxAge: -1} },
+			wantErr: 3},
+		{name: "syslog without port", cfg: func(c *AuditCfg) { c.Sink, c.SyslogAddress = SinkSyslog, "syslog" },
+			wantErr: 1},
+		{name: "webhook without scheme", cfg: func(c *AuditCfg) { c.Sink, c.WebhookURL = SinkWebhook, "siem:8080" },
+			wantErr: 1},
+		{name: "invalid delivery", cfg: func(c *AuditCfg) {
+			c.Sink, c.File, c.BufferSize, c.MaxRetries, c.RetryBackoff = SinkFile, file, 0, -1, -time.Second
+		}, wantErr: 3},
+		{name: "invalid redact pattern", cfg: func(c *AuditCfg) {
+			c.Sink, c.File, c.RedactPatterns = SinkFile, file, []string{`token=(\S+`}
+		}, wantErr: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.cfg(&cfg)
+			assert.Len(t, cfg.Validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	logger, err := New(&AuditCfg{})
+	require.NoError(t, err)
+	assert.Nil(t, logger)
+
+	logger, err = New(&AuditCfg{Enabled: true, Sink: SinkFile, BufferSize: 1,
+		File: FileCfg{Path: t.TempDir() + "/audit.log", MaxSize: 1}})
+	require.NoError(t, err)
+	assert.IsType(t, &FileSink{}, logger.sink)
+
+	_, err = New(&AuditCfg{Enabled: true, Sink: "tape"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package audit
+
+import "time"
+
+// types of the audit events
+const (
+	// TypeAuthorization records whether a user may open a terminal
+	TypeAuthorization = "authz.decision"
+	// TypeSessionOpen records a session whose shell is about to start
+	TypeSessionOpen = "session.open"
+	// TypeExecStart records the outcome of starting the shell of a session
+	TypeExecStart = "exec.start"
+	// TypeResize records the terminal size requested by the client
+	TypeResize = "session.resize"
+	// TypeSessionClose records the end of a session and why it ended
+	TypeSessionClose = "session.close"
+	// TypeShareCreate records a share handed out by the owner of a session
+	TypeShareCreate = "share.create"
+	// TypeShareRevoke records the owner of a session revoking its shares
+	TypeShareRevoke = "share.revoke"
+	// TypeSessionJoin records a user joining a shared session
+	TypeSessionJoin = "session.join"
+	// TypeSessionLeave records a user leaving a shared session
+	TypeSessionLeave = "session.leave"
+	// TypeSessionKill records an administrator terminating a session
+	TypeSessionKill = "session.kill"
+	// TypeCommand records a command line submitted in a session
+	TypeCommand = "session.command"
+	// TypeCommandPolicy records the decision taken on a command line matching a rule of the command policies
+	TypeCommandPolicy = "command.policy"
+	// TypeExecCommand records a command run in a container without a terminal and how it exited
+	TypeExecCommand = "exec.command"
+)
+
+// Target is the container a session runs in
+type Target struct {
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+	// Kind is pod or cluster
+	Kind string `json:"kind,omitempty"`
+}
+
+// Event is one entry of the audit log. User, Groups and SourceIP describe who acted, which is the joining
+// user for joins and the administrator for kills.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	SessionID string    `json:"sessionID,omitempty"`
+	User      string    `json:"user"`
+	Groups    []string  `json:"groups,omitempty"`
+	SourceIP  string    `json:"sourceIP,omitempty"`
+	Target    Target    `json:"target"`
+	// Allowed is the outcome of authorization decisions, nil for other events
+	Allowed *bool `json:"allowed,omitempty"`
+	// Reason explains decisions, failed execs and why sessions closed. For command policy decisions it is the
+	// message of the rule.
+	Reason string `json:"reason,omitempty"`
+	// Command is the command line of command and exec command events, with its secrets redacted
+	Command string `json:"command,omitempty"`
+	// Details holds the attributes specific to the type, e.g. the mode of a share or the size of a resize
+	Details map[string]string `json:"details,omitempty"`
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileSink appends one JSON event per line to a file, rotating it by size
+type FileSink struct {
+	out *lumberjack.Logger
+}
+
+// NewFileSink creates a FileSink for cfg, the file is created on the first write
+func NewFileSink(cfg *FileCfg) *FileSink {
+	return &FileSink{out: &lumberjack.Logger{
+		Filename:   cfg.Path,
+		MaxSize:    cfg.MaxSize,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAge,
+		LocalTime:  true,
+	}}
+}
+
+// Write implements Sink
+func (s *FileSink) Write(_ context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.out.Write(append(line, '\n'))
+	return err
+}
+
+// Close implements Sink
+func (s *FileSink) Close() error {
+	return s.out.Close()
+}
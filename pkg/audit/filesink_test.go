@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+	sink := NewFileSink(&FileCfg{Path: path, MaxSize: 1})
+	allowed := true
+	require.NoError(t, sink.Write(context.Background(), Event{Type: TypeAuthorization, User: "alice",
+		Groups: []string{"sre"}, Allowed: &allowed}))
+	require.NoError(t, sink.Write(context.Background(), Event{Type: TypeSessionOpen, SessionID: "s-1",
+		User: "alice", Target: Target{Namespace: "default", Pod: "nginx", Container: "nginx", Kind: "pod"}}))
+	require.NoError(t, sink.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.Len(t, events, 2)
+	assert.Equal(t, []string{"sre"}, events[0].Groups)
+	assert.True(t, *events[0].Allowed)
+	assert.Equal(t, Target{Namespace: "default", Pod: "nginx", Container: "nginx", Kind: "pod"}, events[1].Target)
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"openfuyao.com/web-terminal-service/pkg/metrics"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+// maxRetryBackoff bounds the wait between two attempts to write an event
+const maxRetryBackoff = 30 * time.Second
+
+// Logger queues audit events and writes them to its sink in the background, so sessions never wait for the
+// sink. Failed writes are retried with backoff. Events that can not be delivered, because the buffer is full
+// or the sink keeps failing, are written to the error log and counted instead of being lost silently.
+// All methods of a nil Logger do nothing.
+type Logger struct {
+	sink       Sink
+	maxRetries int
+	backoff    time.Duration
+	now        func() time.Time
+	// redactor masks the secrets in the command lines of events, nil keeps them
+	redactor *Redactor
+
+	// mu guards closed, events is closed once Close is called
+	mu     sync.RWMutex
+	closed bool
+	events chan Event
+	done   chan struct{}
+}
+
+// New creates the Logger writing to the sink selected by cfg, or nil when auditing is disabled
+func New(cfg *AuditCfg) (*Logger, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	redactor, err := NewRedactor(cfg.RedactPatterns)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := NewSink(cfg)
+	if err != nil {
+		return nil, err
+	}
+	zlog.LogInfof("Writing the audit log to the %s sink", cfg.Sink)
+	l := NewLogger(sink, cfg.BufferSize, cfg.MaxRetries, cfg.RetryBackoff)
+	l.redactor = redactor
+	return l, nil
+}
+
+// NewLogger returns a Logger writing to sink. It keeps up to bufferSize events while the sink is busy and
+// retries a failed write maxRetries times, waiting backoff before the first retry and twice as long before
+// each next one.
+func NewLogger(sink Sink, bufferSize, maxRetries int, backoff time.Duration) *Logger {
+	l := &Logger{
+		sink:       sink,
+		maxRetries: maxRetries,
+		backoff:    backoff,
+		now:        time.Now,
+		events:     make(chan Event, bufferSize),
+		done:       make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Log queues event for the sink, stamped with the current time unless it has one. The secrets of its command
+// line are masked before it leaves the Logger, even to the error log.
+func (l *Logger) Log(event Event) {
+	if l == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = l.now()
+	}
+	event.Command = l.redactor.Redact(event.Command)
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		l.drop(event, metrics.AuditDropClosed, nil)
+		return
+	}
+	select {
+	case l.events <- event:
+	default:
+		l.drop(event, metrics.AuditDropBufferFull, nil)
+	}
+}
+
+// Close writes the queued events and closes the sink. It gives up on the events still queued once ctx is done.
+func (l *Logger) Close(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.events)
+	}
+	l.mu.Unlock()
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return fmt.Errorf("%d audit events not written: %w", len(l.events), ctx.Err())
+	}
+	return l.sink.Close()
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	for event := range l.events {
+		l.deliver(event)
+	}
+}
+
+// deliver writes event to the sink, retrying failed writes
+func (l *Logger) deliver(event Event) {
+	backoff := l.backoff
+	for attempt := 0; ; attempt++ {
+		err := l.sink.Write(context.Background(), event)
+		if err == nil {
+			return
+		}
+		if attempt >= l.maxRetries {
+			l.drop(event, metrics.AuditDropSinkError, err)
+			return
+		}
+		zlog.LogWarnf("Failed to write audit event, retrying in %v: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
+
+// drop writes event, which the sink does not get, to the error log
+func (l *Logger) drop(event Event, reason string, err error) {
+	metrics.AuditEventsDropped.WithLabelValues(reason).Inc()
+	line, marshalErr := json.Marshal(event)
+	if marshalErr != nil {
+		line = []byte(fmt.Sprintf("%+v", event))
+	}
+	if err != nil {
+		zlog.LogErrorf("Audit event not delivered (%s: %v): %s", reason, err, line)
+		return
+	}
+	zlog.LogErrorf("Audit event not delivered (%s): %s", reason, line)
+}
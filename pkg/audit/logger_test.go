@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package audit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"openfuyao.com/web-terminal-service/pkg/metrics"
+)
+
+// memorySink keeps the events written to it, failing the first failures writes
+type memorySink struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	events   []Event
+	closed   bool
+	// block holds writes until it is closed, nil does not hold them
+	block chan struct{}
+}
+
+func (s *memorySink) Write(_ context.Context, event Event) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.attempts <= s.failures {
+		return errors.New("connection refused")
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestLoggerDelivers(t *testing.T) {
+	sink := &memorySink{}
+	logger := NewLogger(sink, 8, 0, 0)
+	stamped := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	logger.Log(Event{Type: TypeSessionOpen, SessionID: "s-1", Time: stamped})
+	logger.Log(Event{Type: TypeSessionClose, SessionID: "s-1"})
+	require.NoError(t, logger.Close(context.Background()))
+
+	require.Len(t, sink.events, 2)
+	assert.Equal(t, stamped, sink.events[0].Time)
+	assert.Equal(t, TypeSessionClose, sink.events[1].Type)
+	assert.False(t, sink.events[1].Time.IsZero())
+	assert.True(t, sink.closed)
+}
+
+func TestLoggerRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    int
+		maxRetries  int
+		wantEvents  int
+		wantDropped float64
+	}{
+		{name: "delivered after retries", failures: 2, maxRetries: 2, wantEvents: 1},
+		{name: "dropped after retries", failures: 3, maxRetries: 2, wantDropped: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dropped := metrics.AuditEventsDropped.WithLabelValues(metrics.AuditDropSinkError)
+			before := testutil.ToFloat64(dropped)
+			sink := &memorySink{failures: tt.failures}
+			logger := NewLogger(sink, 1, tt.maxRetries, time.Millisecond)
+			logger.Log(Event{Type: TypeSessionKill})
+			require.NoError(t, logger.Close(context.Background()))
+
+			assert.Equal(t, tt.maxRetries+1, sink.attempts)
+			assert.Len(t, sink.events, tt.wantEvents)
+			assert.Equal(t, before+tt.wantDropped, testutil.ToFloat64(dropped))
+		})
+	}
+}
+
+func TestLoggerBufferFull(t *testing.T) {
+	dropped := metrics.AuditEventsDropped.WithLabelValues(metrics.AuditDropBufferFull)
+	before := testutil.ToFloat64(dropped)
+	sink := &memorySink{block: make(chan struct{})}
+	logger := NewLogger(sink, 1, 0, 0)
+	logger.Log(Event{Type: TypeResize})
+	// the first event is held by the sink once the logger picked it up, the second one fills the buffer
+	require.Eventually(t, func() bool { return len(logger.events) == 0 }, time.Second, time.Millisecond)
+	logger.Log(Event{Type: TypeResize})
+	logger.Log(Event{Type: TypeResize})
+	assert.Equal(t, before+1, testutil.ToFloat64(dropped))
+
+	close(sink.block)
+	require.NoError(t, logger.Close(context.Background()))
+	assert.Len(t, sink.events, 2)
+}
+
+func TestLoggerClose(t *testing.T) {
+	sink := &memorySink{block: make(chan struct{})}
+	logger := NewLogger(sink, 4, 0, 0)
+	logger.Log(Event{Type: TypeSessionOpen})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, logger.Close(ctx), context.DeadlineExceeded)
+
+	closed := metrics.AuditEventsDropped.WithLabelValues(metrics.AuditDropClosed)
+	before := testutil.ToFloat64(closed)
+	logger.Log(Event{Type: TypeSessionClose})
+	assert.Equal(t, before+1, testutil.ToFloat64(closed))
+	close(sink.block)
+	require.NoError(t, logger.Close(context.Background()))
+	assert.Len(t, sink.events, 1)
+
+	var nilLogger *Logger
+	nilLogger.Log(Event{Type: TypeSessionOpen})
+	assert.NoError(t, nilLogger.Close(context.Background()))
+}
+
+func TestLoggerRedacts(t *testing.T) {
+	sink := &memorySink{}
+	logger := NewLogger(sink, 8, 0, 0)
+	logger.redactor, _ = NewRedactor(defaultRedactPatterns)
+	logger.Log(Event{Type: TypeCommand, Command: "psql --password=s3cr3t"})
+	require.NoError(t, logger.Close(context.Background()))
+
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, "psql --password=***", sink.events[0].Command)
+}
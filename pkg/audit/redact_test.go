@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	redactor, err := NewRedactor(defaultRedactPatterns)
+	require.NoError(t, err)
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{name: "no secret", line: "kubectl get pods -n kube-system", want: "kubectl get pods -n kube-system"},
+		{name: "password flag", line: "mysql -u root --password s3cr3t db", want: "mysql -u root --password *** db"},
+		{name: "password flag with value", line: "login --PASSWORD=s3cr3t", want: "login --PASSWORD=***"},
+		{name: "token flag", line: "kubectl --token=eyJhbGc get pods", want: "kubectl --token=*** get pods"},
+		{name: "aws secret", line: "export AWS_SECRET_ACCESS_KEY=wJalrXUtnFEMI && aws s3 ls",
+			want: "export AWS_SECRET_ACCESS_KEY=*** && aws s3 ls"},
+		{name: "several secrets", line: "DB_PASSWORD=a GITHUB_TOKEN=b ./deploy.sh",
+			want: "DB_PASSWORD=*** GITHUB_TOKEN=*** ./deploy.sh"},
+		{name: "bearer token", line: `curl -H "Authorization: Bearer abc.def" https://api`,
+			want: `curl -H "Authorization: Bearer *** https://api`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, redactor.Redact(tt.line))
+		})
+	}
+}
+
+func TestRedactorPatterns(t *testing.T) {
+	redactor, err := NewRedactor([]string{`ghp_\w+`})
+	require.NoError(t, err)
+	assert.Equal(t, "git clone https://***@github.com/org/repo",
+		redactor.Redact("git clone https://ghp_abc123@github.com/org/repo"))
+
+	var none *Redactor
+	assert.Equal(t, "--password s3cr3t", none.Redact("--password s3cr3t"))
+
+	_, err = NewRedactor([]string{`--password (\S+`})
+	assert.Error(t, err)
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package audit
+
+import (
+	"context"
+	"fmt"
+)
+
+// Sink delivers audit events to where they are kept. The Logger writes to its sink from a single goroutine.
+type Sink interface {
+	// Write delivers event, the Logger retries events that failed
+	Write(ctx context.Context, event Event) error
+	// Close releases the resources of the sink
+	Close() error
+}
+
+// NewSink creates the Sink selected by cfg
+func NewSink(cfg *AuditCfg) (Sink, error) {
+	switch cfg.Sink {
+	case SinkFile:
+		return NewFileSink(&cfg.File), nil
+	case SinkSyslog:
+		return NewSyslogSink(cfg.SyslogAddress), nil
+	case SinkWebhook:
+		return NewWebhookSink(cfg.WebhookURL, cfg.WebhookToken), nil
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q", cfg.Sink)
+	}
+}
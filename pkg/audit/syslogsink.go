@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	syslogAppName = "web-terminal-service"
+	// syslogPriority is facility authpriv (10) with severity informational (6)
+	syslogPriority = 10*8 + 6
+	syslogTimeout  = 10 * time.Second
+)
+
+// SyslogSink sends every event as an RFC 5424 message, framed by octet counting as of RFC 6587, to a
+// syslog server over TCP. The connection is opened on the first write and again after a failed one.
+type SyslogSink struct {
+	address  string
+	hostname string
+	conn     net.Conn
+	now      func() time.Time
+}
+
+// NewSyslogSink creates a SyslogSink sending to address
+func NewSyslogSink(address string) *SyslogSink {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &SyslogSink{address: address, hostname: hostname, now: time.Now}
+}
+
+// Write implements Sink
+func (s *SyslogSink) Write(ctx context.Context, event Event) error {
+	msg, err := s.format(event)
+	if err != nil {
+		return err
+	}
+	if s.conn == nil {
+		dialer := net.Dialer{Timeout: syslogTimeout}
+		if s.conn, err = dialer.DialContext(ctx, "tcp", s.address); err != nil {
+			return fmt.Errorf("connecting to syslog server: %w", err)
+		}
+	}
+	if err = s.conn.SetWriteDeadline(s.now().Add(syslogTimeout)); err == nil {
+		_, err = fmt.Fprintf(s.conn, "%d %s", len(msg), msg)
+	}
+	if err != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("writing to syslog server: %w", err)
+	}
+	return nil
+}
+
+// format returns the RFC 5424 message of event, with the event type as message id and the JSON event as message
+func (s *SyslogSink) format(event Event) ([]byte, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s - ", syslogPriority, event.Time.UTC().Format(time.RFC3339Nano),
+		s.hostname, syslogAppName, os.Getpid(), event.Type)
+	return append([]byte(header), body...), nil
+}
+
+// Close implements Sink
+func (s *SyslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
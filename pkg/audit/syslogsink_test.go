@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readSyslogFrame reads one octet counted syslog message
+func readSyslogFrame(r *bufio.Reader) (string, error) {
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, n)
+	_, err = io.ReadFull(r, msg)
+	return string(msg), err
+}
+
+func TestSyslogSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	messages := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			for {
+				msg, err := readSyslogFrame(r)
+				if err != nil {
+					_ = conn.Close()
+					break
+				}
+				messages <- msg
+			}
+		}
+	}()
+	defer listener.Close()
+
+	sink := NewSyslogSink(listener.Addr().String())
+	sink.hostname = "wts-0"
+	at := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	for _, typ := range []string{TypeSessionOpen, TypeSessionClose} {
+		require.NoError(t, sink.Write(context.Background(), Event{Time: at, Type: typ, SessionID: "s-1",
+			User: "alice"}))
+	}
+	require.NoError(t, sink.Close())
+
+	for _, typ := range []string{TypeSessionOpen, TypeSessionClose} {
+		msg := <-messages
+		header := fmt.Sprintf("<86>1 2024-05-01T08:00:00Z wts-0 web-terminal-service %d %s - ", os.Getpid(), typ)
+		require.True(t, strings.HasPrefix(msg, header), msg)
+		var event Event
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(msg, header)), &event))
+		assert.Equal(t, "alice", event.User)
+	}
+}
+
+func TestSyslogSinkUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	sink := NewSyslogSink(address)
+	assert.Error(t, sink.Write(context.Background(), Event{Type: TypeSessionOpen}))
+	assert.Nil(t, sink.conn)
+	assert.NoError(t, sink.Close())
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookSink(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		status    int
+		wantAuth  string
+		wantError bool
+	}{
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "bearer token", token: "secret", status: http.StatusOK, wantAuth: "Bearer secret"},
+		{name: "rejected", status: http.StatusServiceUnavailable, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Event
+			var auth, contentType string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth, contentType = r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+				_ = json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			sink := NewWebhookSink(server.URL, tt.token)
+			err := sink.Write(context.Background(), Event{Type: TypeShareCreate, SessionID: "s-1", User: "alice",
+				Details: map[string]string{"mode": "view"}})
+			assert.Equal(t, tt.wantError, err != nil, err)
+			assert.Equal(t, tt.wantAuth, auth)
+			assert.Equal(t, "application/json", contentType)
+			assert.Equal(t, "view", got.Details["mode"])
+			assert.NoError(t, sink.Close())
+		})
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+// Package metrics defines the Prometheus metrics of the web terminal service. They are registered with the
+// registry of the controller manager and served by its metrics server.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "webterminal"
+
+// directions of the session traffic
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// reasons of authorization denials
+const (
+	DenialNoUser       = "no_user"
+	DenialNoAuthorizer = "no_authorizer"
+	DenialError        = "error"
+	DenialForbidden    = "forbidden"
+)
+
+// reasons of audit events not written to the audit sink
+const (
+	AuditDropBufferFull = "buffer_full"
+	AuditDropSinkError  = "sink_error"
+	AuditDropClosed     = "closed"
+)
+
+var (
+	// ActiveSessions counts the live sessions by type, pod or cluster
+	ActiveSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sessions_active",
+		Help:      "Number of live terminal sessions by type.",
+	}, []string{"type"})
+
+	// SessionDuration observes how long sessions lasted by type
+	SessionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "session_duration_seconds",
+		Help:      "Duration of terminal sessions by type.",
+		Buckets:   prometheus.ExponentialBuckets(10, 3, 9),
+	}, []string{"type"})
+
+	// SessionBytes counts the bytes typed by users (in) and sent to them (out) by session type
+	SessionBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_bytes_total",
+		Help:      "Bytes exchanged by terminal sessions by type and direction.",
+	}, []string{"type", "direction"})
+
+	// ShellDetectionDuration observes how long finding the shell of a container took
+	ShellDetectionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "shell_detection_seconds",
+		Help:      "Time taken to find a shell in the container of a session.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// ExecSetupDuration observes how long a session took from its start until its shell was started
+	ExecSetupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "exec_setup_seconds",
+		Help:      "Time taken from accepting a terminal session until its shell is started, by type.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type"})
+
+	// AuthorizationDenials counts the refused sessions by reason
+	AuthorizationDenials = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "authorization_denials_total",
+		Help:      "Terminal sessions refused by the access check, by reason.",
+	}, []string{"reason"})
+
+	// UserPodReadyDuration observes how long the pods of cluster terminals took to become ready
+	UserPodReadyDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "user_pod_ready_seconds",
+		Help:      "Time taken by cluster terminal pods from their creation until they are ready.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	// TTLDeletions counts the cluster terminals removed for inactivity
+	TTLDeletions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ttl_deletions_total",
+		Help:      "Cluster terminals removed after their session timeout.",
+	})
+
+	// WebsocketWriteErrors counts the failed writes to the websockets of clients
+	WebsocketWriteErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_write_errors_total",
+		Help:      "Failed writes of terminal output to client websockets.",
+	})
+
+	// AuditEventsDropped counts the audit events written to the error log instead of the audit sink, by reason
+	AuditEventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_events_dropped_total",
+		Help:      "Audit events the audit sink did not receive, by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		ActiveSessions,
+		SessionDuration,
+		SessionBytes,
+		ShellDetectionDuration,
+		ExecSetupDuration,
+		AuthorizationDenials,
+		UserPodReadyDuration,
+		TTLDeletions,
+		WebsocketWriteErrors,
+		AuditEventsDropped,
+	)
+}
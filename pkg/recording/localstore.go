@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+// LocalStore keeps recordings as <id>.cast and <id>.json files in a directory
+type LocalStore struct {
+	dir string
+}
+
+// NewLocalStore creates a LocalStore in dir, creating the directory if needed
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &LocalStore{dir: dir}, nil
+}
+
+// Create implements RecordingStore
+func (s *LocalStore) Create(_ context.Context, meta Metadata) (io.WriteCloser, error) {
+	if err := validateID(meta.ID); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(s.path(meta.ID, castSuffix), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.writeMeta(meta); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &localWriter{store: s, file: f, meta: meta}, nil
+}
+
+// Open implements RecordingStore
+func (s *LocalStore) Open(_ context.Context, id string) (io.ReadCloser, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path(id, castSuffix))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Stat implements RecordingStore
+func (s *LocalStore) Stat(_ context.Context, id string) (*Metadata, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	return s.readMeta(s.path(id, metaSuffix))
+}
+
+// List implements RecordingStore
+func (s *LocalStore) List(_ context.Context) ([]Metadata, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Metadata, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), metaSuffix) {
+			continue
+		}
+		meta, err := s.readMeta(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			zlog.LogWarnf("Skipping unreadable recording metadata %s: %v", entry.Name(), err)
+			continue
+		}
+		items = append(items, *meta)
+	}
+	sortNewestFirst(items)
+	return items, nil
+}
+
+func (s *LocalStore) path(id, suffix string) string {
+	return filepath.Join(s.dir, id+suffix)
+}
+
+func (s *LocalStore) readMeta(path string) (*Metadata, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta := &Metadata{}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// writeMeta replaces the metadata file atomically so readers never see a partial document
+func (s *LocalStore) writeMeta(meta Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, "."+meta.ID+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(meta.ID, metaSuffix))
+}
+
+type localWriter struct {
+	store *LocalStore
+	file  *os.File
+	meta  Metadata
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.meta.Size += int64(n)
+	return n, err
+}
+
+func (w *localWriter) Close() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.meta.EndTime = time.Now()
+	return w.store.writeMeta(w.meta)
+}
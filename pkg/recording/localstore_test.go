@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package recording
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStoreRoundTrip checks the behaviour every RecordingStore must have
+func testStoreRoundTrip(t *testing.T, store RecordingStore) {
+	ctx := context.Background()
+	start := time.Date(2024, time.March, 1, 8, 0, 0, 0, time.UTC)
+	meta := Metadata{ID: "s1", User: "alice", Namespace: "default", Pod: "web", Container: "app",
+		Kind: KindPod, StartTime: start}
+
+	w, err := store.Create(ctx, meta)
+	require.NoError(t, err)
+
+	running, err := store.Stat(ctx, "s1")
+	require.NoError(t, err)
+	assert.True(t, running.EndTime.IsZero(), "a running recording has no end time")
+
+	_, err = w.Write([]byte("{\"version\":2}\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	older, err := store.Create(ctx, Metadata{ID: "s0", StartTime: start.Add(-time.Hour)})
+	require.NoError(t, err)
+	require.NoError(t, older.Close())
+
+	got, err := store.Stat(ctx, "s1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", got.User)
+	assert.Equal(t, int64(14), got.Size)
+	assert.False(t, got.EndTime.IsZero())
+
+	r, err := store.Open(ctx, "s1")
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "{\"version\":2}\n", string(content))
+
+	items, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "s1", items[0].ID, "newest first")
+
+	_, err = store.Stat(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Open(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Open(ctx, "../etc/passwd")
+	assert.Error(t, err)
+}
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	testStoreRoundTrip(t, store)
+
+	_, err = store.Create(context.Background(), Metadata{ID: "s1"})
+	assert.Error(t, err, "recordings must never be overwritten")
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxLineSize bounds a single asciicast line, output chunks are far smaller
+const maxLineSize = 4 << 20
+
+// Event is one asciicast v2 event
+type Event struct {
+	// Time is the offset from the start of the session
+	Time time.Duration
+	Code string
+	Data string
+}
+
+// Reader reads an asciicast v2 file event by event
+type Reader struct {
+	scanner *bufio.Scanner
+	header  Header
+	line    int
+}
+
+// NewReader reads the header of an asciicast v2 stream
+func NewReader(r io.Reader) (*Reader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	reader := &Reader{scanner: scanner}
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("empty recording")
+	}
+	reader.line = 1
+	if err := json.Unmarshal(scanner.Bytes(), &reader.header); err != nil {
+		return nil, fmt.Errorf("invalid asciicast header: %w", err)
+	}
+	if reader.header.Version != asciicastVersion {
+		return nil, fmt.Errorf("unsupported asciicast version %d", reader.header.Version)
+	}
+	return reader, nil
+}
+
+// Header returns the asciicast header
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next returns the next event, or io.EOF at the end of the recording
+func (r *Reader) Next() (Event, error) {
+	for r.scanner.Scan() {
+		r.line++
+		if len(r.scanner.Bytes()) == 0 {
+			continue
+		}
+		var raw []interface{}
+		if err := json.Unmarshal(r.scanner.Bytes(), &raw); err != nil {
+			return Event{}, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		if len(raw) != 3 {
+			return Event{}, fmt.Errorf("line %d: expected 3 fields, got %d", r.line, len(raw))
+		}
+		seconds, ok1 := raw[0].(float64)
+		code, ok2 := raw[1].(string)
+		data, ok3 := raw[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return Event{}, fmt.Errorf("line %d: malformed event", r.line)
+		}
+		return Event{Time: time.Duration(seconds * float64(time.Second)), Code: code, Data: data}, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	return Event{}, io.EOF
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	// EventOutput is an asciicast event carrying terminal output
+	EventOutput = "o"
+	// EventInput is an asciicast event carrying keyboard input
+	EventInput = "i"
+	// EventResize is an asciicast event carrying the new size as "<cols>x<rows>"
+	EventResize = "r"
+
+	asciicastVersion = 2
+	defaultWidth     = 80
+	defaultHeight    = 24
+)
+
+// Header is the first line of an asciicast v2 file
+type Header struct {
+	Version   int               `json:"version"`
+	Width     uint16            `json:"width"`
+	Height    uint16            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder writes the events of one terminal session as asciicast v2.
+// All methods are safe for concurrent use and a nil Recorder records nothing.
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.WriteCloser
+	start time.Time
+	err   error
+	now   func() time.Time
+}
+
+// NewRecorder writes the asciicast header for meta to w and returns a Recorder appending to it
+func NewRecorder(w io.WriteCloser, meta Metadata) (*Recorder, error) {
+	r := &Recorder{w: w, start: meta.StartTime, now: time.Now}
+	if r.start.IsZero() {
+		r.start = r.now()
+	}
+	header := Header{
+		Version:   asciicastVersion,
+		Width:     defaultWidth,
+		Height:    defaultHeight,
+		Timestamp: r.start.Unix(),
+		Title:     fmt.Sprintf("%s@%s/%s/%s", meta.User, meta.Namespace, meta.Pod, meta.Container),
+		Env:       map[string]string{"TERM": "xterm"},
+	}
+	if err := r.writeLine(header); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Output records data written to the terminal
+func (r *Recorder) Output(data []byte) {
+	r.event(EventOutput, string(data))
+}
+
+// Input records data typed by the user
+func (r *Recorder) Input(data []byte) {
+	r.event(EventInput, string(data))
+}
+
+// Resize records a terminal size change
+func (r *Recorder) Resize(cols, rows uint16) {
+	r.event(EventResize, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Close completes the recording
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w == nil {
+		return r.err
+	}
+	err := r.w.Close()
+	r.w = nil
+	if err != nil {
+		return err
+	}
+	return r.err
+}
+
+func (r *Recorder) event(code, data string) {
+	if r == nil {
+		return
+	}
+	elapsed := r.now().Sub(r.start).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w == nil || r.err != nil {
+		return
+	}
+	if err := r.writeLine([]interface{}{elapsed, code, data}); err != nil {
+		r.err = err
+		zlog.LogErrorf("Session recording stopped: %v", err)
+	}
+}
+
+func (r *Recorder) writeLine(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = r.w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package recording
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+	err    error
+}
+
+func (b *bufferCloser) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	buf := &bufferCloser{}
+	r, err := NewRecorder(buf, Metadata{User: "alice", Namespace: "default", Pod: "web", Container: "app",
+		StartTime: start})
+	require.NoError(t, err)
+
+	now := start
+	r.now = func() time.Time { return now }
+	now = start.Add(500 * time.Millisecond)
+	r.Resize(120, 40)
+	now = start.Add(time.Second)
+	r.Input([]byte("ls\r"))
+	now = start.Add(1250 * time.Millisecond)
+	r.Output([]byte("a.txt\r\n"))
+	require.NoError(t, r.Close())
+	r.Output([]byte("after close"))
+
+	assert.True(t, buf.closed)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{
+		`{"version":2,"width":80,"height":24,"timestamp":1700000000,"title":"alice@default/web/app",` +
+			`"env":{"TERM":"xterm"}}`,
+		`[0.5,"r","120x40"]`,
+		`[1,"i","ls\r"]`,
+		`[1.25,"o","a.txt\r\n"]`,
+	}, lines)
+}
+
+func TestRecorderWriteFailure(t *testing.T) {
+	buf := &bufferCloser{}
+	r, err := NewRecorder(buf, Metadata{})
+	require.NoError(t, err)
+
+	buf.err = errors.New("disk full")
+	r.Output([]byte("x"))
+	buf.err = nil
+	r.Output([]byte("y"))
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"), "no events after a failed write")
+	assert.EqualError(t, r.Close(), "disk full")
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *Recorder
+	r.Output([]byte("x"))
+	r.Input([]byte("x"))
+	r.Resize(1, 1)
+	assert.NoError(t, r.Close())
+}
+
+func TestReader(t *testing.T) {
+	buf := &bufferCloser{}
+	r, err := NewRecorder(buf, Metadata{StartTime: time.Unix(1700000000, 0)})
+	require.NoError(t, err)
+	r.now = func() time.Time { return time.Unix(1700000001, 500000000) }
+	r.Resize(100, 30)
+	r.Output([]byte("hi"))
+	require.NoError(t, r.Close())
+
+	reader, err := NewReader(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1700000000), reader.Header().Timestamp)
+	ev, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, Event{Time: 1500 * time.Millisecond, Code: EventResize, Data: "100x30"}, ev)
+	ev, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "hi", ev.Data)
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReaderInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "empty", content: ""},
+		{name: "bad header", content: "nope\n"},
+		{name: "wrong version", content: `{"version":1}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReader(strings.NewReader(tt.content))
+			assert.Error(t, err)
+		})
+	}
+
+	reader, err := NewReader(strings.NewReader(`{"version":2}` + "\n[1,\"o\"]\n"))
+	require.NoError(t, err)
+	_, err = reader.Next()
+	assert.ErrorContains(t, err, "line 2")
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+// Package recording records terminal sessions as asciicast v2 files.
+package recording
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	// BackendLocal stores recordings on the local filesystem
+	BackendLocal = "local"
+	// BackendS3 stores recordings in an S3 compatible object store
+	BackendS3 = "s3"
+
+	defaultDir      = "/var/log/webterminal-service/recordings"
+	defaultS3Region = "us-east-1"
+	defaultS3Prefix = "recordings/"
+
+	envEnabled           = "RECORDING_ENABLED"
+	envBackend           = "RECORDING_BACKEND"
+	envDir               = "RECORDING_DIR"
+	envS3Endpoint        = "RECORDING_S3_ENDPOINT"
+	envS3Region          = "RECORDING_S3_REGION"
+	envS3Bucket          = "RECORDING_S3_BUCKET"
+	envS3Prefix          = "RECORDING_S3_PREFIX"
+	envS3PathStyle       = "RECORDING_S3_PATH_STYLE"
+	envS3AccessKeyID     = "RECORDING_S3_ACCESS_KEY_ID"
+	envS3SecretAccessKey = "RECORDING_S3_SECRET_ACCESS_KEY"
+)
+
+// S3Cfg holds the connection settings of an S3 compatible object store
+type S3Cfg struct {
+	// Endpoint is the base url, e.g. https://s3.us-east-1.amazonaws.com or http://minio:9000
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to every object key
+	Prefix string
+	// PathStyle addresses the bucket as <endpoint>/<bucket> instead of <bucket>.<endpoint>
+	PathStyle       bool
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// RecordingCfg holds the session recording settings
+type RecordingCfg struct {
+	Enabled bool
+	Backend string
+	// Dir is where the local backend keeps recordings
+	Dir string
+	S3  S3Cfg
+}
+
+// NewRecordingCfg returns the recording config read from the environment
+func NewRecordingCfg() *RecordingCfg {
+	c := &RecordingCfg{
+		Backend: BackendLocal,
+		Dir:     defaultDir,
+		S3: S3Cfg{
+			Endpoint:        os.Getenv(envS3Endpoint),
+			Region:          defaultS3Region,
+			Bucket:          os.Getenv(envS3Bucket),
+			Prefix:          defaultS3Prefix,
+			PathStyle:       true,
+			AccessKeyID:     os.Getenv(envS3AccessKeyID),
+			SecretAccessKey: os.Getenv(envS3SecretAccessKey),
+		},
+	}
+	c.Enabled = boolFromEnv(envEnabled, false)
+	c.S3.PathStyle = boolFromEnv(envS3PathStyle, true)
+	if v := os.Getenv(envBackend); v != "" {
+		c.Backend = v
+	}
+	if v := os.Getenv(envDir); v != "" {
+		c.Dir = v
+	}
+	if v := os.Getenv(envS3Region); v != "" {
+		c.S3.Region = v
+	}
+	if v, ok := os.LookupEnv(envS3Prefix); ok {
+		c.S3.Prefix = v
+	}
+	return c
+}
+
+// Validate validate recording config
+func (c *RecordingCfg) Validate() []error {
+	if !c.Enabled {
+		return nil
+	}
+	var errs []error
+	switch c.Backend {
+	case BackendLocal:
+		if c.Dir == "" {
+			errs = append(errs, fmt.Errorf("recording directory is required for the local backend"))
+		}
+	case BackendS3:
+		errs = append(errs, c.S3.validate()...)
+	default:
+		errs = append(errs, fmt.Errorf("unknown recording backend %q", c.Backend))
+	}
+	return errs
+}
+
+func (c *S3Cfg) validate() []error {
+	var errs []error
+	if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("invalid recording s3 endpoint %q", c.Endpoint))
+	}
+	if c.Bucket == "" {
+		errs = append(errs, fmt.Errorf("recording s3 bucket is required"))
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		errs = append(errs, fmt.Errorf("recording s3 credentials are required"))
+	}
+	return errs
+}
+
+func boolFromEnv(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		zlog.LogWarnf("invalid %s %q, use default %t", name, v, def)
+		return def
+	}
+	return b
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package recording
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRecordingCfg(t *testing.T) {
+	got := NewRecordingCfg()
+	assert.False(t, got.Enabled)
+	assert.Equal(t, BackendLocal, got.Backend)
+	assert.Equal(t, defaultDir, got.Dir)
+	assert.True(t, got.S3.PathStyle)
+
+	t.Setenv(envEnabled, "true")
+	t.Setenv(envBackend, BackendS3)
+	t.Setenv(envS3Endpoint, "http://minio:9000")
+	t.Setenv(envS3Bucket, "audit")
+	t.Setenv(envS3Prefix, "")
+	t.Setenv(envS3PathStyle, "maybe")
+	got = NewRecordingCfg()
+	assert.True(t, got.Enabled)
+	assert.Equal(t, S3Cfg{Endpoint: "http://minio:9000", Region: defaultS3Region, Bucket: "audit",
+		PathStyle: true}, got.S3)
+}
+
+func TestRecordingCfgValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     RecordingCfg
+		wantErr int
+	}{
+		{name: "disabled", cfg: RecordingCfg{Backend: "tape"}},
+		{name: "local", cfg: RecordingCfg{Enabled: true, Backend: BackendLocal, Dir: "/tmp"}},
+		{name: "unknown backend", cfg: RecordingCfg{Enabled: true, Backend: "tape"}, wantErr: 1},
+		{name: "incomplete s3", cfg: RecordingCfg{Enabled: true, Backend: BackendS3,
+			S3: S3Cfg{Endpoint: "minio:9000"}}, wantErr: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, tt.cfg.Validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	store, err := NewStore(&RecordingCfg{})
+	require.NoError(t, err)
+	assert.Nil(t, store)
+
+	store, err = NewStore(&RecordingCfg{Enabled: true, Backend: BackendLocal, Dir: t.TempDir()})
+	require.NoError(t, err)
+	assert.IsType(t, &LocalStore{}, store)
+
+	_, err = NewStore(&RecordingCfg{Enabled: true, Backend: BackendS3})
+	assert.Error(t, err)
+}
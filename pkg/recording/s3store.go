@@ -0,0 +1,267 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package recording
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	s3RequestTimeout = 30 * time.Second
+	maxErrorBody     = 1024
+)
+
+// S3Store keeps recordings as <prefix><id>.cast and <prefix><id>.json objects in an
+// S3 compatible bucket. Requests are signed with AWS signature version 4.
+//
+// Objects can not be appended to, so the asciicast content is spooled to a temporary
+// file while the session runs and uploaded when the recording is closed.
+type S3Store struct {
+	cfg      S3Cfg
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Store creates an S3Store for cfg
+func NewS3Store(cfg *S3Cfg) (*S3Store, error) {
+	if errs := cfg.validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return &S3Store{
+		cfg:      *cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: s3RequestTimeout},
+		now:      time.Now,
+	}, nil
+}
+
+// Create implements RecordingStore
+func (s *S3Store) Create(ctx context.Context, meta Metadata) (io.WriteCloser, error) {
+	if err := validateID(meta.ID); err != nil {
+		return nil, err
+	}
+	spool, err := os.CreateTemp("", "recording-"+meta.ID+"-*")
+	if err != nil {
+		return nil, err
+	}
+	if err = s.putMeta(ctx, meta); err != nil {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+		return nil, err
+	}
+	return &s3Writer{store: s, spool: spool, hash: sha256.New(), meta: meta}, nil
+}
+
+// Open implements RecordingStore
+func (s *S3Store) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.key(id, castSuffix), nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat implements RecordingStore
+func (s *S3Store) Stat(ctx context.Context, id string) (*Metadata, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	return s.getMeta(ctx, s.key(id, metaSuffix))
+}
+
+// List implements RecordingStore
+func (s *S3Store) List(ctx context.Context) ([]Metadata, error) {
+	keys, err := s.listKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Metadata, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasSuffix(key, metaSuffix) {
+			continue
+		}
+		meta, err := s.getMeta(ctx, key)
+		if err != nil {
+			zlog.LogWarnf("Skipping unreadable recording metadata %s: %v", key, err)
+			continue
+		}
+		items = append(items, *meta)
+	}
+	sortNewestFirst(items)
+	return items, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) listKeys(ctx context.Context) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		result := listBucketResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding bucket listing: %w", err)
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Store) getMeta(ctx context.Context, key string) (*Metadata, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	meta := &Metadata{}
+	if err = json.NewDecoder(resp.Body).Decode(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (s *S3Store) putMeta(ctx context.Context, meta Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.key(meta.ID, metaSuffix), nil, bytes.NewReader(data),
+		int64(len(data)), hashHex(data))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3Store) key(id, suffix string) string {
+	return s.cfg.Prefix + id + suffix
+}
+
+// objectURL returns the url of key, or of the bucket itself when key is empty
+func (s *S3Store) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	if s.cfg.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	u.RawPath = awsURIEscape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do sends a signed request and returns the response if it succeeded.
+// A missing object is reported as ErrNotFound.
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64,
+	payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		// S3 rejects chunked uploads that are not signed as such
+		req.ContentLength = size
+	}
+	signV4(req, payloadHash, s.cfg.AccessKeyID, s.cfg.SecretAccessKey, s.cfg.Region, s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		return nil, ErrNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+type s3Writer struct {
+	store *S3Store
+	spool *os.File
+	hash  hash.Hash
+	meta  Metadata
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	n, err := w.spool.Write(p)
+	w.hash.Write(p[:n])
+	w.meta.Size += int64(n)
+	return n, err
+}
+
+// Close uploads the spooled recording and its final metadata
+func (w *s3Writer) Close() error {
+	defer os.Remove(w.spool.Name())
+	defer w.spool.Close()
+	if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+	// the spool is wrapped so the request does not take ownership of the file
+	resp, err := w.store.do(ctx, http.MethodPut, w.store.key(w.meta.ID, castSuffix), nil,
+		io.NopCloser(io.LimitReader(w.spool, w.meta.Size)), w.meta.Size, hex.EncodeToString(w.hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	w.meta.EndTime = time.Now()
+	return w.store.putMeta(ctx, w.meta)
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package recording
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "secret"
+	testBucket    = "audit"
+)
+
+// fakeS3 is a minimal path-style S3 stand-in that verifies request signatures
+type fakeS3 struct {
+	t        *testing.T
+	mu       sync.Mutex
+	objects  map[string][]byte
+	pageSize int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{t: t, objects: map[string][]byte{}, pageSize: 1000}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if !f.verify(r, body) {
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+	bucketPrefix := "/" + testBucket + "/"
+	if !strings.HasPrefix(r.URL.Path+"/", bucketPrefix) {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, bucketPrefix)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r)
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	start := 0
+	if token := r.URL.Query().Get("continuation-token"); token != "" {
+		_, _ = fmt.Sscanf(token, "%d", &start)
+	}
+	end := start + f.pageSize
+	result := listBucketResult{}
+	if end < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = fmt.Sprint(end)
+	} else {
+		end = len(keys)
+	}
+	for _, k := range keys[start:end] {
+		result.Contents = append(result.Contents, struct {
+			Key string `xml:"Key"`
+		}{Key: k})
+	}
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// verify recomputes the signature of r the way S3 does
+func (f *fakeS3) verify(r *http.Request, body []byte) bool {
+	if r.Header.Get(headerAmzContent) != hashHex(body) {
+		return false
+	}
+	signedAt, err := time.Parse(amzDateFormat, r.Header.Get(headerAmzDate))
+	if err != nil {
+		return false
+	}
+	expected, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	signV4(expected, hashHex(body), testAccessKey, testSecretKey, "us-east-1", signedAt)
+	return expected.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func newTestS3Store(t *testing.T, endpoint string) *S3Store {
+	store, err := NewS3Store(&S3Cfg{
+		Endpoint:        endpoint,
+		Region:          "us-east-1",
+		Bucket:          testBucket,
+		Prefix:          "recordings/",
+		PathStyle:       true,
+		AccessKeyID:     testAccessKey,
+		SecretAccessKey: testSecretKey,
+	})
+	require.NoError(t, err)
+	return store
+}
+
+func TestS3Store(t *testing.T) {
+	fake, server := newFakeS3(t)
+	store := newTestS3Store(t, server.URL)
+	testStoreRoundTrip(t, store)
+
+	fake.mu.Lock()
+	_, ok := fake.objects["recordings/s1.cast"]
+	fake.mu.Unlock()
+	assert.True(t, ok)
+}
+
+func TestS3StoreListPagination(t *testing.T) {
+	fake, server := newFakeS3(t)
+	fake.pageSize = 2
+	store := newTestS3Store(t, server.URL)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		w, err := store.Create(ctx, Metadata{ID: fmt.Sprintf("s%d", i), StartTime: time.Unix(int64(i), 0)})
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+	items, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, items, 3)
+	assert.Equal(t, "s2", items[0].ID)
+}
+
+func TestS3StoreRejectedCredentials(t *testing.T) {
+	_, server := newFakeS3(t)
+	store := newTestS3Store(t, server.URL)
+	store.cfg.SecretAccessKey = "wrong"
+
+	_, err := store.Create(context.Background(), Metadata{ID: "s1"})
+	assert.ErrorContains(t, err, "403")
+}
+
+func TestS3StoreVirtualHostURL(t *testing.T) {
+	store, err := NewS3Store(&S3Cfg{Endpoint: "https://s3.example.com", Bucket: "b", Prefix: "r/",
+		AccessKeyID: "a", SecretAccessKey: "s"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://b.s3.example.com/r/x.cast", store.objectURL(store.key("x", castSuffix), nil).String())
+}
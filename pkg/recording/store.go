@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package recording
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	// KindPod marks a recording of a pod terminal
+	KindPod = "pod"
+	// KindCluster marks a recording of a cluster terminal
+	KindCluster = "cluster"
+
+	castSuffix = ".cast"
+	metaSuffix = ".json"
+)
+
+var (
+	// ErrNotFound is returned when a recording does not exist
+	ErrNotFound = errors.New("recording not found")
+	// ErrInvalidID is returned for ids that can not name a recording
+	ErrInvalidID = errors.New("invalid recording id")
+)
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,127}$`)
+
+// Metadata describes a recorded terminal session
+type Metadata struct {
+	ID        string    `json:"id"`
+	User      string    `json:"user"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Container string    `json:"container"`
+	Kind      string    `json:"kind"`
+	StartTime time.Time `json:"startTime"`
+	// EndTime is zero while the session is still running
+	EndTime time.Time `json:"endTime,omitempty"`
+	// Size of the asciicast file in bytes, known once the recording is closed
+	Size int64 `json:"size"`
+}
+
+// RecordingStore persists asciicast recordings together with their metadata
+type RecordingStore interface {
+	// Create starts a new recording; closing the returned writer completes it
+	Create(ctx context.Context, meta Metadata) (io.WriteCloser, error)
+	// Open returns the asciicast content of a completed recording
+	Open(ctx context.Context, id string) (io.ReadCloser, error)
+	// Stat returns the metadata of a recording
+	Stat(ctx context.Context, id string) (*Metadata, error)
+	// List returns the metadata of all recordings, newest first
+	List(ctx context.Context) ([]Metadata, error)
+}
+
+// NewStore creates the RecordingStore selected by cfg, or nil when recording is disabled
+func NewStore(cfg *RecordingCfg) (RecordingStore, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	var (
+		store RecordingStore
+		err   error
+	)
+	switch cfg.Backend {
+	case BackendS3:
+		store, err = NewS3Store(&cfg.S3)
+	case BackendLocal:
+		store, err = NewLocalStore(cfg.Dir)
+	default:
+		err = fmt.Errorf("unknown recording backend %q", cfg.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func validateID(id string) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("%w %q", ErrInvalidID, id)
+	}
+	return nil
+}
+
+func sortNewestFirst(items []Metadata) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].StartTime.After(items[j].StartTime)
+	})
+}
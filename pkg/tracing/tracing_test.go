@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector stands in for an OTLP/HTTP collector and keeps the names of the spans exported to it
+type collector struct {
+	mu       sync.Mutex
+	services []string
+	spans    []string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	req := &coltracepb.ExportTraceServiceRequest{}
+	if err != nil || r.URL.Path != "/v1/traces" || proto.Unmarshal(body, req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, resourceSpans := range req.ResourceSpans {
+		for _, attr := range resourceSpans.Resource.Attributes {
+			if attr.Key == "service.name" {
+				c.services = append(c.services, attr.Value.GetStringValue())
+			}
+		}
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				c.spans = append(c.spans, span.Name)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+// restoreGlobals puts back the global tracer provider and propagator once t is done
+func restoreGlobals(t *testing.T) {
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+}
+
+func TestSetupExports(t *testing.T) {
+	restoreGlobals(t)
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	shutdown, err := Setup(context.Background(), &TracingCfg{Enabled: true, Endpoint: server.URL,
+		SampleRatio: 1, ServiceName: "wts-test"})
+	require.NoError(t, err)
+	ctx, parent := Start(context.Background(), "HandlePodTerminal")
+	_, child := Start(ctx, "getShell")
+	End(child, nil)
+	End(parent, nil)
+	require.NoError(t, shutdown(context.Background()))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	assert.ElementsMatch(t, []string{"HandlePodTerminal", "getShell"}, c.spans)
+	assert.Contains(t, c.services, "wts-test")
+}
+
+func TestSetupDisabled(t *testing.T) {
+	restoreGlobals(t)
+	shutdown, err := Setup(context.Background(), &TracingCfg{})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	// the trace context of callers is propagated even when no spans are exported
+	assert.ElementsMatch(t, []string{"traceparent", "tracestate", "baggage"}, otel.GetTextMapPropagator().Fields())
+	_, span := Start(context.Background(), "getShell")
+	assert.False(t, span.IsRecording())
+}
+
+func TestNewProviderSamples(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := newProvider(&TracingCfg{ServiceName: "wts-test"}, exporter)
+	tracer := provider.Tracer(instrumentationName)
+
+	_, span := tracer.Start(context.Background(), "dropped")
+	span.End()
+	// a sampled caller keeps the trace although the ratio drops new ones
+	parent := sdktrace.NewTracerProvider().Tracer(instrumentationName)
+	ctx, caller := parent.Start(context.Background(), "caller")
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	ctx = propagation.TraceContext{}.Extract(context.Background(), carrier)
+	_, span = tracer.Start(ctx, "continued")
+	span.End()
+	caller.End()
+	require.NoError(t, provider.ForceFlush(context.Background()))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "continued", spans[0].Name)
+	assert.Equal(t, caller.SpanContext().TraceID(), spans[0].SpanContext.TraceID())
+}
+
+func TestEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(instrumentationName)
+
+	_, ok := tracer.Start(context.Background(), "ok")
+	End(ok, nil)
+	_, failed := tracer.Start(context.Background(), "failed")
+	End(failed, errors.New("pods \"alice\" is forbidden"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, "pods \"alice\" is forbidden", spans[1].Status().Description)
+	assert.Len(t, spans[1].Events(), 1)
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package tracing
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	defaultServiceName = "web-terminal-service"
+	defaultSampleRatio = 1.0
+
+	envEnabled     = "TRACING_ENABLED"
+	envEndpoint    = "TRACING_ENDPOINT"
+	envSampleRatio = "TRACING_SAMPLE_RATIO"
+	envServiceName = "TRACING_SERVICE_NAME"
+)
+
+// TracingCfg holds the trace export settings
+type TracingCfg struct {
+	Enabled bool
+	// Endpoint is the url of an OTLP/HTTP collector, e.g. http://otel-collector:4318
+	Endpoint string
+	// SampleRatio is the share of the traces started here that are sampled, traces continued from a
+	// caller follow the decision of the caller
+	SampleRatio float64
+	ServiceName string
+}
+
+// NewTracingCfg returns the tracing config read from the environment
+func NewTracingCfg() *TracingCfg {
+	c := &TracingCfg{
+		Enabled:     boolFromEnv(envEnabled, false),
+		Endpoint:    os.Getenv(envEndpoint),
+		SampleRatio: floatFromEnv(envSampleRatio, defaultSampleRatio),
+		ServiceName: defaultServiceName,
+	}
+	if v := os.Getenv(envServiceName); v != "" {
+		c.ServiceName = v
+	}
+	return c
+}
+
+// Validate validate tracing config
+func (c *TracingCfg) Validate() []error {
+	if !c.Enabled {
+		return nil
+	}
+	var errs []error
+	if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("invalid tracing endpoint %q", c.Endpoint))
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing sample ratio must be between 0 and 1, got %v", c.SampleRatio))
+	}
+	return errs
+}
+
+func boolFromEnv(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		zlog.LogWarnf("invalid %s %q, use default %t", name, v, def)
+		return def
+	}
+	return b
+}
+
+func floatFromEnv(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		zlog.LogWarnf("invalid %s %q, use default %v", name, v, def)
+		return def
+	}
+	return f
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package tracing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTracingCfg(t *testing.T) {
+	got := NewTracingCfg()
+	assert.Equal(t, &TracingCfg{SampleRatio: defaultSampleRatio, ServiceName: defaultServiceName}, got)
+
+	t.Setenv(envEnabled, "true")
+	t.Setenv(envEndpoint, "http://otel-collector:4318")
+	t.Setenv(envSampleRatio, "often")
+	t.Setenv(envServiceName, "wts-dev")
+	got = NewTracingCfg()
+	assert.Equal(t, &TracingCfg{Enabled: true, Endpoint: "http://otel-collector:4318",
+		SampleRatio: defaultSampleRatio, ServiceName: "wts-dev"}, got)
+
+	t.Setenv(envSampleRatio, "0.25")
+	assert.Equal(t, 0.25, NewTracingCfg().SampleRatio)
+}
+
+func TestTracingCfgValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     TracingCfg
+		wantErr int
+	}{
+		{name: "disabled", cfg: TracingCfg{SampleRatio: 2}},
+		{name: "enabled", cfg: TracingCfg{Enabled: true, Endpoint: "https://otel-collector:4318", SampleRatio: 0.5}},
+		{name: "endpoint without scheme", cfg: TracingCfg{Enabled: true, Endpoint: "otel-collector:4318"},
+			wantErr: 1},
+		{name: "invalid sample ratio", cfg: TracingCfg{Enabled: true, Endpoint: "http://otel-collector:4318",
+			SampleRatio: -0.1}, wantErr: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, tt.cfg.Validate(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+// activityFlushTimeout bounds a single write of the activity of a session
+const activityFlushTimeout = 10 * time.Second
+
+// activityTracker records when a cluster terminal was last used and writes it to the RenewTime of its
+// WebterminalTemplate at most once per interval, so that typing does not update the template on every frame
+type activityTracker struct {
+	client   client.Client
+	key      types.NamespacedName
+	interval time.Duration
+	// flushMu keeps writes in order, a late write must not move the activity back
+	flushMu sync.Mutex
+
+	mu         sync.Mutex
+	lastInput  time.Time
+	lastOutput time.Time
+	// flushed is the activity last written, lastFlush when it was written
+	flushed   time.Time
+	lastFlush time.Time
+	// timer is pending while there is activity to write
+	timer  *time.Timer
+	closed bool
+}
+
+func newActivityTracker(c client.Client, key types.NamespacedName, interval time.Duration) *activityTracker {
+	return &activityTracker{client: c, key: key, interval: interval}
+}
+
+// input records input of the user, it does nothing on a nil tracker
+func (a *activityTracker) input() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastInput = time.Now()
+	a.schedule()
+}
+
+// output records output of the terminal, it does nothing on a nil tracker
+func (a *activityTracker) output() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastOutput = time.Now()
+	a.schedule()
+}
+
+// schedule arranges a write of the activity once the interval since the last write has passed, mu must be held
+func (a *activityTracker) schedule() {
+	if a.closed || a.timer != nil {
+		return
+	}
+	a.timer = time.AfterFunc(time.Until(a.lastFlush.Add(a.interval)), func() {
+		a.flush()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.timer = nil
+		if a.last().After(a.flushed) {
+			// activity recorded during the write waits for the next one
+			a.schedule()
+		}
+	})
+}
+
+// last returns the time of the latest input or output, mu must be held
+func (a *activityTracker) last() time.Time {
+	if a.lastOutput.After(a.lastInput) {
+		return a.lastOutput
+	}
+	return a.lastInput
+}
+
+// flush writes the latest activity to the template unless it has been written already
+func (a *activityTracker) flush() {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+	a.mu.Lock()
+	last := a.last()
+	if !last.After(a.flushed) {
+		a.mu.Unlock()
+		return
+	}
+	a.flushed, a.lastFlush = last, time.Now()
+	a.mu.Unlock()
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"renewTime": metav1.NewTime(last)},
+	})
+	if err != nil {
+		zlog.LogErrorf("Failed to encode activity of %s: %v", a.key.Name, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), activityFlushTimeout)
+	defer cancel()
+	template := &v1beta1.WebterminalTemplate{}
+	template.Name, template.Namespace = a.key.Name, a.key.Namespace
+	if err = a.client.Patch(ctx, template, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		zlog.LogWarnf("Failed to record activity of %s: %v", a.key.Name, err)
+	}
+}
+
+// close stops the periodic writes and writes the activity not written yet, it does nothing on a nil tracker
+func (a *activityTracker) close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.closed = true
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	a.mu.Unlock()
+	a.flush()
+}
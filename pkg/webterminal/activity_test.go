@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+)
+
+// newActivityClient returns a client holding the template of key and counting the patches sent to it
+func newActivityClient(t *testing.T, key types.NamespacedName) (client.Client, *int32) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1beta1.AddToScheme(scheme))
+	template := &v1beta1.WebterminalTemplate{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	patches := new(int32)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(template).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch,
+				opts ...client.PatchOption) error {
+				atomic.AddInt32(patches, 1)
+				return c.Patch(ctx, obj, patch, opts...)
+			},
+		}).Build()
+	return c, patches
+}
+
+func renewTime(t *testing.T, c client.Client, key types.NamespacedName) time.Time {
+	template := &v1beta1.WebterminalTemplate{}
+	require.NoError(t, c.Get(context.Background(), key, template))
+	return template.Spec.RenewTime.Time
+}
+
+func TestActivityTrackerDebounces(t *testing.T) {
+	key := types.NamespacedName{Name: "openfuyao-alice", Namespace: UserPodNamespace}
+	c, patches := newActivityClient(t, key)
+	tracker := newActivityTracker(c, key, 200*time.Millisecond)
+
+	for i := 0; i < 50; i++ {
+		tracker.input()
+		tracker.output()
+	}
+	// the first activity is written right away, the rest waits for the interval
+	require.Eventually(t, func() bool { return atomic.LoadInt32(patches) >= 1 }, time.Second, 10*time.Millisecond)
+	first := renewTime(t, c, key)
+	assert.WithinDuration(t, time.Now(), first, time.Second)
+	time.Sleep(300 * time.Millisecond)
+	written := atomic.LoadInt32(patches)
+	assert.LessOrEqual(t, written, int32(2))
+
+	tracker.input()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(patches) == written+1 }, time.Second,
+		10*time.Millisecond)
+	assert.False(t, renewTime(t, c, key).Before(first))
+
+	// nothing new to write
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, written+1, atomic.LoadInt32(patches))
+}
+
+func TestActivityTrackerFlushesOnClose(t *testing.T) {
+	key := types.NamespacedName{Name: "openfuyao-alice", Namespace: UserPodNamespace}
+	c, patches := newActivityClient(t, key)
+	tracker := newActivityTracker(c, key, time.Hour)
+
+	tracker.output()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(patches) == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	tracker.input()
+	tracker.close()
+	assert.Equal(t, int32(2), atomic.LoadInt32(patches))
+	tracker.mu.Lock()
+	assert.Equal(t, tracker.lastInput.Unix(), renewTime(t, c, key).Unix())
+	tracker.mu.Unlock()
+
+	// activity after the session ended is not written
+	tracker.input()
+	tracker.close()
+	assert.Equal(t, int32(2), atomic.LoadInt32(patches))
+}
+
+func TestActivityTrackerNil(t *testing.T) {
+	var tracker *activityTracker
+	tracker.input()
+	tracker.output()
+	tracker.close()
+}
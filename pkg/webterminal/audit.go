@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"openfuyao.com/web-terminal-service/pkg/audit"
+)
+
+// sessionIDFrom returns the session id the request of ctx was assigned, so that the audit events of its
+// authorization and of its session share it, or a new one
+func sessionIDFrom(ctx context.Context) string {
+	if id, ok := ctx.Value("sessionID").(string); ok && id != "" {
+		return id
+	}
+	return uuid.NewString()
+}
+
+// auditEvent returns an event of type typ on the session of info, done by the user of ctx
+func auditEvent(ctx context.Context, typ string, info SessionInfo) audit.Event {
+	user, _ := ctx.Value("user").(string)
+	groups, _ := ctx.Value("groups").([]string)
+	clientIP, _ := ctx.Value("clientIP").(string)
+	return audit.Event{
+		Type:      typ,
+		SessionID: info.ID,
+		User:      user,
+		Groups:    groups,
+		SourceIP:  clientIP,
+		Target:    audit.Target{Namespace: info.Namespace, Pod: info.Pod, Container: info.Container, Kind: info.Kind},
+	}
+}
+
+// audit logs an event of type typ on the session of the window, done by its owner
+func (w *Window) audit(typ, reason string, details map[string]string) {
+	if w.session == nil {
+		return
+	}
+	event := auditEvent(w.ctx, typ, w.session.info)
+	event.Reason = reason
+	event.Details = details
+	w.logAudit(event)
+}
+
+// logAudit writes event to the audit log of the terminal of the window
+func (w *Window) logAudit(event audit.Event) {
+	if w.terminaler != nil {
+		w.terminaler.auditor.Log(event)
+	}
+}
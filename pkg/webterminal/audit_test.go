@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package webterminal
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"openfuyao.com/web-terminal-service/pkg/audit"
+)
+
+// memorySink keeps the audit events written to it
+type memorySink struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (s *memorySink) Write(_ context.Context, event audit.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func TestSessionIDFrom(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "sessionID", "s-1")
+	assert.Equal(t, "s-1", sessionIDFrom(ctx))
+	assert.NotEqual(t, sessionIDFrom(context.Background()), sessionIDFrom(context.Background()))
+}
+
+func TestSessionAudit(t *testing.T) {
+	sink := &memorySink{}
+	auditor := audit.NewLogger(sink, 16, 0, 0)
+	ctx := context.WithValue(context.Background(), "user", "alice")
+	ctx = context.WithValue(ctx, "groups", []string{"sre"})
+	ctx = context.WithValue(ctx, "clientIP", "10.0.0.1")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conn, owner := newConnPair(t)
+	window := &Window{conn: conn, sizeChan: make(chan remotecommand.TerminalSize, 1), ctx: ctx,
+		terminaler: &terminaler{auditor: auditor}, input: make(chan string)}
+	target := audit.Target{Namespace: "default", Pod: "nginx", Container: "nginx", Kind: "pod"}
+	session := newSession(SessionInfo{ID: "s1", User: "alice", Namespace: target.Namespace, Pod: target.Pod,
+		Container: target.Container, Kind: target.Kind, StartTime: time.Now()}, cancel, window)
+	window.session = session
+	window.commands = newCommandLog(auditEvent(ctx, audit.TypeCommand, session.info), auditor.Log)
+	manager := NewSessionManager()
+	manager.register(session)
+
+	require.NoError(t, owner.WriteJSON(Message{Op: OpResize, Rows: 40, Cols: 120}))
+	_, err := window.Read(make([]byte, 16))
+	require.NoError(t, err)
+	require.NoError(t, owner.WriteJSON(Message{Op: OpStdin, Data: "whoami\r"}))
+	_, err = window.Read(make([]byte, 16))
+	require.NoError(t, err)
+	_, err = window.Write([]byte("root\r\n"))
+	require.NoError(t, err)
+	readMessage(t, owner)
+
+	share, err := session.CreateShare(ShareModeView, time.Hour)
+	require.NoError(t, err)
+	viewerConn, _ := newConnPair(t)
+	joined := make(chan error, 1)
+	viewerCtx := context.WithValue(context.WithValue(context.Background(), "user", "bob"), "clientIP", "10.0.0.2")
+	go func() { joined <- session.Join(viewerCtx, share.Token, "bob", viewerConn) }()
+	assert.Equal(t, "bob joined your session (read-only)", readMessage(t, owner).Data)
+
+	require.NoError(t, manager.Terminate("s1", "Session terminated by administrator root"))
+	window.Close("Process finished")
+	require.NoError(t, <-joined)
+	require.NoError(t, auditor.Close(context.Background()))
+
+	owned := audit.Event{SessionID: "s1", User: "alice", Groups: []string{"sre"}, SourceIP: "10.0.0.1",
+		Target: target}
+	viewed := audit.Event{SessionID: "s1", User: "bob", SourceIP: "10.0.0.2", Target: target,
+		Details: map[string]string{"mode": ShareModeView, "owner": "alice"}}
+	want := []audit.Event{owned, viewed, owned, owned, viewed}
+	want[0].Type, want[0].Details = audit.TypeResize, map[string]string{"rows": "40", "cols": "120"}
+	want[1].Type = audit.TypeSessionJoin
+	want[2].Type, want[2].Command = audit.TypeCommand, "whoami"
+	want[2].Details = map[string]string{"outputBytes": "6"}
+	want[3].Type, want[3].Reason = audit.TypeSessionClose, "Session terminated by administrator root"
+	want[4].Type = audit.TypeSessionLeave
+	require.Len(t, sink.events, len(want))
+	for i := range sink.events {
+		assert.False(t, sink.events[i].Time.IsZero())
+		sink.events[i].Time = time.Time{}
+	}
+	assert.Equal(t, want, sink.events)
+}
@@ -0,0 +1,424 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+)
+
+// control keys the line assembler interprets, as sent by xterm compatible terminals
+const (
+	keyCtrlA     = 0x01
+	keyCtrlB     = 0x02
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyCtrlE     = 0x05
+	keyCtrlF     = 0x06
+	keyBackspace = 0x08
+	keyTab       = 0x09
+	keyLineFeed  = 0x0a
+	keyCtrlK     = 0x0b
+	keyReturn    = 0x0d
+	keyCtrlR     = 0x12
+	keyCtrlU     = 0x15
+	keyCtrlW     = 0x17
+	keyEscape    = 0x1b
+	keyDelete    = 0x7f
+
+	pasteStart = "[200~"
+	pasteEnd   = "[201~"
+)
+
+// commandLine is a line the user submitted to the shell
+type commandLine struct {
+	text string
+	// incomplete is set when the shell changed the line in ways the keys do not tell, e.g. by recalling
+	// history or completing a word. text then only holds what was typed.
+	incomplete bool
+}
+
+// lineAssembler rebuilds the command lines a user submits from the keys typed into a terminal.
+// It follows the readline editing keys: cursor and word movement, deleting, killing, Ctrl-C and bracketed
+// paste.
+type lineAssembler struct {
+	line   []rune
+	cursor int
+	// incomplete marks the current line as changed by the shell
+	incomplete bool
+	// escape holds an unfinished escape sequence, partial an unfinished UTF-8 character
+	escape  []byte
+	partial []byte
+	pasting bool
+	// afterReturn skips the line feed of a CRLF sent by some clients
+	afterReturn bool
+}
+
+// feed processes typed data and returns the lines it submitted
+func (a *lineAssembler) feed(data string) []commandLine {
+	var lines []commandLine
+	for data != "" {
+		n, submitting := a.next(data)
+		data = data[n:]
+		if !submitting {
+			continue
+		}
+		if line, ok := a.submit(); ok {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// next processes data up to the first key submitting the current line. It returns how much of data it
+// processed and whether that ended with such a key, the line is kept until submit is called.
+func (a *lineAssembler) next(data string) (int, bool) {
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		afterReturn := a.afterReturn
+		a.afterReturn = false
+		switch {
+		case a.escape != nil:
+			a.escape = append(a.escape, b)
+			a.continueEscape()
+		case len(a.partial) > 0 || b >= utf8.RuneSelf:
+			a.partial = append(a.partial, b)
+			if utf8.FullRune(a.partial) {
+				r, _ := utf8.DecodeRune(a.partial)
+				a.partial = a.partial[:0]
+				a.insert(r)
+			}
+		case b == keyEscape:
+			a.escape = []byte{}
+		case a.pasting && (b == keyReturn || b == keyLineFeed):
+			a.insert('\n')
+		case a.pasting:
+			a.insert(rune(b))
+		case b == keyLineFeed && afterReturn:
+		case b == keyReturn || b == keyLineFeed:
+			a.afterReturn = b == keyReturn
+			return i + 1, true
+		default:
+			a.key(b)
+		}
+	}
+	return len(data), false
+}
+
+// key applies the key b, which is a control key or printable ASCII
+func (a *lineAssembler) key(b byte) {
+	switch b {
+	case keyBackspace, keyDelete:
+		if a.cursor > 0 {
+			a.remove(a.cursor-1, a.cursor)
+		}
+	case keyCtrlD:
+		if a.cursor < len(a.line) {
+			a.remove(a.cursor, a.cursor+1)
+		}
+	case keyCtrlC:
+		a.reset()
+	case keyCtrlA:
+		a.cursor = 0
+	case keyCtrlE:
+		a.cursor = len(a.line)
+	case keyCtrlB:
+		a.move(-1)
+	case keyCtrlF:
+		a.move(1)
+	case keyCtrlU:
+		a.remove(0, a.cursor)
+	case keyCtrlK:
+		a.remove(a.cursor, len(a.line))
+	case keyCtrlW:
+		a.remove(a.wordStart(), a.cursor)
+	case keyTab, keyCtrlR:
+		// completion and history search change the line invisibly
+		a.incomplete = true
+	default:
+		if b >= ' ' {
+			a.insert(rune(b))
+		}
+	}
+}
+
+// continueEscape applies the escape sequence once it is complete. CSI sequences end with a byte in
+// 0x40-0x7e, SS3 sequences after one byte and other sequences, e.g. Alt with a key, after their first byte.
+func (a *lineAssembler) continueEscape() {
+	seq := string(a.escape)
+	switch {
+	case seq == "[" || seq == "O":
+		return
+	case seq[0] == '[':
+		if last := seq[len(seq)-1]; last < 0x40 || last > 0x7e {
+			return
+		}
+	}
+	a.escape = nil
+	if a.pasting {
+		if seq == pasteEnd {
+			a.pasting = false
+		}
+		return
+	}
+	switch seq {
+	case pasteStart:
+		a.pasting = true
+	case "[D", "OD":
+		a.move(-1)
+	case "[C", "OC":
+		a.move(1)
+	case "[1;5D", "[1;3D", "b":
+		a.moveWord(-1)
+	case "[1;5C", "[1;3C", "f":
+		a.moveWord(1)
+	case "[H", "OH", "[1~", "[7~":
+		a.cursor = 0
+	case "[F", "OF", "[4~", "[8~":
+		a.cursor = len(a.line)
+	case "[3~":
+		if a.cursor < len(a.line) {
+			a.remove(a.cursor, a.cursor+1)
+		}
+	case "[A", "OA", "[B", "OB":
+		// the shell replaces the line with an entry of its history
+		a.line, a.cursor, a.incomplete = a.line[:0], 0, true
+	}
+}
+
+func (a *lineAssembler) insert(r rune) {
+	a.line = append(a.line, 0)
+	copy(a.line[a.cursor+1:], a.line[a.cursor:])
+	a.line[a.cursor] = r
+	a.cursor++
+}
+
+func (a *lineAssembler) remove(from, to int) {
+	a.line = append(a.line[:from], a.line[to:]...)
+	a.cursor = from
+}
+
+func (a *lineAssembler) move(by int) {
+	a.cursor = min(max(a.cursor+by, 0), len(a.line))
+}
+
+// moveWord moves the cursor to the start of the previous word or to the end of the next one
+func (a *lineAssembler) moveWord(direction int) {
+	if direction < 0 {
+		a.cursor = a.wordStart()
+		return
+	}
+	for a.cursor < len(a.line) && unicode.IsSpace(a.line[a.cursor]) {
+		a.cursor++
+	}
+	for a.cursor < len(a.line) && !unicode.IsSpace(a.line[a.cursor]) {
+		a.cursor++
+	}
+}
+
+// wordStart returns where the word before the cursor starts
+func (a *lineAssembler) wordStart() int {
+	start := a.cursor
+	for start > 0 && unicode.IsSpace(a.line[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(a.line[start-1]) {
+		start--
+	}
+	return start
+}
+
+// current returns the line typed so far
+func (a *lineAssembler) current() commandLine {
+	return commandLine{text: string(a.line), incomplete: a.incomplete}
+}
+
+func (a *lineAssembler) reset() {
+	a.line, a.cursor, a.incomplete = a.line[:0], 0, false
+}
+
+// submit returns the current line and starts a new one. Blank lines are not returned.
+func (a *lineAssembler) submit() (commandLine, bool) {
+	line := a.current()
+	a.reset()
+	return line, strings.TrimSpace(line.text) != "" || line.incomplete
+}
+
+// commandLog writes the command lines submitted in a session to the audit log. A command is logged once
+// the user types again or the session ends, with the size of the output it produced until then. Co-driving
+// viewers type into the same line as the owner, so commands are logged as the owner's.
+// Before a line is passed to the shell it is checked against the command policies, see input. Its methods
+// are safe for concurrent use and all methods of a nil commandLog do nothing.
+type commandLog struct {
+	mu        sync.Mutex
+	assembler lineAssembler
+	// event describes the session, pending is the last command waiting for its output and written its size
+	event   audit.Event
+	pending *audit.Event
+	written int64
+	log     func(audit.Event)
+	// check returns the policy rule a command line matches, nil when none does. A nil check lets every line run.
+	check func(line commandLine) *commandVerdict
+	// held is the line waiting for the user to confirm it
+	held *commandVerdict
+	// notify shows a message to the user, notices are the messages waiting for mu to be released
+	notify  func(text string)
+	notices []string
+}
+
+// newCommandLog returns a commandLog passing the commands of the session described by event to log
+func newCommandLog(event audit.Event, log func(audit.Event)) *commandLog {
+	return &commandLog{event: event, log: log}
+}
+
+// input assembles the command lines of data typed into the session and returns the part of data that may
+// reach the shell. The key submitting a line matching a rule of the command policies is only passed on when
+// the rule warns. A denied line stays at the prompt, a line needing confirmation is held until the next
+// input, which runs it when it starts with Enter. The input following such a key is dropped.
+func (c *commandLog) input(data string) string {
+	if c == nil || data == "" {
+		return data
+	}
+	c.mu.Lock()
+	forward := c.filter(data)
+	notices := c.notices
+	c.notices = nil
+	c.mu.Unlock()
+	if c.notify != nil {
+		for _, text := range notices {
+			c.notify(text)
+		}
+	}
+	return forward
+}
+
+// filter implements input, c.mu must be held
+func (c *commandLog) filter(data string) string {
+	c.flush()
+	var forward strings.Builder
+	if held := c.held; held != nil {
+		c.held = nil
+		if data[0] != keyReturn && data[0] != keyLineFeed {
+			c.decide(held, decisionCancelled)
+		} else {
+			c.decide(held, decisionConfirmed)
+			c.submit()
+			forward.WriteByte(data[0])
+			data = data[1:]
+		}
+	}
+	for data != "" {
+		n, submitting := c.assembler.next(data)
+		chunk := data[:n]
+		data = data[n:]
+		if submitting {
+			if verdict := c.verdict(); verdict != nil {
+				switch verdict.rule.Action {
+				case v1beta1.CommandDeny:
+					c.decide(verdict, decisionBlocked)
+					return forward.String() + chunk[:n-1]
+				case v1beta1.CommandConfirm:
+					c.held = verdict
+					c.notices = append(c.notices, verdict.notice(decisionHeld))
+					return forward.String() + chunk[:n-1]
+				default:
+					c.decide(verdict, decisionWarned)
+				}
+			}
+			c.submit()
+		}
+		forward.WriteString(chunk)
+	}
+	return forward.String()
+}
+
+// verdict checks the line about to be submitted against the command policies, c.mu must be held
+func (c *commandLog) verdict() *commandVerdict {
+	line := c.assembler.current()
+	if c.check == nil || (strings.TrimSpace(line.text) == "" && !line.incomplete) {
+		return nil
+	}
+	return c.check(line)
+}
+
+// submit ends the current line, which becomes the pending command unless it is blank. c.mu must be held.
+func (c *commandLog) submit() {
+	line, ok := c.assembler.submit()
+	if !ok {
+		return
+	}
+	c.flush()
+	event := c.event
+	event.Time = time.Now()
+	event.Command = line.text
+	if line.incomplete {
+		event.Details = map[string]string{"incomplete": "true"}
+	}
+	c.pending = &event
+}
+
+// decide logs the decision taken on the line of verdict and tells the user about it, c.mu must be held
+func (c *commandLog) decide(verdict *commandVerdict, decision string) {
+	c.log(verdict.event(c.event, decision))
+	if text := verdict.notice(decision); text != "" {
+		c.notices = append(c.notices, text)
+	}
+}
+
+// output counts n bytes of output towards the pending command
+func (c *commandLog) output(n int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending != nil {
+		c.written += int64(n)
+	}
+}
+
+// close logs the pending command, a line still waiting for confirmation is cancelled
+func (c *commandLog) close() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.held != nil {
+		c.decide(c.held, decisionCancelled)
+		c.held = nil
+	}
+	c.flush()
+}
+
+// flush logs the pending command with its output size, c.mu must be held
+func (c *commandLog) flush() {
+	if c.pending == nil {
+		return
+	}
+	event := *c.pending
+	details := map[string]string{"outputBytes": strconv.FormatInt(c.written, 10)}
+	for k, v := range event.Details {
+		details[k] = v
+	}
+	event.Details = details
+	c.log(event)
+	c.pending, c.written = nil, 0
+}
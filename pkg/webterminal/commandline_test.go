@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"openfuyao.com/web-terminal-service/pkg/audit"
+)
+
+func TestLineAssembler(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string
+		want  []commandLine
+	}{
+		{name: "plain", input: []string{"ls -l\r"}, want: []commandLine{{text: "ls -l"}}},
+		{name: "typed key by key", input: []string{"l", "s", "\r"}, want: []commandLine{{text: "ls"}}},
+		{name: "backspace", input: []string{"lss\x7f -a\x08l\r"}, want: []commandLine{{text: "ls -l"}}},
+		{name: "blank lines", input: []string{"\r  \r"}},
+		{name: "crlf", input: []string{"pwd\r\nid\r", "\nuname\n"},
+			want: []commandLine{{text: "pwd"}, {text: "id"}, {text: "uname"}}},
+		{name: "ctrl-c discards the line", input: []string{"rm -rf /\x03echo ok\r"},
+			want: []commandLine{{text: "echo ok"}}},
+		{name: "arrow keys", input: []string{"at\x1b[D\x1b[Dc\x1b[C\x1b[Cs\r"}, want: []commandLine{{text: "cats"}}},
+		{name: "escape split across reads", input: []string{"ct\x1b", "[", "Da\r"},
+			want: []commandLine{{text: "cat"}}},
+		{name: "home end and delete", input: []string{"sl\x1b[H\x1b[3~\x1b[Fs\x1bOH\x1b[3~l\r"},
+			want: []commandLine{{text: "ls"}}},
+		{name: "ctrl movement", input: []string{"cho hi\x01e\x05\x02\x02\x06!\r"},
+			want: []commandLine{{text: "echo h!i"}}},
+		{name: "kill keys", input: []string{"junk\x15echo a b\x17c\x01\x06\x0b\r"}, want: []commandLine{{text: "e"}}},
+		{name: "word movement", input: []string{"echo b\x1b[1;5Da \x1b[1;5Cc\r"},
+			want: []commandLine{{text: "echo a bc"}}},
+		{name: "history marks incomplete", input: []string{"ls\x1b[A\r"},
+			want: []commandLine{{incomplete: true}}},
+		{name: "completion marks incomplete", input: []string{"cat /etc/pas\t\r"},
+			want: []commandLine{{text: "cat /etc/pas", incomplete: true}}},
+		{name: "bracketed paste", input: []string{"\x1b[200~echo a\r\necho\x7f b\x1b[201~\r"},
+			want: []commandLine{{text: "echo a\n\necho\x7f b"}}},
+		{name: "utf-8 split across reads", input: []string{"echo h\xc3", "\xa9llo\r"},
+			want: []commandLine{{text: "echo héllo"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a lineAssembler
+			var got []commandLine
+			for _, data := range tt.input {
+				got = append(got, a.feed(data)...)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCommandLog(t *testing.T) {
+	var events []audit.Event
+	commands := newCommandLog(audit.Event{Type: audit.TypeCommand, SessionID: "s-1", User: "alice"},
+		func(event audit.Event) { events = append(events, event) })
+
+	commands.output(10)
+	commands.input("ls\r")
+	commands.output(5)
+	commands.output(7)
+	assert.Empty(t, events)
+	commands.input("h")
+	require.Len(t, events, 1)
+	assert.Equal(t, "ls", events[0].Command)
+	assert.Equal(t, "s-1", events[0].SessionID)
+	assert.Equal(t, map[string]string{"outputBytes": "12"}, events[0].Details)
+	assert.False(t, events[0].Time.IsZero())
+
+	commands.input("istory\x1b[A\rpwd\r")
+	require.Len(t, events, 2)
+	assert.Equal(t, map[string]string{"outputBytes": "0", "incomplete": "true"}, events[1].Details)
+	commands.output(4)
+	commands.close()
+	require.Len(t, events, 3)
+	assert.Equal(t, "pwd", events[2].Command)
+	assert.Equal(t, map[string]string{"outputBytes": "4"}, events[2].Details)
+	commands.close()
+	assert.Len(t, events, 3)
+
+	var none *commandLog
+	none.input("ls\r")
+	none.output(1)
+	none.close()
+}
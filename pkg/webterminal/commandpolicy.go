@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+//+kubebuilder:rbac:groups=terminal.openfuyao.com,resources=webterminalcommandpolicies,verbs=get;list;watch
+
+// policyListTimeout bounds reading the policies, a line is held for confirmation when they cannot be read in time
+const policyListTimeout = 2 * time.Second
+
+// decisions taken on command lines matching a policy rule
+const (
+	decisionBlocked   = "blocked"
+	decisionWarned    = "warned"
+	decisionHeld      = "held"
+	decisionConfirmed = "confirmed"
+	decisionCancelled = "cancelled"
+)
+
+// actionSeverity orders the actions of rules, the most severe of the rules a line matches applies
+var actionSeverity = map[v1beta1.CommandAction]int{
+	v1beta1.CommandWarn:    1,
+	v1beta1.CommandConfirm: 2,
+	v1beta1.CommandDeny:    3,
+}
+
+// commandVerdict is the rule of a policy a command line matched
+type commandVerdict struct {
+	policy string
+	rule   v1beta1.CommandRule
+	line   string
+}
+
+// notice returns the message telling the user about decision, empty when the user needs none
+func (v *commandVerdict) notice(decision string) string {
+	var text string
+	switch decision {
+	case decisionBlocked:
+		text = fmt.Sprintf("Command blocked by rule %q", v.rule.Name)
+	case decisionHeld:
+		text = fmt.Sprintf("Command needs confirmation by rule %q", v.rule.Name)
+	case decisionWarned:
+		text = fmt.Sprintf("Warning from rule %q", v.rule.Name)
+	default:
+		return ""
+	}
+	if v.rule.Message != "" {
+		text += ": " + v.rule.Message
+	}
+	if decision == decisionHeld {
+		text += ". Press Enter again to run it, any other key cancels it."
+	}
+	return text
+}
+
+// event returns the audit event of decision, taken on the line of the session of base
+func (v *commandVerdict) event(base audit.Event, decision string) audit.Event {
+	allowed := decision == decisionWarned || decision == decisionConfirmed
+	event := base
+	event.Type = audit.TypeCommandPolicy
+	event.Time = time.Now()
+	event.Command = v.line
+	event.Allowed = &allowed
+	event.Reason = v.rule.Message
+	event.Details = map[string]string{"policy": v.policy, "rule": v.rule.Name, "action": string(v.rule.Action),
+		"decision": decision}
+	return event
+}
+
+// rules of the verdicts taken without a rule of the command policies
+const (
+	ruleUnavailable = "policies-unavailable"
+	ruleUnverified  = "unverified-line"
+)
+
+// commandPolicies checks command lines against the WebTerminalCommandPolicies of the cluster. While the
+// policies cannot be read the ones read last apply, lines need confirmation when none were read yet.
+type commandPolicies struct {
+	reader  client.Reader
+	timeout time.Duration
+	// patterns caches the compiled patterns of the rules, nil for invalid ones. last holds the policies read
+	// last, it is only valid once listed is set.
+	mu       sync.Mutex
+	patterns map[string]*regexp.Regexp
+	last     []v1beta1.WebTerminalCommandPolicy
+	listed   bool
+}
+
+func newCommandPolicies(reader client.Reader) *commandPolicies {
+	return &commandPolicies{reader: reader, timeout: policyListTimeout, patterns: map[string]*regexp.Regexp{}}
+}
+
+// check returns the most severe rule line matches for a user in groups in a session in namespace, nil when
+// it matches none
+func (p *commandPolicies) check(ctx context.Context, groups []string, namespace, line string) *commandVerdict {
+	return p.checkLine(ctx, groups, namespace, commandLine{text: line})
+}
+
+// checkLine implements check for a line submitted in a terminal. Of equally severe rules the first one of the
+// policies ordered by name applies. A line the shell changed in ways the keys do not tell cannot be checked,
+// it needs confirmation when a deny or confirm rule applies to the session.
+func (p *commandPolicies) checkLine(ctx context.Context, groups []string, namespace string,
+	line commandLine) *commandVerdict {
+	policies, ok := p.list(ctx)
+	if !ok {
+		return &commandVerdict{line: line.text, rule: v1beta1.CommandRule{Name: ruleUnavailable,
+			Action: v1beta1.CommandConfirm, Message: "the command policies cannot be read"}}
+	}
+	typed := strings.TrimSpace(line.text) != ""
+	var verdict *commandVerdict
+	guarded := false
+	for _, policy := range policies {
+		for _, rule := range policy.Spec.Rules {
+			if !rule.Applies(groups, namespace) {
+				continue
+			}
+			guarded = guarded || actionSeverity[rule.Action] >= actionSeverity[v1beta1.CommandConfirm]
+			if !typed || !p.matches(rule, line.text) {
+				continue
+			}
+			if verdict == nil || actionSeverity[rule.Action] > actionSeverity[verdict.rule.Action] {
+				verdict = &commandVerdict{policy: policy.Name, rule: rule, line: line.text}
+			}
+		}
+	}
+	if line.incomplete && guarded &&
+		(verdict == nil || actionSeverity[verdict.rule.Action] < actionSeverity[v1beta1.CommandConfirm]) {
+		return &commandVerdict{line: line.text, rule: v1beta1.CommandRule{Name: ruleUnverified,
+			Action: v1beta1.CommandConfirm, Message: "the line was changed by the shell history or completion"}}
+	}
+	return verdict
+}
+
+// list returns the policies ordered by name, or the ones read last when they cannot be read. It reports
+// false when no policies were read yet. Without the WebTerminalCommandPolicy kind there are none.
+func (p *commandPolicies) list(ctx context.Context) ([]v1beta1.WebTerminalCommandPolicy, bool) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	policies := &v1beta1.WebTerminalCommandPolicyList{}
+	err := p.reader.List(ctx, policies)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err == nil:
+		sort.Slice(policies.Items, func(i, j int) bool { return policies.Items[i].Name < policies.Items[j].Name })
+		p.last, p.listed = policies.Items, true
+	case meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err):
+		p.last, p.listed = nil, true
+	default:
+		zlog.LogErrorf("Failed to list command policies, applying the ones read last: %v", err)
+	}
+	return p.last, p.listed
+}
+
+// matches reports whether the pattern of rule matches line, invalid patterns match nothing
+func (p *commandPolicies) matches(rule v1beta1.CommandRule, line string) bool {
+	p.mu.Lock()
+	re, ok := p.patterns[rule.Pattern]
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(rule.Pattern); err != nil {
+			zlog.LogWarnf("Ignoring command policy rule %q with invalid pattern: %v", rule.Name, err)
+		}
+		p.patterns[rule.Pattern] = re
+	}
+	p.mu.Unlock()
+	return re != nil && re.MatchString(line)
+}
@@ -0,0 +1,286 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+)
+
+func newTestCommandPolicy(name string, rules ...v1beta1.CommandRule) *v1beta1.WebTerminalCommandPolicy {
+	return &v1beta1.WebTerminalCommandPolicy{ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1beta1.WebTerminalCommandPolicySpec{Rules: rules}}
+}
+
+func TestCommandPoliciesCheck(t *testing.T) {
+	mgrClient := CreateFakeClient()
+	for _, policy := range []*v1beta1.WebTerminalCommandPolicy{
+		newTestCommandPolicy("destructive",
+			v1beta1.CommandRule{Name: "wipe-root", Action: v1beta1.CommandDeny, Pattern: `rm\s+-rf\s+/(\s|$)`},
+			v1beta1.CommandRule{Name: "delete-ns", Action: v1beta1.CommandConfirm,
+				Pattern: `kubectl\s+delete\s+(ns|namespace)\b`},
+			v1beta1.CommandRule{Name: "broken", Action: v1beta1.CommandDeny, Pattern: `kubectl (`}),
+		newTestCommandPolicy("team",
+			v1beta1.CommandRule{Name: "kubectl", Action: v1beta1.CommandWarn, Pattern: `^kubectl\b`},
+			v1beta1.CommandRule{Name: "helm", Action: v1beta1.CommandConfirm, Pattern: `helm\s+uninstall`,
+				Groups: []string{"developers"}},
+			v1beta1.CommandRule{Name: "production", Action: v1beta1.CommandDeny, Pattern: `kubectl\s+edit`,
+				Namespaces: []string{"production"}}),
+	} {
+		require.NoError(t, mgrClient.Create(context.Background(), policy))
+	}
+	policies := newCommandPolicies(mgrClient)
+
+	tests := []struct {
+		name      string
+		groups    []string
+		namespace string
+		line      string
+		wantRule  string
+	}{
+		{name: "no rule matches", line: "ls -l /"},
+		{name: "deny", line: "sudo rm -rf / --no-preserve-root", wantRule: "wipe-root"},
+		{name: "most severe rule", line: "kubectl delete ns payments", wantRule: "delete-ns"},
+		{name: "warn", line: "kubectl get pods", wantRule: "kubectl"},
+		{name: "group rule for other groups", groups: []string{"sre"}, line: "helm uninstall app"},
+		{name: "group rule", groups: []string{"developers"}, line: "helm uninstall app", wantRule: "helm"},
+		{name: "namespace rule elsewhere", namespace: "staging", line: "kubectl edit deploy app",
+			wantRule: "kubectl"},
+		{name: "namespace rule", namespace: "production", line: "kubectl edit deploy app",
+			wantRule: "production"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := policies.check(context.Background(), tt.groups, tt.namespace, tt.line)
+			if tt.wantRule == "" {
+				assert.Nil(t, verdict)
+				return
+			}
+			require.NotNil(t, verdict)
+			assert.Equal(t, tt.wantRule, verdict.rule.Name)
+			assert.Equal(t, tt.line, verdict.line)
+		})
+	}
+
+	unregistered := newCommandPolicies(fake.NewClientBuilder().Build())
+	assert.Nil(t, unregistered.check(context.Background(), nil, "", "rm -rf /"))
+}
+
+func TestCommandPoliciesCheckIncompleteLine(t *testing.T) {
+	mgrClient := CreateFakeClient()
+	require.NoError(t, mgrClient.Create(context.Background(), newTestCommandPolicy("ops",
+		v1beta1.CommandRule{Name: "wipe-root", Action: v1beta1.CommandDeny, Pattern: `rm\s+-rf\s+/`,
+			Namespaces: []string{"production"}},
+		v1beta1.CommandRule{Name: "kubectl", Action: v1beta1.CommandWarn, Pattern: `^kubectl\b`})))
+	policies := newCommandPolicies(mgrClient)
+
+	tests := []struct {
+		name      string
+		namespace string
+		line      commandLine
+		wantRule  string
+	}{
+		{name: "recalled line", namespace: "production", line: commandLine{incomplete: true},
+			wantRule: ruleUnverified},
+		{name: "completed line matching a warning", namespace: "production",
+			line: commandLine{text: "kubectl get", incomplete: true}, wantRule: ruleUnverified},
+		{name: "completed line matching a denial", namespace: "production",
+			line: commandLine{text: "rm -rf /", incomplete: true}, wantRule: "wipe-root"},
+		{name: "recalled line without denials", namespace: "staging", line: commandLine{incomplete: true}},
+		{name: "completed line without denials", namespace: "staging",
+			line: commandLine{text: "kubectl get", incomplete: true}, wantRule: "kubectl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := policies.checkLine(context.Background(), nil, tt.namespace, tt.line)
+			if tt.wantRule == "" {
+				assert.Nil(t, verdict)
+				return
+			}
+			require.NotNil(t, verdict)
+			assert.Equal(t, tt.wantRule, verdict.rule.Name)
+		})
+	}
+}
+
+// flakyReader fails to list while err is set
+type flakyReader struct {
+	client.Reader
+	err error
+}
+
+func (r *flakyReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.Reader.List(ctx, list, opts...)
+}
+
+// hangingReader never answers a list before its context is done
+type hangingReader struct {
+	client.Reader
+}
+
+func (r *hangingReader) List(ctx context.Context, _ client.ObjectList, _ ...client.ListOption) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCommandPoliciesListTimeout(t *testing.T) {
+	policies := newCommandPolicies(&hangingReader{Reader: CreateFakeClient()})
+	policies.timeout = 10 * time.Millisecond
+
+	verdict := policies.check(context.Background(), nil, "", "ls")
+	require.NotNil(t, verdict, "lines need confirmation while the policies cannot be read in time")
+	assert.Equal(t, ruleUnavailable, verdict.rule.Name)
+	assert.Equal(t, v1beta1.CommandConfirm, verdict.rule.Action)
+}
+
+func TestCommandPoliciesUnavailable(t *testing.T) {
+	mgrClient := CreateFakeClient()
+	require.NoError(t, mgrClient.Create(context.Background(), newTestCommandPolicy("ops",
+		v1beta1.CommandRule{Name: "wipe-root", Action: v1beta1.CommandDeny, Pattern: `rm -rf /`})))
+	reader := &flakyReader{Reader: mgrClient, err: errors.New("connection refused")}
+	policies := newCommandPolicies(reader)
+	ctx := context.Background()
+
+	verdict := policies.check(ctx, nil, "", "ls")
+	require.NotNil(t, verdict, "lines need confirmation until the policies were read")
+	assert.Equal(t, ruleUnavailable, verdict.rule.Name)
+	assert.Equal(t, v1beta1.CommandConfirm, verdict.rule.Action)
+
+	reader.err = nil
+	assert.Nil(t, policies.check(ctx, nil, "", "ls"))
+	reader.err = errors.New("connection refused")
+	assert.Nil(t, policies.check(ctx, nil, "", "ls"))
+	verdict = policies.check(ctx, nil, "", "rm -rf /")
+	require.NotNil(t, verdict, "the policies read last apply")
+	assert.Equal(t, "wipe-root", verdict.rule.Name)
+}
+
+func TestCommandLogHistoryRecall(t *testing.T) {
+	mgrClient := CreateFakeClient()
+	require.NoError(t, mgrClient.Create(context.Background(), newTestCommandPolicy("ops",
+		v1beta1.CommandRule{Name: "wipe-root", Action: v1beta1.CommandDeny, Pattern: `rm -rf /`})))
+	policies := newCommandPolicies(mgrClient)
+	var events []audit.Event
+	var notices []string
+	commands := newCommandLog(audit.Event{Type: audit.TypeCommand}, func(event audit.Event) {
+		events = append(events, event)
+	})
+	commands.notify = func(text string) { notices = append(notices, text) }
+	commands.check = func(line commandLine) *commandVerdict {
+		return policies.checkLine(context.Background(), nil, "", line)
+	}
+
+	// the shell recalls "rm -rf /" from its history, which the keys do not tell
+	assert.Equal(t, "\x1b[A", commands.input("\x1b[A\r"))
+	assert.Equal(t, []string{`Command needs confirmation by rule "unverified-line": the line was changed by ` +
+		`the shell history or completion. Press Enter again to run it, any other key cancels it.`}, notices)
+	assert.Equal(t, "\r", commands.input("\r"))
+	commands.close()
+
+	require.Len(t, events, 2)
+	assert.Equal(t, audit.TypeCommandPolicy, events[0].Type)
+	assert.Equal(t, map[string]string{"policy": "", "rule": ruleUnverified, "action": "Confirm",
+		"decision": decisionConfirmed}, events[0].Details)
+	assert.Equal(t, audit.TypeCommand, events[1].Type)
+	assert.Equal(t, "true", events[1].Details["incomplete"])
+}
+
+func TestCommandLogPolicy(t *testing.T) {
+	rules := map[string]v1beta1.CommandRule{
+		"rm -rf /":        {Name: "wipe-root", Action: v1beta1.CommandDeny, Message: "not here"},
+		"kubectl delete":  {Name: "delete", Action: v1beta1.CommandConfirm},
+		"kubectl get pod": {Name: "kubectl", Action: v1beta1.CommandWarn, Message: "production cluster"},
+	}
+	tests := []struct {
+		name          string
+		input         []string
+		wantForwarded string
+		wantCommands  []string
+		wantDecisions []string
+		wantNotices   []string
+	}{
+		{name: "allowed", input: []string{"ls\r"}, wantForwarded: "ls\r", wantCommands: []string{"ls"}},
+		{name: "denied", input: []string{"rm -rf /\rls\r", "\x15ls\r"}, wantForwarded: "rm -rf /\x15ls\r",
+			wantCommands: []string{"ls"}, wantDecisions: []string{decisionBlocked},
+			wantNotices: []string{`Command blocked by rule "wipe-root": not here`}},
+		{name: "confirmed", input: []string{"kubectl delete\r", "\r"}, wantForwarded: "kubectl delete\r",
+			wantCommands: []string{"kubectl delete"}, wantDecisions: []string{decisionConfirmed},
+			wantNotices: []string{`Command needs confirmation by rule "delete". ` +
+				"Press Enter again to run it, any other key cancels it."}},
+		{name: "cancelled", input: []string{"kubectl delete\r", "\x03"}, wantForwarded: "kubectl delete\x03",
+			wantDecisions: []string{decisionCancelled}, wantNotices: []string{`Command needs confirmation by ` +
+				`rule "delete". Press Enter again to run it, any other key cancels it.`}},
+		{name: "cancelled by the end of the session", input: []string{"kubectl delete\r"},
+			wantForwarded: "kubectl delete", wantDecisions: []string{decisionCancelled},
+			wantNotices: []string{`Command needs confirmation by rule "delete". ` +
+				"Press Enter again to run it, any other key cancels it."}},
+		{name: "warned", input: []string{"kubectl get pod\r\n"}, wantForwarded: "kubectl get pod\r\n",
+			wantCommands: []string{"kubectl get pod"}, wantDecisions: []string{decisionWarned},
+			wantNotices: []string{`Warning from rule "kubectl": production cluster`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []audit.Event
+			var notices []string
+			commands := newCommandLog(audit.Event{Type: audit.TypeCommand, SessionID: "s-1"},
+				func(event audit.Event) { events = append(events, event) })
+			commands.notify = func(text string) { notices = append(notices, text) }
+			commands.check = func(line commandLine) *commandVerdict {
+				if rule, ok := rules[line.text]; ok {
+					return &commandVerdict{policy: "test", rule: rule, line: line.text}
+				}
+				return nil
+			}
+			var forwarded string
+			for _, data := range tt.input {
+				forwarded += commands.input(data)
+			}
+			commands.close()
+
+			assert.Equal(t, tt.wantForwarded, forwarded)
+			assert.Equal(t, tt.wantNotices, notices)
+			var gotCommands, gotDecisions []string
+			for _, event := range events {
+				assert.Equal(t, "s-1", event.SessionID)
+				switch event.Type {
+				case audit.TypeCommand:
+					gotCommands = append(gotCommands, event.Command)
+				case audit.TypeCommandPolicy:
+					gotDecisions = append(gotDecisions, event.Details["decision"])
+					assert.Equal(t, "test", event.Details["policy"])
+					assert.Equal(t, rules[event.Command].Name, event.Details["rule"])
+					assert.Equal(t, rules[event.Command].Message, event.Reason)
+					require.NotNil(t, event.Allowed)
+					assert.Equal(t, event.Details["decision"] != decisionBlocked &&
+						event.Details["decision"] != decisionCancelled, *event.Allowed)
+				}
+			}
+			assert.Equal(t, tt.wantCommands, gotCommands)
+			assert.Equal(t, tt.wantDecisions, gotDecisions)
+		})
+	}
+}
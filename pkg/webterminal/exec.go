@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/tracing"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+var (
+	// ErrInvalidExec is returned for exec requests that can not be run, e.g. without a command
+	ErrInvalidExec = errors.New("invalid exec request")
+	// ErrCommandDenied is returned for commands refused by a rule of the command policies
+	ErrCommandDenied = errors.New("command denied")
+)
+
+// ExecRequest is a command to run in a container without a terminal
+type ExecRequest struct {
+	// Command is the program and its arguments, it is not run by a shell
+	Command []string `json:"command"`
+	// Stdin is written to the standard input of the command, empty gives it none
+	Stdin string `json:"stdin,omitempty"`
+	// Timeout is a Go duration such as 10s after which the command is stopped, the configured default when empty
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// ExecResult is the outcome of a command run by an ExecRequest
+type ExecResult struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	// ExitCode is the exit code of the command, -1 when it timed out
+	ExitCode int `json:"exitCode"`
+	// Duration is a Go duration such as 1.2s
+	Duration string `json:"duration"`
+	// StdoutTruncated and StderrTruncated report output dropped beyond the configured size
+	StdoutTruncated bool `json:"stdoutTruncated,omitempty"`
+	StderrTruncated bool `json:"stderrTruncated,omitempty"`
+	TimedOut        bool `json:"timedOut,omitempty"`
+	// Warnings are the messages of the warning rules of the command policies the command matched
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// WithExecCfg applies cfg to the commands run without a terminal
+func WithExecCfg(cfg *ExecCfg) Option {
+	return func(t *terminaler) {
+		t.execCfg = cfg
+	}
+}
+
+// execSettings returns the exec config of the terminal, the defaults when it has none
+func (t *terminaler) execSettings() *ExecCfg {
+	if t.execCfg != nil {
+		return t.execCfg
+	}
+	return &ExecCfg{Timeout: defaultExecTimeout, MaxTimeout: defaultExecMaxTimeout,
+		MaxOutputSize: defaultExecMaxOutputSize, MaxInputSize: defaultExecMaxInputSize}
+}
+
+// execTimeout returns how long the command of req may run
+func (t *terminaler) execTimeout(req ExecRequest) (time.Duration, error) {
+	cfg := t.execSettings()
+	if req.Timeout == "" {
+		return cfg.Timeout, nil
+	}
+	timeout, err := time.ParseDuration(req.Timeout)
+	if err != nil || timeout <= 0 || timeout > cfg.MaxTimeout {
+		return 0, fmt.Errorf("%w: timeout must be a duration greater than 0 and at most %v", ErrInvalidExec,
+			cfg.MaxTimeout)
+	}
+	return timeout, nil
+}
+
+// Exec runs the command of req in the container as the user of ctx and collects its output. A command exiting
+// with a non-zero code or timing out is no error, both are reported in the result. Commands matching a deny
+// or a confirm rule of the command policies are refused with ErrCommandDenied, there is nobody to confirm them.
+func (t *terminaler) Exec(ctx context.Context, namespace, podName, containerName string,
+	req ExecRequest) (result *ExecResult, err error) {
+	if len(req.Command) == 0 || req.Command[0] == "" {
+		return nil, fmt.Errorf("%w: command is required", ErrInvalidExec)
+	}
+	timeout, err := t.execTimeout(req)
+	if err != nil {
+		return nil, err
+	}
+	impersonate, err := impersonationFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := newSessionInfo(ctx, sessionIDFrom(ctx), namespace, podName, containerName)
+	line := strings.Join(req.Command, " ")
+	warnings, err := t.checkExec(ctx, info, line)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := tracing.Start(ctx, "exec", trace.WithAttributes(semconv.K8SNamespaceName(namespace),
+		semconv.K8SPodName(podName), semconv.ContainerName(containerName)))
+	event := auditEvent(ctx, audit.TypeExecCommand, info)
+	event.Command = line
+	defer func() {
+		if err != nil {
+			event.Reason = err.Error()
+		} else {
+			span.SetAttributes(attribute.Int("exec.exit_code", result.ExitCode))
+			event.Details = map[string]string{"exitCode": strconv.Itoa(result.ExitCode),
+				"duration": result.Duration, "timedOut": strconv.FormatBool(result.TimedOut)}
+		}
+		t.auditor.Log(event)
+		tracing.End(span, err)
+	}()
+
+	result, err = t.runExec(ctx, execOptions{namespace: namespace, podName: podName,
+		containerName: containerName, cmd: req.Command, stdin: req.Stdin != "", stdout: true, stderr: true,
+		impersonate: impersonate}, req.Stdin, timeout)
+	if err != nil {
+		// the command line is left out, the audit event of the exec carries it with its secrets redacted
+		zlog.LogWarnf("Failed to exec in %s/%s/%s: %v", namespace, podName, containerName, err)
+		return nil, err
+	}
+	result.Warnings = warnings
+	return result, nil
+}
+
+// checkExec checks line against the command policies, it returns the messages of the warning rule it matches
+func (t *terminaler) checkExec(ctx context.Context, info SessionInfo, line string) ([]string, error) {
+	if t.policies == nil {
+		return nil, nil
+	}
+	groups, _ := ctx.Value("groups").([]string)
+	verdict := t.policies.check(ctx, groups, info.Namespace, line)
+	if verdict == nil {
+		return nil, nil
+	}
+	base := auditEvent(ctx, audit.TypeExecCommand, info)
+	if verdict.rule.Action == v1beta1.CommandWarn {
+		t.auditor.Log(verdict.event(base, decisionWarned))
+		return []string{verdict.notice(decisionWarned)}, nil
+	}
+	t.auditor.Log(verdict.event(base, decisionBlocked))
+	return nil, fmt.Errorf("%w: %s", ErrCommandDenied, verdict.notice(decisionBlocked))
+}
+
+// runExec runs the command of options with stdin as input, stopping it after timeout
+func (t *terminaler) runExec(ctx context.Context, options execOptions, stdin string,
+	timeout time.Duration) (*ExecResult, error) {
+	executor, err := t.executePodExec(options)
+	if err != nil {
+		return nil, err
+	}
+	limit := t.execSettings().MaxOutputSize
+	stdout, stderr := newExecOutput(limit), newExecOutput(limit)
+	streams := remotecommand.StreamOptions{Stdout: stdout, Stderr: stderr}
+	if options.stdin {
+		streams.Stdin = strings.NewReader(stdin)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	err = executor.StreamWithContext(runCtx, streams)
+	result := &ExecResult{Duration: time.Since(start).String()}
+	result.Stdout, result.StdoutTruncated = stdout.result()
+	result.Stderr, result.StderrTruncated = stderr.result()
+
+	var exitErr utilexec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr) && exitErr.Exited():
+		result.ExitCode = exitErr.ExitStatus()
+	case ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		result.ExitCode = -1
+		result.TimedOut = true
+	default:
+		return nil, err
+	}
+	return result, nil
+}
+
+// execOutput keeps the first bytes of an output of a command. It may be read while the command still writes,
+// which a command stopped by its timeout can do.
+type execOutput struct {
+	mu        sync.Mutex
+	buf       limitedBuffer
+	truncated bool
+}
+
+func newExecOutput(limit int) *execOutput {
+	return &execOutput{buf: limitedBuffer{limit: limit}}
+}
+
+func (o *execOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.buf.Len()+len(p) > o.buf.limit {
+		o.truncated = true
+	}
+	return o.buf.Write(p)
+}
+
+// result returns the kept output and whether some was dropped
+func (o *execOutput) result() (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String(), o.truncated
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+)
+
+// execExecutor answers a command with fixed output, echoing its stdin
+type execExecutor struct {
+	stdout string
+	stderr string
+	err    error
+	// hang keeps the command running until it is stopped
+	hang bool
+}
+
+func (e *execExecutor) StreamWithContext(ctx context.Context, options remotecommand.StreamOptions) error {
+	if options.Stdin != nil {
+		if _, err := io.Copy(options.Stdout, options.Stdin); err != nil {
+			return err
+		}
+	}
+	_, _ = options.Stdout.Write([]byte(e.stdout))
+	_, _ = options.Stderr.Write([]byte(e.stderr))
+	if e.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return e.err
+}
+
+func (e *execExecutor) Stream(options remotecommand.StreamOptions) error {
+	return e.StreamWithContext(context.Background(), options)
+}
+
+// newExecTerminal returns a terminaler whose execs are answered by executor, the exec URLs are sent to urls
+func newExecTerminal(t *testing.T, executor remotecommand.Executor, urls chan<- *url.URL) *terminaler {
+	serviceConfig := &rest.Config{Host: "https://kubernetes.default.svc"}
+	patch := gomonkey.ApplyFunc(remotecommand.NewSPDYExecutor,
+		func(config *rest.Config, method string, url *url.URL) (remotecommand.Executor, error) {
+			if urls != nil {
+				urls <- url
+			}
+			return executor, nil
+		})
+	t.Cleanup(patch.Reset)
+	return &terminaler{client: kubernetes.NewForConfigOrDie(serviceConfig), config: serviceConfig,
+		execCfg: &ExecCfg{Timeout: time.Minute, MaxTimeout: time.Minute, MaxOutputSize: 16, MaxInputSize: 64}}
+}
+
+func TestTerminalerExec(t *testing.T) {
+	exitErr := utilexec.CodeExitError{Err: errors.New("command terminated with exit code 2"), Code: 2}
+	tests := []struct {
+		name     string
+		executor *execExecutor
+		req      ExecRequest
+		want     *ExecResult
+		wantErr  error
+	}{
+		{name: "output", executor: &execExecutor{stdout: "nameserver 1.1\n"},
+			req:  ExecRequest{Command: []string{"cat", "/etc/resolv.conf"}},
+			want: &ExecResult{Stdout: "nameserver 1.1\n"}},
+		{name: "exit code", executor: &execExecutor{stderr: "no such file", err: exitErr},
+			req:  ExecRequest{Command: []string{"cat", "/nope"}},
+			want: &ExecResult{Stderr: "no such file", ExitCode: 2}},
+		{name: "stdin", executor: &execExecutor{},
+			req:  ExecRequest{Command: []string{"cat"}, Stdin: "hello"},
+			want: &ExecResult{Stdout: "hello"}},
+		{name: "truncated", executor: &execExecutor{stdout: "0123456789abcdefXYZ"},
+			req:  ExecRequest{Command: []string{"seq", "100"}},
+			want: &ExecResult{Stdout: "0123456789abcdef", StdoutTruncated: true}},
+		{name: "timeout", executor: &execExecutor{stdout: "tick", hang: true},
+			req:  ExecRequest{Command: []string{"sleep", "inf"}, Timeout: "10ms"},
+			want: &ExecResult{Stdout: "tick", ExitCode: -1, TimedOut: true}},
+		{name: "stream error", executor: &execExecutor{err: errors.New("upgrade failed")},
+			req: ExecRequest{Command: []string{"ls"}}, wantErr: errors.New("upgrade failed")},
+		{name: "no command", req: ExecRequest{}, wantErr: ErrInvalidExec},
+		{name: "timeout above max", req: ExecRequest{Command: []string{"ls"}, Timeout: "1h"},
+			wantErr: ErrInvalidExec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := newExecTerminal(t, tt.executor, nil)
+			ctx := context.WithValue(context.Background(), "user", "alice")
+			got, err := term.Exec(ctx, "default", "nginx", "nginx", tt.req)
+			if tt.wantErr != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, tt.wantErr) || err.Error() == tt.wantErr.Error(), err)
+				return
+			}
+			require.NoError(t, err)
+			_, err = time.ParseDuration(got.Duration)
+			assert.NoError(t, err)
+			got.Duration = ""
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTerminalerExecRequest(t *testing.T) {
+	urls := make(chan *url.URL, 1)
+	term := newExecTerminal(t, &execExecutor{}, urls)
+	ctx := context.WithValue(context.Background(), "user", "alice")
+
+	_, err := term.Exec(ctx, "default", "nginx", "sidecar", ExecRequest{Command: []string{"ls", "-l"}})
+	require.NoError(t, err)
+	query := (<-urls).Query()
+	assert.Equal(t, []string{"ls", "-l"}, query["command"])
+	assert.Equal(t, "sidecar", query.Get("container"))
+	assert.Equal(t, "true", query.Get("stdout"))
+	assert.Empty(t, query.Get("stdin"))
+	assert.Empty(t, query.Get("tty"))
+
+	_, err = term.Exec(context.Background(), "default", "nginx", "sidecar", ExecRequest{Command: []string{"ls"}})
+	assert.Error(t, err, "execs without a user to impersonate are refused")
+}
+
+func TestTerminalerExecPolicies(t *testing.T) {
+	mgrClient := CreateFakeClient()
+	require.NoError(t, mgrClient.Create(context.Background(), newTestCommandPolicy("ops",
+		v1beta1.CommandRule{Name: "wipe", Action: v1beta1.CommandDeny, Pattern: `^rm -rf`},
+		v1beta1.CommandRule{Name: "drain", Action: v1beta1.CommandConfirm, Pattern: `^kubectl drain`},
+		v1beta1.CommandRule{Name: "kubectl", Action: v1beta1.CommandWarn, Pattern: `^kubectl`,
+			Message: "prefer the console"})))
+	sink := &memorySink{}
+	auditor := audit.NewLogger(sink, 16, 0, 0)
+	term := newExecTerminal(t, &execExecutor{stdout: "ok"}, nil)
+	term.policies = newCommandPolicies(mgrClient)
+	term.auditor = auditor
+	ctx := context.WithValue(context.Background(), "user", "alice")
+	ctx = context.WithValue(ctx, "sessionID", "e1")
+
+	_, err := term.Exec(ctx, "default", "nginx", "nginx", ExecRequest{Command: []string{"rm", "-rf", "/data"}})
+	assert.ErrorIs(t, err, ErrCommandDenied)
+	_, err = term.Exec(ctx, "default", "nginx", "nginx", ExecRequest{Command: []string{"kubectl", "drain", "n1"}})
+	assert.ErrorIs(t, err, ErrCommandDenied, "nobody can confirm the command")
+	got, err := term.Exec(ctx, "default", "nginx", "nginx", ExecRequest{Command: []string{"kubectl", "version"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`Warning from rule "kubectl": prefer the console`}, got.Warnings)
+	require.NoError(t, auditor.Close(context.Background()))
+
+	require.Len(t, sink.events, 4)
+	assert.Equal(t, audit.TypeCommandPolicy, sink.events[0].Type)
+	assert.Equal(t, "rm -rf /data", sink.events[0].Command)
+	assert.Equal(t, decisionBlocked, sink.events[0].Details["decision"])
+	assert.Equal(t, decisionBlocked, sink.events[1].Details["decision"])
+	assert.Equal(t, decisionWarned, sink.events[2].Details["decision"])
+	exec := sink.events[3]
+	assert.Equal(t, audit.TypeExecCommand, exec.Type)
+	assert.Equal(t, "e1", exec.SessionID)
+	assert.Equal(t, "kubectl version", exec.Command)
+	assert.Equal(t, audit.Target{Namespace: "default", Pod: "nginx", Container: "nginx", Kind: "pod"}, exec.Target)
+	assert.Equal(t, "0", exec.Details["exitCode"])
+	assert.Equal(t, "false", exec.Details["timedOut"])
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultExecTimeout       = 30 * time.Second
+	defaultExecMaxTimeout    = 5 * time.Minute
+	defaultExecMaxOutputSize = 1 << 20
+	defaultExecMaxInputSize  = 1 << 20
+
+	envExecTimeout       = "EXEC_TIMEOUT"
+	envExecMaxTimeout    = "EXEC_MAX_TIMEOUT"
+	envExecMaxOutputSize = "EXEC_MAX_OUTPUT_SIZE"
+	envExecMaxInputSize  = "EXEC_MAX_INPUT_SIZE"
+)
+
+// ExecCfg holds the settings of the commands run in containers without a terminal
+type ExecCfg struct {
+	// Timeout bounds the commands that ask for no timeout, MaxTimeout the timeout they may ask for
+	Timeout    time.Duration
+	MaxTimeout time.Duration
+	// MaxOutputSize bounds the stdout and the stderr returned of a command each, in bytes
+	MaxOutputSize int
+	// MaxInputSize bounds the request running a command, including its stdin, in bytes
+	MaxInputSize int
+}
+
+// NewExecCfg returns the exec config read from the environment
+func NewExecCfg() *ExecCfg {
+	return &ExecCfg{
+		Timeout:       durationFromEnv(envExecTimeout, defaultExecTimeout),
+		MaxTimeout:    durationFromEnv(envExecMaxTimeout, defaultExecMaxTimeout),
+		MaxOutputSize: intFromEnv(envExecMaxOutputSize, defaultExecMaxOutputSize),
+		MaxInputSize:  intFromEnv(envExecMaxInputSize, defaultExecMaxInputSize),
+	}
+}
+
+// Validate validate exec config
+func (c *ExecCfg) Validate() []error {
+	var errs []error
+	if c.Timeout <= 0 || c.MaxTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("exec timeout and max timeout must be positive"))
+	} else if c.Timeout > c.MaxTimeout {
+		errs = append(errs, fmt.Errorf("exec timeout must not exceed the max timeout"))
+	}
+	if c.MaxOutputSize <= 0 || c.MaxInputSize <= 0 {
+		errs = append(errs, fmt.Errorf("exec max output size and max input size must be positive"))
+	}
+	return errs
+}
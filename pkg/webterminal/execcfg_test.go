@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewExecCfg(t *testing.T) {
+	assert.Equal(t, &ExecCfg{Timeout: 30 * time.Second, MaxTimeout: 5 * time.Minute, MaxOutputSize: 1 << 20,
+		MaxInputSize: 1 << 20}, NewExecCfg())
+
+	t.Setenv(envExecTimeout, "10s")
+	t.Setenv(envExecMaxTimeout, "1m")
+	t.Setenv(envExecMaxOutputSize, "4096")
+	t.Setenv(envExecMaxInputSize, "invalid")
+	assert.Equal(t, &ExecCfg{Timeout: 10 * time.Second, MaxTimeout: time.Minute, MaxOutputSize: 4096,
+		MaxInputSize: 1 << 20}, NewExecCfg())
+}
+
+func TestExecCfgValidate(t *testing.T) {
+	valid := ExecCfg{Timeout: time.Second, MaxTimeout: time.Minute, MaxOutputSize: 1, MaxInputSize: 1}
+	tests := []struct {
+		name    string
+		modify  func(*ExecCfg)
+		wantErr int
+	}{
+		{name: "valid", modify: func(*ExecCfg) {}},
+		{name: "zero timeout", modify: func(c *ExecCfg) { c.Timeout = 0 }, wantErr: 1},
+		{name: "timeout above max", modify: func(c *ExecCfg) { c.Timeout = time.Hour }, wantErr: 1},
+		{name: "zero sizes", modify: func(c *ExecCfg) { c.MaxOutputSize, c.MaxInputSize = 0, 0 }, wantErr: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			assert.Len(t, cfg.Validate(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+)
+
+const (
+	envHomeStorageClass = "TERMINAL_HOME_STORAGE_CLASS"
+	envHomeSize         = "TERMINAL_HOME_SIZE"
+)
+
+// HomeCfg holds the settings of the persistent home directories of cluster terminals
+type HomeCfg struct {
+	// StorageClass of the home volumes, the default storage class of the cluster when empty
+	StorageClass string
+	// Size of the home volumes, empty disables persistent home directories
+	Size string
+}
+
+// NewHomeCfg returns the home directory config read from the environment
+func NewHomeCfg() *HomeCfg {
+	return &HomeCfg{
+		StorageClass: os.Getenv(envHomeStorageClass),
+		Size:         os.Getenv(envHomeSize),
+	}
+}
+
+// Validate validate home directory config
+func (c *HomeCfg) Validate() []error {
+	if c.Size == "" {
+		return nil
+	}
+	size, err := resource.ParseQuantity(c.Size)
+	if err != nil {
+		return []error{fmt.Errorf("invalid terminal home size %q: %w", c.Size, err)}
+	}
+	if size.Sign() <= 0 {
+		return []error{fmt.Errorf("terminal home size must be positive")}
+	}
+	return nil
+}
+
+// volume returns the home volume requested for cluster terminals, nil when they get none
+func (c *HomeCfg) volume() *v1beta1.HomeVolume {
+	if c == nil || c.Size == "" {
+		return nil
+	}
+	size, err := resource.ParseQuantity(c.Size)
+	if err != nil {
+		return nil
+	}
+	fsGroup := int64(builtinUserID)
+	return &v1beta1.HomeVolume{StorageClassName: c.StorageClass, Size: size, FSGroup: &fsGroup}
+}
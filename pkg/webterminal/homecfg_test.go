@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestNewHomeCfg(t *testing.T) {
+	assert.Equal(t, &HomeCfg{}, NewHomeCfg())
+	assert.Nil(t, NewHomeCfg().volume())
+
+	t.Setenv(envHomeStorageClass, "ssd")
+	t.Setenv(envHomeSize, "5Gi")
+	cfg := NewHomeCfg()
+	assert.Equal(t, &HomeCfg{StorageClass: "ssd", Size: "5Gi"}, cfg)
+	home := cfg.volume()
+	require.NotNil(t, home)
+	assert.Equal(t, "ssd", home.StorageClassName)
+	assert.True(t, resource.MustParse("5Gi").Equal(home.Size))
+	assert.Equal(t, int64(builtinUserID), *home.FSGroup)
+}
+
+func TestHomeCfgValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     HomeCfg
+		wantErr int
+	}{
+		{name: "disabled", cfg: HomeCfg{}},
+		{name: "size", cfg: HomeCfg{Size: "1Gi"}},
+		{name: "invalid size", cfg: HomeCfg{Size: "big"}, wantErr: 1},
+		{name: "zero size", cfg: HomeCfg{Size: "0"}, wantErr: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, tt.cfg.Validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestTemplateWithHome(t *testing.T) {
+	home := (&HomeCfg{Size: "1Gi"}).volume()
+	got := template(context.Background(), "openfuyao-alice", newTestProfile(), home)
+
+	assert.Equal(t, home, got.Spec.Home)
+	spec := got.Spec.PodTemplate.Spec
+	assert.Contains(t, spec.Volumes, v1.Volume{Name: "home", VolumeSource: v1.VolumeSource{
+		PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "openfuyao-alice-home"}}})
+	require.Len(t, spec.Containers, 1)
+	assert.Contains(t, spec.Containers[0].Env, v1.EnvVar{Name: "HOME", Value: "/home/terminal"})
+	assert.Contains(t, spec.Containers[0].VolumeMounts, v1.VolumeMount{Name: "home", MountPath: "/home/terminal"})
+}
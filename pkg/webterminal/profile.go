@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	v1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+//+kubebuilder:rbac:groups=terminal.openfuyao.com,resources=webterminalprofiles,verbs=get;list;watch
+
+// ProfileLabel names the WebTerminalProfile a WebterminalTemplate was built from
+const ProfileLabel = "terminal.openfuyao.com/profile"
+
+const (
+	builtinUserID = 65532
+	// kubeconfigVolume mounts the kubeconfig Secret of the user at kubeconfigDir in every terminal pod
+	kubeconfigVolume = "kubeconfig"
+	kubeconfigDir    = "/mnt/.kube"
+	// homeVolume mounts the persistent home directory of the user at homeDir when it is enabled
+	homeVolume = "home"
+	homeDir    = "/home/terminal"
+)
+
+var (
+	// ErrProfileNotFound is returned for profiles that do not exist
+	ErrProfileNotFound = errors.New("terminal profile not found")
+	// ErrProfileForbidden is returned when none of the groups of the user may use the profile
+	ErrProfileForbidden = errors.New("terminal profile not allowed")
+)
+
+// clusterProfile returns the profile named name for the user of ctx, the built-in profile when name is empty
+func (t *terminaler) clusterProfile(ctx context.Context, name string) (*v1beta1.WebTerminalProfile, error) {
+	if name == "" {
+		return builtinProfile(), nil
+	}
+	profile := &v1beta1.WebTerminalProfile{}
+	if err := t.MgrClient.Get(ctx, types.NamespacedName{Name: name}, profile); err != nil {
+		if apierr.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+		}
+		return nil, err
+	}
+	groups, _ := ctx.Value("groups").([]string)
+	if !profile.AllowsGroups(groups) {
+		return nil, fmt.Errorf("%w: %s", ErrProfileForbidden, name)
+	}
+	return profile, nil
+}
+
+// builtinProfile is the kubectl toolbox of cluster terminals started without a profile. Its image
+// is the one the service is deployed with.
+func builtinProfile() *v1beta1.WebTerminalProfile {
+	podUser := int64(builtinUserID)
+	return &v1beta1.WebTerminalProfile{
+		Spec: v1beta1.WebTerminalProfileSpec{
+			ImagePullPolicy: v1.PullIfNotPresent,
+			SecurityContext: &v1.SecurityContext{
+				RunAsUser:  &podUser,
+				RunAsGroup: new(int64),
+			},
+		},
+	}
+}
+
+// templateProfile returns the profile a template was built from, empty for the built-in profile
+func templateProfile(template *v1beta1.WebterminalTemplate) string {
+	return template.Labels[ProfileLabel]
+}
+
+func describeProfile(name string) string {
+	if name == "" {
+		return "the built-in profile"
+	}
+	return fmt.Sprintf("profile %q", name)
+}
+
+// sendTerminalError tells the client why its terminal cannot start and closes conn
+func sendTerminalError(conn *websocket.Conn, message string) {
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("LogError: "+message)); err != nil {
+		zlog.LogErrorf("Websocket write message error: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		zlog.LogWarn("failed to close websocket: ", err)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package webterminal
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+)
+
+func newTestProfile() *v1beta1.WebTerminalProfile {
+	return &v1beta1.WebTerminalProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "sre"},
+		Spec: v1beta1.WebTerminalProfileSpec{
+			Image: "registry.local/sre-toolbox:1.2",
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")},
+			},
+			Env:            []v1.EnvVar{{Name: "EDITOR", Value: "vim"}},
+			Tolerations:    []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpExists}},
+			NodeSelector:   map[string]string{"pool": "tools"},
+			AllowedGroups:  []string{"sre", "platform"},
+			SessionTimeout: 120,
+		},
+	}
+}
+
+func TestClusterProfile(t *testing.T) {
+	mgrClient := CreateFakeClient()
+	require.NoError(t, mgrClient.Create(context.Background(), newTestProfile()))
+	terminal := &terminaler{MgrClient: mgrClient}
+
+	tests := []struct {
+		name    string
+		profile string
+		groups  []string
+		want    string
+		wantErr error
+	}{
+		{name: "built-in", profile: ""},
+		{name: "allowed group", profile: "sre", groups: []string{"dev", "sre"}, want: "sre"},
+		{name: "other groups", profile: "sre", groups: []string{"dev"}, wantErr: ErrProfileForbidden},
+		{name: "no groups", profile: "sre", wantErr: ErrProfileForbidden},
+		{name: "unknown profile", profile: "gpu", groups: []string{"sre"}, wantErr: ErrProfileNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), "groups", tt.groups)
+			got, err := terminal.clusterProfile(ctx, tt.profile)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Name)
+		})
+	}
+}
+
+func TestTemplateFromProfile(t *testing.T) {
+	profile := newTestProfile()
+	ctx := context.WithValue(context.WithValue(context.Background(), "user", "alice"), "groups",
+		[]string{"sre", "system:authenticated"})
+	got := template(ctx, "openfuyao-alice", profile, nil)
+
+	assert.Equal(t, map[string]string{ProfileLabel: "sre"}, got.Labels)
+	assert.Equal(t, "sre", templateProfile(got))
+	assert.Equal(t, "registry.local/sre-toolbox:1.2", got.Spec.DefaultImage)
+	assert.Equal(t, 120, got.Spec.SessionTimeout)
+	assert.Equal(t, "alice", got.Spec.User)
+	assert.Equal(t, []string{"sre"}, got.Spec.Groups)
+	spec := got.Spec.PodTemplate.Spec
+	assert.Equal(t, profile.Spec.Tolerations, spec.Tolerations)
+	assert.Equal(t, profile.Spec.NodeSelector, spec.NodeSelector)
+	require.Len(t, spec.Containers, 1)
+	container := spec.Containers[0]
+	assert.Equal(t, UserContainerName, container.Name)
+	assert.Empty(t, container.Image)
+	assert.Equal(t, append([]v1.EnvVar{{Name: "KUBECONFIG", Value: "/mnt/.kube/config"}}, profile.Spec.Env...),
+		container.Env)
+	assert.Equal(t, profile.Spec.Resources, container.Resources)
+	assert.Empty(t, spec.InitContainers)
+}
+
+func TestTemplateFromBuiltinProfile(t *testing.T) {
+	patch := gomonkey.ApplyFunc(getImagePath, func(string) (string, error) {
+		return "kubectl:latest\n", nil
+	})
+	defer patch.Reset()
+
+	got := template(context.Background(), "openfuyao-alice", builtinProfile(), nil)
+	assert.Empty(t, got.Labels)
+	assert.Empty(t, templateProfile(got))
+	assert.Equal(t, "kubectl:latest", got.Spec.DefaultImage)
+	assert.Zero(t, got.Spec.SessionTimeout)
+	spec := got.Spec.PodTemplate.Spec
+	assert.Empty(t, spec.InitContainers)
+	require.Len(t, spec.Containers, 1)
+	assert.Equal(t, int64(builtinUserID), *spec.Containers[0].SecurityContext.RunAsUser)
+	assert.Equal(t, []v1.VolumeMount{{Name: "kubeconfig", MountPath: "/mnt/.kube", ReadOnly: true}},
+		spec.Containers[0].VolumeMounts)
+
+	// the kubeconfig is the Secret issued by the controller, no host credentials are mounted
+	require.Len(t, spec.Volumes, 1)
+	assert.Equal(t, "openfuyao-alice-kubeconfig", spec.Volumes[0].Secret.SecretName)
+}
+
+func TestHandleClusterTerminalProfileErrors(t *testing.T) {
+	mgrClient := CreateFakeClient()
+	require.NoError(t, mgrClient.Create(context.Background(), newTestProfile()))
+	require.NoError(t, mgrClient.Create(context.Background(),
+		&v1beta1.WebTerminalProfile{ObjectMeta: metav1.ObjectMeta{Name: "toolbox"}}))
+	running := template(context.Background(), UserPodName("alice"), newTestProfile(), nil)
+	require.NoError(t, mgrClient.Create(context.Background(), running))
+	terminal := &terminaler{MgrClient: mgrClient}
+
+	tests := []struct {
+		name     string
+		username string
+		profile  string
+		want     string
+	}{
+		{name: "forbidden profile", username: "bob", profile: "sre",
+			want: "LogError: terminal profile not allowed: sre"},
+		{name: "different profile running", username: "alice", profile: "toolbox",
+			want: `LogError: cluster terminal already runs profile "sre"`},
+		{name: "running profile no longer allowed", username: "alice", profile: "",
+			want: "LogError: terminal profile not allowed: sre"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, client := newConnPair(t)
+			ctx := context.WithValue(context.Background(), "groups", []string{"dev"})
+			terminal.HandleCusterTerminal(ctx, tt.username, tt.profile, conn)
+			require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+			_, data, err := client.ReadMessage()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(data))
+		})
+	}
+}
+
+func TestHandleClusterTerminalReconnect(t *testing.T) {
+	mgrClient := CreateFakeClient()
+	profile := newTestProfile()
+	profile.Spec.IdleTimeout = &metav1.Duration{Duration: time.Hour}
+	require.NoError(t, mgrClient.Create(context.Background(), profile))
+	running := template(context.Background(), UserPodName("alice"), profile, nil)
+	require.NoError(t, mgrClient.Create(context.Background(), running))
+	terminal := &terminaler{MgrClient: mgrClient}
+
+	var attached []string
+	var limits sessionLimits
+	patch := gomonkey.ApplyPrivateMethod(reflect.TypeOf(terminal), "startSessionWithPing",
+		func(_ *terminaler, _ context.Context, _, podName, _ string, _ *websocket.Conn, l sessionLimits) {
+			attached = append(attached, podName)
+			limits = l
+		})
+	defer patch.Reset()
+
+	conn, _ := newConnPair(t)
+	ctx := context.WithValue(context.Background(), "groups", []string{"sre"})
+	terminal.HandleCusterTerminal(ctx, "alice", "", conn)
+	assert.Equal(t, []string{UserPodName("alice")}, attached)
+	assert.Equal(t, time.Hour, limits.idle, "the limits of the running profile apply")
+}
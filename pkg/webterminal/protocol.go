@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Websocket subprotocols of the terminal. Clients pick one through Sec-WebSocket-Protocol, clients
+// that do not ask for any speak ProtocolV1. Plain text frames carrying the outcome of the access
+// check precede the terminal messages in every version.
+const (
+	// ProtocolV1 exchanges Message objects as JSON text frames. Output that is not valid UTF-8 is
+	// altered by the JSON encoding, file chunks are base64 encoded.
+	ProtocolV1 = "wts.v1"
+	// ProtocolV2 exchanges binary frames made of a channel byte followed by the raw payload
+	ProtocolV2 = "wts.v2"
+)
+
+// Subprotocols lists the supported subprotocols, the most preferred first
+var Subprotocols = []string{ProtocolV2, ProtocolV1}
+
+// Operations of a Message
+const (
+	OpStdin      = "stdin"
+	OpStdout     = "stdout"
+	OpResize     = "resize"
+	OpToast      = "toast"
+	OpDisconnect = "disconnect"
+	OpSession    = "session"
+
+	// OpUploadBegin starts an upload, its data is a TransferRequest
+	OpUploadBegin = "upload-begin"
+	// OpUploadChunk carries the next bytes of the file being uploaded
+	OpUploadChunk = "upload-chunk"
+	// OpUploadEnd completes an upload once every chunk is sent
+	OpUploadEnd = "upload-end"
+	// OpDownload requests a file, its data is a TransferRequest
+	OpDownload = "download"
+	// OpDownloadChunk carries the next bytes of the file being downloaded
+	OpDownloadChunk = "download-chunk"
+	// OpTransferProgress, OpTransferDone and OpTransferError report on a transfer with a TransferStatus
+	OpTransferProgress = "transfer-progress"
+	OpTransferDone     = "transfer-done"
+	OpTransferError    = "transfer-error"
+)
+
+// Channels of ProtocolV2 frames, numbered after the channels of kubectl exec where they overlap
+const (
+	// ChannelStdin carries input of the client
+	ChannelStdin byte = 0
+	// ChannelStdout carries output of the terminal
+	ChannelStdout byte = 1
+	// ChannelResize carries the terminal width and height, each a big endian uint16
+	ChannelResize byte = 4
+	// ChannelToast carries a notification for the user
+	ChannelToast byte = 5
+	// ChannelDisconnect carries the reason the server ends the session
+	ChannelDisconnect byte = 6
+	// ChannelSession carries the SessionHello of a resumable session as JSON
+	ChannelSession byte = 7
+	// Channels of file transfers, see the Op constants of the same name
+	ChannelUploadBegin      byte = 8
+	ChannelUploadChunk      byte = 9
+	ChannelUploadEnd        byte = 10
+	ChannelDownload         byte = 11
+	ChannelDownloadChunk    byte = 12
+	ChannelTransferProgress byte = 13
+	ChannelTransferDone     byte = 14
+	ChannelTransferError    byte = 15
+)
+
+const resizePayloadSize = 4
+
+// ErrProtocol is returned for frames that do not follow the negotiated protocol
+var ErrProtocol = errors.New("terminal protocol violation")
+
+var (
+	v2Channels = map[string]byte{
+		OpStdin:      ChannelStdin,
+		OpStdout:     ChannelStdout,
+		OpResize:     ChannelResize,
+		OpToast:      ChannelToast,
+		OpDisconnect: ChannelDisconnect,
+		OpSession:    ChannelSession,
+
+		OpUploadBegin:      ChannelUploadBegin,
+		OpUploadChunk:      ChannelUploadChunk,
+		OpUploadEnd:        ChannelUploadEnd,
+		OpDownload:         ChannelDownload,
+		OpDownloadChunk:    ChannelDownloadChunk,
+		OpTransferProgress: ChannelTransferProgress,
+		OpTransferDone:     ChannelTransferDone,
+		OpTransferError:    ChannelTransferError,
+	}
+	v2Ops = map[byte]string{}
+
+	// binaryOps carry file contents, which ProtocolV1 encodes in base64
+	binaryOps = map[string]bool{OpUploadChunk: true, OpDownloadChunk: true}
+)
+
+func init() {
+	for op, channel := range v2Channels {
+		v2Ops[channel] = op
+	}
+}
+
+// codec translates terminal messages from and to the frames of a protocol version
+type codec interface {
+	encode(msg Message) (messageType int, data []byte, err error)
+	decode(messageType int, data []byte) (Message, error)
+}
+
+// codecFor returns the codec of the subprotocol negotiated on conn
+func codecFor(conn *websocket.Conn) codec {
+	if conn.Subprotocol() == ProtocolV2 {
+		return binaryCodec{}
+	}
+	return jsonCodec{}
+}
+
+// writeFrame sends msg to conn in the negotiated protocol
+func writeFrame(conn *websocket.Conn, msg Message) error {
+	messageType, data, err := codecFor(conn).encode(msg)
+	if err != nil {
+		return err
+	}
+	if err = conn.SetWriteDeadline(time.Now().Add(WaitWirte)); err != nil {
+		return err
+	}
+	return conn.WriteMessage(messageType, data)
+}
+
+// readFrame receives the next message from conn in the negotiated protocol
+func readFrame(conn *websocket.Conn) (Message, error) {
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		return Message{}, err
+	}
+	return codecFor(conn).decode(messageType, data)
+}
+
+// jsonCodec implements ProtocolV1
+type jsonCodec struct{}
+
+func (jsonCodec) encode(msg Message) (int, []byte, error) {
+	if binaryOps[msg.Op] {
+		msg.Data = base64.StdEncoding.EncodeToString([]byte(msg.Data))
+	}
+	data, err := json.Marshal(msg)
+	return websocket.TextMessage, data, err
+}
+
+func (jsonCodec) decode(_ int, data []byte) (Message, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return Message{}, fmt.Errorf("%w: %v", ErrProtocol, err)
+	}
+	if binaryOps[msg.Op] {
+		payload, err := base64.StdEncoding.DecodeString(msg.Data)
+		if err != nil {
+			return Message{}, fmt.Errorf("%w: %s data is not base64: %v", ErrProtocol, msg.Op, err)
+		}
+		msg.Data = string(payload)
+	}
+	return msg, nil
+}
+
+// binaryCodec implements ProtocolV2
+type binaryCodec struct{}
+
+func (binaryCodec) encode(msg Message) (int, []byte, error) {
+	channel, ok := v2Channels[msg.Op]
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: no channel for %q", ErrProtocol, msg.Op)
+	}
+	if channel == ChannelResize {
+		data := make([]byte, 1+resizePayloadSize)
+		data[0] = channel
+		binary.BigEndian.PutUint16(data[1:], msg.Cols)
+		binary.BigEndian.PutUint16(data[3:], msg.Rows)
+		return websocket.BinaryMessage, data, nil
+	}
+	data := make([]byte, 1+len(msg.Data))
+	data[0] = channel
+	copy(data[1:], msg.Data)
+	return websocket.BinaryMessage, data, nil
+}
+
+func (binaryCodec) decode(messageType int, data []byte) (Message, error) {
+	if messageType != websocket.BinaryMessage || len(data) == 0 {
+		return Message{}, fmt.Errorf("%w: expected a binary frame with a channel", ErrProtocol)
+	}
+	op, ok := v2Ops[data[0]]
+	if !ok {
+		return Message{}, fmt.Errorf("%w: unknown channel %d", ErrProtocol, data[0])
+	}
+	payload := data[1:]
+	if op != OpResize {
+		return Message{Op: op, Data: string(payload)}, nil
+	}
+	if len(payload) != resizePayloadSize {
+		return Message{}, fmt.Errorf("%w: resize payload of %d bytes", ErrProtocol, len(payload))
+	}
+	return Message{Op: op, Cols: binary.BigEndian.Uint16(payload), Rows: binary.BigEndian.Uint16(payload[2:])}, nil
+}
@@ -0,0 +1,259 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package webterminal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// wireFormat is the client side of a protocol version, written from the protocol description
+// rather than with the server codecs so that both sides are checked against the specification.
+type wireFormat struct {
+	name         string
+	subprotocols []string
+	negotiated   string
+	// binarySafe reports whether output that is not UTF-8 reaches the client unaltered
+	binarySafe bool
+	stdin      func(data string) (int, []byte)
+	resize     func(cols, rows uint16) (int, []byte)
+	// send encodes any other message of the client
+	send      func(op, data string) (int, []byte)
+	malformed func() (int, []byte)
+	parse     func(t *testing.T, messageType int, data []byte) Message
+}
+
+func jsonWire(name string, subprotocols []string, negotiated string) wireFormat {
+	return wireFormat{
+		name:         name,
+		subprotocols: subprotocols,
+		negotiated:   negotiated,
+		stdin: func(data string) (int, []byte) {
+			return websocket.TextMessage, []byte(`{"Op":"stdin","Data":` + quote(data) + `}`)
+		},
+		resize: func(cols, rows uint16) (int, []byte) {
+			msg, _ := json.Marshal(map[string]interface{}{"Op": "resize", "Cols": cols, "Rows": rows})
+			return websocket.TextMessage, msg
+		},
+		send: func(op, data string) (int, []byte) {
+			if op == "upload-chunk" {
+				data = base64.StdEncoding.EncodeToString([]byte(data))
+			}
+			return websocket.TextMessage, []byte(`{"Op":` + quote(op) + `,"Data":` + quote(data) + `}`)
+		},
+		malformed: func() (int, []byte) { return websocket.TextMessage, []byte(`{"Op":`) },
+		parse: func(t *testing.T, messageType int, data []byte) Message {
+			require.Equal(t, websocket.TextMessage, messageType)
+			var msg Message
+			require.NoError(t, json.Unmarshal(data, &msg))
+			if msg.Op == "download-chunk" {
+				payload, err := base64.StdEncoding.DecodeString(msg.Data)
+				require.NoError(t, err)
+				msg.Data = string(payload)
+			}
+			return msg
+		},
+	}
+}
+
+func binaryWire() wireFormat {
+	ops := map[byte]string{1: "stdout", 4: "resize", 5: "toast", 6: "disconnect", 7: "session",
+		12: "download-chunk", 13: "transfer-progress", 14: "transfer-done", 15: "transfer-error"}
+	channels := map[string]byte{"upload-begin": 8, "upload-chunk": 9, "upload-end": 10, "download": 11}
+	return wireFormat{
+		name:         "v2",
+		subprotocols: []string{"wts.v2"},
+		negotiated:   "wts.v2",
+		binarySafe:   true,
+		stdin: func(data string) (int, []byte) {
+			return websocket.BinaryMessage, append([]byte{0}, data...)
+		},
+		resize: func(cols, rows uint16) (int, []byte) {
+			return websocket.BinaryMessage, []byte{4, byte(cols >> 8), byte(cols), byte(rows >> 8), byte(rows)}
+		},
+		send: func(op, data string) (int, []byte) {
+			return websocket.BinaryMessage, append([]byte{channels[op]}, data...)
+		},
+		malformed: func() (int, []byte) { return websocket.BinaryMessage, []byte{42, 'x'} },
+		parse: func(t *testing.T, messageType int, data []byte) Message {
+			require.Equal(t, websocket.BinaryMessage, messageType)
+			require.NotEmpty(t, data)
+			op, ok := ops[data[0]]
+			require.True(t, ok, "unexpected channel %d", data[0])
+			if op == "resize" {
+				require.Len(t, data, 5)
+				return Message{Op: op, Cols: uint16(data[1])<<8 | uint16(data[2]),
+					Rows: uint16(data[3])<<8 | uint16(data[4])}
+			}
+			return Message{Op: op, Data: string(data[1:])}
+		},
+	}
+}
+
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+var wireFormats = []wireFormat{
+	jsonWire("legacy client", nil, ""),
+	jsonWire("v1", []string{"wts.v1"}, "wts.v1"),
+	binaryWire(),
+}
+
+// newProtocolWindow connects a client speaking wire to a Window
+func newProtocolWindow(t *testing.T, wire wireFormat) (*Window, *websocket.Conn) {
+	upgrader := websocket.Upgrader{Subprotocols: Subprotocols}
+	serverConns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		serverConns <- conn
+	}))
+	t.Cleanup(server.Close)
+	dialer := websocket.Dialer{Subprotocols: wire.subprotocols}
+	client, _, err := dialer.Dial(strings.Replace(server.URL, "http", "ws", 1), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	conn := <-serverConns
+	t.Cleanup(func() { conn.Close() })
+	window := &Window{conn: conn, sizeChan: make(chan remotecommand.TerminalSize, 1), ctx: context.Background(),
+		terminaler: &terminaler{}}
+	return window, client
+}
+
+func receive(t *testing.T, wire wireFormat, client *websocket.Conn) Message {
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	messageType, data, err := client.ReadMessage()
+	require.NoError(t, err)
+	return wire.parse(t, messageType, data)
+}
+
+func TestProtocolConformance(t *testing.T) {
+	for _, wire := range wireFormats {
+		t.Run(wire.name, func(t *testing.T) {
+			t.Run("negotiation", func(t *testing.T) {
+				_, client := newProtocolWindow(t, wire)
+				assert.Equal(t, wire.negotiated, client.Subprotocol())
+			})
+
+			t.Run("stdin", func(t *testing.T) {
+				window, client := newProtocolWindow(t, wire)
+				require.NoError(t, client.WriteMessage(wire.stdin("ls -l\r")))
+				buffer := make([]byte, 32)
+				n, err := window.Read(buffer)
+				require.NoError(t, err)
+				assert.Equal(t, "ls -l\r", string(buffer[:n]))
+			})
+
+			t.Run("resize", func(t *testing.T) {
+				window, client := newProtocolWindow(t, wire)
+				require.NoError(t, client.WriteMessage(wire.resize(300, 70)))
+				n, err := window.Read(make([]byte, 32))
+				require.NoError(t, err)
+				assert.Zero(t, n)
+				assert.Equal(t, remotecommand.TerminalSize{Width: 300, Height: 70}, <-window.sizeChan)
+			})
+
+			t.Run("stdout", func(t *testing.T) {
+				window, client := newProtocolWindow(t, wire)
+				output := "héllo\r\n"
+				if wire.binarySafe {
+					output += "\xff\xfe\x00"
+				}
+				n, err := window.Write([]byte(output))
+				require.NoError(t, err)
+				assert.Equal(t, len(output), n)
+				msg := receive(t, wire, client)
+				assert.Equal(t, "stdout", msg.Op)
+				assert.Equal(t, output, msg.Data)
+			})
+
+			t.Run("toast and disconnect", func(t *testing.T) {
+				window, client := newProtocolWindow(t, wire)
+				require.NoError(t, window.Toast("bob joined your session (read-only)"))
+				window.sendMessage("Session terminated")
+				assert.Equal(t, Message{Op: "toast", Data: "bob joined your session (read-only)"},
+					receive(t, wire, client))
+				assert.Equal(t, Message{Op: "disconnect", Data: "Session terminated"}, receive(t, wire, client))
+			})
+
+			t.Run("file transfer", func(t *testing.T) {
+				window, client := newProtocolWindow(t, wire)
+				files, container, _ := newTestTransfers(1 << 10)
+				files.send = window.send
+				window.files = files
+				content := "\x7fELF\x00\xff"
+				begin := `{"path":"/tmp/tool","size":6,"sha256":"` + checksum(content) + `"}`
+				for _, msg := range []struct{ op, data string }{
+					{"upload-begin", begin}, {"upload-chunk", content}, {"upload-end", ""},
+					{"download", `{"path":"/tmp/tool"}`},
+				} {
+					require.NoError(t, client.WriteMessage(wire.send(msg.op, msg.data)))
+					n, err := window.Read(make([]byte, 32))
+					require.NoError(t, err)
+					assert.Zero(t, n)
+				}
+
+				var ops []string
+				for len(ops) == 0 || ops[len(ops)-1] != "transfer-done" || len(ops) < 5 {
+					msg := receive(t, wire, client)
+					ops = append(ops, msg.Op)
+					if msg.Op == "download-chunk" {
+						assert.Equal(t, content, msg.Data)
+					}
+				}
+				assert.Equal(t, []string{"transfer-progress", "transfer-done", "transfer-progress", "download-chunk",
+					"transfer-done"}, ops)
+				got, _ := container.get("/tmp/tool")
+				assert.Equal(t, content, string(got))
+			})
+
+			t.Run("malformed frame", func(t *testing.T) {
+				window, client := newProtocolWindow(t, wire)
+				require.NoError(t, client.WriteMessage(wire.malformed()))
+				buffer := make([]byte, 32)
+				n, err := window.Read(buffer)
+				assert.ErrorIs(t, err, ErrProtocol)
+				assert.Equal(t, endOfWindow, string(buffer[:n]))
+			})
+		})
+	}
+}
+
+// TestProtocolV1OutputSize checks that stdout messages of the JSON protocol report the terminal size
+func TestProtocolV1OutputSize(t *testing.T) {
+	wire := wireFormats[1]
+	window, client := newProtocolWindow(t, wire)
+	_, err := window.Write([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, Message{Op: "stdout", Data: "a", Rows: defaultRows, Cols: defaultCols}, receive(t, wire, client))
+
+	require.NoError(t, client.WriteMessage(wire.resize(120, 40)))
+	_, err = window.Read(make([]byte, 32))
+	require.NoError(t, err)
+	<-window.sizeChan
+	_, err = window.Write([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, Message{Op: "stdout", Data: "b", Rows: 40, Cols: 120}, receive(t, wire, client))
+}
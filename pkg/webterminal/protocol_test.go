@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package webterminal
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryCodec(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		want []byte
+	}{
+		{name: "stdin", msg: Message{Op: OpStdin, Data: "ls"}, want: []byte{ChannelStdin, 'l', 's'}},
+		{name: "stdout", msg: Message{Op: OpStdout, Data: "\xff"}, want: []byte{ChannelStdout, 0xff}},
+		{name: "empty stdout", msg: Message{Op: OpStdout}, want: []byte{ChannelStdout}},
+		{name: "resize", msg: Message{Op: OpResize, Cols: 258, Rows: 40}, want: []byte{ChannelResize, 1, 2, 0, 40}},
+		{name: "toast", msg: Message{Op: OpToast, Data: "hi"}, want: []byte{ChannelToast, 'h', 'i'}},
+		{name: "disconnect", msg: Message{Op: OpDisconnect, Data: "x"}, want: []byte{ChannelDisconnect, 'x'}},
+		{name: "session", msg: Message{Op: OpSession, Data: "{}"}, want: []byte{ChannelSession, '{', '}'}},
+		{name: "upload chunk", msg: Message{Op: OpUploadChunk, Data: "\x00\xff"},
+			want: []byte{ChannelUploadChunk, 0, 0xff}},
+		{name: "download chunk", msg: Message{Op: OpDownloadChunk, Data: "\x1f"}, want: []byte{ChannelDownloadChunk, 0x1f}},
+		{name: "transfer error", msg: Message{Op: OpTransferError, Data: "{}"},
+			want: []byte{ChannelTransferError, '{', '}'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageType, data, err := binaryCodec{}.encode(tt.msg)
+			require.NoError(t, err)
+			assert.Equal(t, websocket.BinaryMessage, messageType)
+			assert.Equal(t, tt.want, data)
+
+			got, err := binaryCodec{}.decode(messageType, data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.msg, got)
+		})
+	}
+
+	_, _, err := binaryCodec{}.encode(Message{Op: "unknown"})
+	assert.ErrorIs(t, err, ErrProtocol)
+}
+
+func TestBinaryCodecDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		messageType int
+		data        []byte
+	}{
+		{name: "text frame", messageType: websocket.TextMessage, data: []byte(`{"Op":"stdin"}`)},
+		{name: "empty frame", messageType: websocket.BinaryMessage},
+		{name: "unknown channel", messageType: websocket.BinaryMessage, data: []byte{42, 'x'}},
+		{name: "short resize", messageType: websocket.BinaryMessage, data: []byte{ChannelResize, 0, 80}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := binaryCodec{}.decode(tt.messageType, tt.data)
+			assert.ErrorIs(t, err, ErrProtocol)
+		})
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	msg := Message{Op: OpStdout, Data: "hi", Rows: 24, Cols: 80}
+	messageType, data, err := jsonCodec{}.encode(msg)
+	require.NoError(t, err)
+	assert.Equal(t, websocket.TextMessage, messageType)
+	assert.JSONEq(t, `{"Op":"stdout","Data":"hi","Rows":24,"Cols":80}`, string(data))
+	got, err := jsonCodec{}.decode(messageType, data)
+	require.NoError(t, err)
+	assert.Equal(t, msg, got)
+
+	_, err = jsonCodec{}.decode(websocket.TextMessage, []byte("stdin"))
+	assert.ErrorIs(t, err, ErrProtocol)
+
+	// file chunks travel in base64
+	chunk := Message{Op: OpDownloadChunk, Data: "\xff\x00"}
+	_, data, err = jsonCodec{}.encode(chunk)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"Op":"download-chunk","Data":"/wA=","Rows":0,"Cols":0}`, string(data))
+	got, err = jsonCodec{}.decode(websocket.TextMessage, []byte(`{"Op":"upload-chunk","Data":"/wA="}`))
+	require.NoError(t, err)
+	assert.Equal(t, Message{Op: OpUploadChunk, Data: "\xff\x00"}, got)
+	_, err = jsonCodec{}.decode(websocket.TextMessage, []byte(`{"Op":"upload-chunk","Data":"%%"}`))
+	assert.ErrorIs(t, err, ErrProtocol)
+}
+
+func TestSubprotocolPreference(t *testing.T) {
+	wire := binaryWire()
+	wire.subprotocols = []string{ProtocolV1, ProtocolV2}
+	_, client := newProtocolWindow(t, wire)
+	assert.Equal(t, ProtocolV2, client.Subprotocol())
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"openfuyao.com/web-terminal-service/pkg/recording"
+)
+
+// Replay streams an asciicast recording over conn in the protocol of a live terminal: output
+// becomes "stdout" messages and size changes "resize" messages. Delays between
+// events are divided by speed. Replay stops when ctx is done or the client goes away.
+func Replay(ctx context.Context, conn *websocket.Conn, r io.Reader, speed float64) error {
+	if speed <= 0 {
+		return fmt.Errorf("invalid replay speed %v", speed)
+	}
+	reader, err := recording.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// the client sends nothing during a replay, reading only notices when it disconnects
+	go func() {
+		for {
+			if _, _, readErr := conn.NextReader(); readErr != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	header := reader.Header()
+	rows, cols := header.Height, header.Width
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var last time.Duration
+	for {
+		event, nextErr := reader.Next()
+		if errors.Is(nextErr, io.EOF) {
+			break
+		}
+		if nextErr != nil {
+			return nextErr
+		}
+		if wait := time.Duration(float64(event.Time-last) / speed); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		last = event.Time
+
+		var msg Message
+		switch event.Code {
+		case recording.EventOutput:
+			msg = Message{Op: OpStdout, Data: event.Data, Rows: rows, Cols: cols}
+		case recording.EventResize:
+			if _, scanErr := fmt.Sscanf(event.Data, "%dx%d", &cols, &rows); scanErr != nil {
+				continue
+			}
+			msg = Message{Op: OpResize, Rows: rows, Cols: cols}
+		default:
+			// input is already visible through the echoed output
+			continue
+		}
+		if err = writeFrame(conn, msg); err != nil {
+			return err
+		}
+	}
+	return writeFrame(conn, Message{Op: OpDisconnect, Data: "Replay finished"})
+}
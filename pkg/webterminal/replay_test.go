@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package webterminal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCast = `{"version":2,"width":80,"height":24,"timestamp":1700000000}
+[0.1,"r","120x40"]
+[0.2,"i","ls\r"]
+[1.2,"o","a.txt\r\n"]
+`
+
+// replayOver runs Replay on the server side of a websocket and returns the client end
+func replayOver(t *testing.T, cast string, speed float64) (*websocket.Conn, chan error) {
+	upgrader := websocket.Upgrader{}
+	done := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		done <- Replay(r.Context(), conn, strings.NewReader(cast), speed)
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial(strings.Replace(server.URL, "http", "ws", 1), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn, done
+}
+
+func TestReplay(t *testing.T) {
+	conn, done := replayOver(t, testCast, 100)
+	start := time.Now()
+
+	var got []Message
+	for i := 0; i < 3; i++ {
+		var msg Message
+		require.NoError(t, conn.ReadJSON(&msg))
+		got = append(got, msg)
+	}
+	assert.Equal(t, []Message{
+		{Op: "resize", Rows: 40, Cols: 120},
+		{Op: "stdout", Data: "a.txt\r\n", Rows: 40, Cols: 120},
+		{Op: "disconnect", Data: "Replay finished"},
+	}, got)
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond, "delays are scaled, not skipped")
+	assert.NoError(t, <-done)
+}
+
+func TestReplayStopsWhenClientLeaves(t *testing.T) {
+	cast := `{"version":2,"width":80,"height":24}
+[0,"o","$ "]
+[3600,"o","exit\r\n"]
+`
+	conn, done := replayOver(t, cast, 1)
+	var msg Message
+	require.NoError(t, conn.ReadJSON(&msg))
+	require.NoError(t, conn.Close())
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay did not stop after the client disconnected")
+	}
+}
+
+func TestReplayInvalid(t *testing.T) {
+	assert.Error(t, Replay(context.Background(), nil, strings.NewReader(testCast), 0))
+	assert.Error(t, Replay(context.Background(), nil, strings.NewReader("garbage"), 1))
+}
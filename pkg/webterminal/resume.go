@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const tokenBytes = 32
+
+// ErrNotResumable is returned when resuming a session that has ended or does not support resuming
+var ErrNotResumable = errors.New("session cannot be resumed")
+
+// SessionHello tells the client how to resume its session, it is sent as the data of a "session" message
+// when the session starts and after every resume.
+type SessionHello struct {
+	ID          string `json:"id"`
+	ResumeToken string `json:"resumeToken"`
+	// GracePeriod is how many seconds the session waits for the client to come back after a disconnect
+	GracePeriod int `json:"gracePeriod"`
+}
+
+// randomToken returns an unguessable url safe token
+func randomToken() (string, error) {
+	raw := make([]byte, tokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Resumable returns the session that token resumes
+func (m *SessionManager) Resumable(token string) (*Session, bool) {
+	if m == nil || token == "" {
+		return nil, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, s := range m.sessions {
+		if s.matchesResumeToken(token) {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Session) matchesResumeToken(token string) bool {
+	s.resumeMu.Lock()
+	defer s.resumeMu.Unlock()
+	return s.resumeToken != "" && subtle.ConstantTimeCompare([]byte(s.resumeToken), []byte(token)) == 1
+}
+
+// hello returns the session message carrying a new resume token, invalidating the previous one
+func (s *Session) hello() (Message, error) {
+	token, err := randomToken()
+	if err != nil {
+		return Message{}, err
+	}
+	s.resumeMu.Lock()
+	s.resumeToken = token
+	s.resumeMu.Unlock()
+	data, err := json.Marshal(SessionHello{ID: s.info.ID, ResumeToken: token,
+		GracePeriod: int(s.window.grace / time.Second)})
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{Op: OpSession, Data: string(data)}, nil
+}
+
+// sendHello tells the client of a resumable session how to resume it
+func (s *Session) sendHello() error {
+	msg, err := s.hello()
+	if err != nil {
+		return err
+	}
+	w := s.window
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	return writeFrame(w.conn, msg)
+}
+
+// Resume attaches conn to the session in place of the connection it lost. The client receives
+// a new resume token followed by the output it missed.
+func (s *Session) Resume(conn *websocket.Conn) error {
+	w := s.window
+	if w == nil || w.grace <= 0 {
+		return ErrNotResumable
+	}
+	hello, err := s.hello()
+	if err != nil {
+		return err
+	}
+	if err = w.resume(conn, hello); err != nil {
+		return err
+	}
+	zlog.LogInfof("Session %s of %s resumed", s.info.ID, s.info.User)
+	return nil
+}
+
+func (w *Window) currentConn() *websocket.Conn {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	return w.conn
+}
+
+// resume swaps conn in and replays the missed output to it
+func (w *Window) resume(conn *websocket.Conn, hello Message) error {
+	w.writeMu.Lock()
+	if w.ended {
+		w.writeMu.Unlock()
+		return ErrNotResumable
+	}
+	previous := w.conn
+	w.conn, w.detached = conn, false
+	missed, dropped := w.missed.Drain()
+	if err := w.catchUp(hello, missed, dropped); err != nil {
+		// the new connection failed as well, Read notices it and waits for the next resume
+		zlog.LogWarnf("Failed to replay output to resumed client: %v", err)
+		w.detached = true
+		_, _ = w.missed.Write(missed)
+	}
+	w.writeMu.Unlock()
+
+	if w.keepAlive != nil {
+		w.keepAlive(conn)
+	}
+	if previous != conn {
+		// a half open connection has not failed yet, closing it releases its pending read
+		_ = previous.Close()
+	}
+	select {
+	case w.reattached <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// catchUp sends the hello and the missed output to a resumed client, writeMu must be held
+func (w *Window) catchUp(hello Message, missed []byte, dropped int64) error {
+	messages := []Message{hello}
+	if dropped > 0 {
+		messages = append(messages, Message{Op: OpToast,
+			Data: fmt.Sprintf("%d bytes of output were lost while disconnected", dropped)})
+	}
+	if len(missed) > 0 {
+		messages = append(messages, w.output(missed))
+	}
+	for _, msg := range messages {
+		if err := writeFrame(w.conn, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// awaitResume detaches the window from conn that failed with err and waits for the client to
+// resume the session. It reports whether the window has a new connection to read from.
+func (w *Window) awaitResume(conn *websocket.Conn, err error) bool {
+	if w.grace <= 0 || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		// the client closed the terminal on purpose
+		return false
+	}
+	w.writeMu.Lock()
+	if w.ended {
+		w.writeMu.Unlock()
+		return false
+	}
+	w.detached = true
+	w.writeMu.Unlock()
+	zlog.LogInfof("Terminal client disconnected: %v, waiting %v for it to resume", err, w.grace)
+
+	timer := time.NewTimer(w.grace)
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.reattached:
+			if w.currentConn() != conn {
+				return true
+			}
+		case <-timer.C:
+			w.writeMu.Lock()
+			defer w.writeMu.Unlock()
+			if w.conn != conn {
+				return true
+			}
+			w.ended = true
+			zlog.LogInfof("Terminal client did not resume within %v", w.grace)
+			return false
+		case <-w.ctx.Done():
+			return false
+		}
+	}
+}
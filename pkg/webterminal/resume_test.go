@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package webterminal
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+type readResult struct {
+	data string
+	err  error
+}
+
+func newResumableSession(t *testing.T, grace time.Duration, bufferSize int) (*Session, *websocket.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	conn, client := newConnPair(t)
+	window := &Window{conn: conn, sizeChan: make(chan remotecommand.TerminalSize, 1), ctx: ctx,
+		terminaler: &terminaler{}, grace: grace, missed: newRingBuffer(bufferSize),
+		reattached: make(chan struct{}, 1)}
+	session := newSession(SessionInfo{ID: "s1", User: "alice", StartTime: time.Now()}, cancel, window)
+	window.session = session
+	return session, client
+}
+
+func readHello(t *testing.T, conn *websocket.Conn) SessionHello {
+	msg := readMessage(t, conn)
+	require.Equal(t, "session", msg.Op)
+	var hello SessionHello
+	require.NoError(t, json.Unmarshal([]byte(msg.Data), &hello))
+	return hello
+}
+
+func readAsync(w *Window) <-chan readResult {
+	results := make(chan readResult, 1)
+	go func() {
+		buffer := make([]byte, 64)
+		n, err := w.Read(buffer)
+		results <- readResult{data: string(buffer[:n]), err: err}
+	}()
+	return results
+}
+
+func waitDetached(t *testing.T, w *Window) {
+	require.Eventually(t, func() bool {
+		w.writeMu.Lock()
+		defer w.writeMu.Unlock()
+		return w.detached
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSessionResume(t *testing.T) {
+	session, client := newResumableSession(t, time.Minute, 1024)
+	window := session.window
+	manager := NewSessionManager()
+	manager.register(session)
+
+	require.NoError(t, session.sendHello())
+	hello := readHello(t, client)
+	assert.Equal(t, SessionHello{ID: "s1", ResumeToken: hello.ResumeToken, GracePeriod: 60}, hello)
+	found, ok := manager.Resumable(hello.ResumeToken)
+	assert.True(t, ok)
+	assert.Same(t, session, found)
+	_, ok = manager.Resumable("guess")
+	assert.False(t, ok)
+
+	// the network drops, the session keeps running and buffers its output
+	results := readAsync(window)
+	require.NoError(t, client.UnderlyingConn().Close())
+	waitDetached(t, window)
+	n, err := window.Write([]byte("missed"))
+	require.NoError(t, err)
+	assert.Equal(t, len("missed"), n)
+
+	conn, resumed := newConnPair(t)
+	require.NoError(t, session.Resume(conn))
+	next := readHello(t, resumed)
+	assert.NotEqual(t, hello.ResumeToken, next.ResumeToken)
+	_, ok = manager.Resumable(hello.ResumeToken)
+	assert.False(t, ok, "resume tokens are single use")
+	assert.Equal(t, Message{Op: "stdout", Data: "missed", Rows: defaultRows, Cols: defaultCols}, readMessage(t, resumed))
+
+	// stdin and resize flow from the new connection
+	require.NoError(t, resumed.WriteJSON(Message{Op: "resize", Rows: 40, Cols: 100}))
+	select {
+	case result := <-results:
+		require.NoError(t, result.err)
+		assert.Empty(t, result.data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read did not return after resume")
+	}
+	assert.Equal(t, remotecommand.TerminalSize{Width: 100, Height: 40}, <-window.sizeChan)
+	require.NoError(t, resumed.WriteJSON(Message{Op: "stdin", Data: "ls\r"}))
+	result := <-readAsync(window)
+	require.NoError(t, result.err)
+	assert.Equal(t, "ls\r", result.data)
+}
+
+func TestSessionResumeLostOutput(t *testing.T) {
+	session, client := newResumableSession(t, time.Minute, 8)
+	window := session.window
+	results := readAsync(window)
+	require.NoError(t, client.UnderlyingConn().Close())
+	waitDetached(t, window)
+	_, err := window.Write([]byte("0123456789"))
+	require.NoError(t, err)
+
+	conn, resumed := newConnPair(t)
+	require.NoError(t, session.Resume(conn))
+	readHello(t, resumed)
+	assert.Equal(t, Message{Op: "toast", Data: "2 bytes of output were lost while disconnected"},
+		readMessage(t, resumed))
+	assert.Equal(t, "23456789", readMessage(t, resumed).Data)
+
+	window.Close("Process finished")
+	result := <-results
+	assert.Error(t, result.err)
+}
+
+func TestSessionResumeKeepAlive(t *testing.T) {
+	session, client := newResumableSession(t, time.Minute, 8)
+	window := session.window
+	window.terminaler = &terminaler{sessionCfg: &SessionCfg{ResumeGracePeriod: time.Minute}}
+	var swapped []*websocket.Conn
+	window.keepAlive = func(conn *websocket.Conn) {
+		swapped = append(swapped, conn)
+		window.terminaler.keepAlive(window.ctx, session.cancel, conn)
+	}
+	results := readAsync(window)
+	require.NoError(t, client.UnderlyingConn().Close())
+	waitDetached(t, window)
+
+	conn, resumed := newConnPair(t)
+	require.NoError(t, session.Resume(conn))
+	assert.Equal(t, []*websocket.Conn{conn}, swapped)
+	readHello(t, resumed)
+
+	// the close handler of the resumed connection ends the session when its client leaves
+	require.NoError(t, resumed.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+	select {
+	case result := <-results:
+		assert.ErrorIs(t, result.err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing the resumed connection did not end the session")
+	}
+}
+
+func TestSessionResumeExpired(t *testing.T) {
+	session, client := newResumableSession(t, 50*time.Millisecond, 8)
+	results := readAsync(session.window)
+	require.NoError(t, client.UnderlyingConn().Close())
+	result := <-results
+	assert.Error(t, result.err)
+	assert.Equal(t, endOfWindow, result.data)
+
+	conn, _ := newConnPair(t)
+	assert.ErrorIs(t, session.Resume(conn), ErrNotResumable)
+}
+
+func TestSessionResumeNotResumable(t *testing.T) {
+	tests := []struct {
+		name  string
+		grace time.Duration
+		close func(conn *websocket.Conn) error
+	}{
+		{
+			name:  "resume disabled",
+			close: func(conn *websocket.Conn) error { return conn.UnderlyingConn().Close() },
+		},
+		{
+			name:  "closed by the client",
+			grace: time.Minute,
+			close: func(conn *websocket.Conn) error {
+				return conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, client := newResumableSession(t, tt.grace, 8)
+			results := readAsync(session.window)
+			require.NoError(t, tt.close(client))
+			select {
+			case result := <-results:
+				assert.Error(t, result.err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("Read waited for a resume")
+			}
+		})
+	}
+	session, _ := newResumableSession(t, 0, 8)
+	conn, _ := newConnPair(t)
+	assert.ErrorIs(t, session.Resume(conn), ErrNotResumable)
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+// ringBuffer keeps the most recent bytes written to it, up to its capacity
+type ringBuffer struct {
+	buf   []byte
+	start int
+	size  int
+	// dropped counts the bytes overwritten since the last drain
+	dropped int64
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, capacity)}
+}
+
+// Write appends p, overwriting the oldest bytes once the buffer is full
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	capacity := len(r.buf)
+	if n >= capacity {
+		r.dropped += int64(r.size + n - capacity)
+		copy(r.buf, p[n-capacity:])
+		r.start, r.size = 0, capacity
+		return n, nil
+	}
+	if overflow := r.size + n - capacity; overflow > 0 {
+		r.dropped += int64(overflow)
+		r.start = (r.start + overflow) % capacity
+		r.size -= overflow
+	}
+	end := (r.start + r.size) % capacity
+	copied := copy(r.buf[end:], p)
+	copy(r.buf, p[copied:])
+	r.size += n
+	return n, nil
+}
+
+// Drain returns the buffered bytes, oldest first, with the number of bytes lost to overflow and empties the buffer
+func (r *ringBuffer) Drain() ([]byte, int64) {
+	out := make([]byte, r.size)
+	copied := copy(out, r.buf[r.start:min(r.start+r.size, len(r.buf))])
+	copy(out[copied:], r.buf[:r.size-copied])
+	dropped := r.dropped
+	r.start, r.size, r.dropped = 0, 0, 0
+	return out, dropped
+}
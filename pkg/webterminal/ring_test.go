@@ -0,0 +1,51 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package webterminal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name        string
+		writes      []string
+		want        string
+		wantDropped int64
+	}{
+		{name: "empty", want: ""},
+		{name: "fits", writes: []string{"ab", "cd"}, want: "abcd"},
+		{name: "full", writes: []string{"abc", "def", "gh"}, want: "abcdefgh"},
+		{name: "wraps", writes: []string{"abcdef", "ghij"}, want: "cdefghij", wantDropped: 2},
+		{name: "wraps twice", writes: []string{"abcdef", "ghijk", "lmnop"}, want: "ijklmnop", wantDropped: 8},
+		{name: "larger than capacity", writes: []string{"ab", "0123456789"}, want: "23456789", wantDropped: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRingBuffer(8)
+			for _, w := range tt.writes {
+				n, err := r.Write([]byte(w))
+				assert.NoError(t, err)
+				assert.Equal(t, len(w), n)
+			}
+			got, dropped := r.Drain()
+			assert.Equal(t, tt.want, string(got))
+			assert.Equal(t, tt.wantDropped, dropped)
+
+			got, dropped = r.Drain()
+			assert.Empty(t, got)
+			assert.Zero(t, dropped)
+		})
+	}
+}
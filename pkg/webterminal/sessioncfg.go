@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	defaultResumeGracePeriod = 2 * time.Minute
+	defaultResumeBufferSize  = 256 << 10
+	defaultMaxTransferSize   = 512 << 20
+	defaultActivityInterval  = 30 * time.Second
+	defaultTimeoutWarnings   = "5m,1m"
+
+	envResumeGracePeriod = "SESSION_RESUME_GRACE_PERIOD"
+	envResumeBufferSize  = "SESSION_RESUME_BUFFER_SIZE"
+	envMaxTransferSize   = "SESSION_MAX_TRANSFER_SIZE"
+	envActivityInterval  = "SESSION_ACTIVITY_INTERVAL"
+	envIdleTimeout       = "SESSION_IDLE_TIMEOUT"
+	envMaxDuration       = "SESSION_MAX_DURATION"
+	envTimeoutWarnings   = "SESSION_TIMEOUT_WARNINGS"
+)
+
+// SessionCfg holds the settings of live terminal sessions
+type SessionCfg struct {
+	// ResumeGracePeriod is how long a session outlives the websocket of its client, 0 disables resuming
+	ResumeGracePeriod time.Duration
+	// ResumeBufferSize bounds the output kept for a disconnected client, in bytes
+	ResumeBufferSize int
+	// MaxTransferSize bounds the files uploaded to or downloaded from a container, in bytes.
+	// 0 disables file transfers.
+	MaxTransferSize int64
+	// ActivityInterval is how often the activity of a cluster terminal is written to its WebterminalTemplate
+	// at most. It must stay well below the session timeout of the templates.
+	ActivityInterval time.Duration
+	// IdleTimeout disconnects sessions without input for that long, 0 disables it.
+	// Profiles may override it for cluster terminals, as MaxDuration.
+	IdleTimeout time.Duration
+	// MaxDuration disconnects sessions once they lasted that long, 0 disables it
+	MaxDuration time.Duration
+	// TimeoutWarnings are how long before a timeout the user is warned, longest first
+	TimeoutWarnings []time.Duration
+}
+
+// NewSessionCfg returns the session config read from the environment
+func NewSessionCfg() *SessionCfg {
+	return &SessionCfg{
+		ResumeGracePeriod: durationFromEnv(envResumeGracePeriod, defaultResumeGracePeriod),
+		ResumeBufferSize:  intFromEnv(envResumeBufferSize, defaultResumeBufferSize),
+		MaxTransferSize:   int64(intFromEnv(envMaxTransferSize, defaultMaxTransferSize)),
+		ActivityInterval:  durationFromEnv(envActivityInterval, defaultActivityInterval),
+		IdleTimeout:       durationFromEnv(envIdleTimeout, 0),
+		MaxDuration:       durationFromEnv(envMaxDuration, 0),
+		TimeoutWarnings:   durationsFromEnv(envTimeoutWarnings, defaultTimeoutWarnings),
+	}
+}
+
+// Validate validate session config
+func (c *SessionCfg) Validate() []error {
+	var errs []error
+	if c.ResumeGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("session resume grace period must not be negative"))
+	}
+	if c.ResumeGracePeriod > 0 && c.ResumeBufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("session resume buffer size must be positive"))
+	}
+	if c.MaxTransferSize < 0 {
+		errs = append(errs, fmt.Errorf("session max transfer size must not be negative"))
+	}
+	if c.ActivityInterval < 0 {
+		errs = append(errs, fmt.Errorf("session activity interval must not be negative"))
+	}
+	if c.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("session idle timeout must not be negative"))
+	}
+	if c.MaxDuration < 0 {
+		errs = append(errs, fmt.Errorf("session max duration must not be negative"))
+	}
+	for _, warning := range c.TimeoutWarnings {
+		if warning <= 0 {
+			errs = append(errs, fmt.Errorf("session timeout warnings must be positive"))
+			break
+		}
+	}
+	return errs
+}
+
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		zlog.LogWarnf("invalid %s %q, use default %v", name, v, def)
+		return def
+	}
+	return d
+}
+
+// durationsFromEnv reads a comma separated list of durations, longest first. An empty variable is an empty list.
+func durationsFromEnv(name string, def string) []time.Duration {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		v = def
+	}
+	durations, err := parseDurations(v)
+	if err != nil {
+		zlog.LogWarnf("invalid %s %q, use default %s", name, v, def)
+		durations, _ = parseDurations(def)
+	}
+	return durations
+}
+
+func parseDurations(list string) ([]time.Duration, error) {
+	var durations []time.Duration
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		d, err := time.ParseDuration(entry)
+		if err != nil {
+			return nil, err
+		}
+		durations = append(durations, d)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] > durations[j] })
+	return durations, nil
+}
+
+func intFromEnv(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		zlog.LogWarnf("invalid %s %q, use default %d", name, v, def)
+		return def
+	}
+	return i
+}
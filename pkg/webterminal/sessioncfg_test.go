@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package webterminal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSessionCfg(t *testing.T) {
+	warnings := []time.Duration{5 * time.Minute, time.Minute}
+	got := NewSessionCfg()
+	assert.Equal(t, &SessionCfg{ResumeGracePeriod: defaultResumeGracePeriod,
+		ResumeBufferSize: defaultResumeBufferSize, MaxTransferSize: defaultMaxTransferSize,
+		ActivityInterval: defaultActivityInterval, TimeoutWarnings: warnings}, got)
+
+	t.Setenv(envResumeGracePeriod, "30s")
+	t.Setenv(envActivityInterval, "1m")
+	t.Setenv(envIdleTimeout, "15m")
+	t.Setenv(envMaxDuration, "8h")
+	t.Setenv(envTimeoutWarnings, "30s, 2m")
+	t.Setenv(envResumeBufferSize, "many")
+	t.Setenv(envMaxTransferSize, "0")
+	got = NewSessionCfg()
+	assert.Equal(t, &SessionCfg{ResumeGracePeriod: 30 * time.Second,
+		ResumeBufferSize: defaultResumeBufferSize, ActivityInterval: time.Minute, IdleTimeout: 15 * time.Minute,
+		MaxDuration: 8 * time.Hour, TimeoutWarnings: []time.Duration{2 * time.Minute, 30 * time.Second}}, got)
+
+	t.Setenv(envResumeGracePeriod, "soon")
+	t.Setenv(envResumeBufferSize, "1024")
+	t.Setenv(envMaxTransferSize, "1MiB")
+	t.Setenv(envActivityInterval, "often")
+	t.Setenv(envIdleTimeout, "")
+	t.Setenv(envMaxDuration, "")
+	t.Setenv(envTimeoutWarnings, "soon")
+	got = NewSessionCfg()
+	assert.Equal(t, &SessionCfg{ResumeGracePeriod: defaultResumeGracePeriod, ResumeBufferSize: 1024,
+		MaxTransferSize: defaultMaxTransferSize, ActivityInterval: defaultActivityInterval,
+		TimeoutWarnings: warnings}, got)
+
+	t.Setenv(envTimeoutWarnings, "")
+	assert.Empty(t, NewSessionCfg().TimeoutWarnings)
+}
+
+func TestSessionCfgValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     SessionCfg
+		wantErr int
+	}{
+		{name: "default", cfg: SessionCfg{ResumeGracePeriod: time.Minute, ResumeBufferSize: 1024}},
+		{name: "resume disabled", cfg: SessionCfg{}},
+		{name: "negative grace period", cfg: SessionCfg{ResumeGracePeriod: -time.Second}, wantErr: 1},
+		{name: "no buffer", cfg: SessionCfg{ResumeGracePeriod: time.Minute}, wantErr: 1},
+		{name: "negative transfer size", cfg: SessionCfg{MaxTransferSize: -1}, wantErr: 1},
+		{name: "negative activity interval", cfg: SessionCfg{ActivityInterval: -time.Second}, wantErr: 1},
+		{name: "negative timeouts", cfg: SessionCfg{IdleTimeout: -time.Second, MaxDuration: -time.Second},
+			wantErr: 2},
+		{name: "zero warning", cfg: SessionCfg{TimeoutWarnings: []time.Duration{time.Minute, 0}}, wantErr: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, tt.cfg.Validate(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+// ErrSessionNotFound is returned for unknown session ids
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionInfo is a snapshot of a live terminal session
+type SessionInfo struct {
+	ID           string    `json:"id"`
+	User         string    `json:"user"`
+	Namespace    string    `json:"namespace"`
+	Pod          string    `json:"pod"`
+	Container    string    `json:"container"`
+	Kind         string    `json:"kind"`
+	ClientIP     string    `json:"clientIP"`
+	StartTime    time.Time `json:"startTime"`
+	LastActivity time.Time `json:"lastActivity"`
+	// BytesIn counts input sent by the user, BytesOut output sent to the user
+	BytesIn  int64 `json:"bytesIn"`
+	BytesOut int64 `json:"bytesOut"`
+	// Viewers counts the users the session is shared with
+	Viewers int `json:"viewers"`
+}
+
+// Session is a live terminal session. Its counters are safe for concurrent use and
+// all methods of a nil Session do nothing.
+type Session struct {
+	info   SessionInfo
+	cancel context.CancelFunc
+	window *Window
+
+	lastActivity atomic.Int64
+	lastInput    atomic.Int64
+	bytesIn      atomic.Int64
+	bytesOut     atomic.Int64
+
+	sharesMu sync.Mutex
+	shares   map[string]Share
+
+	resumeMu    sync.Mutex
+	resumeToken string
+
+	// stopReason is why the session was stopped, nil until it is
+	stopReason atomic.Pointer[string]
+}
+
+func newSession(info SessionInfo, cancel context.CancelFunc, window *Window) *Session {
+	s := &Session{info: info, cancel: cancel, window: window}
+	s.lastActivity.Store(info.StartTime.UnixNano())
+	s.lastInput.Store(info.StartTime.UnixNano())
+	return s
+}
+
+// Info returns a snapshot of the session
+func (s *Session) Info() SessionInfo {
+	info := s.info
+	info.LastActivity = time.Unix(0, s.lastActivity.Load())
+	info.BytesIn = s.bytesIn.Load()
+	info.BytesOut = s.bytesOut.Load()
+	if s.window != nil {
+		info.Viewers = s.window.viewerCount()
+	}
+	return info
+}
+
+func (s *Session) addIn(n int) {
+	if s == nil {
+		return
+	}
+	s.bytesIn.Add(int64(n))
+	now := time.Now().UnixNano()
+	s.lastActivity.Store(now)
+	s.lastInput.Store(now)
+}
+
+// lastInputTime returns when the user last typed, the start of the session before
+func (s *Session) lastInputTime() time.Time {
+	return time.Unix(0, s.lastInput.Load())
+}
+
+func (s *Session) addOut(n int) {
+	if s == nil {
+		return
+	}
+	s.bytesOut.Add(int64(n))
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// SessionManager keeps track of the live terminal sessions of this replica.
+// All methods of a nil SessionManager do nothing.
+type SessionManager struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewSessionManager returns an empty SessionManager
+func NewSessionManager() *SessionManager {
+	return &SessionManager{sessions: map[string]*Session{}}
+}
+
+func (m *SessionManager) register(s *Session) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.info.ID] = s
+}
+
+func (m *SessionManager) unregister(id string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+}
+
+// Get returns the session with id
+func (m *SessionManager) Get(id string) (*Session, bool) {
+	if m == nil {
+		return nil, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	return s, ok
+}
+
+// List returns the live sessions, oldest first
+func (m *SessionManager) List() []SessionInfo {
+	if m == nil {
+		return nil
+	}
+	m.mu.RLock()
+	infos := make([]SessionInfo, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		infos = append(infos, s.Info())
+	}
+	m.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartTime.Before(infos[j].StartTime)
+	})
+	return infos
+}
+
+// Terminate tells the client of session id why it is disconnected and cancels the session
+func (m *SessionManager) Terminate(id, reason string) error {
+	s, ok := m.Get(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	zlog.LogInfof("Terminating session %s of %s: %s", id, s.info.User, reason)
+	s.stop(reason)
+	return nil
+}
+
+// stop tells the client why the session is disconnected and cancels the session
+func (s *Session) stop(reason string) {
+	s.stopReason.Store(&reason)
+	if s.window != nil {
+		s.window.sendMessage(reason)
+	}
+	s.cancel()
+}
+
+// closeReason returns why the session was stopped, reason when it was not
+func (s *Session) closeReason(reason string) string {
+	if s == nil {
+		return reason
+	}
+	if stopped := s.stopReason.Load(); stopped != nil {
+		return *stopped
+	}
+	return reason
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package webterminal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestSessionManager(t *testing.T) {
+	conn := setupWebSockerServer(t)
+	defer conn.Close()
+
+	manager := NewSessionManager()
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "path", "testpath"))
+	defer cancel()
+	window := &Window{conn: conn, sizeChan: make(chan remotecommand.TerminalSize, 1), ctx: ctx,
+		terminaler: &terminaler{}}
+	start := time.Now().Add(-time.Minute)
+	session := newSession(SessionInfo{ID: "s1", User: "alice", ClientIP: "10.0.0.1", StartTime: start},
+		cancel, window)
+	window.session = session
+	manager.register(session)
+	manager.register(newSession(SessionInfo{ID: "s0", StartTime: start.Add(-time.Hour)}, func() {}, nil))
+
+	require.NoError(t, conn.WriteJSON(Message{Op: "stdin", Data: "ls\r"}))
+	_, err := window.Read(make([]byte, 16))
+	require.NoError(t, err)
+	_, err = window.Write([]byte("a.txt\r\n"))
+	require.NoError(t, err)
+	var echoed Message
+	require.NoError(t, conn.ReadJSON(&echoed))
+
+	infos := manager.List()
+	require.Len(t, infos, 2)
+	assert.Equal(t, "s0", infos[0].ID, "oldest first")
+	got := infos[1]
+	assert.Equal(t, int64(3), got.BytesIn)
+	assert.Equal(t, int64(7), got.BytesOut)
+	assert.Equal(t, "10.0.0.1", got.ClientIP)
+	assert.True(t, got.LastActivity.After(start))
+
+	assert.ErrorIs(t, manager.Terminate("missing", "bye"), ErrSessionNotFound)
+	require.NoError(t, manager.Terminate("s1", "Session terminated by administrator root"))
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	var msg Message
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, Message{Op: "disconnect", Data: "Session terminated by administrator root"}, msg)
+
+	manager.unregister("s1")
+	_, ok := manager.Get("s1")
+	assert.False(t, ok)
+}
+
+func TestNilSessionManager(t *testing.T) {
+	var manager *SessionManager
+	manager.register(newSession(SessionInfo{ID: "s1"}, func() {}, nil))
+	manager.unregister("s1")
+	assert.Empty(t, manager.List())
+	assert.ErrorIs(t, manager.Terminate("s1", "bye"), ErrSessionNotFound)
+
+	var session *Session
+	session.addIn(1)
+	session.addOut(1)
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	// ShareModeView lets viewers watch the terminal
+	ShareModeView = "view"
+	// ShareModeDrive additionally merges the stdin of viewers into the session
+	ShareModeDrive = "drive"
+
+	// viewerBacklog is the number of messages queued for a viewer before it is dropped as too slow
+	viewerBacklog = 256
+	// scrollbackSize bounds the recent output replayed to a viewer when it joins
+	scrollbackSize = 16 << 10
+)
+
+var (
+	// ErrShareNotFound is returned for unknown, revoked or expired share tokens
+	ErrShareNotFound = errors.New("share not found")
+	// ErrInvalidShareMode is returned for share modes other than view and drive
+	ErrInvalidShareMode = errors.New("invalid share mode")
+	// ErrSessionEnded is returned when joining a session that has finished
+	ErrSessionEnded = errors.New("session ended")
+)
+
+// Share grants access to a live session to whoever presents its token
+type Share struct {
+	Token     string    `json:"token"`
+	Mode      string    `json:"mode"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CreateShare creates a share of the session that expires after ttl
+func (s *Session) CreateShare(mode string, ttl time.Duration) (Share, error) {
+	if mode != ShareModeView && mode != ShareModeDrive {
+		return Share{}, ErrInvalidShareMode
+	}
+	token, err := randomToken()
+	if err != nil {
+		return Share{}, err
+	}
+	share := Share{
+		Token:     token,
+		Mode:      mode,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	s.sharesMu.Lock()
+	defer s.sharesMu.Unlock()
+	if s.shares == nil {
+		s.shares = map[string]Share{}
+	}
+	for token, existing := range s.shares {
+		if !time.Now().Before(existing.ExpiresAt) {
+			delete(s.shares, token)
+		}
+	}
+	s.shares[share.Token] = share
+	return share, nil
+}
+
+// RevokeShares invalidates every share of the session and disconnects its viewers
+func (s *Session) RevokeShares() {
+	s.sharesMu.Lock()
+	s.shares = nil
+	s.sharesMu.Unlock()
+	if s.window != nil {
+		s.window.closeViewers("Sharing stopped by the owner")
+	}
+}
+
+// Share returns the unexpired share with token
+func (s *Session) Share(token string) (Share, bool) {
+	s.sharesMu.Lock()
+	defer s.sharesMu.Unlock()
+	share, ok := s.shares[token]
+	if !ok || !time.Now().Before(share.ExpiresAt) {
+		return Share{}, false
+	}
+	return share, true
+}
+
+// Join attaches conn of user to the session through the share with token. It returns once the
+// viewer leaves, the owner revokes the shares or the session ends.
+func (s *Session) Join(ctx context.Context, token, user string, conn *websocket.Conn) error {
+	share, ok := s.Share(token)
+	if !ok {
+		return ErrShareNotFound
+	}
+	w := s.window
+	v := &viewer{user: user, mode: share.Mode, conn: conn,
+		send: make(chan Message, viewerBacklog), done: make(chan struct{})}
+	if w == nil || !w.attach(v) {
+		return ErrSessionEnded
+	}
+	zlog.LogInfof("User %s joined session %s of %s (%s)", user, s.info.ID, s.info.User, share.Mode)
+	w.logAudit(s.joinEvent(ctx, audit.TypeSessionJoin, user, share.Mode))
+	w.notify(fmt.Sprintf("%s joined your session (%s)", user, modeLabel(share.Mode)))
+
+	go v.writeLoop(w)
+	v.readLoop(ctx, w)
+	<-v.done
+
+	zlog.LogInfof("User %s left session %s of %s", user, s.info.ID, s.info.User)
+	w.logAudit(s.joinEvent(ctx, audit.TypeSessionLeave, user, share.Mode))
+	w.notify(fmt.Sprintf("%s left your session", user))
+	return nil
+}
+
+// joinEvent returns an audit event of type typ on the session, done by user joining it with mode
+func (s *Session) joinEvent(ctx context.Context, typ, user, mode string) audit.Event {
+	event := auditEvent(ctx, typ, s.info)
+	event.User = user
+	event.Details = map[string]string{"mode": mode, "owner": s.info.User}
+	return event
+}
+
+func modeLabel(mode string) string {
+	if mode == ShareModeDrive {
+		return "co-driving"
+	}
+	return "read-only"
+}
+
+// viewer is a connection attached to the window of another user
+type viewer struct {
+	user string
+	mode string
+	conn *websocket.Conn
+	// send queues the messages for the viewer, it is closed when the viewer is detached
+	send chan Message
+	// done is closed once the viewer connection is closed
+	done chan struct{}
+}
+
+// writeLoop sends the queued messages to the viewer and closes its connection once detached
+func (v *viewer) writeLoop(w *Window) {
+	defer close(v.done)
+	failed := false
+	for msg := range v.send {
+		if failed {
+			continue
+		}
+		if err := writeFrame(v.conn, msg); err != nil {
+			zlog.LogWarnf("Failed to write to viewer %s: %v", v.user, err)
+			failed = true
+			w.detach(v, "")
+		}
+	}
+	if err := v.conn.Close(); err != nil {
+		zlog.LogWarn("failed to close websocket: ", err)
+	}
+}
+
+// readLoop forwards the stdin of a co-driving viewer until its connection fails
+func (v *viewer) readLoop(ctx context.Context, w *Window) {
+	defer w.detach(v, "")
+	for {
+		msg, err := readFrame(v.conn)
+		if err != nil {
+			return
+		}
+		if msg.Op != OpStdin || v.mode != ShareModeDrive {
+			// the terminal size follows the owner, viewers cannot resize it
+			continue
+		}
+		select {
+		case w.input <- msg.Data:
+		case <-w.ctx.Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finish queues the disconnect reason and ends the queue, viewersMu must be held
+func (v *viewer) finish(reason string) {
+	if reason != "" {
+		select {
+		case v.send <- Message{Op: OpDisconnect, Data: reason}:
+		default:
+		}
+	}
+	close(v.send)
+}
+
+// attach adds v to the viewers and queues the recent output for it, it fails once the window is closed
+func (w *Window) attach(v *viewer) bool {
+	w.viewersMu.Lock()
+	defer w.viewersMu.Unlock()
+	if w.closed || w.input == nil {
+		return false
+	}
+	if w.viewers == nil {
+		w.viewers = map[*viewer]struct{}{}
+	}
+	w.viewers[v] = struct{}{}
+	if len(w.scrollback) > 0 {
+		msg := w.lastOutput
+		msg.Data = string(w.scrollback)
+		v.send <- msg
+	}
+	return true
+}
+
+// detach removes v from the viewers, telling it reason when not empty
+func (w *Window) detach(v *viewer, reason string) {
+	w.viewersMu.Lock()
+	defer w.viewersMu.Unlock()
+	if _, ok := w.viewers[v]; !ok {
+		return
+	}
+	delete(w.viewers, v)
+	v.finish(reason)
+}
+
+// stopSharing detaches every viewer and refuses new ones, the session has ended
+func (w *Window) stopSharing() {
+	w.viewersMu.Lock()
+	w.closed = true
+	w.viewersMu.Unlock()
+	w.closeViewers("Session ended")
+}
+
+// closeViewers detaches every viewer
+func (w *Window) closeViewers(reason string) {
+	w.viewersMu.Lock()
+	defer w.viewersMu.Unlock()
+	for v := range w.viewers {
+		delete(w.viewers, v)
+		v.finish(reason)
+	}
+}
+
+// broadcast sends an output message to the viewers and keeps the output for viewers joining later.
+// Viewers that cannot keep up are dropped rather than slowing down the session.
+func (w *Window) broadcast(msg Message, output []byte) {
+	if w.input == nil {
+		return
+	}
+	w.viewersMu.Lock()
+	defer w.viewersMu.Unlock()
+	w.lastOutput = msg
+	w.scrollback = append(w.scrollback, output...)
+	if excess := len(w.scrollback) - scrollbackSize; excess > 0 {
+		for excess < len(w.scrollback) && !utf8.RuneStart(w.scrollback[excess]) {
+			excess++
+		}
+		w.scrollback = append(w.scrollback[:0], w.scrollback[excess:]...)
+	}
+	for v := range w.viewers {
+		select {
+		case v.send <- msg:
+		default:
+			zlog.LogWarnf("Dropping viewer %s that cannot keep up", v.user)
+			delete(w.viewers, v)
+			v.finish("")
+		}
+	}
+}
+
+// viewerCount returns the number of attached viewers
+func (w *Window) viewerCount() int {
+	w.viewersMu.Lock()
+	defer w.viewersMu.Unlock()
+	return len(w.viewers)
+}
+
+// notify shows text to the owner of the window
+func (w *Window) notify(text string) {
+	w.viewersMu.Lock()
+	closed := w.closed
+	w.viewersMu.Unlock()
+	if closed {
+		return
+	}
+	if err := w.Toast(text); err != nil {
+		zlog.LogWarnf("Failed to notify session owner: %v", err)
+	}
+}
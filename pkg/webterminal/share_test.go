@@ -0,0 +1,182 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package webterminal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// newConnPair returns the server and client side of a websocket connection
+func newConnPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	serverConns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		serverConns <- conn
+	}))
+	t.Cleanup(server.Close)
+	client, _, err := websocket.DefaultDialer.Dial(strings.Replace(server.URL, "http", "ws", 1), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	serverConn := <-serverConns
+	t.Cleanup(func() { serverConn.Close() })
+	return serverConn, client
+}
+
+func newSharedSession(t *testing.T) (*Session, *websocket.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	conn, owner := newConnPair(t)
+	window := &Window{conn: conn, sizeChan: make(chan remotecommand.TerminalSize, 1), ctx: ctx,
+		terminaler: &terminaler{}, input: make(chan string)}
+	session := newSession(SessionInfo{ID: "s1", User: "alice", StartTime: time.Now()}, cancel, window)
+	window.session = session
+	return session, owner
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) Message {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var msg Message
+	require.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func TestSessionShares(t *testing.T) {
+	session := newSession(SessionInfo{ID: "s1", User: "alice"}, func() {}, nil)
+
+	_, err := session.CreateShare("edit", time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidShareMode)
+
+	view, err := session.CreateShare(ShareModeView, time.Hour)
+	require.NoError(t, err)
+	drive, err := session.CreateShare(ShareModeDrive, time.Hour)
+	require.NoError(t, err)
+	assert.NotEqual(t, view.Token, drive.Token)
+	expired, err := session.CreateShare(ShareModeView, -time.Second)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+		found bool
+	}{
+		{name: "view", token: view.Token, want: ShareModeView, found: true},
+		{name: "drive", token: drive.Token, want: ShareModeDrive, found: true},
+		{name: "expired", token: expired.Token},
+		{name: "unknown", token: "guess"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := session.Share(tt.token)
+			assert.Equal(t, tt.found, ok)
+			assert.Equal(t, tt.want, got.Mode)
+		})
+	}
+
+	session.RevokeShares()
+	_, ok := session.Share(view.Token)
+	assert.False(t, ok)
+}
+
+func TestSessionJoinDrive(t *testing.T) {
+	session, owner := newSharedSession(t)
+	window := session.window
+	_, err := window.Write([]byte("$ "))
+	require.NoError(t, err)
+	assert.Equal(t, "$ ", readMessage(t, owner).Data)
+
+	share, err := session.CreateShare(ShareModeDrive, time.Hour)
+	require.NoError(t, err)
+	conn, bob := newConnPair(t)
+	joined := make(chan error, 1)
+	go func() { joined <- session.Join(context.Background(), share.Token, "bob", conn) }()
+
+	// the viewer catches up with the recent output and the owner is told about it
+	assert.Equal(t, Message{Op: "stdout", Data: "$ ", Rows: defaultRows, Cols: defaultCols}, readMessage(t, bob))
+	assert.Equal(t, Message{Op: "toast", Data: "bob joined your session (co-driving)"}, readMessage(t, owner))
+	assert.Equal(t, 1, session.Info().Viewers)
+
+	_, err = window.Write([]byte("ls"))
+	require.NoError(t, err)
+	assert.Equal(t, "ls", readMessage(t, owner).Data)
+	assert.Equal(t, "ls", readMessage(t, bob).Data)
+
+	require.NoError(t, bob.WriteJSON(Message{Op: "stdin", Data: "pwd\r"}))
+	buffer := make([]byte, 16)
+	n, err := window.Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "pwd\r", string(buffer[:n]))
+	assert.Equal(t, int64(4), session.Info().BytesIn)
+
+	session.RevokeShares()
+	assert.Equal(t, Message{Op: "disconnect", Data: "Sharing stopped by the owner"}, readMessage(t, bob))
+	require.NoError(t, <-joined)
+	assert.Equal(t, Message{Op: "toast", Data: "bob left your session"}, readMessage(t, owner))
+	assert.Equal(t, 0, session.Info().Viewers)
+}
+
+func TestSessionJoinView(t *testing.T) {
+	session, owner := newSharedSession(t)
+	window := session.window
+	share, err := session.CreateShare(ShareModeView, time.Hour)
+	require.NoError(t, err)
+	conn, bob := newConnPair(t)
+	joined := make(chan error, 1)
+	go func() { joined <- session.Join(context.Background(), share.Token, "bob", conn) }()
+	assert.Equal(t, "bob joined your session (read-only)", readMessage(t, owner).Data)
+
+	// input of a read-only viewer is dropped, the owner's input is read
+	require.NoError(t, bob.WriteJSON(Message{Op: "stdin", Data: "rm -rf /\r"}))
+	require.NoError(t, owner.WriteJSON(Message{Op: "stdin", Data: "id\r"}))
+	buffer := make([]byte, 16)
+	n, err := window.Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "id\r", string(buffer[:n]))
+
+	window.Close("Process finished")
+	assert.Equal(t, Message{Op: "disconnect", Data: "Session ended"}, readMessage(t, bob))
+	require.NoError(t, <-joined)
+
+	_, err = session.CreateShare(ShareModeView, time.Hour)
+	require.NoError(t, err)
+	assert.ErrorIs(t, session.Join(context.Background(), share.Token, "bob", conn), ErrSessionEnded)
+	assert.ErrorIs(t, session.Join(context.Background(), "guess", "bob", conn), ErrShareNotFound)
+}
+
+func TestWindowBroadcastScrollback(t *testing.T) {
+	w := &Window{input: make(chan string)}
+	chunk := strings.Repeat("日", 1000)
+	for i := 0; i < 10; i++ {
+		w.broadcast(Message{}, []byte(chunk))
+	}
+	assert.LessOrEqual(t, len(w.scrollback), scrollbackSize)
+	assert.Greater(t, len(w.scrollback), scrollbackSize-utf8.UTFMax)
+	assert.True(t, utf8.Valid(w.scrollback))
+
+	// windows that cannot be shared keep no output
+	unshared := &Window{}
+	unshared.broadcast(Message{}, []byte(chunk))
+	assert.Empty(t, unshared.scrollback)
+}
@@ -25,6 +25,9 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/core/v1"
 	apierr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -38,6 +41,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/metrics"
+	"openfuyao.com/web-terminal-service/pkg/recording"
+	"openfuyao.com/web-terminal-service/pkg/tracing"
 	"openfuyao.com/web-terminal-service/pkg/zlog"
 )
 
@@ -46,7 +53,9 @@ type HandleInterface interface {
 	// HandleTerminal 处理与指定Pod容器的终端交互
 	HandleTerminal(ctx context.Context, namespace, podName, containerName string, conn *websocket.Conn)
 	// HandleCusterTerminal 定义 user Pod交互terminal的方法
-	HandleCusterTerminal(ctx context.Context, username string, conn *websocket.Conn)
+	HandleCusterTerminal(ctx context.Context, username, profile string, conn *websocket.Conn)
+	// Exec 在指定Pod容器中以非交互方式执行命令并返回其输出和退出码
+	Exec(ctx context.Context, namespace, podName, containerName string, req ExecRequest) (*ExecResult, error)
 }
 
 const (
@@ -64,12 +73,96 @@ type execOptions struct {
 	stderr        bool
 	tty           bool
 	persuo        Persuo
+	// impersonate is the identity the exec request is sent as, empty for the service account
+	impersonate rest.ImpersonationConfig
 }
 
 type terminaler struct {
 	client    kubernetes.Interface
 	config    *rest.Config
 	MgrClient client.Client
+	// recordings stores session recordings, nil disables recording
+	recordings recording.RecordingStore
+	// sessions tracks live sessions, nil disables tracking
+	sessions *SessionManager
+	// sessionCfg holds the session settings, nil disables resuming sessions
+	sessionCfg *SessionCfg
+	// homeCfg holds the home directory settings, nil gives cluster terminals no persistent home
+	homeCfg *HomeCfg
+	// auditor writes the audit log of the sessions, nil disables auditing
+	auditor *audit.Logger
+	// policies checks the command lines typed in sessions, nil lets every line run
+	policies *commandPolicies
+	// execCfg holds the settings of the commands run without a terminal, nil applies the defaults
+	execCfg *ExecCfg
+}
+
+// Option configures optional terminaler behaviour
+type Option func(*terminaler)
+
+// WithRecordingStore records every terminal session into store
+func WithRecordingStore(store recording.RecordingStore) Option {
+	return func(t *terminaler) {
+		t.recordings = store
+	}
+}
+
+// WithSessionCfg applies cfg to every terminal session
+func WithSessionCfg(cfg *SessionCfg) Option {
+	return func(t *terminaler) {
+		t.sessionCfg = cfg
+	}
+}
+
+// WithHomeCfg gives cluster terminals the persistent home directories described by cfg
+func WithHomeCfg(cfg *HomeCfg) Option {
+	return func(t *terminaler) {
+		t.homeCfg = cfg
+	}
+}
+
+// WithSessionManager registers every terminal session with sessions
+func WithSessionManager(sessions *SessionManager) Option {
+	return func(t *terminaler) {
+		t.sessions = sessions
+	}
+}
+
+// WithAuditLogger writes the lifecycle of every terminal session to the audit log of auditor
+func WithAuditLogger(auditor *audit.Logger) Option {
+	return func(t *terminaler) {
+		t.auditor = auditor
+	}
+}
+
+// commandLog returns the commandLog of the session of info shown in window, nil when its command lines are
+// neither audited nor checked
+func (t *terminaler) commandLog(ctx context.Context, info SessionInfo, window *Window) *commandLog {
+	if t.auditor == nil && t.policies == nil {
+		return nil
+	}
+	commands := newCommandLog(auditEvent(ctx, audit.TypeCommand, info), t.auditor.Log)
+	commands.notify = window.notify
+	if t.policies != nil {
+		groups, _ := ctx.Value("groups").([]string)
+		commands.check = func(line commandLine) *commandVerdict {
+			return t.policies.checkLine(ctx, groups, info.Namespace, line)
+		}
+	}
+	return commands
+}
+
+// activityInterval returns how often the activity of a cluster terminal is written at most
+func (t *terminaler) activityInterval() time.Duration {
+	if t.sessionCfg == nil {
+		return defaultActivityInterval
+	}
+	return t.sessionCfg.ActivityInterval
+}
+
+// resumable reports whether sessions outlive the websocket of their client
+func (t *terminaler) resumable() bool {
+	return t.sessionCfg != nil && t.sessionCfg.ResumeGracePeriod > 0
 }
 
 // Persuo is an interface that implements io.Reader, io.Writer and remotecommand.TerminalSizeQueue
@@ -80,8 +173,16 @@ type Persuo interface {
 }
 
 // NewTerminal creates a new terminaler instance with the provided client, config, and mgrclient.
-func NewTerminal(client kubernetes.Interface, config *rest.Config, mgrclient client.Client) HandleInterface {
-	return &terminaler{client: client, config: config, MgrClient: mgrclient}
+func NewTerminal(client kubernetes.Interface, config *rest.Config, mgrclient client.Client,
+	opts ...Option) HandleInterface {
+	t := &terminaler{client: client, config: config, MgrClient: mgrclient}
+	if mgrclient != nil {
+		t.policies = newCommandPolicies(mgrclient)
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
 func isPodReady(pod *v1.Pod) bool {
@@ -95,10 +196,44 @@ func isPodReady(pod *v1.Pod) bool {
 
 func (t *terminaler) HandleTerminal(ctx context.Context, namespace, podName,
 	containerName string, conn *websocket.Conn) {
+	t.handleTerminal(ctx, namespace, podName, containerName, conn, t.sessionLimits(nil), nil)
+}
+
+// handleTerminal connects conn to a shell in the container, the session ends once it exceeds limits.
+// keepAlive, when not nil, is applied to every connection a resumed session swaps in.
+func (t *terminaler) handleTerminal(ctx context.Context, namespace, podName, containerName string,
+	conn *websocket.Conn, limits sessionLimits, keepAlive func(conn *websocket.Conn)) {
 	var err error
-	terminalWindow := &Window{conn: conn, sizeChan: make(chan remotecommand.TerminalSize), ctx: ctx, terminaler: t}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	terminalWindow := &Window{conn: conn, sizeChan: make(chan remotecommand.TerminalSize), ctx: ctx, terminaler: t,
+		input: make(chan string), keepAlive: keepAlive, setupStart: time.Now()}
+	if t.resumable() {
+		terminalWindow.grace = t.sessionCfg.ResumeGracePeriod
+		terminalWindow.missed = newRingBuffer(t.sessionCfg.ResumeBufferSize)
+		terminalWindow.reattached = make(chan struct{}, 1)
+	}
+	if username, ok := ctx.Value("username").(string); ok && isClusterTerminal(ctx) {
+		key := types.NamespacedName{Name: UserPodName(username), Namespace: UserPodNamespace}
+		terminalWindow.activity = newActivityTracker(t.MgrClient, key, t.activityInterval())
+		defer terminalWindow.activity.close()
+	}
 
-	supportedShell := t.getShell(ctx, namespace, podName, containerName)
+	impersonate, err := impersonationFor(ctx)
+	if err != nil {
+		zlog.LogErrorf("Refusing to exec into %s/%s: %v", namespace, podName, err)
+		terminalWindow.Close(err.Error())
+		return
+	}
+	if t.sessionCfg != nil && t.sessionCfg.MaxTransferSize > 0 {
+		target := execOptions{namespace: namespace, podName: podName, containerName: containerName,
+			impersonate: impersonate}
+		terminalWindow.files = newFileTransfers(ctx, t, target, t.sessionCfg.MaxTransferSize, terminalWindow.send)
+	}
+
+	detectStart := time.Now()
+	supportedShell := t.getShell(ctx, namespace, podName, containerName, impersonate)
+	metrics.ShellDetectionDuration.Observe(time.Since(detectStart).Seconds())
 	if supportedShell == "" {
 		zlog.LogErrorf("No valid shell found in the container")
 		WriteErr := conn.WriteMessage(websocket.TextMessage, []byte("404 LogError:  No valid shell found in the container"))
@@ -109,6 +244,38 @@ func (t *terminaler) HandleTerminal(ctx context.Context, namespace, podName,
 		return
 	}
 
+	info := newSessionInfo(ctx, sessionIDFrom(ctx), namespace, podName, containerName)
+	recorder, err := t.startRecording(ctx, info)
+	if err != nil {
+		zlog.LogErrorf("Refusing unrecorded session to %s/%s: %v", namespace, podName, err)
+		terminalWindow.Close("Session recording unavailable")
+		return
+	}
+	defer func() {
+		if closeErr := recorder.Close(); closeErr != nil {
+			zlog.LogErrorf("Failed to save session recording: %v", closeErr)
+		}
+	}()
+	terminalWindow.recorder = recorder
+
+	session := newSession(info, cancel, terminalWindow)
+	terminalWindow.session = session
+	t.sessions.register(session)
+	defer t.sessions.unregister(info.ID)
+	go session.enforceLimits(ctx, limits)
+	t.auditor.Log(auditEvent(ctx, audit.TypeSessionOpen, info))
+	terminalWindow.commands = t.commandLog(ctx, info, terminalWindow)
+	metrics.ActiveSessions.WithLabelValues(info.Kind).Inc()
+	defer func() {
+		metrics.ActiveSessions.WithLabelValues(info.Kind).Dec()
+		metrics.SessionDuration.WithLabelValues(info.Kind).Observe(time.Since(info.StartTime).Seconds())
+	}()
+	if terminalWindow.grace > 0 {
+		if err = session.sendHello(); err != nil {
+			zlog.LogWarnf("Failed to send resume token of session %s: %v", info.ID, err)
+		}
+	}
+
 	options := execOptions{
 		namespace:     namespace,
 		podName:       podName,
@@ -119,9 +286,16 @@ func (t *terminaler) HandleTerminal(ctx context.Context, namespace, podName,
 		stderr:        true,
 		tty:           true,
 		persuo:        terminalWindow,
+		impersonate:   impersonate,
 	}
 
+	execEvent := auditEvent(ctx, audit.TypeExecStart, info)
+	execEvent.Details = map[string]string{"command": supportedShell}
+	t.auditor.Log(execEvent)
+	_, terminalWindow.setup = tracing.Start(ctx, "executePodExec", trace.WithAttributes(
+		semconv.K8SNamespaceName(namespace), semconv.K8SPodName(podName), semconv.ContainerName(containerName)))
 	err = t.startProcess(ctx, options)
+	terminalWindow.endSetup(err)
 
 	if err != nil && !errors.Is(err, context.Canceled) {
 		zlog.LogErrorf("Shell execution failed: %v", err)
@@ -149,17 +323,24 @@ func (t *terminaler) startProcess(ctx context.Context, options execOptions) erro
 	return err
 }
 
-func (t *terminaler) getShell(ctx context.Context, namespace, podName, containerName string) string {
+func (t *terminaler) getShell(ctx context.Context, namespace, podName, containerName string,
+	impersonate rest.ImpersonationConfig) string {
+	ctx, span := tracing.Start(ctx, "getShell", trace.WithAttributes(semconv.K8SNamespaceName(namespace),
+		semconv.K8SPodName(podName), semconv.ContainerName(containerName)))
+	defer span.End()
 	shells := []string{"bash", "sh"}
 	for _, shell := range shells {
-		if t.shellExists(ctx, namespace, podName, containerName, shell) {
+		if t.shellExists(ctx, namespace, podName, containerName, shell, impersonate) {
+			span.SetAttributes(attribute.String("shell", shell))
 			return shell
 		}
 	}
 	return ""
 }
 
-func (t *terminaler) shellExists(ctx context.Context, namespace, podName, containerName, shell string) bool {
+func (t *terminaler) shellExists(ctx context.Context, namespace, podName, containerName, shell string,
+	impersonate rest.ImpersonationConfig) bool {
+	ctx, span := tracing.Start(ctx, "shellExists", trace.WithAttributes(attribute.String("shell", shell)))
 	cmd := []string{"which", shell}
 	options := execOptions{
 		namespace:     namespace,
@@ -170,11 +351,13 @@ func (t *terminaler) shellExists(ctx context.Context, namespace, podName, contai
 		stdout:        true,
 		stderr:        true,
 		tty:           false,
+		impersonate:   impersonate,
 	}
 
 	exec, err := t.executePodExec(options)
 	if err != nil {
 		zlog.LogWarnf("Failed to create exec executor for shell check: %v", err)
+		tracing.End(span, err)
 		return false
 	}
 
@@ -183,6 +366,7 @@ func (t *terminaler) shellExists(ctx context.Context, namespace, podName, contai
 		Stdout: &output,
 		Stderr: os.Stderr,
 	})
+	tracing.End(span, err)
 	if err != nil {
 		zlog.LogWarnf("Failed to execute command %s: %v", shell, err)
 		return false
@@ -206,7 +390,14 @@ func (t *terminaler) executePodExec(options execOptions) (remotecommand.Executor
 		TTY:       options.tty,
 	}, scheme.ParameterCodec)
 
-	exec, err := remotecommand.NewSPDYExecutor(t.config, "POST", req.URL())
+	config := t.config
+	if options.impersonate.UserName != "" {
+		// 以终端用户身份执行 exec，使 RBAC 和审计日志作用于真实用户
+		config = rest.CopyConfig(t.config)
+		config.Impersonate = options.impersonate
+	}
+
+	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
 	if err != nil {
 		zlog.LogWarnf("Failed to create exec executor: %v", err)
 		return nil, err
@@ -215,22 +406,109 @@ func (t *terminaler) executePodExec(options execOptions) (remotecommand.Executor
 	return exec, nil
 }
 
-func (t *terminaler) HandleCusterTerminal(ctx context.Context, username string, conn *websocket.Conn) {
-	var err error
+// newSessionInfo describes a session that is about to start
+func newSessionInfo(ctx context.Context, sessionID, namespace, podName, containerName string) SessionInfo {
+	user, _ := ctx.Value("user").(string)
+	clientIP, _ := ctx.Value("clientIP").(string)
+	info := SessionInfo{
+		ID:        sessionID,
+		User:      user,
+		Namespace: namespace,
+		Pod:       podName,
+		Container: containerName,
+		Kind:      recording.KindPod,
+		ClientIP:  clientIP,
+		StartTime: time.Now(),
+	}
+	if isClusterTerminal(ctx) {
+		info.Kind = recording.KindCluster
+	}
+	return info
+}
+
+// startRecording begins recording the session, it returns a nil Recorder when recording is disabled
+func (t *terminaler) startRecording(ctx context.Context, info SessionInfo) (*recording.Recorder, error) {
+	if t.recordings == nil {
+		return nil, nil
+	}
+	meta := recording.Metadata{
+		ID:        info.ID,
+		User:      info.User,
+		Namespace: info.Namespace,
+		Pod:       info.Pod,
+		Container: info.Container,
+		Kind:      info.Kind,
+		StartTime: info.StartTime,
+	}
+	w, err := t.recordings.Create(ctx, meta)
+	if err != nil {
+		return nil, err
+	}
+	recorder, err := recording.NewRecorder(w, meta)
+	if err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	zlog.LogInfof("Recording session %s of %s to %s/%s", info.ID, info.User, info.Namespace, info.Pod)
+	return recorder, nil
+}
+
+// impersonationFor returns the identity pod exec requests of ctx are sent as. Cluster terminals
+// exec into the user pod managed by this service and keep the service account identity.
+func impersonationFor(ctx context.Context) (rest.ImpersonationConfig, error) {
+	if isClusterTerminal(ctx) {
+		return rest.ImpersonationConfig{}, nil
+	}
+	user, _ := ctx.Value("user").(string)
+	if user == "" {
+		return rest.ImpersonationConfig{}, errors.New("no authenticated user to impersonate")
+	}
+	groups, _ := ctx.Value("groups").([]string)
+	return rest.ImpersonationConfig{UserName: user, Groups: groups}, nil
+}
+
+// isClusterTerminal reports whether ctx belongs to a cluster terminal session
+func isClusterTerminal(ctx context.Context) bool {
+	path, _ := ctx.Value("path").(string)
+	return strings.Contains(path, KubectlApi)
+}
+
+// HandleCusterTerminal connects conn to the terminal pod of username, creating it from the named
+// profile when the user has none. Without a profile name conn attaches to the running pod whatever its profile.
+func (t *terminaler) HandleCusterTerminal(ctx context.Context, username, profileName string,
+	conn *websocket.Conn) {
+	profile, err := t.clusterProfile(ctx, profileName)
+	if err != nil {
+		zlog.LogWarnf("Refusing cluster terminal of %s: %v", username, err)
+		sendTerminalError(conn, err.Error())
+		return
+	}
 	webTerminalTemplate := &v1beta1.WebterminalTemplate{}
-	user := fmt.Sprintf("%s-%s", "openfuyao", username)
+	user := UserPodName(username)
 
 	err = t.MgrClient.Get(ctx, types.NamespacedName{Name: user, Namespace: UserPodNamespace}, webTerminalTemplate)
 	if err != nil {
 		zlog.LogInfof("not get user pod", err)
-		t.CreateUserPod(ctx, user)
-		t.startSessionWithPing(ctx, UserPodNamespace, user, UserContainerName, conn)
+		t.CreateUserPod(ctx, user, profile)
+		t.startSessionWithPing(ctx, UserPodNamespace, user, UserContainerName, conn, t.sessionLimits(profile))
 		return
 	}
 
 	if webTerminalTemplate != nil && webTerminalTemplate.ObjectMeta.DeletionTimestamp.IsZero() {
 		zlog.LogInfof("CR already exists and is not being deleted. Skipping creation.")
-		t.startSessionWithPing(ctx, UserPodNamespace, user, UserContainerName, conn)
+		if running := templateProfile(webTerminalTemplate); running != profile.Name {
+			if profileName != "" {
+				sendTerminalError(conn, fmt.Sprintf("cluster terminal already runs %s", describeProfile(running)))
+				return
+			}
+			// the user may have lost access to the profile since the pod was created from it
+			if profile, err = t.clusterProfile(ctx, running); err != nil {
+				zlog.LogWarnf("Refusing cluster terminal of %s: %v", username, err)
+				sendTerminalError(conn, err.Error())
+				return
+			}
+		}
+		t.startSessionWithPing(ctx, UserPodNamespace, user, UserContainerName, conn, t.sessionLimits(profile))
 		return
 	}
 
@@ -258,17 +536,21 @@ func (t *terminaler) HandleCusterTerminal(ctx context.Context, username string,
 		}
 	}
 	// 等待删除完后创建新的CR
-	t.CreateUserPod(ctx, user)
-	t.startSessionWithPing(ctx, UserPodNamespace, user, UserContainerName, conn)
+	t.CreateUserPod(ctx, user, profile)
+	t.startSessionWithPing(ctx, UserPodNamespace, user, UserContainerName, conn, t.sessionLimits(profile))
 
 }
 
-func (t *terminaler) CreateUserPod(ctx context.Context, user string) { // 定义好user pod模板，创建CR
-	webTemplate := template(user)
+// CreateUserPod 定义好user pod模板，创建CR
+func (t *terminaler) CreateUserPod(ctx context.Context, user string, profile *v1beta1.WebTerminalProfile) {
+	ctx, span := tracing.Start(ctx, "CreateUserPod", trace.WithAttributes(semconv.K8SPodName(user)))
+	webTemplate := template(ctx, user, profile, t.homeCfg.volume())
 	kubectlPod := &v1.Pod{}
 	err := wait.PollUntilContextTimeout(ctx, period, time.Minute, false,
 		func(ctx context.Context) (done bool, err error) {
 			err = t.MgrClient.Get(ctx, types.NamespacedName{Name: user, Namespace: "openfuyao-system"}, kubectlPod)
+			span.AddEvent("poll", trace.WithAttributes(attribute.String("pod.phase", string(kubectlPod.Status.Phase)),
+				attribute.Bool("pod.found", err == nil)))
 			if err != nil {
 				if apierr.IsNotFound(err) {
 					creErr := t.MgrClient.Create(ctx, webTemplate)
@@ -288,6 +570,7 @@ func (t *terminaler) CreateUserPod(ctx context.Context, user string) { // 定义
 			zlog.LogInfof("get pod success!")
 			return true, nil
 		})
+	tracing.End(span, err)
 
 	if err != nil {
 		zlog.LogErrorf("LogError creating v1beta1 cluster object : %v", err)
@@ -296,16 +579,30 @@ func (t *terminaler) CreateUserPod(ctx context.Context, user string) { // 定义
 
 }
 
-func (t *terminaler) startSessionWithPing(ctx context.Context, namespace, podName, containerName string, conn *websocket.Conn) {
+func (t *terminaler) startSessionWithPing(ctx context.Context, namespace, podName, containerName string,
+	conn *websocket.Conn, limits sessionLimits) {
 	// 定义上下文和 Ping 定时器
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	keepAlive := func(conn *websocket.Conn) { t.keepAlive(ctx, cancel, conn) }
+	keepAlive(conn)
+	t.handleTerminal(ctx, namespace, podName, containerName, conn, limits, keepAlive)
+}
+
+// keepAlive pings the client on conn and installs its pong and close handlers. A ping or close that ends
+// the connection cancels the session unless it may be resumed.
+func (t *terminaler) keepAlive(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn) {
 	// 定期发送 Ping 消息
-	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+	pingCtx, stopPing := context.WithCancel(ctx)
+	go wait.UntilWithContext(pingCtx, func(ctx context.Context) {
 		if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingPeriod)); err != nil {
 			zlog.LogErrorf("Failed to send ping message: %v", err)
-			cancel()
+			if !t.resumable() {
+				cancel()
+			}
+			// a resumable session waits for its client once the window notices the closed connection
+			stopPing()
 			_ = conn.Close()
 		}
 	}, pingSend)
@@ -320,8 +617,10 @@ func (t *terminaler) startSessionWithPing(ctx context.Context, namespace, podNam
 	})
 	conn.SetCloseHandler(func(code int, text string) error {
 		zlog.LogInfof("WebSocket connection closed: code %d, %s", code, text)
-		// 取消上下文，停止后台任务
-		cancel()
+		// 取消上下文，停止后台任务; a resumable session survives the client going away
+		if code == websocket.CloseNormalClosure || !t.resumable() {
+			cancel()
+		}
 		// 确保发送 Close 帧
 		err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 		if err != nil {
@@ -330,8 +629,6 @@ func (t *terminaler) startSessionWithPing(ctx context.Context, namespace, podNam
 
 		return nil
 	})
-
-	t.HandleTerminal(ctx, namespace, podName, containerName, conn)
 }
 
 func getImagePath(filePath string) (string, error) {
@@ -342,91 +639,91 @@ func getImagePath(filePath string) (string, error) {
 	return string(content), nil
 }
 
-func template(user string) *v1beta1.WebterminalTemplate {
-	imagePath, err := getImagePath(ImagePath)
-	if err != nil {
-		zlog.LogFatalf("Failed to read image path: %v", err)
+// template returns the WebterminalTemplate of the cluster terminal pod named user, built from profile
+// for the user authenticated in ctx. The pod mounts home as the home directory when it is not nil.
+func template(ctx context.Context, user string, profile *v1beta1.WebTerminalProfile,
+	home *v1beta1.HomeVolume) *v1beta1.WebterminalTemplate {
+	image := profile.Spec.Image
+	if image == "" {
+		imagePath, err := getImagePath(ImagePath)
+		if err != nil {
+			zlog.LogFatalf("Failed to read image path: %v", err)
+		}
+		image = strings.TrimSpace(imagePath)
+	}
+	zlog.LogInfof("Creating pod for user: %s with image: %s \n", user, image)
+	// the containers leave their image empty, the controller fills in DefaultImage
+	pod := createPodTemplate(user, profile.Spec, home != nil)
+	identity, _ := ctx.Value("user").(string)
+	// the API server adds system groups such as system:authenticated itself, they may not be impersonated
+	userGroups, _ := ctx.Value("groups").([]string)
+	var groups []string
+	for _, group := range userGroups {
+		if !v1beta1.IsSystemIdentity(group) {
+			groups = append(groups, group)
+		}
 	}
-	zlog.LogInfof("Creating pod for user: %s with image: %s \n", user, imagePath)
-	imagePath = strings.TrimSpace(imagePath)
-	pod := createPodTemplate(user, imagePath)
 
-	return &v1beta1.WebterminalTemplate{
+	webTerminalTemplate := &v1beta1.WebterminalTemplate{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      user,
 			Namespace: UserPodNamespace,
 		},
 		Spec: v1beta1.WebterminalTemplateSpec{
-			PodTemplate: *pod,
+			DefaultImage:   image,
+			SessionTimeout: profile.Spec.SessionTimeout,
+			User:           identity,
+			Groups:         groups,
+			Home:           home,
+			PodTemplate:    *pod,
 		},
 	}
+	if profile.Name != "" {
+		webTerminalTemplate.Labels = map[string]string{ProfileLabel: profile.Name}
+	}
+	return webTerminalTemplate
 }
 
-func createPodTemplate(user, imagePath string) *v1beta1.PodTemplate {
-	podUser := int64(65532)
-
+func createPodTemplate(user string, profile v1beta1.WebTerminalProfileSpec, home bool) *v1beta1.PodTemplate {
+	volumes := createVolumes(user)
+	if home {
+		volumes = append(volumes, createHomeVolume(user))
+	}
 	return &v1beta1.PodTemplate{
 		ObjectMeta: v1beta1.PodTemplateObjectMeta{
 			Name:      user,
 			Namespace: UserPodNamespace,
 		},
 		Spec: v1beta1.PodTemplateSpec{
-			InitContainers: createInitContainers(imagePath),
-			Containers:     createMainContainers(imagePath, podUser),
-			Volumes:        createVolumes(),
+			InitContainers: profile.InitContainers,
+			Containers:     createMainContainers(profile, home),
+			Volumes:        append(volumes, profile.Volumes...),
+			Tolerations:    profile.Tolerations,
+			NodeSelector:   profile.NodeSelector,
 		},
 	}
 }
 
-func createInitContainers(imagePath string) []v1.Container {
-	return []v1.Container{
+func createMainContainers(profile v1beta1.WebTerminalProfileSpec, home bool) []v1.Container {
+	env := []v1.EnvVar{
 		{
-			Name:    "init-kubeconfig",
-			Image:   imagePath,
-			Command: []string{"sh", "-c"},
-			Args: []string{
-				`mkdir -p /mnt/.kube && cp /etc/kubernetes/config /mnt/.kube/config && 
-				chmod 644 /mnt/.kube/config && export KUBECONFIG=/mnt/.kube/config && 
-				kubectl config set-context --current --namespace=default`,
-			},
-			VolumeMounts: getInitContainerVolumeMounts(),
-			SecurityContext: &v1.SecurityContext{
-				RunAsUser: new(int64),
-			},
+			Name:  "KUBECONFIG",
+			Value: kubeconfigDir + "/config",
 		},
 	}
-}
-
-func getInitContainerVolumeMounts() []v1.VolumeMount {
-	return []v1.VolumeMount{
-		{
-			Name:      "kubeconfig",
-			MountPath: "/mnt/.kube",
-		},
-		{
-			Name:      "config-source",
-			MountPath: "/etc/kubernetes",
-		},
+	mounts := getMainContainerVolumeMounts()
+	if home {
+		env = append(env, v1.EnvVar{Name: "HOME", Value: homeDir})
+		mounts = append(mounts, v1.VolumeMount{Name: homeVolume, MountPath: homeDir})
 	}
-}
-
-func createMainContainers(imagePath string, podUser int64) []v1.Container {
 	return []v1.Container{
 		{
 			Name:            UserContainerName,
-			Image:           imagePath,
-			ImagePullPolicy: v1.PullIfNotPresent,
-			Env: []v1.EnvVar{
-				{
-					Name:  "KUBECONFIG",
-					Value: "/mnt/.kube/config",
-				},
-			},
-			VolumeMounts: getMainContainerVolumeMounts(),
-			SecurityContext: &v1.SecurityContext{
-				RunAsUser:  &podUser,
-				RunAsGroup: new(int64),
-			},
+			ImagePullPolicy: profile.ImagePullPolicy,
+			Env:             append(env, profile.Env...),
+			Resources:       profile.Resources,
+			VolumeMounts:    append(mounts, profile.VolumeMounts...),
+			SecurityContext: profile.SecurityContext,
 		},
 	}
 }
@@ -434,26 +731,34 @@ func createMainContainers(imagePath string, podUser int64) []v1.Container {
 func getMainContainerVolumeMounts() []v1.VolumeMount {
 	return []v1.VolumeMount{
 		{
-			Name:      "kubeconfig",
-			MountPath: "/mnt/.kube",
+			Name:      kubeconfigVolume,
+			MountPath: kubeconfigDir,
+			ReadOnly:  true,
 		},
 	}
 }
 
-func createVolumes() []v1.Volume {
+// createVolumes returns the volumes of every terminal pod, the kubeconfig the controller issues for user
+func createVolumes(user string) []v1.Volume {
 	return []v1.Volume{
 		{
-			Name: "kubeconfig",
+			Name: kubeconfigVolume,
 			VolumeSource: v1.VolumeSource{
-				EmptyDir: &v1.EmptyDirVolumeSource{},
+				Secret: &v1.SecretVolumeSource{
+					SecretName: v1beta1.KubeconfigSecretName(user),
+				},
 			},
 		},
-		{
-			Name: "config-source",
-			VolumeSource: v1.VolumeSource{
-				HostPath: &v1.HostPathVolumeSource{
-					Path: "/root/.kube",
-				},
+	}
+}
+
+// createHomeVolume returns the volume of the home directory the controller provisions for user
+func createHomeVolume(user string) v1.Volume {
+	return v1.Volume{
+		Name: homeVolume,
+		VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+				ClaimName: v1beta1.HomeClaimName(user),
 			},
 		},
 	}
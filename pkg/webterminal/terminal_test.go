@@ -13,14 +13,23 @@ package webterminal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"reflect"
 	"testing"
 
 	"github.com/agiledragon/gomonkey/v2"
 	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
@@ -31,6 +40,7 @@ import (
 	faker "sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/recording"
 )
 
 func CreateFakeClient() client.Client {
@@ -66,6 +76,7 @@ func TestNewTerminal(t *testing.T) {
 				client:    client,
 				config:    config,
 				MgrClient: fakeMgrClient,
+				policies:  newCommandPolicies(fakeMgrClient),
 			},
 		},
 	}
@@ -76,6 +87,38 @@ func TestNewTerminal(t *testing.T) {
 			}
 		})
 	}
+
+	store, _ := recording.NewLocalStore(t.TempDir())
+	got := NewTerminal(client, config, fakeMgrClient, WithRecordingStore(store)).(*terminaler)
+	if got.recordings != store {
+		t.Errorf("NewTerminal() did not apply WithRecordingStore")
+	}
+}
+
+func TestTerminalerStartRecording(t *testing.T) {
+	recorder, err := (&terminaler{}).startRecording(context.Background(),
+		newSessionInfo(context.Background(), "s1", "default", "web", "app"))
+	if err != nil || recorder != nil {
+		t.Errorf("startRecording() without store = %v, %v, want nil, nil", recorder, err)
+	}
+
+	store, _ := recording.NewLocalStore(t.TempDir())
+	term := &terminaler{recordings: store}
+	ctx := context.WithValue(context.WithValue(context.Background(), "path", KubectlApi+"bob/terminal"), "user", "bob")
+	recorder, err = term.startRecording(ctx, newSessionInfo(ctx, "s2", UserPodNamespace, UserPodName("bob"),
+		UserContainerName))
+	if err != nil {
+		t.Fatalf("startRecording() error = %v", err)
+	}
+	_ = recorder.Close()
+	meta, err := store.Stat(ctx, "s2")
+	if err != nil || meta.Kind != recording.KindCluster || meta.User != "bob" {
+		t.Errorf("startRecording() stored %+v, %v", meta, err)
+	}
+
+	if _, err = term.startRecording(ctx, newSessionInfo(ctx, "s2", "default", "web", "app")); err == nil {
+		t.Errorf("startRecording() must fail when the recording can not be created")
+	}
 }
 
 func TestIsPodReady(t *testing.T) {
@@ -175,7 +218,7 @@ func TestTerminalerCreateUserPod(t1 *testing.T) {
 				config:    tt.fields.config,
 				MgrClient: tt.fields.MgrClient,
 			}
-			t.CreateUserPod(tt.args.ctx, tt.args.user)
+			t.CreateUserPod(tt.args.ctx, tt.args.user, builtinProfile())
 		})
 	}
 }
@@ -265,6 +308,57 @@ func (m *mockExecutor) Stream(options remotecommand.StreamOptions) error {
 	return nil
 }
 
+// recordSpans installs a tracer provider recording the spans ended until t is done
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	provider := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(provider) })
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}
+
+// shellExecutor runs which in a container that only has sh
+type shellExecutor struct {
+	shell string
+}
+
+func (e *shellExecutor) StreamWithContext(ctx context.Context, options remotecommand.StreamOptions) error {
+	if e.shell != "sh" {
+		return errors.New("command terminated with exit code 1")
+	}
+	_, err := options.Stdout.Write([]byte("/bin/sh"))
+	return err
+}
+
+func (e *shellExecutor) Stream(options remotecommand.StreamOptions) error {
+	return e.StreamWithContext(context.Background(), options)
+}
+
+func TestTerminalerGetShellTraces(t *testing.T) {
+	recorder := recordSpans(t)
+	serviceConfig := &rest.Config{Host: "https://kubernetes.default.svc"}
+	term := &terminaler{client: kubernetes.NewForConfigOrDie(serviceConfig), config: serviceConfig}
+	patch := gomonkey.ApplyFunc(remotecommand.NewSPDYExecutor,
+		func(config *rest.Config, method string, url *url.URL) (remotecommand.Executor, error) {
+			command := url.Query()["command"]
+			return &shellExecutor{shell: command[len(command)-1]}, nil
+		})
+	defer patch.Reset()
+
+	assert.Equal(t, "sh", term.getShell(context.Background(), "default", "nginx", "nginx",
+		rest.ImpersonationConfig{}))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	assert.Equal(t, "shellExists", spans[0].Name())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "shellExists", spans[1].Name())
+	assert.Equal(t, codes.Unset, spans[1].Status().Code)
+	assert.Equal(t, "getShell", spans[2].Name())
+	assert.Contains(t, spans[2].Attributes(), attribute.String("shell", "sh"))
+	assert.Equal(t, spans[2].SpanContext().SpanID(), spans[0].Parent().SpanID())
+}
+
 func TestTerminalerStartSessionWithPing(t1 *testing.T) {
 	conn := setupWebSockerServer(t1)
 	defer conn.Close()
@@ -327,7 +421,80 @@ func TestTerminalerStartSessionWithPing(t1 *testing.T) {
 					fmt.Println("success")
 				})
 			defer patch.Reset()
-			t.startSessionWithPing(tt.args.ctx, tt.args.namespace, tt.args.podName, tt.args.containerName, tt.args.conn)
+			t.startSessionWithPing(tt.args.ctx, tt.args.namespace, tt.args.podName, tt.args.containerName, tt.args.conn,
+				sessionLimits{})
 		})
 	}
 }
+
+func TestImpersonationFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		want    rest.ImpersonationConfig
+		wantErr bool
+	}{
+		{
+			name: "pod terminal",
+			ctx: context.WithValue(context.WithValue(context.WithValue(context.Background(),
+				"path", "/rest/webterminal/v1/namespace/default/pod/p/container/c/terminal"),
+				"user", "alice"), "groups", []string{"sre"}),
+			want: rest.ImpersonationConfig{UserName: "alice", Groups: []string{"sre"}},
+		},
+		{
+			name:    "pod terminal without user",
+			ctx:     context.WithValue(context.Background(), "path", "/rest/webterminal/v1/namespace/default"),
+			wantErr: true,
+		},
+		{
+			name: "cluster terminal keeps service identity",
+			ctx:  context.WithValue(context.WithValue(context.Background(), "path", KubectlApi+"alice/terminal"), "user", "alice"),
+			want: rest.ImpersonationConfig{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := impersonationFor(tt.ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("impersonationFor() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("impersonationFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminalerExecutePodExecImpersonation(t *testing.T) {
+	serviceConfig := &rest.Config{Host: "https://kubernetes.default.svc"}
+	term := &terminaler{client: kubernetes.NewForConfigOrDie(serviceConfig), config: serviceConfig}
+
+	var used *rest.Config
+	patch := gomonkey.ApplyFunc(remotecommand.NewSPDYExecutor,
+		func(config *rest.Config, method string, url *url.URL) (remotecommand.Executor, error) {
+			used = config
+			return &mockExecutor{}, nil
+		})
+	defer patch.Reset()
+
+	impersonate := rest.ImpersonationConfig{UserName: "alice", Groups: []string{"sre"}}
+	_, err := term.executePodExec(execOptions{namespace: "default", podName: "p", impersonate: impersonate})
+	if err != nil {
+		t.Fatalf("executePodExec() error = %v", err)
+	}
+	if !reflect.DeepEqual(used.Impersonate, impersonate) {
+		t.Errorf("executor impersonates %v, want %v", used.Impersonate, impersonate)
+	}
+	if serviceConfig.Impersonate.UserName != "" {
+		t.Errorf("service config must not be modified")
+	}
+
+	_, err = term.executePodExec(execOptions{namespace: "default", podName: "p"})
+	if err != nil {
+		t.Fatalf("executePodExec() error = %v", err)
+	}
+	if used != serviceConfig {
+		t.Errorf("exec without impersonation should use the service config")
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+// messages of the session timeouts
+const (
+	idleWarning        = "The session is disconnected for inactivity in %s."
+	idleDisconnect     = "Connection closed due to inactivity."
+	maxDurationWarning = "The session reaches its maximum duration in %s."
+	maxDurationEnd     = "Connection closed as the session reached its maximum duration."
+)
+
+// sessionLimits bounds how long a session lasts, zero limits do not apply
+type sessionLimits struct {
+	idle time.Duration
+	max  time.Duration
+	// warnings are how long before a timeout the user is warned, longest first
+	warnings []time.Duration
+}
+
+// sessionLimits returns the limits of sessions, the ones of profile take precedence for cluster terminals
+func (t *terminaler) sessionLimits(profile *v1beta1.WebTerminalProfile) sessionLimits {
+	var limits sessionLimits
+	if t.sessionCfg != nil {
+		limits = sessionLimits{idle: t.sessionCfg.IdleTimeout, max: t.sessionCfg.MaxDuration,
+			warnings: t.sessionCfg.TimeoutWarnings}
+	}
+	if profile != nil && profile.Spec.IdleTimeout != nil {
+		limits.idle = profile.Spec.IdleTimeout.Duration
+	}
+	if profile != nil && profile.Spec.MaxSessionDuration != nil {
+		limits.max = profile.Spec.MaxSessionDuration.Duration
+	}
+	return limits
+}
+
+func (l sessionLimits) enabled() bool {
+	return l.idle > 0 || l.max > 0
+}
+
+// deadline returns when a session started at start and last used at lastInput ends, and whether it ends
+// for inactivity
+func (l sessionLimits) deadline(start, lastInput time.Time) (time.Time, bool) {
+	var deadline time.Time
+	idle := false
+	if l.idle > 0 {
+		deadline, idle = lastInput.Add(l.idle), true
+	}
+	if l.max > 0 {
+		if end := start.Add(l.max); deadline.IsZero() || end.Before(deadline) {
+			deadline, idle = end, false
+		}
+	}
+	return deadline, idle
+}
+
+// enforceLimits disconnects the session once it exceeds limits, warning its user before the cutoff.
+// It returns when the session ends.
+func (s *Session) enforceLimits(ctx context.Context, limits sessionLimits) {
+	if !limits.enabled() {
+		return
+	}
+	var warnedFor time.Time
+	// warned is the shortest warning sent for the deadline warnedFor, 0 before the first one
+	var warned time.Duration
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		deadline, idle := limits.deadline(s.info.StartTime, s.lastInputTime())
+		if !deadline.Equal(warnedFor) {
+			// input moved the deadline, the warnings start over
+			warnedFor, warned = deadline, 0
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			s.end(idle)
+			return
+		}
+		due, next := limits.warning(remaining, warned)
+		if due > 0 {
+			warned = due
+			s.warn(idle, remaining)
+		}
+		timer.Reset(remaining - next)
+	}
+}
+
+// warning returns the shortest warning due with remaining time left that is shorter than warned, 0 when none
+// is, and the warning after it
+func (l sessionLimits) warning(remaining, warned time.Duration) (time.Duration, time.Duration) {
+	var due, next time.Duration
+	for _, warning := range l.warnings {
+		if warning >= remaining && (warned == 0 || warning < warned) {
+			due = warning
+		} else if warning < remaining && next == 0 {
+			next = warning
+		}
+	}
+	return due, next
+}
+
+func (s *Session) warn(idle bool, remaining time.Duration) {
+	format := maxDurationWarning
+	if idle {
+		format = idleWarning
+	}
+	if s.window == nil {
+		return
+	}
+	if err := s.window.Toast(fmt.Sprintf(format, remaining.Round(time.Second))); err != nil {
+		zlog.LogWarnf("Failed to warn session %s of its timeout: %v", s.info.ID, err)
+	}
+}
+
+// end tells the client why the session timed out and stops it
+func (s *Session) end(idle bool) {
+	reason := maxDurationEnd
+	if idle {
+		reason = idleDisconnect
+	}
+	zlog.LogInfof("Disconnecting session %s of %s: %s", s.info.ID, s.info.User, reason)
+	s.stop(reason)
+}
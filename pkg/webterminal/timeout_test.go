@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"openfuyao.com/web-terminal-service/api/v1beta1"
+)
+
+func TestTerminalerSessionLimits(t *testing.T) {
+	cfg := &SessionCfg{IdleTimeout: 15 * time.Minute, MaxDuration: 8 * time.Hour,
+		TimeoutWarnings: []time.Duration{time.Minute}}
+	profile := &v1beta1.WebTerminalProfile{Spec: v1beta1.WebTerminalProfileSpec{
+		IdleTimeout: &metav1.Duration{Duration: time.Hour}, MaxSessionDuration: &metav1.Duration{}}}
+
+	tests := []struct {
+		name    string
+		cfg     *SessionCfg
+		profile *v1beta1.WebTerminalProfile
+		want    sessionLimits
+	}{
+		{name: "no config", want: sessionLimits{}},
+		{name: "global limits", cfg: cfg, want: sessionLimits{idle: 15 * time.Minute, max: 8 * time.Hour,
+			warnings: []time.Duration{time.Minute}}},
+		{name: "profile without limits", cfg: cfg, profile: &v1beta1.WebTerminalProfile{},
+			want: sessionLimits{idle: 15 * time.Minute, max: 8 * time.Hour, warnings: []time.Duration{time.Minute}}},
+		{name: "profile limits", cfg: cfg, profile: profile,
+			want: sessionLimits{idle: time.Hour, warnings: []time.Duration{time.Minute}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, (&terminaler{sessionCfg: tt.cfg}).sessionLimits(tt.profile))
+		})
+	}
+}
+
+func TestSessionLimitsDeadline(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		limits    sessionLimits
+		lastInput time.Time
+		want      time.Time
+		wantIdle  bool
+	}{
+		{name: "idle", limits: sessionLimits{idle: time.Hour}, lastInput: start.Add(time.Hour),
+			want: start.Add(2 * time.Hour), wantIdle: true},
+		{name: "max duration", limits: sessionLimits{max: time.Hour}, lastInput: start.Add(time.Hour),
+			want: start.Add(time.Hour)},
+		{name: "idle first", limits: sessionLimits{idle: time.Hour, max: 8 * time.Hour}, lastInput: start,
+			want: start.Add(time.Hour), wantIdle: true},
+		{name: "max duration first", limits: sessionLimits{idle: time.Hour, max: 8 * time.Hour},
+			lastInput: start.Add(7*time.Hour + 30*time.Minute), want: start.Add(8 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, idle := tt.limits.deadline(start, tt.lastInput)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantIdle, idle)
+		})
+	}
+}
+
+func TestSessionLimitsWarning(t *testing.T) {
+	limits := sessionLimits{warnings: []time.Duration{5 * time.Minute, time.Minute}}
+	tests := []struct {
+		name      string
+		remaining time.Duration
+		warned    time.Duration
+		wantDue   time.Duration
+		wantNext  time.Duration
+	}{
+		{name: "far from the deadline", remaining: time.Hour, wantNext: 5 * time.Minute},
+		{name: "first warning", remaining: 5 * time.Minute, wantDue: 5 * time.Minute, wantNext: time.Minute},
+		{name: "late start", remaining: 3 * time.Minute, wantDue: 5 * time.Minute, wantNext: time.Minute},
+		{name: "first warning sent", remaining: 3 * time.Minute, warned: 5 * time.Minute, wantNext: time.Minute},
+		{name: "last warning", remaining: time.Minute, warned: 5 * time.Minute, wantDue: time.Minute},
+		{name: "skipped warnings", remaining: 30 * time.Second, wantDue: time.Minute},
+		{name: "every warning sent", remaining: 30 * time.Second, warned: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			due, next := limits.warning(tt.remaining, tt.warned)
+			assert.Equal(t, tt.wantDue, due)
+			assert.Equal(t, tt.wantNext, next)
+		})
+	}
+}
+
+func TestSessionEnforceLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newSession(SessionInfo{ID: "s1", StartTime: time.Now()}, cancel, nil)
+	limits := sessionLimits{idle: 300 * time.Millisecond, warnings: []time.Duration{100 * time.Millisecond}}
+	done := make(chan struct{})
+	go func() {
+		s.enforceLimits(ctx, limits)
+		close(done)
+	}()
+
+	// input keeps the session alive
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		s.addIn(1)
+	}
+	assert.NoError(t, ctx.Err())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the idle session was not ended")
+	}
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+}
+
+func TestSessionEnforceLimitsDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newSession(SessionInfo{ID: "s1", StartTime: time.Now().Add(-time.Hour)}, cancel, nil)
+	s.enforceLimits(ctx, sessionLimits{warnings: []time.Duration{time.Minute}})
+	assert.NoError(t, ctx.Err())
+}
@@ -0,0 +1,385 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package webterminal
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/remotecommand"
+
+	"openfuyao.com/web-terminal-service/pkg/zlog"
+)
+
+const (
+	// progressInterval is the number of bytes transferred between two progress messages
+	progressInterval = 1 << 20
+	// downloadChunkSize bounds the payload of a download-chunk message
+	downloadChunkSize = 32 << 10
+	// maxStderrSize bounds the error output of tar kept for error messages
+	maxStderrSize = 4 << 10
+	// cleanupTimeout bounds the removal of a partial upload
+	cleanupTimeout = 10 * time.Second
+	uploadSuffix   = ".wts-upload"
+)
+
+// TransferRequest is the data of upload-begin and download messages
+type TransferRequest struct {
+	// Path is the absolute path of the file in the container
+	Path string `json:"path"`
+	// Size and SHA256 (hex encoded) describe the uploaded file, they are required for uploads
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// TransferStatus is the data of transfer-progress, transfer-done and transfer-error messages
+type TransferStatus struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+	// Total is the size of the file, when known
+	Total  int64  `json:"total,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// runFunc runs cmd in the container of the session
+type runFunc func(ctx context.Context, cmd []string, stdin io.Reader, stdout io.Writer) error
+
+// fileTransfers moves files between the client and the container of a session, one file at a time.
+// The file travels as a tar stream through a separate exec call, like kubectl cp does.
+type fileTransfers struct {
+	ctx     context.Context
+	run     runFunc
+	maxSize int64
+	send    func(Message) error
+
+	mu   sync.Mutex
+	busy bool
+	// upload is only touched by the reader of the window
+	upload *upload
+}
+
+type upload struct {
+	req      TransferRequest
+	tmp      string
+	pipe     *io.PipeWriter
+	tw       *tar.Writer
+	hash     hash.Hash
+	written  int64
+	reported int64
+	// done is closed once the extracting tar exits with err
+	done chan struct{}
+	err  error
+}
+
+func newFileTransfers(ctx context.Context, t *terminaler, target execOptions, maxSize int64,
+	send func(Message) error) *fileTransfers {
+	run := func(ctx context.Context, cmd []string, stdin io.Reader, stdout io.Writer) error {
+		options := target
+		options.cmd = cmd
+		options.stdin, options.stdout, options.stderr, options.tty = stdin != nil, stdout != nil, true, false
+		exec, err := t.executePodExec(options)
+		if err != nil {
+			return err
+		}
+		stderr := &limitedBuffer{limit: maxStderrSize}
+		err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{Stdin: stdin, Stdout: stdout, Stderr: stderr})
+		if err != nil && stderr.Len() > 0 {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	return &fileTransfers{ctx: ctx, run: run, maxSize: maxSize, send: send}
+}
+
+// handle processes a transfer message of the client
+func (f *fileTransfers) handle(msg Message) {
+	switch msg.Op {
+	case OpUploadBegin:
+		f.beginUpload(msg.Data)
+	case OpUploadChunk:
+		f.writeUpload([]byte(msg.Data))
+	case OpUploadEnd:
+		f.endUpload()
+	case OpDownload:
+		f.download(msg.Data)
+	}
+}
+
+func (f *fileTransfers) acquire() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.busy {
+		return false
+	}
+	f.busy = true
+	return true
+}
+
+func (f *fileTransfers) release() {
+	f.mu.Lock()
+	f.busy = false
+	f.mu.Unlock()
+}
+
+func (f *fileTransfers) status(op string, status TransferStatus) {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	if err = f.send(Message{Op: op, Data: string(data)}); err != nil {
+		zlog.LogWarnf("Failed to send %s of %s: %v", op, status.Path, err)
+	}
+}
+
+func (f *fileTransfers) fail(filePath string, bytes int64, err error) {
+	zlog.LogWarnf("File transfer of %s failed: %v", filePath, err)
+	f.status(OpTransferError, TransferStatus{Path: filePath, Bytes: bytes, Error: err.Error()})
+}
+
+// parseTransferRequest reads and checks the request of an upload or download
+func parseTransferRequest(data string) (TransferRequest, error) {
+	var req TransferRequest
+	if err := json.Unmarshal([]byte(data), &req); err != nil {
+		return req, fmt.Errorf("invalid transfer request: %v", err)
+	}
+	if !path.IsAbs(req.Path) {
+		return req, fmt.Errorf("path %q must be absolute", req.Path)
+	}
+	req.Path = path.Clean(req.Path)
+	if base := path.Base(req.Path); base == "/" || base == "." || base == ".." {
+		return req, fmt.Errorf("path %q must name a file", req.Path)
+	}
+	return req, nil
+}
+
+func (f *fileTransfers) beginUpload(data string) {
+	req, err := parseTransferRequest(data)
+	if err == nil {
+		err = f.checkUpload(req)
+	}
+	if err != nil {
+		f.fail(req.Path, 0, err)
+		return
+	}
+	if !f.acquire() {
+		f.fail(req.Path, 0, errors.New("another file transfer is in progress"))
+		return
+	}
+
+	dir, name := path.Split(req.Path)
+	tmpName := "." + name + uploadSuffix
+	reader, writer := io.Pipe()
+	u := &upload{req: req, tmp: path.Join(dir, tmpName), pipe: writer, tw: tar.NewWriter(writer),
+		hash: sha256.New(), done: make(chan struct{})}
+	go func() {
+		defer close(u.done)
+		u.err = f.run(f.ctx, []string{"tar", "-xmf", "-", "-C", dir}, reader, nil)
+		reader.CloseWithError(u.err)
+	}()
+	err = u.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: tmpName, Size: req.Size, Mode: 0o644,
+		ModTime: time.Now()})
+	f.upload = u
+	if err != nil {
+		f.abortUpload(err)
+		return
+	}
+	zlog.LogInfof("Uploading %d bytes to %s", req.Size, req.Path)
+	f.status(OpTransferProgress, TransferStatus{Path: req.Path, Total: req.Size})
+}
+
+func (f *fileTransfers) checkUpload(req TransferRequest) error {
+	if req.Size < 0 || req.Size > f.maxSize {
+		return fmt.Errorf("size must be between 0 and %d bytes", f.maxSize)
+	}
+	if sum, err := hex.DecodeString(req.SHA256); err != nil || len(sum) != sha256.Size {
+		return errors.New("sha256 must be the hex encoded SHA-256 checksum of the file")
+	}
+	return nil
+}
+
+func (f *fileTransfers) writeUpload(chunk []byte) {
+	u := f.upload
+	if u == nil {
+		f.fail("", 0, errors.New("no upload in progress"))
+		return
+	}
+	if u.written+int64(len(chunk)) > u.req.Size {
+		f.abortUpload(fmt.Errorf("upload exceeds its declared size of %d bytes", u.req.Size))
+		return
+	}
+	if _, err := u.tw.Write(chunk); err != nil {
+		f.abortUpload(err)
+		return
+	}
+	u.hash.Write(chunk)
+	u.written += int64(len(chunk))
+	if u.written-u.reported >= progressInterval {
+		u.reported = u.written
+		f.status(OpTransferProgress, TransferStatus{Path: u.req.Path, Bytes: u.written, Total: u.req.Size})
+	}
+}
+
+func (f *fileTransfers) endUpload() {
+	u := f.upload
+	if u == nil {
+		f.fail("", 0, errors.New("no upload in progress"))
+		return
+	}
+	if u.written != u.req.Size {
+		f.abortUpload(fmt.Errorf("upload ended after %d of %d bytes", u.written, u.req.Size))
+		return
+	}
+	if err := u.tw.Close(); err != nil {
+		f.abortUpload(err)
+		return
+	}
+	_ = u.pipe.Close()
+	<-u.done
+	if u.err != nil {
+		f.abortUpload(u.err)
+		return
+	}
+	if sum := hex.EncodeToString(u.hash.Sum(nil)); !strings.EqualFold(sum, u.req.SHA256) {
+		f.abortUpload(fmt.Errorf("checksum mismatch, received data has sha256 %s", sum))
+		return
+	}
+	if err := f.run(f.ctx, []string{"mv", "-f", u.tmp, u.req.Path}, nil, nil); err != nil {
+		f.abortUpload(err)
+		return
+	}
+	f.upload = nil
+	f.release()
+	zlog.LogInfof("Uploaded %d bytes to %s", u.written, u.req.Path)
+	f.status(OpTransferDone, TransferStatus{Path: u.req.Path, Bytes: u.written, Total: u.req.Size,
+		SHA256: u.req.SHA256})
+}
+
+// abortUpload stops the upload in progress and removes what reached the container
+func (f *fileTransfers) abortUpload(cause error) {
+	u := f.upload
+	f.upload = nil
+	u.pipe.CloseWithError(cause)
+	<-u.done
+	// the session may be over, the cleanup gets its own deadline
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(f.ctx), cleanupTimeout)
+	defer cancel()
+	if err := f.run(ctx, []string{"rm", "-f", u.tmp}, nil, nil); err != nil {
+		zlog.LogWarnf("Failed to remove partial upload %s: %v", u.tmp, err)
+	}
+	f.release()
+	f.fail(u.req.Path, u.written, cause)
+}
+
+// close aborts an upload left unfinished by the client, it is called by the reader of the window
+func (f *fileTransfers) close() {
+	if f != nil && f.upload != nil {
+		f.abortUpload(errors.New("session ended"))
+	}
+}
+
+func (f *fileTransfers) download(data string) {
+	req, err := parseTransferRequest(data)
+	if err != nil {
+		f.fail(req.Path, 0, err)
+		return
+	}
+	if !f.acquire() {
+		f.fail(req.Path, 0, errors.New("another file transfer is in progress"))
+		return
+	}
+	go func() {
+		defer f.release()
+		sent, sum, err := f.streamDownload(req.Path)
+		if err != nil {
+			f.fail(req.Path, sent, err)
+			return
+		}
+		zlog.LogInfof("Downloaded %d bytes from %s", sent, req.Path)
+		f.status(OpTransferDone, TransferStatus{Path: req.Path, Bytes: sent, Total: sent, SHA256: sum})
+	}()
+}
+
+// streamDownload sends the file at filePath to the client, returning the bytes sent and their checksum
+func (f *fileTransfers) streamDownload(filePath string) (int64, string, error) {
+	dir, name := path.Split(filePath)
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		// ./ keeps names starting with a dash from being read as options
+		writer.CloseWithError(f.run(f.ctx, []string{"tar", "-cf", "-", "-C", dir, "./" + name}, nil, writer))
+	}()
+
+	tr := tar.NewReader(reader)
+	header, err := tr.Next()
+	if err != nil {
+		return 0, "", fmt.Errorf("reading %s: %w", filePath, err)
+	}
+	if header.Typeflag != tar.TypeReg {
+		return 0, "", fmt.Errorf("%s is not a regular file", filePath)
+	}
+	if header.Size > f.maxSize {
+		return 0, "", fmt.Errorf("%s has %d bytes, more than the limit of %d", filePath, header.Size, f.maxSize)
+	}
+	f.status(OpTransferProgress, TransferStatus{Path: filePath, Total: header.Size})
+
+	sum := sha256.New()
+	buffer := make([]byte, downloadChunkSize)
+	var sent, reported int64
+	for {
+		n, readErr := tr.Read(buffer)
+		if n > 0 {
+			if err = f.send(Message{Op: OpDownloadChunk, Data: string(buffer[:n])}); err != nil {
+				return sent, "", err
+			}
+			sum.Write(buffer[:n])
+			sent += int64(n)
+			if sent-reported >= progressInterval {
+				reported = sent
+				f.status(OpTransferProgress, TransferStatus{Path: filePath, Bytes: sent, Total: header.Size})
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return sent, "", readErr
+		}
+	}
+	return sent, hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
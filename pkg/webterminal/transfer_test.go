@@ -0,0 +1,322 @@
+/*
+ * Copyright (c) 2024 Huawei Technologies Co., Ltd.
+ * openFuyao is licensed under Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *          http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND,
+ * EITHER EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT,
+ * MERCHANTABILITY OR FIT FOR A PARTICULAR PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package webterminal
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeContainer runs the commands of file transfers against an in-memory file system
+type fakeContainer struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (c *fakeContainer) run(_ context.Context, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	switch {
+	case cmd[0] == "tar" && cmd[1] == "-xmf":
+		tr := tar.NewReader(stdin)
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			c.set(path.Join(cmd[4], header.Name), data)
+		}
+	case cmd[0] == "tar" && cmd[1] == "-cf":
+		data, ok := c.get(path.Join(cmd[4], cmd[5]))
+		if !ok {
+			return fmt.Errorf("tar: %s: No such file or directory", cmd[5])
+		}
+		tw := tar.NewWriter(stdout)
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: cmd[5], Size: int64(len(data))}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+		return tw.Close()
+	case cmd[0] == "mv":
+		data, _ := c.get(cmd[2])
+		c.remove(cmd[2])
+		c.set(cmd[3], data)
+		return nil
+	case cmd[0] == "rm":
+		c.remove(cmd[2])
+		return nil
+	}
+	return fmt.Errorf("unexpected command %v", cmd)
+}
+
+func (c *fakeContainer) get(name string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.files[name]
+	return data, ok
+}
+
+func (c *fakeContainer) set(name string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[name] = data
+}
+
+func (c *fakeContainer) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.files, name)
+}
+
+func (c *fakeContainer) names() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var names []string
+	for name := range c.files {
+		names = append(names, name)
+	}
+	return names
+}
+
+func newTestTransfers(maxSize int64) (*fileTransfers, *fakeContainer, chan Message) {
+	container := &fakeContainer{files: map[string][]byte{}}
+	sent := make(chan Message, 64)
+	f := &fileTransfers{ctx: context.Background(), run: container.run, maxSize: maxSize,
+		send: func(msg Message) error {
+			sent <- msg
+			return nil
+		}}
+	return f, container, sent
+}
+
+func transferRequest(t *testing.T, req TransferRequest) string {
+	data, err := json.Marshal(req)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// awaitTransfer collects the downloaded chunks until the transfer finishes and returns its final status
+func awaitTransfer(t *testing.T, sent chan Message) (string, Message) {
+	var chunks strings.Builder
+	for {
+		select {
+		case msg := <-sent:
+			switch msg.Op {
+			case OpDownloadChunk:
+				chunks.WriteString(msg.Data)
+			case OpTransferDone, OpTransferError:
+				return chunks.String(), msg
+			}
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "transfer did not finish")
+		}
+	}
+}
+
+func transferStatus(t *testing.T, msg Message) TransferStatus {
+	var status TransferStatus
+	require.NoError(t, json.Unmarshal([]byte(msg.Data), &status))
+	return status
+}
+
+func TestParseTransferRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "file", data: `{"path":"/tmp//dump.hprof"}`, want: "/tmp/dump.hprof"},
+		{name: "dot segments", data: `{"path":"/tmp/a/../b"}`, want: "/tmp/b"},
+		{name: "relative", data: `{"path":"tmp/a"}`, wantErr: true},
+		{name: "root", data: `{"path":"/"}`, wantErr: true},
+		{name: "parent of root", data: `{"path":"/.."}`, wantErr: true},
+		{name: "not json", data: `/tmp/a`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTransferRequest(tt.data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Path)
+		})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	f, container, sent := newTestTransfers(1 << 20)
+	content := "key: value\n"
+	f.handle(Message{Op: OpUploadBegin, Data: transferRequest(t, TransferRequest{Path: "/etc/app/config.yaml",
+		Size: int64(len(content)), SHA256: checksum(content)})})
+	assert.Equal(t, TransferStatus{Path: "/etc/app/config.yaml", Total: int64(len(content))},
+		transferStatus(t, <-sent))
+	f.handle(Message{Op: OpUploadChunk, Data: content[:4]})
+	f.handle(Message{Op: OpUploadChunk, Data: content[4:]})
+	f.handle(Message{Op: OpUploadEnd})
+
+	_, done := awaitTransfer(t, sent)
+	assert.Equal(t, OpTransferDone, done.Op)
+	assert.Equal(t, TransferStatus{Path: "/etc/app/config.yaml", Bytes: int64(len(content)),
+		Total: int64(len(content)), SHA256: checksum(content)}, transferStatus(t, done))
+	got, ok := container.get("/etc/app/config.yaml")
+	assert.True(t, ok)
+	assert.Equal(t, content, string(got))
+	assert.Equal(t, []string{"/etc/app/config.yaml"}, container.names())
+}
+
+func TestUploadFailures(t *testing.T) {
+	content := "0123456789"
+	valid := TransferRequest{Path: "/tmp/data", Size: int64(len(content)), SHA256: checksum(content)}
+	tests := []struct {
+		name    string
+		req     TransferRequest
+		chunks  []string
+		wantErr string
+	}{
+		{name: "checksum mismatch", req: TransferRequest{Path: "/tmp/data", Size: int64(len(content)),
+			SHA256: checksum("9876543210")}, chunks: []string{content}, wantErr: "checksum mismatch"},
+		{name: "more than declared", req: valid, chunks: []string{content, "!"}, wantErr: "exceeds its declared size"},
+		{name: "less than declared", req: valid, chunks: []string{content[:5]}, wantErr: "after 5 of 10 bytes"},
+		{name: "too large", req: TransferRequest{Path: "/tmp/data", Size: 1 << 20, SHA256: checksum(content)},
+			wantErr: "size must be between"},
+		{name: "no checksum", req: TransferRequest{Path: "/tmp/data", Size: 10}, wantErr: "sha256 must be"},
+		{name: "relative path", req: TransferRequest{Path: "data", Size: 10, SHA256: checksum(content)},
+			wantErr: "must be absolute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, container, sent := newTestTransfers(1 << 10)
+			f.handle(Message{Op: OpUploadBegin, Data: transferRequest(t, tt.req)})
+			for _, chunk := range tt.chunks {
+				f.handle(Message{Op: OpUploadChunk, Data: chunk})
+			}
+			f.handle(Message{Op: OpUploadEnd})
+
+			_, failed := awaitTransfer(t, sent)
+			assert.Equal(t, OpTransferError, failed.Op)
+			assert.Contains(t, transferStatus(t, failed).Error, tt.wantErr)
+			assert.Empty(t, container.names(), "partial uploads must be removed")
+			assert.False(t, f.busy)
+		})
+	}
+}
+
+func TestUploadWithoutBegin(t *testing.T) {
+	f, _, sent := newTestTransfers(1 << 10)
+	f.handle(Message{Op: OpUploadChunk, Data: "x"})
+	assert.Equal(t, "no upload in progress", transferStatus(t, <-sent).Error)
+	f.handle(Message{Op: OpUploadEnd})
+	assert.Equal(t, "no upload in progress", transferStatus(t, <-sent).Error)
+}
+
+func TestTransferOneAtATime(t *testing.T) {
+	f, container, sent := newTestTransfers(1 << 10)
+	f.handle(Message{Op: OpUploadBegin, Data: transferRequest(t, TransferRequest{Path: "/tmp/a", Size: 1,
+		SHA256: checksum("a")})})
+	<-sent
+	f.handle(Message{Op: OpDownload, Data: `{"path":"/tmp/b"}`})
+	assert.Equal(t, "another file transfer is in progress", transferStatus(t, <-sent).Error)
+
+	// an upload left behind by the client is removed when the session ends
+	f.close()
+	_, failed := awaitTransfer(t, sent)
+	assert.Equal(t, "session ended", transferStatus(t, failed).Error)
+	assert.Empty(t, container.names())
+}
+
+func TestDownload(t *testing.T) {
+	content := strings.Repeat("heap", downloadChunkSize/2)
+	f, container, sent := newTestTransfers(1 << 20)
+	container.set("/tmp/dump.hprof", []byte(content))
+
+	f.handle(Message{Op: OpDownload, Data: `{"path":"/tmp/dump.hprof"}`})
+	assert.Equal(t, TransferStatus{Path: "/tmp/dump.hprof", Total: int64(len(content))}, transferStatus(t, <-sent))
+	got, done := awaitTransfer(t, sent)
+	assert.Equal(t, OpTransferDone, done.Op)
+	assert.Equal(t, content, got)
+	assert.Equal(t, TransferStatus{Path: "/tmp/dump.hprof", Bytes: int64(len(content)), Total: int64(len(content)),
+		SHA256: checksum(content)}, transferStatus(t, done))
+}
+
+func TestDownloadFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "missing file", path: "/tmp/missing", wantErr: "No such file or directory"},
+		{name: "too large", path: "/tmp/large", wantErr: "more than the limit of 16"},
+		{name: "not absolute", path: "large", wantErr: "must be absolute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, container, sent := newTestTransfers(16)
+			container.set("/tmp/large", make([]byte, 17))
+			f.handle(Message{Op: OpDownload, Data: transferRequest(t, TransferRequest{Path: tt.path})})
+			got, failed := awaitTransfer(t, sent)
+			assert.Empty(t, got)
+			assert.Equal(t, OpTransferError, failed.Op)
+			assert.Contains(t, transferStatus(t, failed).Error, tt.wantErr)
+		})
+	}
+}
+
+func TestWindowTransferDisabled(t *testing.T) {
+	conn, client := newConnPair(t)
+	window := &Window{conn: conn, ctx: context.Background(), terminaler: &terminaler{}}
+	n, err := window.handle(make([]byte, 8), Message{Op: OpDownload, Data: `{"path":"/etc/passwd"}`})
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	msg := readMessage(t, client)
+	assert.Equal(t, OpTransferError, msg.Op)
+	assert.Equal(t, "file transfer is disabled", transferStatus(t, msg).Error)
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{limit: 4}
+	n, err := b.Write([]byte("abc"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = b.Write([]byte("defg"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, "abcd", b.String())
+}
@@ -12,7 +12,10 @@
 
 package webterminal
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 const (
 	endOfWindow = "\u0004"
@@ -35,3 +38,8 @@ const (
 	KubectlApi = "/rest/webterminal/v1/user/"
 	ImagePath  = "/mnt/data/imagePath.txt"
 )
+
+// UserPodName returns the name of the WebterminalTemplate and pod backing the cluster terminal of username
+func UserPodName(username string) string {
+	return fmt.Sprintf("%s-%s", "openfuyao", username)
+}
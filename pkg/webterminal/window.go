@@ -15,16 +15,20 @@ package webterminal
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/client-go/tools/remotecommand"
 
-	"openfuyao.com/web-terminal-service/api/v1beta1"
+	"openfuyao.com/web-terminal-service/pkg/audit"
+	"openfuyao.com/web-terminal-service/pkg/metrics"
+	"openfuyao.com/web-terminal-service/pkg/recording"
+	"openfuyao.com/web-terminal-service/pkg/tracing"
 	"openfuyao.com/web-terminal-service/pkg/zlog"
 )
 
@@ -34,26 +38,108 @@ type Window struct {
 	sizeChan   chan remotecommand.TerminalSize
 	ctx        context.Context
 	terminaler *terminaler
+	// recorder captures the session, nil when recording is disabled
+	recorder *recording.Recorder
+	// session counts the traffic of the window, nil when sessions are not tracked
+	session *Session
+	// activity keeps the template of a cluster terminal alive while it is used, nil for pod terminals
+	activity *activityTracker
+	// setup traces the exec of the shell until its streams are up, nil when it is not traced
+	setup     trace.Span
+	setupOnce sync.Once
+	// setupStart is when the session was accepted, zero when its setup is not measured
+	setupStart time.Time
+	// writeMu serializes writes to conn, websocket connections allow only one writer.
+	// It also guards conn, detached and missed as a resumed session swaps its connection.
+	writeMu sync.Mutex
+
+	// grace keeps the session alive for a resume after its client disconnects, 0 ends it right away
+	grace time.Duration
+	// detached is set while the client is disconnected, missed keeps the output it did not receive
+	detached bool
+	ended    bool
+	missed   *ringBuffer
+	// reattached signals Read that a resumed client replaced conn
+	reattached chan struct{}
+	// keepAlive pings a client swapped in by a resume and handles its pongs and close, nil does neither
+	keepAlive func(conn *websocket.Conn)
+	// rows and cols are the terminal size last requested by the client, guarded by writeMu
+	rows, cols uint16
+
+	// files moves files into and out of the container, nil when file transfers are disabled
+	files *fileTransfers
+	// commands logs and checks the command lines typed into the session, nil when neither is done
+	commands *commandLog
+
+	// input carries stdin of co-driving viewers, nil when the window cannot be shared
+	input chan string
+	// owner holds the result of a read of conn that outlived the Read call which started it
+	owner   chan inbound
+	reading bool
+
+	// viewersMu guards the viewers of a shared window and the output they replay on joining
+	viewersMu  sync.Mutex
+	viewers    map[*viewer]struct{}
+	scrollback []byte
+	lastOutput Message
+	closed     bool
+}
+
+// inbound is the outcome of reading one message of the owner from conn
+type inbound struct {
+	conn *websocket.Conn
+	msg  Message
+	err  error
 }
 
+// defaultRows and defaultCols are reported until the client sizes the terminal
+const (
+	defaultRows = 24
+	defaultCols = 80
+)
+
 // Message 结构体
 type Message struct {
 	Op, Data   string
 	Rows, Cols uint16
 }
 
-// Close closes the window and logs the reason for closing.
+// Close closes the window and logs the reason for closing. A session stopped by a timeout or an
+// administrator is closed for the reason it was stopped.
 func (w *Window) Close(reason string) {
+	reason = w.session.closeReason(reason)
 	zlog.LogInfof("Terminal closed : %s", reason)
+	w.commands.close()
+	w.audit(audit.TypeSessionClose, reason, nil)
+	w.stopSharing()
 	close(w.sizeChan)
-	if err := w.conn.Close(); err != nil {
+	w.writeMu.Lock()
+	w.ended = true
+	conn := w.conn
+	w.writeMu.Unlock()
+	if err := conn.Close(); err != nil {
 		zlog.LogWarn("failed to close websocket: ", err)
 	}
 }
 
+// endSetup ends the span of the exec setup, failed when err is not nil, and observes how long a successful
+// setup took. The exec reads the terminal size and the input only once its streams are up.
+func (w *Window) endSetup(err error) {
+	w.setupOnce.Do(func() {
+		if err == nil && w.session != nil && !w.setupStart.IsZero() {
+			metrics.ExecSetupDuration.WithLabelValues(w.session.info.Kind).Observe(
+				time.Since(w.setupStart).Seconds())
+		}
+		if w.setup != nil {
+			tracing.End(w.setup, err)
+		}
+	})
+}
+
 // Next returns the next terminal size from the size channel.
 // If the size is invalid (both height and width are 0), it returns nil.
 func (w *Window) Next() *remotecommand.TerminalSize {
+	w.endSetup(nil)
 	size := <-w.sizeChan
 	if size.Height == 0 && size.Width == 0 {
 		return nil
@@ -61,111 +147,170 @@ func (w *Window) Next() *remotecommand.TerminalSize {
 	return &size
 }
 
-// Renewtime 更新窗口的某些状态或属性，例如更新终端模板或处理与用户上下文相关的信息。
-func (w *Window) Renewtime() {
-	var err error
-	webTerminalTemplate := &v1beta1.WebterminalTemplate{}
-	podName := "openfuyao-" + w.ctx.Value("username").(string)
-	err = w.terminaler.MgrClient.Get(w.ctx,
-		types.NamespacedName{Name: podName, Namespace: UserPodNamespace}, webTerminalTemplate)
-	if err != nil {
-		zlog.LogInfof("not get user pod", err)
-	}
-	webTerminalTemplate.Spec.RenewTime = metav1.NewTime(time.Now())
-	err = w.terminaler.MgrClient.Update(w.ctx, webTerminalTemplate)
-	zlog.LogInfof("RenewTime updated")
-	if err != nil {
-		zlog.LogInfof("update user pod failed", err)
-	}
-
-}
-
+// Read returns the next input of the owner or, when the window is shared, of a co-driving viewer.
+// When the owner disconnects from a resumable session, Read waits for the owner to resume it.
 func (w *Window) Read(buffer []byte) (int, error) {
-	var msg Message
-	if err := w.conn.ReadJSON(&msg); err != nil {
-		fmt.Println("ReadJSON error:", err)
-		return copy(buffer, endOfWindow), err
+	w.endSetup(nil)
+	if w.owner == nil {
+		w.owner = make(chan inbound, 1)
 	}
+	for {
+		if !w.reading {
+			// the read may outlive this call when a viewer types first, its result is kept for the next call
+			w.reading = true
+			conn := w.currentConn()
+			go func() {
+				msg, err := readFrame(conn)
+				w.owner <- inbound{conn: conn, msg: msg, err: err}
+			}()
+		}
 
-	fmt.Printf("Received message: %+v\n", msg)
+		var in inbound
+		select {
+		case in = <-w.owner:
+			w.reading = false
+		case data := <-w.input:
+			return w.stdin(buffer, data), nil
+		case <-w.ctx.Done():
+			w.files.close()
+			return copy(buffer, endOfWindow), w.ctx.Err()
+		}
+		if in.err != nil {
+			if !errors.Is(in.err, ErrProtocol) && (in.conn != w.currentConn() || w.awaitResume(in.conn, in.err)) {
+				// the failed connection has been replaced, read from the new one
+				continue
+			}
+			zlog.LogInfof("Terminal input ended: %v", in.err)
+			w.files.close()
+			return copy(buffer, endOfWindow), in.err
+		}
+		return w.handle(buffer, in.msg)
+	}
+}
 
+func (w *Window) handle(buffer []byte, msg Message) (int, error) {
 	switch msg.Op {
-	case "stdin":
-		if strings.Contains(w.ctx.Value("path").(string), KubectlApi) {
-			w.Renewtime()
-		}
-		fmt.Println("Processing stdin message")
-		return copy(buffer, msg.Data), nil
-	case "resize":
-		fmt.Println("Processing resize message")
+	case OpStdin:
+		return w.stdin(buffer, msg.Data), nil
+	case OpResize:
+		w.writeMu.Lock()
+		w.cols, w.rows = msg.Cols, msg.Rows
+		w.writeMu.Unlock()
+		w.recorder.Resize(msg.Cols, msg.Rows)
+		w.audit(audit.TypeResize, "", map[string]string{"rows": strconv.Itoa(int(msg.Rows)),
+			"cols": strconv.Itoa(int(msg.Cols))})
 		w.sizeChan <- remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows}
 		return 0, nil
+	case OpUploadBegin, OpUploadChunk, OpUploadEnd, OpDownload:
+		w.transfer(msg)
+		return 0, nil
 	default:
-		fmt.Printf("Unknown message type: %s\n", msg.Op)
+		zlog.LogWarnf("Unknown message type %q", msg.Op)
 		return copy(buffer, endOfWindow), fmt.Errorf("unknown message type '%s'", msg.Op)
 	}
 }
 
-func (w *Window) Write(buffer []byte) (int, error) { // Write 将容器内输出数据传到Websocket
-	message := Message{
-		Op:   "stdout",
-		Data: string(buffer),
-		Rows: 200,
-		Cols: 200,
+// transfer hands a file transfer message to the transfers of the window
+func (w *Window) transfer(msg Message) {
+	if w.files == nil {
+		status, _ := json.Marshal(TransferStatus{Error: "file transfer is disabled"})
+		_ = w.send(Message{Op: OpTransferError, Data: string(status)})
+		return
 	}
-	if strings.Contains(w.ctx.Value("path").(string), KubectlApi) {
-		w.Renewtime()
+	w.files.handle(msg)
+}
+
+func (w *Window) stdin(buffer []byte, data string) int {
+	w.activity.input()
+	data = w.commands.input(data)
+	w.recorder.Input([]byte(data))
+	w.session.addIn(len(data))
+	w.countBytes(metrics.DirectionIn, len(data))
+	return copy(buffer, data)
+}
+
+// countBytes adds n bytes of traffic in direction to the metrics of the kind of the session
+func (w *Window) countBytes(direction string, n int) {
+	kind := recording.KindPod
+	if w.session != nil {
+		kind = w.session.info.Kind
 	}
-	msg, marshalErr := json.Marshal(message)
-	if marshalErr != nil {
-		return 0, marshalErr
+	metrics.SessionBytes.WithLabelValues(kind, direction).Add(float64(n))
+}
+
+// output returns the message carrying output of the terminal, writeMu must be held
+func (w *Window) output(data []byte) Message {
+	message := Message{Op: OpStdout, Data: string(data), Rows: w.rows, Cols: w.cols}
+	if message.Rows == 0 || message.Cols == 0 {
+		message.Rows, message.Cols = defaultRows, defaultCols
 	}
-	deadline := time.Now().Add(WaitWirte)
-	setErr := w.conn.SetWriteDeadline(deadline)
-	if setErr != nil {
-		fmt.Println("setErr", setErr)
-		return 0, setErr
+	return message
+}
+
+func (w *Window) Write(buffer []byte) (int, error) { // Write 将容器内输出数据传到Websocket
+	w.activity.output()
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	message := w.output(buffer)
+	if w.detached {
+		// the client is away, keep the output until it resumes
+		_, _ = w.missed.Write(buffer)
+	} else if n, err := w.writeOutput(message, buffer); err != nil {
+		return n, err
 	}
+	w.recorder.Output(buffer)
+	w.commands.output(len(buffer))
+	w.session.addOut(len(buffer))
+	w.countBytes(metrics.DirectionOut, len(buffer))
+	w.broadcast(message, buffer)
+	return len(buffer), nil
+
+}
+
+// writeOutput sends an output message to the client, writeMu must be held. A failed write detaches
+// a resumable session instead of failing it.
+func (w *Window) writeOutput(message Message, buffer []byte) (int, error) {
 	if w.conn == nil {
-		fmt.Println("websocket nil")
 		return 0, nil
 	}
-	writeErr := w.conn.WriteMessage(websocket.TextMessage, msg)
+	writeErr := writeFrame(w.conn, message)
 	if writeErr != nil {
-		fmt.Println("detail", w.conn)
-		fmt.Println("writeErr", writeErr)
-		return 0, writeErr
+		metrics.WebsocketWriteErrors.Inc()
+		zlog.LogWarnf("Failed to write terminal output: %v", writeErr)
+		if w.grace <= 0 || w.ended {
+			return 0, writeErr
+		}
+		w.detached = true
+		_, _ = w.missed.Write(buffer)
 	}
 	return len(buffer), nil
-
 }
 
 func (w *Window) Toast(buffer string) error { // Toast 发送输入错误的信息
-	message := Message{
-		Op:   "toast",
-		Data: buffer,
-	}
-	msg, marshalErr := json.Marshal(message)
-	if marshalErr != nil {
-		return marshalErr
-	}
-	deadline := time.Now().Add(WaitWirte)
-	setErr := w.conn.SetWriteDeadline(deadline)
-	if setErr != nil {
-		return setErr
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	if w.detached {
+		return nil
 	}
-	writeErr := w.conn.WriteMessage(websocket.TextMessage, msg)
-	return writeErr
+	return writeFrame(w.conn, Message{Op: OpToast, Data: buffer})
 }
 
-func (w *Window) sendMessage() {
-	disconnectMessage := Message{
-		Op:   "disconnect",
-		Data: "Connection closed due to inactivity.",
+// send writes msg to the client, failing while the client is disconnected
+func (w *Window) send(msg Message) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	if w.detached || w.conn == nil {
+		return errors.New("client disconnected")
 	}
-	msg, err := json.Marshal(disconnectMessage)
-	if err != nil {
+	return writeFrame(w.conn, msg)
+}
+
+// sendMessage tells the client why its session is disconnected
+func (w *Window) sendMessage(reason string) {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	if w.detached {
 		return
 	}
-	_ = w.conn.WriteMessage(websocket.TextMessage, msg)
+	_ = writeFrame(w.conn, Message{Op: OpDisconnect, Data: reason})
 }
@@ -14,16 +14,26 @@ package webterminal
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/remotecommand"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"openfuyao.com/web-terminal-service/pkg/metrics"
+	"openfuyao.com/web-terminal-service/pkg/recording"
 )
 
 func setupWebSockerServer(t *testing.T) *websocket.Conn {
@@ -113,6 +123,41 @@ func TestWindowNext(t *testing.T) {
 	}
 }
 
+func TestWindowEndSetup(t *testing.T) {
+	recorder := recordSpans(t)
+	tracer := otel.Tracer("test")
+
+	// the streams of the exec are up once it asks for the terminal size
+	w := &Window{sizeChan: make(chan remotecommand.TerminalSize, 1)}
+	_, w.setup = tracer.Start(context.Background(), "executePodExec")
+	w.sizeChan <- remotecommand.TerminalSize{Width: 80, Height: 24}
+	w.Next()
+	w.endSetup(errors.New("context canceled"))
+	// a failed exec fails its setup
+	failed := &Window{}
+	_, failed.setup = tracer.Start(context.Background(), "executePodExec")
+	failed.endSetup(errors.New("pods \"nginx\" is forbidden"))
+	// windows without a traced setup
+	(&Window{}).endSetup(nil)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+}
+
+func TestWindowEndSetupObservesDuration(t *testing.T) {
+	series := testutil.CollectAndCount(metrics.ExecSetupDuration)
+	failed := &Window{session: &Session{info: SessionInfo{Kind: "failed-setup"}}, setupStart: time.Now()}
+	failed.endSetup(errors.New("pods \"nginx\" is forbidden"))
+	require.Equal(t, series, testutil.CollectAndCount(metrics.ExecSetupDuration), "failed setups are not observed")
+
+	w := &Window{session: &Session{info: SessionInfo{Kind: "setup"}}, setupStart: time.Now()}
+	w.endSetup(nil)
+	w.endSetup(nil)
+	require.Equal(t, series+1, testutil.CollectAndCount(metrics.ExecSetupDuration))
+}
+
 func TestWindowRead(t *testing.T) {
 	conn := setupWebSockerServer(t)
 	defer conn.Close()
@@ -312,8 +357,9 @@ func TestWindowWrite(t *testing.T) {
 				terminaler: &terminaler{MgrClient: fakeClient},
 			}
 			if tt.path == KubectlApi {
-				w.ctx = context.WithValue(w.ctx, "username", "testuser")
-				w.Renewtime()
+				w.activity = newActivityTracker(fakeClient,
+					types.NamespacedName{Name: UserPodName("testuser"), Namespace: UserPodNamespace}, time.Minute)
+				defer w.activity.close()
 			}
 
 			got, err := w.Write(tt.args.buffer)
@@ -329,30 +375,6 @@ func TestWindowWrite(t *testing.T) {
 	}
 }
 
-func TestWindowRenewtime(t *testing.T) {
-	tests := []struct {
-		name string
-		ctx  context.Context
-	}{
-		{
-			name: "success",
-			ctx:  context.WithValue(context.Background(), "username", "testuser"),
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			fakeClient := fake.NewClientBuilder().Build()
-			w := &Window{
-				sizeChan:   make(chan remotecommand.TerminalSize, 1),
-				ctx:        tt.ctx,
-				terminaler: &terminaler{MgrClient: fakeClient},
-			}
-
-			w.Renewtime()
-		})
-	}
-}
-
 func TestWindowSendMessage(t *testing.T) {
 	conn := setupWebSockerServer(t)
 	defer conn.Close()
@@ -373,7 +395,43 @@ func TestWindowSendMessage(t *testing.T) {
 				ctx:        context.Background(),
 				terminaler: &terminaler{},
 			}
-			w.sendMessage()
+			w.sendMessage("Connection closed due to inactivity.")
 		})
 	}
 }
+
+func TestWindowRecordsSession(t *testing.T) {
+	conn := setupWebSockerServer(t)
+	defer conn.Close()
+
+	store, err := recording.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	term := &terminaler{recordings: store}
+	ctx := context.WithValue(context.WithValue(context.Background(), "path", "testpath"), "user", "alice")
+	recorder, err := term.startRecording(ctx, newSessionInfo(ctx, "session-1", "default", "web", "app"))
+	require.NoError(t, err)
+
+	w := &Window{conn: conn, sizeChan: make(chan remotecommand.TerminalSize, 1), ctx: ctx, terminaler: term,
+		recorder: recorder}
+	require.NoError(t, conn.WriteJSON(Message{Op: "resize", Rows: 40, Cols: 120}))
+	_, err = w.Read(make([]byte, 16))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, recorder.Close())
+
+	meta, err := store.Stat(context.Background(), "session-1")
+	require.NoError(t, err)
+	require.Equal(t, "alice", meta.User)
+	require.Equal(t, recording.KindPod, meta.Kind)
+
+	r, err := store.Open(context.Background(), "session-1")
+	require.NoError(t, err)
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 3)
+	require.Contains(t, lines[1], `"r","120x40"`)
+	require.Contains(t, lines[2], `"o","hello"`)
+}